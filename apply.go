@@ -0,0 +1,285 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/pack/wad/flp"
+	"github.com/mogaika/god_of_war_browser/pack/wad/rsrcs"
+	"github.com/mogaika/god_of_war_browser/pack/wad/twk"
+	"github.com/mogaika/god_of_war_browser/pack/wad/txr"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// applyManifest is list of modifications, json manifests are accepted too.
+// Paths of files are relative to manifest location
+type applyManifest struct {
+	Operations []applyOperation `yaml:"operations" json:"operations"`
+}
+
+type applyOperation struct {
+	Op   string `yaml:"op" json:"op"` // raw, txr, twk_yaml, flp_json, flp_static_label, flp_bmfont, rsrcs
+	Wad  string `yaml:"wad" json:"wad"`
+	Tag  string `yaml:"tag" json:"tag"`
+	File string `yaml:"file" json:"file"`
+
+	CreateNewPal bool     `yaml:"create_new_pal" json:"create_new_pal"` // txr
+	Scale        float32  `yaml:"scale" json:"scale"`                   // flp_bmfont, 1 if not set
+	Id           int      `yaml:"id" json:"id"`                         // flp_static_label
+	Wads         []string `yaml:"wads" json:"wads"`                     // rsrcs
+}
+
+// deferredSource keeps saved wad in memory, so every wad written to game data once
+type deferredSource struct {
+	utils.ResourceSource
+	data *io.SectionReader
+}
+
+func (ds *deferredSource) Save(in *io.SectionReader) error {
+	ds.data = in
+	return nil
+}
+
+func (ds *deferredSource) Directory() vfs.Directory {
+	return pack.SourceDirectory(ds.ResourceSource)
+}
+
+func (ds *deferredSource) flush() error {
+	if ds.data == nil {
+		return nil
+	}
+	return ds.ResourceSource.Save(ds.data)
+}
+
+type applier struct {
+	gameDir vfs.Directory
+	baseDir string
+	wads    map[string]*file_wad.Wad
+	sources map[string]*deferredSource
+}
+
+// applyMain replays mod manifest onto game data:
+// god_of_war_browser apply -iso GOW.iso -manifest mod.yaml
+func applyMain(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	var source sourceFlags
+	var manifestPath string
+	source.register(fs)
+	fs.StringVar(&manifestPath, "manifest", "", "Path to yaml or json manifest of operations")
+	fs.Parse(args)
+
+	if manifestPath == "" {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	manifest, err := readApplyManifest(manifestPath)
+	if err != nil {
+		log.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	gameDir, driverDir, err := source.open(false)
+	if err == errNoSource {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open game data: %v", err)
+	}
+
+	a := newApplier(gameDir, filepath.Dir(manifestPath))
+	for i := range manifest.Operations {
+		op := &manifest.Operations[i]
+		if err := a.apply(op); err != nil {
+			// nothing is written to game data until all operations succeed
+			log.Fatalf("Operation %d (%s %s:%s) failed: %v", i, op.Op, op.Wad, op.Tag, err)
+		}
+		log.Printf("Applied %d: %s %s:%s", i, op.Op, op.Wad, op.Tag)
+	}
+
+	if err := a.write(gameDir, driverDir); err != nil {
+		log.Fatalf("Failed to write changes: %v", err)
+	}
+	log.Printf("Applied %d operations to %d wads", len(manifest.Operations), len(a.wads))
+}
+
+func readApplyManifest(path string) (*applyManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// json is subset of yaml
+	var manifest applyManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (a *applier) getWad(name string) (*file_wad.Wad, error) {
+	if wad, ok := a.wads[name]; ok {
+		return wad, nil
+	}
+	data, err := pack.GetInstanceHandler(a.gameDir, name)
+	if err != nil {
+		return nil, err
+	}
+	wad, ok := data.(*file_wad.Wad)
+	if !ok {
+		return nil, errors.Errorf("%q is not wad", name)
+	}
+	ds := &deferredSource{ResourceSource: wad.Source}
+	wad.Source = ds
+	a.wads[name] = wad
+	a.sources[name] = ds
+	return wad, nil
+}
+
+func newApplier(gameDir vfs.Directory, baseDir string) *applier {
+	return &applier{
+		gameDir: gameDir,
+		baseDir: baseDir,
+		wads:    make(map[string]*file_wad.Wad),
+		sources: make(map[string]*deferredSource),
+	}
+}
+
+// write saves changed wads and syncs dirs, toc written once after all wads.
+// Dirs synced even if some wad failed, so toc matches wads already written
+func (a *applier) write(dirs ...vfs.Directory) error {
+	for _, d := range dirs {
+		if sd, ok := d.(vfs.SyncDeferrer); ok {
+			sd.DeferSync(true)
+			defer sd.DeferSync(false)
+		}
+	}
+	flushErr := a.flush()
+	for _, d := range dirs {
+		if s, ok := d.(vfs.Syncer); ok {
+			if err := s.Sync(); err != nil {
+				if flushErr != nil {
+					return errors.Wrapf(err, "Failed to sync after error %v", flushErr)
+				}
+				return errors.Wrapf(err, "Failed to sync")
+			}
+		}
+	}
+	return flushErr
+}
+
+func (a *applier) flush() error {
+	names := make([]string, 0, len(a.sources))
+	for name := range a.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := a.sources[name].flush(); err != nil {
+			return errors.Wrapf(err, "Failed to save %q", name)
+		}
+	}
+	return nil
+}
+
+func (a *applier) readFile(op *applyOperation) ([]byte, error) {
+	if op.File == "" {
+		return nil, errors.Errorf("File is not provided")
+	}
+	return ioutil.ReadFile(filepath.Join(a.baseDir, op.File))
+}
+
+func (a *applier) apply(op *applyOperation) error {
+	wad, err := a.getWad(op.Wad)
+	if err != nil {
+		return err
+	}
+
+	node := wad.GetNodeByName(op.Tag, 0, true)
+	if node == nil {
+		return errors.Errorf("Tag %q not found", op.Tag)
+	}
+	wrsrc := wad.GetNodeResourceByNodeId(node.Id)
+
+	if op.Op == "raw" {
+		data, err := a.readFile(op)
+		if err != nil {
+			return err
+		}
+		return wad.UpdateTagsData(map[file_wad.TagId][]byte{wrsrc.Tag.Id: data})
+	}
+
+	inst, _, err := wad.GetInstanceFromNode(node.Id)
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "txr":
+		t, ok := inst.(*txr.Texture)
+		if !ok {
+			return errors.Errorf("%q is not texture", op.Tag)
+		}
+		data, err := a.readFile(op)
+		if err != nil {
+			return err
+		}
+		return t.ChangeTexture(wrsrc, bytes.NewReader(data), op.CreateNewPal)
+	case "twk_yaml":
+		t, ok := inst.(*twk.TWK)
+		if !ok {
+			return errors.Errorf("%q is not tweak", op.Tag)
+		}
+		data, err := a.readFile(op)
+		if err != nil {
+			return err
+		}
+		return t.FromYaml(wrsrc, bytes.NewReader(data))
+	case "flp_json", "flp_static_label", "flp_bmfont":
+		f, ok := inst.(*flp.FLP)
+		if !ok {
+			return errors.Errorf("%q is not flp", op.Tag)
+		}
+		data, err := a.readFile(op)
+		if err != nil {
+			return err
+		}
+		switch op.Op {
+		case "flp_json":
+			return f.FromJson(wrsrc, data)
+		case "flp_static_label":
+			return f.UpdateStaticLabel(wrsrc, op.Id, data)
+		default:
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to open zip reader")
+			}
+			scale := op.Scale
+			if scale == 0 {
+				scale = 1.0
+			}
+			return f.ImportBmFontZip(wrsrc, zr, scale)
+		}
+	case "rsrcs":
+		r, ok := inst.(*rsrcs.RSRCS)
+		if !ok {
+			return errors.Errorf("%q is not rsrcs", op.Tag)
+		}
+		return r.SetWads(wrsrc, op.Wads)
+	default:
+		return fmt.Errorf("Unknown operation %q", op.Op)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/drivers/toc"
+	"github.com/mogaika/god_of_war_browser/pack"
+	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/pack/wad/rsrcs"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// testTocGameDir creates toc with R_A.WAD which contains raw data tag SCR_A and RSRCS
+func testTocGameDir(t *testing.T) (string, *toc.TableOfContent) {
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wadData := file_wad.MarshalTags([]file_wad.Tag{
+		{Tag: file_wad.TAG_GOW1_FILE_RAW_DATA, Name: "SCR_A", Data: []byte("original")},
+		{Tag: rsrcs.RSRCS_Tag, Name: "RSRCS", Data: make([]byte, 24)},
+	})
+	b := toc.NewTableOfContentBuilder()
+	b.AddFile("R_A.WAD", int64(len(wadData)), toc.Encounter{Offset: 0, Size: int64(len(wadData)), Pak: 0})
+	if err := ioutil.WriteFile(filepath.Join(dir, toc.TOC_FILE_NAME), b.Marshal(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pak := make([]byte, 8*utils.SECTOR_SIZE)
+	copy(pak, wadData)
+	if err := ioutil.WriteFile(filepath.Join(dir, "PART1.PAK"), pak, 0666); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	gameDir, err := toc.NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, gameDir
+}
+
+func TestReadApplyManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := []applyOperation{
+		{Op: "txr", Wad: "R_SHELL.WAD", Tag: "TXR_Logo", File: "logo.png", CreateNewPal: true},
+		{Op: "rsrcs", Wad: "R_SHELL.WAD", Tag: "RSRCS", Wads: []string{"R_SHELL", "R_PERM"}},
+		{Op: "flp_bmfont", Wad: "R_SHELL.WAD", Tag: "FLP_Font", File: "font.zip", Scale: 0.5, Id: 3},
+	}
+	for name, data := range map[string]string{
+		"mod.yaml": `operations:
+  - {op: txr, wad: R_SHELL.WAD, tag: TXR_Logo, file: logo.png, create_new_pal: true}
+  - {op: rsrcs, wad: R_SHELL.WAD, tag: RSRCS, wads: [R_SHELL, R_PERM]}
+  - op: flp_bmfont
+    wad: R_SHELL.WAD
+    tag: FLP_Font
+    file: font.zip
+    scale: 0.5
+    id: 3
+`,
+		"mod.json": `{"operations": [
+	{"op": "txr", "wad": "R_SHELL.WAD", "tag": "TXR_Logo", "file": "logo.png", "create_new_pal": true},
+	{"op": "rsrcs", "wad": "R_SHELL.WAD", "tag": "RSRCS", "wads": ["R_SHELL", "R_PERM"]},
+	{"op": "flp_bmfont", "wad": "R_SHELL.WAD", "tag": "FLP_Font", "file": "font.zip", "scale": 0.5, "id": 3}
+]}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := readApplyManifest(path)
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(manifest.Operations, expected) {
+			t.Errorf("Unexpected operations of %s: %+v", name, manifest.Operations)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("operations: {op: raw"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readApplyManifest(filepath.Join(dir, "bad.yaml")); err == nil {
+		t.Errorf("Broken manifest accepted")
+	}
+}
+
+func TestApplyOperations(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, gameDir := testTocGameDir(t)
+	tocBefore, err := ioutil.ReadFile(filepath.Join(dir, toc.TOC_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := bytes.Repeat([]byte("changed script "), 300)
+	if err := ioutil.WriteFile(filepath.Join(dir, "script.bin"), script, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newApplier(gameDir, dir)
+	for _, op := range []applyOperation{
+		{Op: "raw", Wad: "R_A.WAD", Tag: "SCR_A", File: "script.bin"},
+		{Op: "rsrcs", Wad: "R_A.WAD", Tag: "RSRCS", Wads: []string{"R_A"}},
+	} {
+		if err := a.apply(&op); err != nil {
+			t.Fatalf("Operation %s failed: %v", op.Op, err)
+		}
+	}
+	for _, op := range []applyOperation{
+		{Op: "raw", Wad: "R_A.WAD", Tag: "SCR_MISSING", File: "script.bin"},
+		{Op: "raw", Wad: "R_A.WAD", Tag: "SCR_A"},
+		{Op: "unknown", Wad: "R_A.WAD", Tag: "RSRCS"},
+		{Op: "txr", Wad: "R_A.WAD", Tag: "RSRCS", File: "script.bin"},
+		{Op: "raw", Wad: "R_MISSING.WAD", Tag: "SCR_A", File: "script.bin"},
+	} {
+		if err := a.apply(&op); err == nil {
+			t.Errorf("Operation %+v succeeded", op)
+		}
+	}
+
+	// nothing written before write
+	if tocAfter, _ := ioutil.ReadFile(filepath.Join(dir, toc.TOC_FILE_NAME)); !bytes.Equal(tocBefore, tocAfter) {
+		t.Errorf("Toc changed before write")
+	}
+	if err := a.write(gameDir); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	gameDir, err = toc.NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := pack.GetInstanceHandler(gameDir, "R_A.WAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wad := inst.(*file_wad.Wad)
+	if n := wad.GetNodeByName("SCR_A", 0, true); n == nil || !bytes.Equal(n.Tag.Data, script) {
+		t.Errorf("Raw operation not applied")
+	}
+	r, _, err := wad.GetInstanceFromNode(wad.GetNodeByName("RSRCS", 0, true).Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wads := r.(*rsrcs.RSRCS).Wads; !reflect.DeepEqual(wads, []string{"R_A"}) {
+		t.Errorf("Unexpected rsrcs %v", wads)
+	}
+}
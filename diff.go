@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// diffMain prints json diff of two wads, same as /json/diff web handler.
+// Wads are compared inside one source, or first wad taken from -orig-* source:
+// god_of_war_browser diff -iso GOW.iso R_A.WAD R_B.WAD
+// god_of_war_browser diff -orig-iso GOW.iso -iso MOD.iso R_A.WAD
+// god_of_war_browser diff -iso GOW.iso -overlay mod R_A.WAD
+func diffMain(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var source, original sourceFlags
+	source.register(fs)
+	original.registerPaths(fs, "orig-", "Source of first wad: ")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		log.Printf("Usage: diff [flags] fileA [fileB]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fileA, fileB := fs.Arg(0), fs.Arg(0)
+	if fs.NArg() == 2 {
+		fileB = fs.Arg(1)
+	}
+
+	// original opened first, because opening resets detected game version
+	original.psversion, original.gowversion, original.encoding = source.psversion, source.gowversion, source.encoding
+	dirA, _, err := original.open(true)
+	if err != nil && err != errNoSource {
+		log.Fatalf("Cannot open original game data: %v", err)
+	}
+	dirB, _, err := source.open(true)
+	if err == errNoSource {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open game data: %v", err)
+	}
+	if dirA == nil {
+		// overlay compared with game data under it
+		if o, ok := dirB.(*vfs.Overlay); ok {
+			dirA = o.Base()
+		} else {
+			dirA = dirB
+		}
+	}
+
+	wadA := openDiffWad(dirA, fileA)
+	wadB := openDiffWad(dirB, fileB)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(file_wad.Diff(wadA, wadB)); err != nil {
+		log.Fatalf("Failed to encode diff: %v", err)
+	}
+}
+
+func openDiffWad(d vfs.Directory, name string) *file_wad.Wad {
+	data, err := pack.GetInstanceHandler(d, name)
+	if err != nil {
+		log.Fatalf("Failed to open %q: %v", name, err)
+	}
+	wad, ok := data.(*file_wad.Wad)
+	if !ok {
+		log.Fatalf("%q is not wad", name)
+	}
+	return wad
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mogaika/udf"
 
@@ -17,8 +20,12 @@ import (
 
 type IsoDriver struct {
 	f                vfs.File
-	layers           [2]*udf.Udf
+	layers           [2]*isoLayer
 	secondLayerStart int64
+
+	// guards layers and content of image, writes move files and
+	// rewrite directories while other goroutines read them
+	lock sync.RWMutex
 }
 
 func (iso *IsoDriver) Init(parent vfs.Directory) {}
@@ -26,10 +33,12 @@ func (iso *IsoDriver) Name() string              { return iso.f.Name() }
 func (iso *IsoDriver) IsDirectory() bool         { return true }
 
 func (iso *IsoDriver) List() ([]string, error) {
+	iso.lock.RLock()
+	defer iso.lock.RUnlock()
 	result := make([]string, 0, 48)
 	for _, layer := range iso.layers {
 		if layer != nil {
-			files := layer.ReadDir(nil)
+			files := layer.udf.ReadDir(nil)
 			for i := range files {
 				result = append(result, files[i].Name())
 			}
@@ -39,22 +48,87 @@ func (iso *IsoDriver) List() ([]string, error) {
 }
 
 func (iso *IsoDriver) GetElement(name string) (vfs.Element, error) {
+	iso.lock.RLock()
+	defer iso.lock.RUnlock()
+	f, err := iso.getElement(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (iso *IsoDriver) getElement(name string) (*IsoDriverFile, error) {
 	for _, layer := range iso.layers {
 		if layer != nil {
-			dir := layer.ReadDir(nil)
+			dir := layer.udf.ReadDir(nil)
 			for i := range dir {
 				if strings.ToLower(dir[i].Name()) == strings.ToLower(name) {
 					return &IsoDriverFile{
-						iso: iso,
-						f:   &dir[i]}, nil
+						iso:   iso,
+						layer: layer,
+						f:     &dir[i]}, nil
 				}
 			}
 		}
 	}
 	return nil, os.ErrNotExist
 }
-func (iso *IsoDriver) Add(e vfs.Element) error  { panic("Not implemented") }
-func (iso *IsoDriver) Remove(name string) error { panic("Not implemented") }
+
+// Add creates file in first layer with enough free space. If element is vfs.File, then content copied
+func (iso *IsoDriver) Add(e vfs.Element) error {
+	if e.IsDirectory() {
+		return fmt.Errorf("[vfs] [iso] Directories not supported")
+	}
+	var data []byte
+	if f, ok := e.(vfs.File); ok {
+		r, err := vfs.OpenFileAndGetReader(f, true)
+		if err != nil {
+			return fmt.Errorf("[vfs] [iso] Cannot open source file: %v", err)
+		}
+		data, err = ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("[vfs] [iso] Cannot read source file: %v", err)
+		}
+	}
+
+	f, err := iso.addFile(e.Name())
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	return vfs.OpenFileAndCopy(f, bytes.NewReader(data))
+}
+
+func (iso *IsoDriver) addFile(name string) (*IsoDriverFile, error) {
+	iso.lock.Lock()
+	defer iso.lock.Unlock()
+
+	if _, err := iso.getElement(name); err == nil {
+		return nil, fmt.Errorf("[vfs] [iso] File '%s' already exists", name)
+	}
+	var err error
+	for _, layer := range iso.layers {
+		if layer == nil {
+			continue
+		}
+		if err = layer.addFile(name); err != nil {
+			log.Printf("[vfs] [iso] Cannot add file to layer: %v", err)
+			continue
+		}
+		return iso.getElement(name)
+	}
+	return nil, err
+}
+
+func (iso *IsoDriver) Remove(name string) error {
+	iso.lock.Lock()
+	defer iso.lock.Unlock()
+	f, err := iso.getElement(name)
+	if err != nil {
+		return fmt.Errorf("[vfs] [iso] Cannot find file '%s'", name)
+	}
+	return f.layer.removeFile(name)
+}
 func (iso *IsoDriver) Sync() error {
 	if s, ok := iso.f.(vfs.Syncer); ok {
 		return s.Sync()
@@ -63,7 +137,10 @@ func (iso *IsoDriver) Sync() error {
 }
 
 func (iso *IsoDriver) OpenStreams() error {
-	iso.layers[0] = udf.NewUdfFromReader(iso.f)
+	var err error
+	if iso.layers[0], err = newIsoLayer(iso, 0); err != nil {
+		return err
+	}
 
 	var volSizeBuf [4]byte
 	// primary volume description sector + offset of volume space size
@@ -74,7 +151,9 @@ func (iso *IsoDriver) OpenStreams() error {
 		volumeSize := int64(binary.LittleEndian.Uint32(volSizeBuf[:])-16) * utils.SECTOR_SIZE
 
 		if volumeSize+256*utils.SECTOR_SIZE < iso.f.Size() {
-			iso.layers[1] = udf.NewUdfFromReader(io.NewSectionReader(iso.f, volumeSize, iso.f.Size()-volumeSize))
+			if iso.layers[1], err = newIsoLayer(iso, volumeSize); err != nil {
+				return fmt.Errorf("[vfs] [iso] Failed to open second layer: %v", err)
+			}
 			log.Printf("[vfs] [iso] Detected second layer of disk. Start: 0x%x (0x%x)", volumeSize+16*utils.SECTOR_SIZE, volumeSize)
 			iso.secondLayerStart = volumeSize
 		}
@@ -89,6 +168,7 @@ func NewIsoDriver(f vfs.File) (*IsoDriver, error) {
 
 type IsoDriverFile struct {
 	iso      *IsoDriver
+	layer    *isoLayer
 	f        *udf.File
 	readonly bool
 }
@@ -97,6 +177,12 @@ func (f *IsoDriverFile) Init(parent vfs.Directory) {}
 func (f *IsoDriverFile) Name() string              { return f.f.Name() }
 func (f *IsoDriverFile) IsDirectory() bool         { return f.f.IsDir() }
 func (f *IsoDriverFile) Size() int64               { return f.f.Size() }
+func (f *IsoDriverFile) ModTime() (time.Time, error) {
+	if t, ok := vfs.GetModTime(f.iso.f); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("[vfs] [iso] Modification time of image is unknown")
+}
 func (f *IsoDriverFile) Open(readonly bool) error {
 	f.readonly = readonly
 	return nil
@@ -109,9 +195,12 @@ func (f *IsoDriverFile) Close() error {
 	}
 }
 func (f *IsoDriverFile) Reader() (*io.SectionReader, error) {
-	return f.f.NewReader(), nil
+	// every read takes lock of iso, so data is not read while files moved
+	return io.NewSectionReader(f, 0, f.Size()), nil
 }
 func (f *IsoDriverFile) ReadAt(b []byte, off int64) (n int, err error) {
+	f.iso.lock.RLock()
+	defer f.iso.lock.RUnlock()
 	return f.f.NewReader().ReadAt(b, off)
 }
 func (f *IsoDriverFile) Copy(src io.Reader) error {
@@ -119,24 +208,50 @@ func (f *IsoDriverFile) Copy(src io.Reader) error {
 	if _, err := io.Copy(&b, src); err != nil {
 		return err
 	}
+
+	f.iso.lock.Lock()
+	defer f.iso.lock.Unlock()
 	if int64(b.Len()) != f.Size() {
-		return fmt.Errorf("[vfs] [iso] Do not support file size changing")
+		if err := f.resize(int64(b.Len())); err != nil {
+			return err
+		}
 	}
-	_, err := f.WriteAt(b.Bytes(), 0)
+	_, err := f.writeAt(b.Bytes(), 0)
 	return err
 }
 func (f *IsoDriverFile) WriteAt(b []byte, off int64) (n int, err error) {
+	f.iso.lock.Lock()
+	defer f.iso.lock.Unlock()
+	return f.writeAt(b, off)
+}
+func (f *IsoDriverFile) writeAt(b []byte, off int64) (n int, err error) {
 	if f.readonly {
 		return 0, fmt.Errorf("[vfs] [iso] Readonly mode")
 	}
 	if off+int64(len(b)) > f.Size() {
-		return 0, fmt.Errorf("[vfs] [iso] Do not support file size increasing")
+		if err := f.resize(off + int64(len(b))); err != nil {
+			return 0, err
+		}
 	}
 
-	if f.f.Udf == f.iso.layers[1] {
-		off += f.iso.secondLayerStart
+	return f.iso.f.WriteAt(b, f.layer.start+f.f.GetFileOffset()+off)
+}
+
+// resize changes size of file, file can be moved to other place of layer
+func (f *IsoDriverFile) resize(size int64) error {
+	if f.readonly {
+		return fmt.Errorf("[vfs] [iso] Readonly mode")
+	}
+	if err := f.layer.resizeFile(f.Name(), size); err != nil {
+		return err
+	}
+	for _, file := range f.layer.udf.ReadDir(nil) {
+		if strings.EqualFold(file.Name(), f.Name()) {
+			f.f = &file
+			return nil
+		}
 	}
-	return f.iso.f.WriteAt(b, f.f.GetFileOffset()+off)
+	return fmt.Errorf("[vfs] [iso] File '%s' lost after resize", f.Name())
 }
 func (f *IsoDriverFile) Sync() error {
 	if f.readonly {
@@ -0,0 +1,246 @@
+package iso
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mogaika/udf"
+)
+
+const ISO9660_PVD_SECTOR = 16
+
+// record of iso9660 directory
+type iso9660Record struct {
+	sector uint32 // layer relative
+	offset int
+	length int
+	name   string
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:], v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// iso9660RootDirectory returns layer relative extent of root directory,
+// ok is false if layer have no iso9660 descriptor
+func (l *isoLayer) iso9660RootDirectory() (location, size uint32, ok bool, err error) {
+	pvd, err := l.readSectors(ISO9660_PVD_SECTOR, 1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		return 0, 0, false, nil
+	}
+	root := pvd[156:]
+	return binary.LittleEndian.Uint32(root[2:]), binary.LittleEndian.Uint32(root[10:]), true, nil
+}
+
+func (l *isoLayer) iso9660Records() ([]iso9660Record, error) {
+	location, size, ok, err := l.iso9660RootDirectory()
+	if err != nil || !ok {
+		return nil, err
+	}
+	result := make([]iso9660Record, 0, 32)
+	for sector := location; sector < location+sectorsCount(int64(size)); sector++ {
+		b, err := l.readSectors(sector, 1)
+		if err != nil {
+			return nil, err
+		}
+		// records do not cross sector boundary
+		for off := 0; off+33 < udf.SECTOR_SIZE && b[off] != 0; off += int(b[off]) {
+			nameLength := int(b[off+32])
+			result = append(result, iso9660Record{
+				sector: sector,
+				offset: off,
+				length: int(b[off]),
+				name:   strings.TrimSuffix(string(b[off+33:off+33+nameLength]), ";1"),
+			})
+		}
+	}
+	return result, nil
+}
+
+// containsExtent reports whether absolute sectors of file lie inside of layer partition
+func (l *isoLayer) containsExtent(absoluteSector int64, size int64) bool {
+	start := l.start / udf.SECTOR_SIZE
+	end := start + int64(l.partitionStart+l.partitionLength)
+	return absoluteSector >= start && absoluteSector+int64(sectorsCount(size)) <= end
+}
+
+// updateIso9660Records changes location and size of file in iso9660 directories of layer
+// which contains file data, records of other layers use own sector numeration
+func (iso *IsoDriver) updateIso9660Records(name string, absoluteSector int64, size int64) error {
+	for _, l := range iso.layers {
+		if l == nil || !l.containsExtent(absoluteSector, size) {
+			continue
+		}
+		records, err := l.iso9660Records()
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if !strings.EqualFold(r.name, name) {
+				continue
+			}
+			b, err := l.readSectors(r.sector, 1)
+			if err != nil {
+				return err
+			}
+			putBothEndian32(b[r.offset+2:], uint32(absoluteSector-l.start/udf.SECTOR_SIZE))
+			putBothEndian32(b[r.offset+10:], uint32(size))
+			if err := l.writeSectors(r.sector, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addIso9660Record adds copy of template record to root directory of layer
+func (iso *IsoDriver) addIso9660Record(l *isoLayer, templateName, name string, absoluteSector int64) error {
+	records, err := l.iso9660Records()
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		log.Printf("[vfs] [iso] Layer without iso9660 descriptor, only udf record created for %q", name)
+		return nil
+	}
+
+	var template *iso9660Record
+	for i := range records {
+		if strings.EqualFold(records[i].name, templateName) {
+			template = &records[i]
+		}
+	}
+	if template == nil {
+		return fmt.Errorf("[vfs] [iso] Template iso9660 record %q not found", templateName)
+	}
+	tb, err := l.readSectors(template.sector, 1)
+	if err != nil {
+		return err
+	}
+	t := tb[template.offset : template.offset+template.length]
+	oldNameLength := int(t[32])
+	systemUse := t[33+oldNameLength+(1-oldNameLength%2):]
+
+	isoName := strings.ToUpper(name) + ";1"
+	length := 33 + len(isoName) + (1 - len(isoName)%2) + len(systemUse)
+	record := make([]byte, length)
+	copy(record, t[:33])
+	record[0] = byte(length)
+	record[32] = byte(len(isoName))
+	copy(record[33:], isoName)
+	copy(record[33+len(isoName)+(1-len(isoName)%2):], systemUse)
+	putBothEndian32(record[2:], uint32(absoluteSector-l.start/udf.SECTOR_SIZE))
+	putBothEndian32(record[10:], 0)
+
+	return l.insertIso9660Record(records, record, isoName)
+}
+
+// comparePadded compares strings padded with spaces to same length
+func comparePadded(a, b string) int {
+	for len(a) < len(b) {
+		a += " "
+	}
+	for len(b) < len(a) {
+		b += " "
+	}
+	return strings.Compare(a, b)
+}
+
+// iso9660NameLess is order of records required by iso9660:
+// by name, then by extension, both padded with spaces.
+// Names of "." and ".." records are 0x00 and 0x01, so they are always first
+func iso9660NameLess(a, b string) bool {
+	a, b = strings.ToUpper(strings.TrimSuffix(a, ";1")), strings.ToUpper(strings.TrimSuffix(b, ";1"))
+	aName, aExt := a, ""
+	if i := strings.LastIndexByte(a, '.'); i >= 0 {
+		aName, aExt = a[:i], a[i+1:]
+	}
+	bName, bExt := b, ""
+	if i := strings.LastIndexByte(b, '.'); i >= 0 {
+		bName, bExt = b[:i], b[i+1:]
+	}
+	if c := comparePadded(aName, bName); c != 0 {
+		return c < 0
+	}
+	return comparePadded(aExt, bExt) < 0
+}
+
+// insertIso9660Record places record to sorted position and lays out
+// root directory records again, records do not cross sector boundary
+func (l *isoLayer) insertIso9660Record(records []iso9660Record, record []byte, name string) error {
+	location, size, _, err := l.iso9660RootDirectory()
+	if err != nil {
+		return err
+	}
+	sectors := sectorsCount(int64(size))
+	dir, err := l.readSectors(location, sectors)
+	if err != nil {
+		return err
+	}
+
+	raw := make([][]byte, 0, len(records)+1)
+	inserted := false
+	for _, r := range records {
+		if !inserted && iso9660NameLess(name, r.name) {
+			raw = append(raw, record)
+			inserted = true
+		}
+		start := int(r.sector-location)*udf.SECTOR_SIZE + r.offset
+		raw = append(raw, dir[start:start+r.length])
+	}
+	if !inserted {
+		raw = append(raw, record)
+	}
+
+	b := make([]byte, len(dir))
+	pos := 0
+	for _, r := range raw {
+		if pos%udf.SECTOR_SIZE+len(r) > udf.SECTOR_SIZE {
+			pos += udf.SECTOR_SIZE - pos%udf.SECTOR_SIZE
+		}
+		if pos+len(r) > len(b) {
+			return fmt.Errorf("[vfs] [iso] No space in iso9660 root directory for %q", name)
+		}
+		copy(b[pos:], r)
+		pos += len(r)
+	}
+	return l.writeSectors(location, b)
+}
+
+// removeIso9660Records removes file from iso9660 directories of all layers
+func (iso *IsoDriver) removeIso9660Records(name string) error {
+	for _, l := range iso.layers {
+		if l == nil {
+			continue
+		}
+		records, err := l.iso9660Records()
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if !strings.EqualFold(r.name, name) {
+				continue
+			}
+			b, err := l.readSectors(r.sector, 1)
+			if err != nil {
+				return err
+			}
+			copy(b[r.offset:], b[r.offset+r.length:])
+			for i := udf.SECTOR_SIZE - r.length; i < udf.SECTOR_SIZE; i++ {
+				b[i] = 0
+			}
+			if err := l.writeSectors(r.sector, b); err != nil {
+				return err
+			}
+			// offsets of following records are changed
+			return iso.removeIso9660Records(name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package iso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mogaika/udf"
+
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+const (
+	testLayerSectors     = 400
+	testPartitionStart   = 260
+	testPartitionLength  = 100
+	testIso9660Directory = 20
+	testFirstDataSector  = 10 // partition relative
+	testIntegritySector  = 64
+	testNextUniqueId     = 40
+)
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+// writeTestLayer writes udf volume and iso9660 root directory with files
+// placed one after another, same as mastering tools do
+func writeTestLayer(img []byte, volumeSectors uint32, files []testFile) {
+	sector := func(n uint32) []byte { return img[n*udf.SECTOR_SIZE : (n+1)*udf.SECTOR_SIZE] }
+	part := func(n uint32) []byte { return sector(testPartitionStart + n) }
+	descriptor := func(b []byte, tag uint16, location uint32) {
+		binary.LittleEndian.PutUint16(b, tag)
+		udfTagUpdate(b, location, 512)
+	}
+
+	pvd := sector(ISO9660_PVD_SECTOR)
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	putBothEndian32(pvd[80:], volumeSectors)
+	term := sector(ISO9660_PVD_SECTOR + 1)
+	term[0] = 255
+	copy(term[1:], "CD001")
+	term[6] = 1
+
+	dir := sector(testIso9660Directory)
+	dirOffset := 0
+	putRecord := func(b []byte, name string, location, size uint32, flags byte) int {
+		length := 33 + len(name) + (1 - len(name)%2)
+		b[0] = byte(length)
+		putBothEndian32(b[2:], location)
+		putBothEndian32(b[10:], size)
+		b[25] = flags
+		b[32] = byte(len(name))
+		copy(b[33:], name)
+		return length
+	}
+	putRecord(pvd[156:], "\x00", testIso9660Directory, udf.SECTOR_SIZE, 2)
+	dirOffset += putRecord(dir[dirOffset:], "\x00", testIso9660Directory, udf.SECTOR_SIZE, 2)
+	dirOffset += putRecord(dir[dirOffset:], "\x01", testIso9660Directory, udf.SECTOR_SIZE, 2)
+
+	anchor := sector(256)
+	binary.LittleEndian.PutUint32(anchor[16:], 3*udf.SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(anchor[20:], 32)
+	descriptor(anchor, udf.DESCRIPTOR_ANCHOR_VOLUME_POINTER, 256)
+	pd := sector(32)
+	binary.LittleEndian.PutUint32(pd[188:], testPartitionStart)
+	binary.LittleEndian.PutUint32(pd[192:], testPartitionLength)
+	descriptor(pd, udf.DESCRIPTOR_PARTITION, 32)
+	lvd := sector(33)
+	binary.LittleEndian.PutUint32(lvd[212:], udf.SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(lvd[248:], udf.SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(lvd[432:], udf.SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(lvd[436:], testIntegritySector)
+	descriptor(lvd, udf.DESCRIPTOR_LOGICAL_VOLUME, 33)
+	lvid := sector(testIntegritySector)
+	binary.LittleEndian.PutUint64(lvid[40:], testNextUniqueId)
+	binary.LittleEndian.PutUint32(lvid[72:], 1)
+	descriptor(lvid, UDF_TAG_LOGICAL_VOLUME_INTEGRITY, testIntegritySector)
+	descriptor(sector(34), udf.DESCRIPTOR_TERMINATING, 34)
+
+	fsd := part(0)
+	binary.LittleEndian.PutUint32(fsd[400:], udf.SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(fsd[404:], 1)
+	descriptor(fsd, udf.DESCRIPTOR_FILE_SET, 0)
+
+	stream := part(2)
+	streamLength := 0
+	putFid := func(name string, flags byte, icb uint32) {
+		b := stream[streamLength:]
+		nameLength := 0
+		if name != "" {
+			nameLength = len(name) + 1
+			b[38] = 8
+			copy(b[39:], name)
+		}
+		binary.LittleEndian.PutUint16(b[16:], 1)
+		b[18] = flags
+		b[19] = byte(nameLength)
+		binary.LittleEndian.PutUint32(b[20:], udf.SECTOR_SIZE)
+		binary.LittleEndian.PutUint32(b[24:], icb)
+		length := fileIdentifierLength(0, nameLength)
+		binary.LittleEndian.PutUint16(b, UDF_TAG_FILE_IDENTIFIER)
+		udfTagUpdate(b, 2, length)
+		streamLength += length
+	}
+	newFileEntry := func(location uint32, fileType byte, uniqueId uint64) fileEntry {
+		fe := fileEntry(part(location))
+		binary.LittleEndian.PutUint16(fe, UDF_TAG_FILE_ENTRY)
+		binary.LittleEndian.PutUint32(fe[12:], location)
+		fe[16+11] = fileType
+		binary.LittleEndian.PutUint16(fe[48:], 1)
+		binary.LittleEndian.PutUint64(fe[160:], uniqueId)
+		return fe
+	}
+
+	putFid("", UDF_FID_PARENT, 1)
+	dataLocation := uint32(testFirstDataSector)
+	for i, f := range files {
+		feLocation := uint32(3 + i)
+		if err := newFileEntry(feLocation, 5, uint64(16+i)).setExtent(dataLocation, int64(len(f.data))); err != nil {
+			panic(err)
+		}
+		copy(img[(testPartitionStart+dataLocation)*udf.SECTOR_SIZE:], f.data)
+		putFid(f.name, 0, feLocation)
+		dirOffset += putRecord(dir[dirOffset:], strings.ToUpper(f.name)+";1", testPartitionStart+dataLocation, uint32(len(f.data)), 0)
+		dataLocation += sectorsCount(int64(len(f.data)))
+	}
+	if err := newFileEntry(1, 4, 0).setExtent(2, int64(streamLength)); err != nil {
+		panic(err)
+	}
+}
+
+func openTestIso(t *testing.T, path string) (*IsoDriver, *vfs.DirectoryDriverFile) {
+	f := vfs.NewDirectoryDriverFile(path)
+	if err := f.Open(false); err != nil {
+		t.Fatal(err)
+	}
+	iso, err := NewIsoDriver(f)
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	return iso, f
+}
+
+func readTestFile(t *testing.T, iso *IsoDriver, name string) []byte {
+	f, err := vfs.DirectoryGetFile(iso, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := vfs.OpenFileAndGetReader(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// checkIso9660 compares iso9660 records of layer with udf files
+func checkIso9660(t *testing.T, l *isoLayer, expected []string) {
+	records, err := l.iso9660Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, r := range records[2:] {
+		names = append(names, r.name)
+		b, err := l.readSectors(r.sector, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		location := int64(binary.LittleEndian.Uint32(b[r.offset+2:]))
+		size := int64(binary.LittleEndian.Uint32(b[r.offset+10:]))
+		for _, f := range l.udf.ReadDir(nil) {
+			if f.Name() == r.name && size != 0 && (f.GetFileOffset() != location*udf.SECTOR_SIZE || f.Size() != size) {
+				t.Errorf("Record %q %#x:%#x not equal to udf %#x:%#x", r.name, location*udf.SECTOR_SIZE, size, f.GetFileOffset(), f.Size())
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected iso9660 records %v, expected %v", names, expected)
+	}
+	if !sort.SliceIsSorted(names, func(i, j int) bool { return iso9660NameLess(names[i], names[j]) }) {
+		t.Errorf("Records are not sorted: %v", names)
+	}
+}
+
+// writeTestIso writes two layers image, A.BIN and B.BIN on first layer, C.BIN on second one
+func writeTestIso(t *testing.T, path string, dataA, dataB, dataC []byte) {
+	img := make([]byte, 2*testLayerSectors*udf.SECTOR_SIZE)
+	// second layer starts after volume of first one without 16 boot sectors
+	writeTestLayer(img, testLayerSectors+16, []testFile{{"A.BIN", dataA}, {"B.BIN", dataB}})
+	writeTestLayer(img[testLayerSectors*udf.SECTOR_SIZE:], testLayerSectors+16, []testFile{{"C.BIN", dataC}})
+	if err := ioutil.WriteFile(path, img, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsoModify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dataA := bytes.Repeat([]byte{'a'}, udf.SECTOR_SIZE)
+	dataB := bytes.Repeat([]byte{'b'}, 100)
+	dataC := bytes.Repeat([]byte{'c'}, 10)
+	path := filepath.Join(dir, "test.iso")
+	writeTestIso(t, path, dataA, dataB, dataC)
+
+	iso, f := openTestIso(t, path)
+	if iso.layers[1] == nil {
+		t.Fatal("Second layer not detected")
+	}
+	if list, _ := iso.List(); !reflect.DeepEqual(list, []string{"A.BIN", "B.BIN", "C.BIN"}) {
+		t.Fatalf("Unexpected files %v", list)
+	}
+
+	// last file grows in place
+	dataB = bytes.Repeat([]byte{'B'}, 3000)
+	fb, _ := vfs.DirectoryGetFile(iso, "B.BIN")
+	offsetB := fb.(*IsoDriverFile).f.GetFileOffset()
+	if err := vfs.OpenFileAndCopy(fb, bytes.NewReader(dataB)); err != nil {
+		t.Fatal(err)
+	}
+	if offset := fb.(*IsoDriverFile).f.GetFileOffset(); offset != offsetB {
+		t.Errorf("Last file moved from %#x to %#x", offsetB, offset)
+	}
+
+	// first file does not fit before second one and relocated
+	dataA = bytes.Repeat([]byte{'A'}, 5000)
+	fa, _ := vfs.DirectoryGetFile(iso, "A.BIN")
+	offsetA := fa.(*IsoDriverFile).f.GetFileOffset()
+	if err := vfs.OpenFileAndCopy(fa, bytes.NewReader(dataA)); err != nil {
+		t.Fatal(err)
+	}
+	if offset := fa.(*IsoDriverFile).f.GetFileOffset(); offset == offsetA {
+		t.Errorf("Grown file not relocated")
+	}
+
+	dataAA := []byte("new file")
+	src := filepath.Join(dir, "AA.BIN")
+	if err := ioutil.WriteFile(src, dataAA, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := iso.Add(vfs.NewDirectoryDriverFile(src)); err != nil {
+		t.Fatal(err)
+	}
+	checkIso9660(t, iso.layers[0], []string{"A.BIN", "AA.BIN", "B.BIN"})
+	_, _, fids, err := iso.layers[0].readRootDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fe, err := iso.layers[0].readFileEntry(findFileIdentifier(fids, "AA.BIN").icb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := fe.uniqueId(); id != testNextUniqueId {
+		t.Errorf("New file unique id %d, expected %d from integrity descriptor", id, testNextUniqueId)
+	}
+	if next, _ := iso.layers[0].nextUniqueId(); next != testNextUniqueId+1 {
+		t.Errorf("Integrity descriptor next unique id %d not updated", next)
+	}
+
+	if err := iso.Remove("B.BIN"); err != nil {
+		t.Fatal(err)
+	}
+	checkIso9660(t, iso.layers[0], []string{"A.BIN", "AA.BIN"})
+	checkIso9660(t, iso.layers[1], []string{"C.BIN"})
+	f.Close()
+
+	// read from disk again
+	iso, f = openTestIso(t, path)
+	defer f.Close()
+	if list, _ := iso.List(); !reflect.DeepEqual(list, []string{"A.BIN", "AA.BIN", "C.BIN"}) {
+		t.Errorf("Unexpected files after reopen %v", list)
+	}
+	for name, data := range map[string][]byte{"A.BIN": dataA, "AA.BIN": dataAA, "C.BIN": dataC} {
+		if !bytes.Equal(readTestFile(t, iso, name), data) {
+			t.Errorf("Content of %s mismatch", name)
+		}
+	}
+	checkIso9660(t, iso.layers[0], []string{"A.BIN", "AA.BIN"})
+}
+
+func TestUpdateIso9660RecordsLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.iso")
+	writeTestIso(t, path, []byte("a"), []byte("b"), []byte("c"))
+	iso, f := openTestIso(t, path)
+	defer f.Close()
+
+	recordLocation := func() uint32 {
+		records, err := iso.layers[1].iso9660Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := records[2]
+		b, err := iso.layers[1].readSectors(r.sector, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary.LittleEndian.Uint32(b[r.offset+2:])
+	}
+	before := recordLocation()
+
+	// sector of first layer is outside of second layer coordinates
+	if err := iso.updateIso9660Records("C.BIN", testPartitionStart+testFirstDataSector, 1); err != nil {
+		t.Fatal(err)
+	}
+	if location := recordLocation(); location != before {
+		t.Errorf("Record of second layer rewritten with first layer sector %#x", location)
+	}
+
+	second := iso.layers[1].absoluteSector(testFirstDataSector + 5)
+	if err := iso.updateIso9660Records("C.BIN", second, 1); err != nil {
+		t.Fatal(err)
+	}
+	if location := recordLocation(); location != testPartitionStart+testFirstDataSector+5 {
+		t.Errorf("Record of second layer not updated: %#x", location)
+	}
+}
+
+// files listed and read by one goroutine while other resizes them, run with -race
+func TestIsoConcurrentUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.iso")
+	writeTestIso(t, path, []byte("a"), []byte("b"), []byte("c"))
+	iso, f := openTestIso(t, path)
+	defer f.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			names, _ := iso.List()
+			for _, name := range names {
+				if f, err := vfs.DirectoryGetFile(iso, name); err == nil {
+					if r, err := vfs.OpenFileAndGetReader(f, true); err == nil {
+						ioutil.ReadAll(r)
+						f.Close()
+					}
+				}
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		fa, err := vfs.DirectoryGetFile(iso, "A.BIN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := vfs.OpenFileAndCopy(fa, bytes.NewReader(bytes.Repeat([]byte{byte(i)}, 100+i*udf.SECTOR_SIZE))); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,423 @@
+package iso
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mogaika/udf"
+)
+
+// partition relative range of sectors [start, end)
+type extent struct {
+	start, end uint32
+}
+
+// isoLayer is udf volume of one layer of disk
+type isoLayer struct {
+	iso   *IsoDriver
+	start int64 // offset of layer in image
+	udf   *udf.Udf
+
+	partitionStart  uint32
+	partitionLength uint32
+	fsd             uint32 // partition relative
+	rootFE          uint32 // partition relative
+	lvid            uint32 // logical volume integrity descriptor, 0 if absent
+}
+
+func newIsoLayer(iso *IsoDriver, start int64) (*isoLayer, error) {
+	l := &isoLayer{iso: iso, start: start}
+	l.reload()
+	return l, l.parse()
+}
+
+func (l *isoLayer) reader() io.ReaderAt {
+	if l.start == 0 {
+		return l.iso.f
+	}
+	return io.NewSectionReader(l.iso.f, l.start, l.iso.f.Size()-l.start)
+}
+
+func (l *isoLayer) reload() {
+	l.udf = udf.NewUdfFromReader(l.reader())
+}
+
+// sector relative to layer start
+func (l *isoLayer) readSectors(sector, count uint32) ([]byte, error) {
+	b := make([]byte, int(count)*udf.SECTOR_SIZE)
+	if _, err := l.iso.f.ReadAt(b, l.start+int64(sector)*udf.SECTOR_SIZE); err != nil {
+		return nil, fmt.Errorf("[vfs] [iso] Failed to read sector %#x: %v", sector, err)
+	}
+	return b, nil
+}
+
+func (l *isoLayer) writeSectors(sector uint32, b []byte) error {
+	if _, err := l.iso.f.WriteAt(b, l.start+int64(sector)*udf.SECTOR_SIZE); err != nil {
+		return fmt.Errorf("[vfs] [iso] Failed to write sector %#x: %v", sector, err)
+	}
+	return nil
+}
+
+func (l *isoLayer) readPartitionSectors(sector, count uint32) ([]byte, error) {
+	return l.readSectors(l.partitionStart+sector, count)
+}
+
+func (l *isoLayer) writePartitionSectors(sector uint32, b []byte) error {
+	return l.writeSectors(l.partitionStart+sector, b)
+}
+
+// parse reads partition and root directory locations from volume descriptor sequence
+func (l *isoLayer) parse() error {
+	anchor, err := l.readSectors(256, 1)
+	if err != nil {
+		return err
+	}
+	if tag := binary.LittleEndian.Uint16(anchor); tag != udf.DESCRIPTOR_ANCHOR_VOLUME_POINTER {
+		return fmt.Errorf("[vfs] [iso] Anchor volume descriptor not found (tag %#x)", tag)
+	}
+
+	var lvd []byte
+	hasPartition := false
+	for sector := binary.LittleEndian.Uint32(anchor[20:]); ; sector++ {
+		desc, err := l.readSectors(sector, 1)
+		if err != nil {
+			return err
+		}
+		tag := binary.LittleEndian.Uint16(desc)
+		if tag == udf.DESCRIPTOR_TERMINATING || tag == 0 {
+			break
+		}
+		switch tag {
+		case udf.DESCRIPTOR_PARTITION:
+			l.partitionStart = binary.LittleEndian.Uint32(desc[188:])
+			l.partitionLength = binary.LittleEndian.Uint32(desc[192:])
+			hasPartition = true
+		case udf.DESCRIPTOR_LOGICAL_VOLUME:
+			lvd = desc
+		}
+	}
+	if !hasPartition || lvd == nil {
+		return fmt.Errorf("[vfs] [iso] Partition or logical volume descriptor not found")
+	}
+
+	if err := l.parseIntegrity(lvd); err != nil {
+		return err
+	}
+
+	// logical volume contents use is long_ad of file set descriptor
+	l.fsd = binary.LittleEndian.Uint32(lvd[248+4:])
+	fsd, err := l.readPartitionSectors(l.fsd, 1)
+	if err != nil {
+		return err
+	}
+	l.rootFE = binary.LittleEndian.Uint32(fsd[400+4:])
+	return nil
+}
+
+func (l *isoLayer) readFileEntry(location uint32) (fileEntry, error) {
+	b, err := l.readPartitionSectors(location, 1)
+	if err != nil {
+		return nil, err
+	}
+	fe := fileEntry(b)
+	return fe, fe.validate()
+}
+
+func (l *isoLayer) writeFileEntry(location uint32, fe fileEntry) error {
+	return l.writePartitionSectors(location, fe)
+}
+
+// readRootDirectory returns root file entry, stream of file identifiers and parsed identifiers
+func (l *isoLayer) readRootDirectory() (fileEntry, []byte, []fileIdentifier, error) {
+	fe, err := l.readFileEntry(l.rootFE)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	exts := fe.extents()
+	if len(exts) != 1 {
+		return nil, nil, nil, fmt.Errorf("[vfs] [iso] Root directory with %d extents not supported", len(exts))
+	}
+	data, err := l.readPartitionSectors(exts[0].start, exts[0].end-exts[0].start)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stream := data[:fe.size()]
+	fids, err := parseFileIdentifiers(stream)
+	return fe, data, fids, err
+}
+
+func findFileIdentifier(fids []fileIdentifier, name string) *fileIdentifier {
+	for i := range fids {
+		if fids[i].flags&(UDF_FID_PARENT|UDF_FID_DELETED) == 0 && strings.EqualFold(fids[i].name, name) {
+			return &fids[i]
+		}
+	}
+	return nil
+}
+
+// writeRootDirectory updates identifiers tags and stores directory stream with its file entry
+func (l *isoLayer) writeRootDirectory(fe fileEntry, data []byte, streamLength int) error {
+	location := fe.location()
+	fids, err := parseFileIdentifiers(data[:streamLength])
+	if err != nil {
+		return err
+	}
+	for _, fid := range fids {
+		udfTagUpdate(data[fid.offset:], location+uint32(fid.offset/udf.SECTOR_SIZE), fid.length)
+	}
+	if err := fe.setExtent(location, int64(streamLength)); err != nil {
+		return err
+	}
+	if err := l.writePartitionSectors(location, data); err != nil {
+		return err
+	}
+	return l.writeFileEntry(l.rootFE, fe)
+}
+
+// usedExtents returns sorted metadata and files extents, and start of files data area
+func (l *isoLayer) usedExtents(ignoreFile string) ([]extent, uint32, error) {
+	rootFE, _, fids, err := l.readRootDirectory()
+	if err != nil {
+		return nil, 0, err
+	}
+	used := []extent{{l.fsd, l.fsd + 1}, {l.rootFE, l.rootFE + 1}}
+	used = append(used, rootFE.extents()...)
+
+	dataStart := l.partitionLength
+	for _, fid := range fids {
+		if fid.flags&(UDF_FID_PARENT|UDF_FID_DELETED) != 0 {
+			continue
+		}
+		used = append(used, extent{fid.icb, fid.icb + 1})
+		if strings.EqualFold(fid.name, ignoreFile) {
+			continue
+		}
+		fe, err := l.readFileEntry(fid.icb)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, e := range fe.extents() {
+			used = append(used, e)
+			if e.start < dataStart {
+				dataStart = e.start
+			}
+		}
+	}
+
+	sort.Slice(used, func(i, j int) bool { return used[i].start < used[j].start })
+	return used, dataStart, nil
+}
+
+// findFreeSpace returns start of free sectors range located after start of files data.
+// Space before files data is reserved for metadata
+func findFreeSpace(used []extent, dataStart, limit, sectors uint32) (uint32, bool) {
+	pos := dataStart
+	for _, e := range used {
+		if e.end <= pos {
+			continue
+		}
+		if e.start >= pos && e.start-pos >= sectors {
+			return pos, true
+		}
+		pos = e.end
+	}
+	if pos < limit && limit-pos >= sectors {
+		return pos, true
+	}
+	return 0, false
+}
+
+// spaceAfter returns count of free sectors starting from location
+func spaceAfter(used []extent, location, limit uint32) uint32 {
+	end := limit
+	for _, e := range used {
+		if e.start >= location && e.start < end {
+			end = e.start
+		} else if e.start < location && e.end > location {
+			return 0
+		}
+	}
+	return end - location
+}
+
+// moveData copies sectors inside layer, ranges can overlap
+func (l *isoLayer) moveData(from, to uint32, size int64) error {
+	const chunkSectors = 256
+	sectors := sectorsCount(size)
+	forward := to < from
+
+	for done := uint32(0); done < sectors; {
+		count := sectors - done
+		if count > chunkSectors {
+			count = chunkSectors
+		}
+		offset := done
+		if !forward {
+			offset = sectors - done - count
+		}
+		b, err := l.readPartitionSectors(from+offset, count)
+		if err != nil {
+			return err
+		}
+		if err := l.writePartitionSectors(to+offset, b); err != nil {
+			return err
+		}
+		done += count
+	}
+	return nil
+}
+
+// resizeFile changes size of file, data is moved to free space if it not fits after file
+func (l *isoLayer) resizeFile(name string, newSize int64) error {
+	_, _, fids, err := l.readRootDirectory()
+	if err != nil {
+		return err
+	}
+	fid := findFileIdentifier(fids, name)
+	if fid == nil {
+		return fmt.Errorf("[vfs] [iso] File %q not found", name)
+	}
+	fe, err := l.readFileEntry(fid.icb)
+	if err != nil {
+		return err
+	}
+	oldLocation, oldSize := fe.location(), fe.size()
+
+	location := oldLocation
+	if sectorsCount(newSize) > sectorsCount(oldSize) {
+		used, dataStart, err := l.usedExtents(name)
+		if err != nil {
+			return err
+		}
+		if spaceAfter(used, oldLocation, l.partitionLength) < sectorsCount(newSize) {
+			newLocation, ok := findFreeSpace(used, dataStart, l.partitionLength, sectorsCount(newSize))
+			if !ok {
+				return fmt.Errorf("[vfs] [iso] No free space for %q (%d sectors) in layer", name, sectorsCount(newSize))
+			}
+			log.Printf("[vfs] [iso] Moving %q from sector %#x to %#x", name, oldLocation, newLocation)
+			if err := l.moveData(oldLocation, newLocation, oldSize); err != nil {
+				return err
+			}
+			location = newLocation
+		}
+	}
+
+	if err := fe.setExtent(location, newSize); err != nil {
+		return err
+	}
+	if err := l.writeFileEntry(fid.icb, fe); err != nil {
+		return err
+	}
+	l.reload()
+
+	return l.iso.updateIso9660Records(name, l.absoluteSector(location), newSize)
+}
+
+func (l *isoLayer) absoluteSector(location uint32) int64 {
+	return l.start/udf.SECTOR_SIZE + int64(l.partitionStart+location)
+}
+
+// addFile creates empty file in root directory, using existing file as template
+func (l *isoLayer) addFile(name string) error {
+	rootFE, data, fids, err := l.readRootDirectory()
+	if err != nil {
+		return err
+	}
+	var template *fileIdentifier
+	for i := range fids {
+		if fids[i].flags&(UDF_FID_PARENT|UDF_FID_DELETED) == 0 {
+			template = &fids[i]
+			break
+		}
+	}
+	if template == nil {
+		return fmt.Errorf("[vfs] [iso] Layer without files, nothing to use as template")
+	}
+
+	streamLength := int(rootFE.size())
+	fidData := data[template.offset : template.offset+template.length]
+	newFidLength := fileIdentifierLength(int(binary.LittleEndian.Uint16(fidData[36:])), len(name)+1)
+	if streamLength+newFidLength > len(data) {
+		return fmt.Errorf("[vfs] [iso] No space in root directory for new file identifier")
+	}
+
+	used, dataStart, err := l.usedExtents("")
+	if err != nil {
+		return err
+	}
+	feLocation, ok := findFreeSpace(used, dataStart, l.partitionLength, 1)
+	if !ok {
+		return fmt.Errorf("[vfs] [iso] No free space for file entry")
+	}
+
+	uniqueId := uint64(16) // lower ids reserved
+	for _, fid := range fids {
+		if fid.flags&UDF_FID_PARENT == 0 {
+			if fe, err := l.readFileEntry(fid.icb); err == nil && fe.uniqueId() >= uniqueId {
+				uniqueId = fe.uniqueId() + 1
+			}
+		}
+	}
+	if next, err := l.nextUniqueId(); err != nil {
+		return err
+	} else if next > uniqueId {
+		uniqueId = next
+	}
+
+	if err := l.iso.addIso9660Record(l, template.name, name, l.absoluteSector(feLocation+1)); err != nil {
+		return err
+	}
+
+	fe, err := l.readFileEntry(template.icb)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(fe[48:], 1) // link count
+	binary.LittleEndian.PutUint64(fe[160:], uniqueId)
+	binary.LittleEndian.PutUint32(fe[12:], feLocation)
+	// data location is not used while file is empty
+	if err := fe.setExtent(feLocation+1, 0); err != nil {
+		return err
+	}
+	if err := l.writeFileEntry(feLocation, fe); err != nil {
+		return err
+	}
+	if err := l.setNextUniqueId(uniqueId + 1); err != nil {
+		return err
+	}
+
+	copy(data[streamLength:], newFileIdentifier(fidData, name, feLocation, uniqueId))
+	if err := l.writeRootDirectory(rootFE, data, streamLength+newFidLength); err != nil {
+		return err
+	}
+	l.reload()
+	return nil
+}
+
+// removeFile removes file identifier from root directory, space of file become free
+func (l *isoLayer) removeFile(name string) error {
+	rootFE, data, fids, err := l.readRootDirectory()
+	if err != nil {
+		return err
+	}
+	fid := findFileIdentifier(fids, name)
+	if fid == nil {
+		return fmt.Errorf("[vfs] [iso] File %q not found", name)
+	}
+
+	streamLength := int(rootFE.size())
+	copy(data[fid.offset:], data[fid.offset+fid.length:streamLength])
+	for i := streamLength - fid.length; i < streamLength; i++ {
+		data[i] = 0
+	}
+	if err := l.writeRootDirectory(rootFE, data, streamLength-fid.length); err != nil {
+		return err
+	}
+	l.reload()
+
+	return l.iso.removeIso9660Records(name)
+}
@@ -0,0 +1,70 @@
+package iso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFindFreeSpace(t *testing.T) {
+	used := []extent{{10, 11}, {11, 12}, {20, 30}, {34, 40}, {45, 50}}
+
+	if pos, ok := findFreeSpace(used, 20, 100, 4); !ok || pos != 30 {
+		t.Errorf("Got %d %v, expected 30", pos, ok)
+	}
+	if pos, ok := findFreeSpace(used, 20, 100, 5); !ok || pos != 40 {
+		t.Errorf("Got %d %v, expected 40", pos, ok)
+	}
+	if pos, ok := findFreeSpace(used, 20, 100, 6); !ok || pos != 50 {
+		t.Errorf("Got %d %v, expected 50", pos, ok)
+	}
+	if _, ok := findFreeSpace(used, 20, 55, 6); ok {
+		t.Errorf("Found space beyond partition end")
+	}
+
+	if n := spaceAfter(used, 30, 100); n != 4 {
+		t.Errorf("Space after 30: %d, expected 4", n)
+	}
+	if n := spaceAfter(used, 25, 100); n != 0 {
+		t.Errorf("Space inside used extent: %d", n)
+	}
+	if n := spaceAfter(used, 50, 60); n != 10 {
+		t.Errorf("Space at partition end: %d, expected 10", n)
+	}
+}
+
+func TestFileEntrySetExtent(t *testing.T) {
+	fe := make(fileEntry, 2048)
+	binary.LittleEndian.PutUint16(fe[0:], UDF_TAG_FILE_ENTRY)
+	binary.LittleEndian.PutUint32(fe[12:], 0x50)
+	binary.LittleEndian.PutUint32(fe[168:], 0) // no extended attributes
+
+	size := int64(UDF_MAX_EXTENT_LENGTH) + 0x1000
+	if err := fe.setExtent(0x100, size); err != nil {
+		t.Fatal(err)
+	}
+	if err := fe.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if fe.size() != size || fe.location() != 0x100 {
+		t.Errorf("Wrong size %#x or location %#x", fe.size(), fe.location())
+	}
+	exts := fe.extents()
+	if len(exts) != 2 || exts[0].end != exts[1].start || exts[1].end-exts[0].start != sectorsCount(size) {
+		t.Errorf("Wrong extents %+v", exts)
+	}
+
+	// shrinking removes second descriptor
+	if err := fe.setExtent(0x100, 10); err != nil {
+		t.Fatal(err)
+	}
+	if exts := fe.extents(); len(exts) != 1 || exts[0] != (extent{0x100, 0x101}) {
+		t.Errorf("Wrong extents after shrink %+v", exts)
+	}
+	if !bytes.Equal(fe[176+8:176+16], make([]byte, 8)) {
+		t.Errorf("Old descriptor not cleared")
+	}
+	if crc := binary.LittleEndian.Uint16(fe[8:]); crc != udfCrc(fe[16:176+8]) {
+		t.Errorf("Wrong tag crc %#x", crc)
+	}
+}
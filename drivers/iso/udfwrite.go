@@ -0,0 +1,242 @@
+package iso
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mogaika/udf"
+)
+
+const (
+	UDF_TAG_LOGICAL_VOLUME_INTEGRITY = 0x9
+	UDF_TAG_FILE_IDENTIFIER          = 0x101
+	UDF_TAG_FILE_ENTRY               = 0x105
+
+	UDF_FID_DELETED = 0x04
+	UDF_FID_PARENT  = 0x08
+
+	// maximum length of extent, aligned to sector
+	UDF_MAX_EXTENT_LENGTH = 0x3ffff800
+)
+
+// crc-itu-t used by descriptor tags
+func udfCrc(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// udfTagUpdate recalculates descriptor tag location, crc and checksum
+func udfTagUpdate(b []byte, location uint32, descriptorLength int) {
+	crcLength := descriptorLength - 16
+	binary.LittleEndian.PutUint32(b[12:], location)
+	binary.LittleEndian.PutUint16(b[10:], uint16(crcLength))
+	binary.LittleEndian.PutUint16(b[8:], udfCrc(b[16:16+crcLength]))
+
+	var sum byte
+	for i := 0; i < 16; i++ {
+		if i != 4 {
+			sum += b[i]
+		}
+	}
+	b[4] = sum
+}
+
+func sectorsCount(size int64) uint32 {
+	return uint32((size + udf.SECTOR_SIZE - 1) / udf.SECTOR_SIZE)
+}
+
+// udf file entry sector
+type fileEntry []byte
+
+func (fe fileEntry) allocStart() int {
+	return 176 + int(binary.LittleEndian.Uint32(fe[168:]))
+}
+
+func (fe fileEntry) validate() error {
+	if tag := binary.LittleEndian.Uint16(fe[0:]); tag != UDF_TAG_FILE_ENTRY {
+		return fmt.Errorf("Unsupported file entry tag %#x", tag)
+	}
+	// icb tag flags, allocation type
+	if flags := binary.LittleEndian.Uint16(fe[16+18:]); flags&7 != 0 {
+		return fmt.Errorf("Only short allocation descriptors supported (flags %#x)", flags)
+	}
+	return nil
+}
+
+func (fe fileEntry) location() uint32 {
+	return binary.LittleEndian.Uint32(fe[fe.allocStart()+4:])
+}
+
+func (fe fileEntry) size() int64 {
+	return int64(binary.LittleEndian.Uint64(fe[56:]))
+}
+
+func (fe fileEntry) uniqueId() uint64 {
+	return binary.LittleEndian.Uint64(fe[160:])
+}
+
+// extents returns used partition sectors of all allocation descriptors
+func (fe fileEntry) extents() []extent {
+	start := fe.allocStart()
+	count := int(binary.LittleEndian.Uint32(fe[172:])) / 8
+	result := make([]extent, 0, count)
+	for i := 0; i < count; i++ {
+		ad := fe[start+i*8:]
+		// upper bits are extent type
+		length := binary.LittleEndian.Uint32(ad) & 0x3fffffff
+		location := binary.LittleEndian.Uint32(ad[4:])
+		if length != 0 {
+			result = append(result, extent{location, location + sectorsCount(int64(length))})
+		}
+	}
+	return result
+}
+
+// setExtent places file data to continuous space, splitting it into maximum sized extents
+func (fe fileEntry) setExtent(location uint32, size int64) error {
+	start := fe.allocStart()
+	ads := make([][2]uint32, 0, 1)
+	for left, loc := size, location; len(ads) == 0 || left > 0; {
+		length := left
+		if length > UDF_MAX_EXTENT_LENGTH {
+			length = UDF_MAX_EXTENT_LENGTH
+		}
+		ads = append(ads, [2]uint32{uint32(length), loc})
+		left -= length
+		loc += sectorsCount(length)
+	}
+	if start+len(ads)*8 > len(fe) {
+		return fmt.Errorf("Too many allocation descriptors (%d) for file entry", len(ads))
+	}
+
+	oldEnd := start + int(binary.LittleEndian.Uint32(fe[172:]))
+	for i := start; i < oldEnd && i < len(fe); i++ {
+		fe[i] = 0
+	}
+	for i, ad := range ads {
+		binary.LittleEndian.PutUint32(fe[start+i*8:], ad[0])
+		binary.LittleEndian.PutUint32(fe[start+i*8+4:], ad[1])
+	}
+	binary.LittleEndian.PutUint32(fe[172:], uint32(len(ads)*8))
+	binary.LittleEndian.PutUint64(fe[56:], uint64(size))
+	binary.LittleEndian.PutUint64(fe[64:], uint64(sectorsCount(size)))
+
+	udfTagUpdate(fe, binary.LittleEndian.Uint32(fe[12:]), start+len(ads)*8)
+	return nil
+}
+
+// udf file identifier descriptor inside directory stream
+type fileIdentifier struct {
+	offset int
+	length int
+	name   string
+	flags  uint8
+	icb    uint32 // partition relative location of file entry
+}
+
+func fileIdentifierLength(implUseLength, nameLength int) int {
+	return 4 * ((38 + implUseLength + nameLength + 3) / 4)
+}
+
+func parseFileIdentifiers(stream []byte) ([]fileIdentifier, error) {
+	result := make([]fileIdentifier, 0, 32)
+	for off := 0; off+38 <= len(stream); {
+		if tag := binary.LittleEndian.Uint16(stream[off:]); tag != UDF_TAG_FILE_IDENTIFIER {
+			return nil, fmt.Errorf("Unexpected tag %#x in directory at %#x", tag, off)
+		}
+		fid := udf.NewFileIdentifierDescriptor(stream[off:])
+		length := fileIdentifierLength(int(fid.LengthOfImplementationUse), int(fid.LengthOfFileIdentifier))
+		result = append(result, fileIdentifier{
+			offset: off,
+			length: length,
+			name:   fid.FileIdentifier,
+			flags:  fid.FileCharacteristics,
+			icb:    binary.LittleEndian.Uint32(stream[off+24:]),
+		})
+		off += length
+	}
+	return result, nil
+}
+
+// newFileIdentifier creates descriptor based on template with other name and file entry location
+func newFileIdentifier(template []byte, name string, icb uint32, uniqueId uint64) []byte {
+	implUseLength := int(binary.LittleEndian.Uint16(template[36:]))
+	length := fileIdentifierLength(implUseLength, len(name)+1)
+
+	b := make([]byte, length)
+	copy(b, template[:38+implUseLength])
+	b[18] = 0 // regular file
+	b[19] = uint8(len(name) + 1)
+	binary.LittleEndian.PutUint32(b[24:], icb)
+	// udf unique id inside implementation use of long_ad
+	binary.LittleEndian.PutUint32(b[32:], uint32(uniqueId))
+	b[38+implUseLength] = 8 // 8 bit characters
+	copy(b[38+implUseLength+1:], name)
+	return b
+}
+
+// parseIntegrity finds last logical volume integrity descriptor of
+// integrity sequence referenced by logical volume descriptor
+func (l *isoLayer) parseIntegrity(lvd []byte) error {
+	length := binary.LittleEndian.Uint32(lvd[432:])
+	location := binary.LittleEndian.Uint32(lvd[436:])
+	for sector := location; sector < location+sectorsCount(int64(length)); sector++ {
+		desc, err := l.readSectors(sector, 1)
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint16(desc) != UDF_TAG_LOGICAL_VOLUME_INTEGRITY {
+			break
+		}
+		l.lvid = sector
+	}
+	return nil
+}
+
+// lvidLength returns length of integrity descriptor with
+// free space and size tables of every partition and implementation use
+func lvidLength(lvid []byte) int {
+	partitions := int(binary.LittleEndian.Uint32(lvid[72:]))
+	return 80 + 8*partitions + int(binary.LittleEndian.Uint32(lvid[76:]))
+}
+
+// nextUniqueId returns unique id for new file recorded in integrity descriptor,
+// 0 if layer has no integrity descriptor
+func (l *isoLayer) nextUniqueId() (uint64, error) {
+	if l.lvid == 0 {
+		return 0, nil
+	}
+	lvid, err := l.readSectors(l.lvid, 1)
+	if err != nil {
+		return 0, err
+	}
+	// logical volume header descriptor in contents use
+	return binary.LittleEndian.Uint64(lvid[40:]), nil
+}
+
+func (l *isoLayer) setNextUniqueId(id uint64) error {
+	if l.lvid == 0 {
+		return nil
+	}
+	lvid, err := l.readSectors(l.lvid, 1)
+	if err != nil {
+		return err
+	}
+	length := lvidLength(lvid)
+	if length > udf.SECTOR_SIZE {
+		return fmt.Errorf("[vfs] [iso] Integrity descriptor of %d bytes not supported", length)
+	}
+	binary.LittleEndian.PutUint64(lvid[40:], id)
+	udfTagUpdate(lvid, l.lvid, length)
+	return l.writeSectors(l.lvid, lvid)
+}
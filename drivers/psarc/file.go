@@ -5,6 +5,8 @@ import (
 	"compress/zlib"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -13,6 +15,12 @@ import (
 	"github.com/mogaika/god_of_war_browser/vfs"
 )
 
+// isZlibBlock reports if block stored with size starts with zlib header,
+// other blocks are stored uncompressed
+func isZlibBlock(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x78 && b[1] == 0xda
+}
+
 type File struct {
 	p   *Psarc
 	e   Entry
@@ -23,6 +31,7 @@ func (f *File) initBuf() error {
 	buf := &bytes.Buffer{}
 
 	if f.e.OriginalSize == 0 {
+		f.buf = buf
 		return nil
 	}
 
@@ -56,13 +65,12 @@ func (f *File) initBuf() error {
 				if _, err := buf.Write(compressedBlock); err != nil {
 					panic(err)
 				}
-			} else {
-				// TODO: improve something here pls
-				if compressedBlock[0] != 0x78 || compressedBlock[1] != 0xda {
-					panic(fmt.Sprintf("incorrect compression header: 0x%x 0x%x 0x%x 0x%x",
-						compressedBlock[0], compressedBlock[1], compressedBlock[2], compressedBlock[3]))
+			} else if !isZlibBlock(compressedBlock) {
+				// block stored uncompressed, because compression not reduced size
+				if _, err := buf.Write(compressedBlock); err != nil {
+					panic(err)
 				}
-
+			} else {
 				if zr, err := zlib.NewReader(bytes.NewReader(compressedBlock)); err != nil {
 					panic(err)
 				} else {
@@ -91,11 +99,22 @@ func (f *File) IsDirectory() bool         { return false }
 
 // interface vfs.File
 func (f *File) Size() int64 { return f.e.OriginalSize }
-func (f *File) Open(readonly bool) error {
-	if readonly != true {
-		return fmt.Errorf("[psarc.File.Open] write not supported")
+func (f *File) ModTime() (time.Time, error) {
+	if t, ok := vfs.GetModTime(f.p.f); ok {
+		return t, nil
 	}
+	return time.Time{}, errors.Errorf("[psarc] Modification time of archive is unknown")
+}
+func (f *File) Open(readonly bool) error {
 	if f.buf == nil {
+		f.p.lock.RLock()
+		defer f.p.lock.RUnlock()
+		// archive can be rebuilt after element was taken
+		e := f.p.lookup(f.e.Name)
+		if e == nil {
+			return errors.Errorf("[psarc] File '%s' removed from archive", f.e.Name)
+		}
+		f.e = *e
 		return f.initBuf()
 	} else {
 		return nil
@@ -112,8 +131,38 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	return copy(b, f.buf.Bytes()[off:]), nil
 }
 func (f *File) Copy(src io.Reader) error {
-	return errors.Errorf("PSARC read-only")
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("[psarc] File Copy(..) ioutil.ReadAll: %v", err)
+	}
+	return f.update(b)
 }
+
+// WriteAt rewrites whole archive, so Copy preferred
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0, errors.Errorf("PSARC read-only")
+	if f.buf == nil {
+		return 0, errors.Errorf("[psarc] First you need to open file")
+	}
+	data := f.buf.Bytes()
+	if end := off + int64(len(b)); end > int64(len(data)) {
+		data = append(data, make([]byte, end-int64(len(data)))...)
+	}
+	copy(data[off:], b)
+	if err := f.update(data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (f *File) update(b []byte) error {
+	if err := f.p.UpdateFile(f.e.Name, b); err != nil {
+		return err
+	}
+	f.p.lock.RLock()
+	f.e = *f.p.entry(f.e.Name)
+	f.p.lock.RUnlock()
+	if f.buf != nil {
+		f.buf = bytes.NewBuffer(b)
+	}
+	return nil
 }
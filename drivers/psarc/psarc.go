@@ -2,13 +2,13 @@ package psarc
 
 import (
 	"bytes"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/mogaika/god_of_war_browser/utils"
 	"github.com/mogaika/god_of_war_browser/vfs"
@@ -20,6 +20,10 @@ type Psarc struct {
 	r          *io.SectionReader
 	blockSizes []uint32
 	entries    []Entry
+
+	// guards archive state, rebuild replaces archive file and entries
+	// while other goroutines read files
+	lock sync.RWMutex
 }
 
 func (p *Psarc) parseHeader() error {
@@ -46,13 +50,7 @@ func (p *Psarc) parseEntries() error {
 }
 
 func (p *Psarc) parseBlockSizes() error {
-	blockStorageLen := 2
-	if p.h.BlockSize > 0x10000 {
-		blockStorageLen = 3
-		if p.h.BlockSize > 0x1000000 {
-			blockStorageLen = 4
-		}
-	}
+	blockStorageLen := p.h.blockSizeWidth()
 
 	sizesStartOffset := RAW_HEADER_SIZE + RAW_ENTRY_SIZE*int64(p.h.NumFiles)
 	sizesBufLen := int64(p.h.TotalTOCSize) - sizesStartOffset
@@ -81,47 +79,49 @@ func (p *Psarc) parseBlockSizes() error {
 func (p *Psarc) parseManifest() error {
 	p.entries[0].Name = "manifest"
 
-	zr, err := zlib.NewReader(
-		io.NewSectionReader(p.r, p.entries[0].StartOffset, p.entries[1].StartOffset-p.entries[0].StartOffset))
-	if err != nil {
+	// manifest stored as usual file
+	manifest := &File{e: p.entries[0], p: p}
+	if err := manifest.initBuf(); err != nil {
 		return err
 	}
-	defer zr.Close()
 
-	if rawManifest, err := ioutil.ReadAll(zr); err != nil {
-		return err
-	} else {
-		b := bytes.NewBuffer(rawManifest)
-		for i := 1; i < int(p.h.NumFiles); i++ {
-			name, _ := b.ReadString('\n')
-			p.entries[i].Name = strings.TrimSuffix(strings.TrimPrefix(name, "/"), "\n")
-			p.entries[i].Name = strings.Replace(p.entries[i].Name, "/", "_", -1)
-		}
+	b := bytes.NewBuffer(manifest.buf.Bytes())
+	for i := 1; i < int(p.h.NumFiles); i++ {
+		name, _ := b.ReadString('\n')
+		p.entries[i].Path = strings.TrimSuffix(name, "\n")
+		p.entries[i].Name = strings.Replace(strings.TrimPrefix(p.entries[i].Path, "/"), "/", "_", -1)
 	}
 
 	return nil
 }
 
-func NewPsarcDriver(f vfs.File) (*Psarc, error) {
-	p := &Psarc{f: f}
-	if r, err := f.Reader(); err != nil {
-		return nil, err
+func (p *Psarc) load() error {
+	if r, err := p.f.Reader(); err != nil {
+		return err
 	} else {
 		p.r = r
 	}
 	if err := p.parseHeader(); err != nil {
-		return nil, err
+		return err
 	}
 	if p.h.CompressionMethod[0] != 0x7a {
-		return nil, fmt.Errorf("Only zlib compression supported (%#+v)", p.h.CompressionMethod)
+		return fmt.Errorf("Only zlib compression supported (%#+v)", p.h.CompressionMethod)
 	}
 	if err := p.parseEntries(); err != nil {
-		return nil, err
+		return err
 	}
 	if err := p.parseBlockSizes(); err != nil {
-		return nil, err
+		return err
 	}
 	if err := p.parseManifest(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func NewPsarcDriver(f vfs.File) (*Psarc, error) {
+	p := &Psarc{f: f}
+	if err := p.load(); err != nil {
 		return nil, err
 	}
 	return p, nil
@@ -133,10 +133,65 @@ func (p *Psarc) Name() string              { return p.f.Name() }
 func (p *Psarc) IsDirectory() bool         { return true }
 
 // interface vfs.Directory
-func (p *Psarc) Add(e vfs.Element) error  { panic("read-only") }
-func (p *Psarc) Remove(name string) error { panic("read-only") }
+
+// Add creates file in archive root. If element is vfs.File, then content copied
+func (p *Psarc) Add(e vfs.Element) error {
+	if e.IsDirectory() {
+		return fmt.Errorf("[psarc] Directories not supported")
+	}
+
+	var data []byte
+	if f, ok := e.(vfs.File); ok {
+		r, err := vfs.OpenFileAndGetReader(f, true)
+		if err != nil {
+			return fmt.Errorf("[psarc] Cannot open source file: %v", err)
+		}
+		data, err = ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("[psarc] Cannot read source file: %v", err)
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.entry(e.Name()) != nil {
+		return fmt.Errorf("[psarc] File '%s' already exists", e.Name())
+	}
+	return p.rebuild(e.Name(), data, false)
+}
+
+func (p *Psarc) Remove(name string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.entry(name) == nil {
+		return fmt.Errorf("[psarc] Cannot find file '%s' in archive", name)
+	}
+	return p.rebuild(name, nil, true)
+}
+
+// UpdateFile replaces content of file and rewrites archive
+func (p *Psarc) UpdateFile(name string, b []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.entry(name) == nil {
+		return fmt.Errorf("[psarc] Cannot find file '%s' in archive", name)
+	}
+	return p.rebuild(name, b, false)
+}
+
+func (p *Psarc) entry(name string) *Entry {
+	for i := 1; i < len(p.entries); i++ {
+		if p.entries[i].Name == name {
+			return &p.entries[i]
+		}
+	}
+	return nil
+}
 
 func (p *Psarc) List() ([]string, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	result := make([]string, 0, p.h.NumFiles)
 	for i := range p.entries {
 		result = append(result, p.entries[i].Name)
@@ -145,10 +200,20 @@ func (p *Psarc) List() ([]string, error) {
 }
 
 func (p *Psarc) GetElement(name string) (vfs.Element, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if e := p.lookup(name); e != nil {
+		return &File{e: *e, p: p}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// lookup is entry which also finds manifest
+func (p *Psarc) lookup(name string) *Entry {
 	for i := range p.entries {
 		if p.entries[i].Name == name {
-			return &File{e: p.entries[i], p: p}, nil
+			return &p.entries[i]
 		}
 	}
-	return nil, os.ErrNotExist
+	return nil
 }
@@ -0,0 +1,274 @@
+package psarc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+func writeEmptyArchive(t *testing.T, path string, blockSize uint32) {
+	h := Header{
+		MagicNumber:   0x50534152,
+		VersionNumber: 0x00010004,
+		TotalTOCSize:  RAW_HEADER_SIZE + RAW_ENTRY_SIZE,
+		TOCEntrySize:  RAW_ENTRY_SIZE,
+		NumFiles:      1,
+		BlockSize:     blockSize,
+	}
+	copy(h.CompressionMethod[:], "zlib")
+	b := make([]byte, h.TotalTOCSize)
+	h.ToBuf(b)
+	(&Entry{StartOffset: int64(h.TotalTOCSize)}).ToBuf(b[RAW_HEADER_SIZE:])
+	if err := ioutil.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readArchiveFile(t *testing.T, p *Psarc, name string) []byte {
+	f, err := vfs.DirectoryGetFile(p, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := vfs.OpenFileAndGetReader(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRebuild(t *testing.T) {
+	// block sizes stored in 2, 3 and 4 bytes
+	for _, blockSize := range []uint32{0x10000, 0x20000, 0x2000000} {
+		t.Run(fmt.Sprintf("%#x", blockSize), func(t *testing.T) {
+			testRebuild(t, blockSize)
+		})
+	}
+}
+
+func testRebuild(t *testing.T, blockSize uint32) {
+	dir, err := ioutil.TempDir("", "psarc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "data.psarc")
+	writeEmptyArchive(t, archivePath, blockSize)
+	af := vfs.NewDirectoryDriverFile(archivePath)
+	if err := af.Open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	p, err := NewPsarcDriver(af)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// random data stored in uncompressed blocks, repeated one compressed
+	random := make([]byte, 0x10000*2+100)
+	rand.New(rand.NewSource(1)).Read(random)
+	repeated := bytes.Repeat([]byte("god of war "), 10000)
+
+	for name, data := range map[string][]byte{"R_A.WAD": random, "R_B.WAD": repeated, "EMPTY.TXT": {}} {
+		src := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(src, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Add(vfs.NewDirectoryDriverFile(src)); err != nil {
+			t.Fatalf("Add %s: %v", name, err)
+		}
+	}
+
+	if !bytes.Equal(readArchiveFile(t, p, "R_A.WAD"), random) {
+		t.Errorf("Uncompressed blocks content mismatch")
+	}
+
+	f, err := vfs.DirectoryGetFile(p, "R_A.WAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vfs.OpenFileAndCopy(f, bytes.NewReader(repeated[:500])); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Remove("EMPTY.TXT"); err != nil {
+		t.Fatal(err)
+	}
+
+	// read from disk again
+	af2 := vfs.NewDirectoryDriverFile(archivePath)
+	if err := af2.Open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer af2.Close()
+	p2, err := NewPsarcDriver(af2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := p2.List(); !reflect.DeepEqual(list[1:], []string{"R_A.WAD", "R_B.WAD"}) &&
+		!reflect.DeepEqual(list[1:], []string{"R_B.WAD", "R_A.WAD"}) {
+		t.Errorf("Unexpected files %v", list)
+	}
+	if !bytes.Equal(readArchiveFile(t, p2, "R_A.WAD"), repeated[:500]) {
+		t.Errorf("Replaced file content mismatch")
+	}
+	if !bytes.Equal(readArchiveFile(t, p2, "R_B.WAD"), repeated) {
+		t.Errorf("Copied file content mismatch")
+	}
+	if e := p2.entry("R_B.WAD"); e.Path != "/R_B.WAD" || e.MD5 != p2.pathHash("/R_B.WAD") {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if w := p2.h.blockSizeWidth(); (blockSize > 0x10000) != (w > 2) {
+		t.Errorf("Unexpected block size width %d", w)
+	}
+
+	// temp files renamed into place
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".tmp") {
+			t.Errorf("Temp file %s left in directory", fi.Name())
+		}
+	}
+}
+
+// nonLocalFile hides path of archive file
+type nonLocalFile struct {
+	vfs.File
+}
+
+func TestRebuildNonLocalFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psarc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "data.psarc")
+	writeEmptyArchive(t, archivePath, 0x10000)
+	original, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	af := vfs.NewDirectoryDriverFile(archivePath)
+	if err := af.Open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	p, err := NewPsarcDriver(&nonLocalFile{af})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "R_A.WAD")
+	if err := ioutil.WriteFile(src, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(vfs.NewDirectoryDriverFile(src)); err == nil {
+		t.Errorf("Archive without path rebuilt")
+	}
+	if data, _ := ioutil.ReadFile(archivePath); !bytes.Equal(data, original) {
+		t.Errorf("Archive changed after failed rebuild")
+	}
+}
+
+// files listed and read by one goroutine while other rebuilds archive, run with -race
+func TestConcurrentUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psarc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "data.psarc")
+	writeEmptyArchive(t, archivePath, 0x10000)
+	af := vfs.NewDirectoryDriverFile(archivePath)
+	if err := af.Open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	p, err := NewPsarcDriver(af)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "R_A.WAD")
+	if err := ioutil.WriteFile(src, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(vfs.NewDirectoryDriverFile(src)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			names, _ := p.List()
+			for _, name := range names {
+				if f, err := vfs.DirectoryGetFile(p, name); err == nil {
+					if err := f.Open(true); err != nil {
+						t.Errorf("Failed to open %s: %v", name, err)
+					}
+					f.Close()
+				}
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := p.UpdateFile("R_A.WAD", bytes.Repeat([]byte{byte(i)}, 100+i)); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+	}
+	<-done
+}
+
+// not full block of random data which starts with zlib header
+// must not be stored as is, reader will try to decompress it
+func TestRawBlockWithZlibHeader(t *testing.T) {
+	defer config.SetPlayStationVersion(config.GetPlayStationVersion())
+	config.SetPlayStationVersion(config.PS3)
+
+	dir, err := ioutil.TempDir("", "psarc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "data.psarc")
+	writeEmptyArchive(t, archivePath, 0x10000)
+	af := vfs.NewDirectoryDriverFile(archivePath)
+	if err := af.Open(true); err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	p, err := NewPsarcDriver(af)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 0x10000+1000)
+	rand.New(rand.NewSource(2)).Read(data)
+	copy(data[0x10000:], []byte{0x78, 0xda})
+	src := filepath.Join(dir, "R_A.WAD")
+	if err := ioutil.WriteFile(src, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(vfs.NewDirectoryDriverFile(src)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readArchiveFile(t, p, "R_A.WAD"), data) {
+		t.Errorf("Content of file mismatch")
+	}
+}
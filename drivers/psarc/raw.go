@@ -9,6 +9,8 @@ import (
 const (
 	RAW_HEADER_SIZE = 0x20
 	RAW_ENTRY_SIZE  = 30
+
+	ARCHIVE_FLAG_IGNORE_CASE = 1
 )
 
 type Header struct {
@@ -33,12 +35,36 @@ func (h *Header) FromBuf(b []byte) {
 	h.ArchiveFlags = binary.BigEndian.Uint32(b[0x1c:])
 }
 
+func (h *Header) ToBuf(b []byte) {
+	binary.BigEndian.PutUint32(b[0:], h.MagicNumber)
+	binary.BigEndian.PutUint32(b[4:], h.VersionNumber)
+	copy(b[8:0xc], h.CompressionMethod[:])
+	binary.BigEndian.PutUint32(b[0xc:], h.TotalTOCSize)
+	binary.BigEndian.PutUint32(b[0x10:], h.TOCEntrySize)
+	binary.BigEndian.PutUint32(b[0x14:], h.NumFiles)
+	binary.BigEndian.PutUint32(b[0x18:], h.BlockSize)
+	binary.BigEndian.PutUint32(b[0x1c:], h.ArchiveFlags)
+}
+
+// blockSizeWidth returns size of block table element,
+// enough to store size of compressed block
+func (h *Header) blockSizeWidth() int {
+	if h.BlockSize > 0x1000000 {
+		return 4
+	} else if h.BlockSize > 0x10000 {
+		return 3
+	}
+	return 2
+}
+
 type Entry struct {
 	MD5            [16]byte
 	BlockListStart uint32
 	OriginalSize   int64
 	StartOffset    int64
 	Name           string
+	// path stored in manifest, Name is path with '/' replaced by '_'
+	Path string
 }
 
 func (e *Entry) FromBuf(b []byte) {
@@ -47,3 +73,10 @@ func (e *Entry) FromBuf(b []byte) {
 	e.OriginalSize = int64(utils.Read40bitUint(binary.BigEndian, b[20:]))
 	e.StartOffset = int64(utils.Read40bitUint(binary.BigEndian, b[25:]))
 }
+
+func (e *Entry) ToBuf(b []byte) {
+	copy(b[:16], e.MD5[:])
+	binary.BigEndian.PutUint32(b[16:], e.BlockListStart)
+	utils.Write40bitUint(binary.BigEndian, b[20:], uint64(e.OriginalSize))
+	utils.Write40bitUint(binary.BigEndian, b[25:], uint64(e.StartOffset))
+}
@@ -0,0 +1,272 @@
+package psarc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+// localFile is archive file stored on disk, rebuilt archive
+// written next to it and renamed into place
+type localFile interface {
+	Path() string
+}
+
+// newEntry is entry of rebuilt archive. Compressed blocks of
+// unchanged entries copied from original archive as is
+type newEntry struct {
+	e          Entry
+	blockSizes []uint32
+	// nil if data copied from original archive
+	data [][]byte
+}
+
+func (p *Psarc) blockSize(size uint32) int64 {
+	if size == 0 {
+		return int64(p.h.BlockSize)
+	}
+	return int64(size)
+}
+
+func (p *Psarc) blocksCount(size int64) int {
+	return int((size + int64(p.h.BlockSize) - 1) / int64(p.h.BlockSize))
+}
+
+// compressBlocks splits data to blocks, block stored without compression
+// if compressed one is not smaller and reader will not take it as compressed
+func (p *Psarc) compressBlocks(data []byte) ([]uint32, [][]byte, error) {
+	sizes := make([]uint32, 0, p.blocksCount(int64(len(data))))
+	blocks := make([][]byte, 0, cap(sizes))
+	for start := 0; start < len(data); start += int(p.h.BlockSize) {
+		end := start + int(p.h.BlockSize)
+		if end > len(data) {
+			end = len(data)
+		}
+		raw := data[start:end]
+
+		var buf bytes.Buffer
+		zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := zw.Write(raw); err != nil {
+			return nil, nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, nil, err
+		}
+
+		block := buf.Bytes()
+		// not full blocks starting with zlib header are decompressed by reader,
+		// except last block of vita archives which read as is
+		if len(block) >= len(raw) && (len(raw) == int(p.h.BlockSize) || !isZlibBlock(raw) ||
+			config.GetPlayStationVersion() == config.PSVita) {
+			block = raw
+		} else if len(block) >= int(p.h.BlockSize) {
+			return nil, nil, fmt.Errorf("[psarc] Block at 0x%x starts with zlib header and cannot be compressed", start)
+		}
+		if len(block) == int(p.h.BlockSize) {
+			// zero means full size uncompressed block
+			sizes = append(sizes, 0)
+		} else {
+			sizes = append(sizes, uint32(len(block)))
+		}
+		blocks = append(blocks, block)
+	}
+	return sizes, blocks, nil
+}
+
+func (p *Psarc) pathHash(path string) [16]byte {
+	if p.h.ArchiveFlags&ARCHIVE_FLAG_IGNORE_CASE != 0 {
+		path = strings.ToUpper(path)
+	}
+	return md5.Sum([]byte(path))
+}
+
+// newPath returns manifest path for added file,
+// using same root prefix as other files of archive
+func (p *Psarc) newPath(name string) string {
+	if len(p.entries) > 1 && !strings.HasPrefix(p.entries[1].Path, "/") {
+		return name
+	}
+	return "/" + name
+}
+
+func isSortedByHash(entries []Entry) bool {
+	return sort.SliceIsSorted(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].MD5[:], entries[j].MD5[:]) < 0
+	})
+}
+
+// rebuild writes new archive with file replaced, added or removed
+func (p *Psarc) rebuild(name string, data []byte, remove bool) error {
+	entries := make([]*newEntry, 0, len(p.entries)+1)
+	found := false
+	for i := 1; i < len(p.entries); i++ {
+		e := p.entries[i]
+		if e.Name == name {
+			found = true
+			if remove {
+				continue
+			}
+			ne := &newEntry{e: e}
+			ne.e.OriginalSize = int64(len(data))
+			var err error
+			if ne.blockSizes, ne.data, err = p.compressBlocks(data); err != nil {
+				return fmt.Errorf("[psarc] Failed to compress '%s': %v", name, err)
+			}
+			entries = append(entries, ne)
+		} else {
+			count := p.blocksCount(e.OriginalSize)
+			entries = append(entries, &newEntry{
+				e:          e,
+				blockSizes: p.blockSizes[e.BlockListStart : int(e.BlockListStart)+count],
+			})
+		}
+	}
+
+	if !found {
+		if remove {
+			return fmt.Errorf("[psarc] Cannot find file '%s' in archive", name)
+		}
+		ne := &newEntry{e: Entry{Name: name, Path: p.newPath(name), OriginalSize: int64(len(data))}}
+		ne.e.MD5 = p.pathHash(ne.e.Path)
+		var err error
+		if ne.blockSizes, ne.data, err = p.compressBlocks(data); err != nil {
+			return fmt.Errorf("[psarc] Failed to compress '%s': %v", name, err)
+		}
+
+		// keep order if entries sorted by hash, game may use binary search
+		pos := len(entries)
+		if isSortedByHash(p.entries[1:]) {
+			pos = sort.Search(len(entries), func(i int) bool {
+				return bytes.Compare(entries[i].e.MD5[:], ne.e.MD5[:]) > 0
+			})
+		}
+		entries = append(entries[:pos], append([]*newEntry{ne}, entries[pos:]...)...)
+	}
+
+	paths := make([]string, len(entries))
+	for i, ne := range entries {
+		paths[i] = ne.e.Path
+	}
+	manifestData := []byte(strings.Join(paths, "\n"))
+	manifest := &newEntry{e: p.entries[0]}
+	manifest.e.OriginalSize = int64(len(manifestData))
+	var err error
+	if manifest.blockSizes, manifest.data, err = p.compressBlocks(manifestData); err != nil {
+		return fmt.Errorf("[psarc] Failed to compress manifest: %v", err)
+	}
+	entries = append([]*newEntry{manifest}, entries...)
+
+	pf, ok := p.f.(localFile)
+	if !ok {
+		return fmt.Errorf("[psarc] Archive '%s' is not a local file, cannot replace it safely", p.f.Name())
+	}
+	path := pf.Path()
+
+	// temp file created next to archive so rename will not cross filesystems
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("[psarc] Cannot create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := p.writeArchive(tmp, entries); err != nil {
+		tmp.Close()
+		return fmt.Errorf("[psarc] Failed to write archive: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("[psarc] Failed to sync archive: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("[psarc] Failed to close archive: %v", err)
+	}
+
+	log.Printf("[psarc] Rewriting archive '%s' with %d files", p.f.Name(), len(entries)-1)
+	// archive closed before rename, windows does not allow to replace opened file
+	p.f.Close()
+	renameErr := os.Rename(tmp.Name(), path)
+	if err := p.f.Open(true); err != nil {
+		return fmt.Errorf("[psarc] Failed to reopen archive: %v", err)
+	}
+	if renameErr != nil {
+		return fmt.Errorf("[psarc] Failed to replace archive: %v", renameErr)
+	}
+	return p.load()
+}
+
+func (p *Psarc) writeArchive(w io.Writer, entries []*newEntry) error {
+	width := p.h.blockSizeWidth()
+	blocksCount := 0
+	for _, ne := range entries {
+		blocksCount += len(ne.blockSizes)
+	}
+
+	h := p.h
+	h.NumFiles = uint32(len(entries))
+	h.TotalTOCSize = uint32(RAW_HEADER_SIZE + RAW_ENTRY_SIZE*len(entries) + width*blocksCount)
+
+	toc := make([]byte, h.TotalTOCSize)
+	h.ToBuf(toc)
+
+	blockIndex := 0
+	offset := int64(h.TotalTOCSize)
+	for i, ne := range entries {
+		// ne.e keeps position in original archive to copy data
+		e := ne.e
+		e.BlockListStart = uint32(blockIndex)
+		e.StartOffset = offset
+		e.ToBuf(toc[RAW_HEADER_SIZE+RAW_ENTRY_SIZE*i:])
+
+		for _, size := range ne.blockSizes {
+			pos := RAW_HEADER_SIZE + RAW_ENTRY_SIZE*len(entries) + width*blockIndex
+			switch width {
+			case 2:
+				binary.BigEndian.PutUint16(toc[pos:], uint16(size))
+			case 3:
+				utils.Write24bitUint(binary.BigEndian, toc[pos:], size)
+			case 4:
+				binary.BigEndian.PutUint32(toc[pos:], size)
+			}
+			offset += p.blockSize(size)
+			blockIndex++
+		}
+	}
+
+	if _, err := w.Write(toc); err != nil {
+		return err
+	}
+
+	for _, ne := range entries {
+		if ne.data != nil {
+			for _, block := range ne.data {
+				if _, err := w.Write(block); err != nil {
+					return err
+				}
+			}
+		} else {
+			size := int64(0)
+			for _, bs := range ne.blockSizes {
+				size += p.blockSize(bs)
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(p.f, ne.e.StartOffset, size)); err != nil {
+				return fmt.Errorf("Failed to copy '%s': %v", ne.e.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package toc
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/mogaika/god_of_war_browser/vfs"
 )
@@ -21,15 +23,49 @@ func (f *File) Name() string              { return f.name }
 func (f *File) IsDirectory() bool         { return false }
 
 // interface vfs.File
-func (f *File) Size() int64              { return f.size }
+func (f *File) Size() int64 {
+	f.toc.lock.RLock()
+	defer f.toc.lock.RUnlock()
+	return f.size
+}
 func (f *File) Open(readonly bool) error { return nil }
 func (f *File) Close() error             { return nil }
 func (f *File) Reader() (*io.SectionReader, error) {
+	f.toc.lock.RLock()
+	defer f.toc.lock.RUnlock()
 	return io.NewSectionReader(f.toc.pa.NewReaderWriter(f.encounters[0]), 0, f.size), nil
 }
 
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return f.toc.pa.NewReaderWriter(f.encounters[0]).ReadAt(b, off)
+	f.toc.lock.RLock()
+	rw := f.toc.pa.NewReaderWriter(f.encounters[0])
+	f.toc.lock.RUnlock()
+	return rw.ReadAt(b, off)
+}
+
+// ModTime is time of last change of toc file or of pak with file data
+func (f *File) ModTime() (time.Time, error) {
+	f.toc.lock.RLock()
+	defer f.toc.lock.RUnlock()
+	if len(f.encounters) == 0 {
+		return time.Time{}, fmt.Errorf("[toc] File '%s' has no data", f.name)
+	}
+	tocFile, err := f.toc.openTocFile()
+	if err != nil {
+		return time.Time{}, err
+	}
+	tocTime, ok := vfs.GetModTime(tocFile)
+	if !ok {
+		return time.Time{}, fmt.Errorf("[toc] Modification time of toc is unknown")
+	}
+	pakTime, ok := vfs.GetModTime(f.toc.paks[f.encounters[0].Pak])
+	if !ok {
+		return time.Time{}, fmt.Errorf("[toc] Modification time of pak is unknown")
+	}
+	if pakTime.After(tocTime) {
+		return pakTime, nil
+	}
+	return tocTime, nil
 }
 
 func (f *File) Copy(src io.Reader) error {
@@ -40,6 +76,15 @@ func (f *File) Copy(src io.Reader) error {
 	}
 }
 
+// interface vfs.ContextCopier
+func (f *File) CopyContext(ctx context.Context, src io.Reader) error {
+	if b, err := ioutil.ReadAll(src); err != nil {
+		return fmt.Errorf("[toc] File CopyContext(..) ioutil.ReadAll: %v", err)
+	} else {
+		return f.toc.UpdateFileContext(ctx, f.name, b)
+	}
+}
+
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
 	panic("Not implemented")
 }
@@ -65,8 +65,15 @@ type FreeSpace struct {
 	Pak        PakIndex
 }
 
-func constructFreeSpaceArray(files map[string]*File, paks []vfs.File) []FreeSpace {
+// constructFreeSpaceArray returns space of paks not used by files and reserved encounters
+func constructFreeSpaceArray(files map[string]*File, paks []vfs.File, reserved ...Encounter) []FreeSpace {
 	encounters := sortedEncountersFromFiles(files)
+	if len(reserved) != 0 {
+		encounters = append(encounters, reserved...)
+		sort.Slice(encounters, func(i int, j int) bool {
+			return encounterSortFunc(&encounters[i], &encounters[j])
+		})
+	}
 	lastPak := PakIndex(0)
 	lastPos := int64(0)
 	result := make([]FreeSpace, 0, len(encounters))
@@ -101,7 +108,10 @@ func constructFreeSpaceArray(files map[string]*File, paks []vfs.File) []FreeSpac
 				Pak:   lastPak})
 		}
 
-		lastPos = e.Offset + utils.GetRequiredSectorsCount(e.Size)*utils.SECTOR_SIZE
+		// reserved encounters can overlap files
+		if end := e.Offset + utils.GetRequiredSectorsCount(e.Size)*utils.SECTOR_SIZE; end > lastPos {
+			lastPos = end
+		}
 	}
 	handlePakChange(PakIndex(len(paks)))
 
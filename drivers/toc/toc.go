@@ -1,10 +1,12 @@
 package toc
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"strings"
+	"sync"
 
 	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/vfs"
@@ -20,6 +22,11 @@ type TableOfContent struct {
 	namingPolicy       *TocNamingPolicy
 	packsArrayIndexing int // only for gow2
 	dirty              bool
+	deferSync          bool
+	freed              []Encounter // used by toc file on disk, but not by files while sync deferred
+
+	// guards files, updates rebuild files map while other goroutines list it
+	lock sync.RWMutex
 }
 
 // interface vfs.Element
@@ -29,6 +36,8 @@ func (t *TableOfContent) IsDirectory() bool         { return true }
 
 // interface vfs.Directory
 func (t *TableOfContent) List() ([]string, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	files := make([]string, 0, 256)
 	for f := range t.files {
 		files = append(files, f)
@@ -37,20 +46,67 @@ func (t *TableOfContent) List() ([]string, error) {
 }
 
 func (t *TableOfContent) GetElement(name string) (vfs.Element, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	if f, ok := t.files[name]; !ok {
 		return nil, fmt.Errorf("[toc] Cannot find file '%s' in toc", name)
 	} else {
 		return f, nil
 	}
 }
-func (t *TableOfContent) Add(e vfs.Element) error { panic("Not implemented") }
+
+// Add creates file in paks. If element is vfs.File, then content copied
+func (t *TableOfContent) Add(e vfs.Element) error {
+	if e.IsDirectory() {
+		return fmt.Errorf("[toc] Directories not supported")
+	}
+	if _, err := t.GetElement(e.Name()); err == nil {
+		return fmt.Errorf("[toc] File '%s' already exists", e.Name())
+	}
+	if len(e.Name()) == 0 || len(e.Name()) > t.maxFileNameLength() {
+		return fmt.Errorf("[toc] File name '%s' length must be in range 1-%d", e.Name(), t.maxFileNameLength())
+	}
+
+	var data []byte
+	if f, ok := e.(vfs.File); ok {
+		r, err := vfs.OpenFileAndGetReader(f, true)
+		if err != nil {
+			return fmt.Errorf("[toc] Cannot open source file: %v", err)
+		}
+		data, err = ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("[toc] Cannot read source file: %v", err)
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// same file can be added while data was read
+	if _, ok := t.files[e.Name()]; ok {
+		return fmt.Errorf("[toc] File '%s' already exists", e.Name())
+	}
+	t.files[e.Name()] = &File{
+		name:       e.Name(),
+		encounters: make([]Encounter, 0),
+		toc:        t,
+	}
+	if err := t.updateFile(context.Background(), e.Name(), data); err != nil {
+		delete(t.files, e.Name())
+		return err
+	}
+	return nil
+}
+
 func (t *TableOfContent) Remove(name string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	if _, ok := t.files[name]; !ok {
 		return fmt.Errorf("[toc] Cannot find file '%s' in toc", name)
 	}
 	t.dirty = true
 	delete(t.files, name)
-	if err := t.Sync(); err != nil {
+	if err := t.sync(); err != nil {
 		return fmt.Errorf("Sync error: %v", err)
 	}
 	return nil
@@ -76,6 +132,13 @@ func (toc *TableOfContent) Unmarshal(b []byte) error {
 	}
 }
 
+func (toc *TableOfContent) maxFileNameLength() int {
+	if config.GetGOWVersion() == config.GOW2 {
+		return 24
+	}
+	return 12
+}
+
 func (toc *TableOfContent) Marshal() []byte {
 	switch config.GetGOWVersion() {
 	case config.GOW1:
@@ -0,0 +1,211 @@
+package toc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// creates toc with single file and pak with free space after it
+func testTocDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewTableOfContentBuilder()
+	b.AddFile("R_OLD.WAD", 100, Encounter{Offset: 0, Size: 100, Pak: 0})
+	if err := ioutil.WriteFile(filepath.Join(dir, TOC_FILE_NAME), b.Marshal(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pak := bytes.Repeat([]byte{0xaa}, 4*utils.SECTOR_SIZE)
+	if err := ioutil.WriteFile(filepath.Join(dir, "PART1.PAK"), pak, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readTocFile(t *testing.T, toc *TableOfContent, name string) []byte {
+	f, err := vfs.DirectoryGetFile(toc, name)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", name, err)
+	}
+	r, err := vfs.OpenFileAndGetReader(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestTocAddFile(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+	dir := testTocDir(t)
+	defer os.RemoveAll(dir)
+
+	toc, err := NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to open toc: %v", err)
+	}
+
+	f, err := vfs.DirectoryGetOrCreateFile(toc, "NEW.VAG")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	content := bytes.Repeat([]byte("new file"), 300)
+	if err := vfs.OpenFileAndCopy(f, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := toc.Add(&File{name: "NEW.VAG"}); err == nil {
+		t.Errorf("Duplicated file added")
+	}
+	if err := toc.Add(&File{name: "VERY_LONG_NAME.WAD"}); err == nil {
+		t.Errorf("File with too long name added")
+	}
+
+	// reopen to check that toc persisted
+	toc, err = NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to reopen toc: %v", err)
+	}
+	if got := readTocFile(t, toc, "NEW.VAG"); !bytes.Equal(got, content) {
+		t.Errorf("Content of added file mismatch")
+	}
+	if got := readTocFile(t, toc, "R_OLD.WAD"); !bytes.Equal(got, bytes.Repeat([]byte{0xaa}, 100)) {
+		t.Errorf("Content of old file corrupted")
+	}
+}
+
+func TestTocDeferSync(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+	dir := testTocDir(t)
+	defer os.RemoveAll(dir)
+
+	toc, err := NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to open toc: %v", err)
+	}
+	tocBefore, err := ioutil.ReadFile(filepath.Join(dir, TOC_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toc.DeferSync(true)
+	content := bytes.Repeat([]byte("changed"), 400)
+	if err := toc.UpdateFile("R_OLD.WAD", content); err != nil {
+		t.Fatalf("Failed to update file: %v", err)
+	}
+	if got := readTocFile(t, toc, "R_OLD.WAD"); !bytes.Equal(got, content) {
+		t.Errorf("Updated file not visible before sync")
+	}
+	if tocAfter, _ := ioutil.ReadFile(filepath.Join(dir, TOC_FILE_NAME)); !bytes.Equal(tocBefore, tocAfter) {
+		t.Errorf("Toc written before sync")
+	}
+
+	if err := toc.Sync(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	toc, err = NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to reopen toc: %v", err)
+	}
+	if got := readTocFile(t, toc, "R_OLD.WAD"); !bytes.Equal(got, content) {
+		t.Errorf("Content of updated file mismatch after sync")
+	}
+}
+
+// space freed while sync deferred is not overwritten until toc on disk stops using it
+func TestTocDeferSyncFreedSpace(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+	dir := testTocDir(t)
+	defer os.RemoveAll(dir)
+
+	toc, err := NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to open toc: %v", err)
+	}
+	readPak := func() []byte {
+		pak, err := ioutil.ReadFile(filepath.Join(dir, "PART1.PAK"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pak
+	}
+	reopen := func() *TableOfContent {
+		toc, err := NewTableOfContent(vfs.NewDirectoryDriver(dir))
+		if err != nil {
+			t.Fatalf("Failed to reopen toc: %v", err)
+		}
+		return toc
+	}
+
+	toc.DeferSync(true)
+	for i := 1; i <= 3; i++ {
+		if err := toc.UpdateFile("R_OLD.WAD", bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+		if got := readPak()[:100]; !bytes.Equal(got, bytes.Repeat([]byte{0xaa}, 100)) {
+			t.Fatalf("Data referenced by toc on disk overwritten on update %d", i)
+		}
+		if got := readTocFile(t, reopen(), "R_OLD.WAD"); !bytes.Equal(got, bytes.Repeat([]byte{0xaa}, 100)) {
+			t.Fatalf("Toc written on update %d", i)
+		}
+	}
+
+	// paks are full, so deferred toc written before reusing freed space
+	content := bytes.Repeat([]byte{4}, 100)
+	if err := toc.UpdateFile("R_OLD.WAD", content); err != nil {
+		t.Fatalf("Failed to update file: %v", err)
+	}
+	if got := readTocFile(t, reopen(), "R_OLD.WAD"); !bytes.Equal(got, bytes.Repeat([]byte{3}, 100)) {
+		t.Errorf("Toc on disk does not point to last synced data")
+	}
+	if err := toc.Sync(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if got := readTocFile(t, reopen(), "R_OLD.WAD"); !bytes.Equal(got, content) {
+		t.Errorf("Content of updated file mismatch after sync")
+	}
+}
+
+// files listed by one goroutine while other updates them, run with -race
+func TestTocConcurrentUpdate(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+	dir := testTocDir(t)
+	defer os.RemoveAll(dir)
+
+	toc, err := NewTableOfContent(vfs.NewDirectoryDriver(dir))
+	if err != nil {
+		t.Fatalf("Failed to open toc: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			names, _ := toc.List()
+			for _, name := range names {
+				if f, err := vfs.DirectoryGetFile(toc, name); err == nil {
+					f.Size()
+				}
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := toc.UpdateFile("R_OLD.WAD", bytes.Repeat([]byte{byte(i)}, 100+i)); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+	}
+	<-done
+}
@@ -1,6 +1,7 @@
 package toc
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -10,6 +11,12 @@ import (
 )
 
 func (toc *TableOfContent) Sync() error {
+	toc.lock.Lock()
+	defer toc.lock.Unlock()
+	return toc.sync()
+}
+
+func (toc *TableOfContent) sync() error {
 	var result error
 	for _, f := range toc.paks {
 		if s, ok := f.(vfs.Syncer); ok {
@@ -22,13 +29,38 @@ func (toc *TableOfContent) Sync() error {
 	if toc.dirty {
 		if err := toc.updateToc(); err != nil {
 			result = fmt.Errorf("[toc] Error updating toc %v", err)
+		} else {
+			toc.dirty = false
+			toc.freed = nil
 		}
 	}
 
 	return result
 }
 
+// DeferSync makes UpdateFile keep changes of toc in memory until Sync,
+// so toc written once after update of many files. Space freed since last
+// sync is not reused, because toc file on disk still points to it. If paks
+// have no free space left, pending changes synced before removing replicas
+// and shrinking, so toc file always describes completely written data
+func (toc *TableOfContent) DeferSync(deferSync bool) {
+	toc.lock.Lock()
+	defer toc.lock.Unlock()
+	toc.deferSync = deferSync
+}
+
 func (toc *TableOfContent) UpdateFile(name string, b []byte) error {
+	return toc.UpdateFileContext(context.Background(), name, b)
+}
+
+// UpdateFileContext is UpdateFile which shrinking can be cancelled by ctx
+func (toc *TableOfContent) UpdateFileContext(ctx context.Context, name string, b []byte) error {
+	toc.lock.Lock()
+	defer toc.lock.Unlock()
+	return toc.updateFile(ctx, name, b)
+}
+
+func (toc *TableOfContent) updateFile(ctx context.Context, name string, b []byte) error {
 	f, ok := toc.files[name]
 	if !ok {
 		return fmt.Errorf("[toc] Cannot find file with name: '%s'", name)
@@ -39,25 +71,39 @@ func (toc *TableOfContent) UpdateFile(name string, b []byte) error {
 	}
 
 	defer func() {
+		// toc file does not contain changes yet
+		if toc.deferSync {
+			return
+		}
 		if err := toc.readTocFile(); err != nil {
 			log.Printf("[toc] Cannot parse toc file after updating toc file '%s': %v", name, err)
 		}
 	}()
 
 	newSize := int64(len(b))
-	f.encounters = make([]Encounter, 0)
+	if !toc.deferSync {
+		// old place of file can be reused, toc written right after update
+		f.encounters = make([]Encounter, 0)
+	}
 
 	fs := toc.findFreeSpaceForFile(newSize)
 	if fs == nil {
 		log.Printf("[toc] There is no free space in paks, trying to remove file replicas (dups)")
-		if err := toc.RemoveReplicas(); err != nil {
+		if err := toc.removeReplicas(); err != nil {
 			return fmt.Errorf("[toc] Cannot remove replicas: %v", err)
 		}
 		fs = toc.findFreeSpaceForFile(newSize)
 	}
+	if fs == nil && toc.deferSync && len(toc.freed) != 0 {
+		log.Printf("[toc] There is no free space in paks, writing deferred toc to release space freed since last sync")
+		if err := toc.sync(); err != nil {
+			return fmt.Errorf("[toc] Sync error: %v", err)
+		}
+		fs = toc.findFreeSpaceForFile(newSize)
+	}
 	if fs == nil {
 		log.Printf("[toc] There is no free space in paks, trying to shrink data and find place for file")
-		if err := toc.Shrink(); err != nil {
+		if err := toc.shrink(ctx); err != nil {
 			return fmt.Errorf("[toc] Cannot shrink files: %v", err)
 		}
 		fs = toc.findFreeSpaceForFile(newSize)
@@ -67,24 +113,30 @@ func (toc *TableOfContent) UpdateFile(name string, b []byte) error {
 		return fmt.Errorf("[toc] There is no free space available in packs. WORKAROUND: Manually increase size of paks files and try again.")
 	}
 
-	f.size = newSize
 	e := Encounter{
 		Offset: fs.Start,
 		Size:   newSize,
 		Pak:    fs.Pak}
-	f.encounters = append(f.encounters, e)
 	if _, err := toc.pa.NewReaderWriter(e).WriteAt(b, 0); err != nil {
 		return fmt.Errorf("[toc] size > oldsize, UpdateFile=>WriteAt: %v", err)
 	}
+	if toc.deferSync {
+		toc.freed = append(toc.freed, f.encounters...)
+	}
+	f.size = newSize
+	f.encounters = []Encounter{e}
 	toc.dirty = true
-	if err := toc.Sync(); err != nil {
+	if toc.deferSync {
+		return nil
+	}
+	if err := toc.sync(); err != nil {
 		return fmt.Errorf("[toc] Sync error: %v", err)
 	}
 	return nil
 }
 
 func (toc *TableOfContent) findFreeSpaceForFile(size int64) *FreeSpace {
-	freeSpaces := constructFreeSpaceArray(toc.files, toc.paks)
+	freeSpaces := constructFreeSpaceArray(toc.files, toc.paks, toc.freed...)
 	for iFreeSpace := range freeSpaces {
 		fs := &freeSpaces[iFreeSpace]
 		if fs.End-fs.Start >= size {
@@ -95,16 +147,29 @@ func (toc *TableOfContent) findFreeSpaceForFile(size int64) *FreeSpace {
 	return nil
 }
 
-func (t *TableOfContent) RemoveReplicas() error {
+func (t *TableOfContent) removeReplicas() error {
 	for _, f := range t.files {
 		if len(f.encounters) > 1 {
+			if t.deferSync {
+				t.freed = append(t.freed, f.encounters[1:]...)
+			}
 			f.encounters = f.encounters[:1]
 		}
 	}
+	t.dirty = true
+	if t.deferSync {
+		return nil
+	}
 	return t.updateToc()
 }
 
-func (t *TableOfContent) Shrink() error {
+// shrink moves files to start of paks to join free space.
+// If ctx cancelled, files already moved are saved to toc.
+// Moved files reachable only by new toc, so it written even if sync deferred
+func (t *TableOfContent) shrink(ctx context.Context) error {
+	if t.deferSync && len(t.freed) != 0 {
+		return fmt.Errorf("[toc] Cannot shrink while space freed since last sync still used by toc file")
+	}
 	sortedFiles := sortFilesByEncounters(t.files)
 	paksUsage := paksAsFreeSpaces(t.paks)
 	alreadyProcessedFiles := make(map[string]*File)
@@ -113,14 +178,19 @@ func (t *TableOfContent) Shrink() error {
 	defer func() {
 		if deferError {
 			status.Error("Data array shrinking error! Probably you lost all data!")
+		} else if ctx.Err() != nil {
+			status.Info("Shrinking cancelled")
 		} else {
 			status.Info("Shrinking done!")
 		}
 	}()
 
 	for _, f := range sortedFiles {
+		if ctx.Err() != nil {
+			break
+		}
 		if _, already := alreadyProcessedFiles[f.name]; !already {
-			status.Progress(float32(len(alreadyProcessedFiles))/float32(len(t.files)), "Shrinking iso image. Current file '%s'", f.name)
+			status.JobProgress(ctx, float32(len(alreadyProcessedFiles))/float32(len(t.files)), "Shrinking iso image. Current file '%s'", f.name)
 			alreadyProcessedFiles[f.name] = f
 			if len(f.encounters) != 0 {
 				oldsencs := f.encounters
@@ -146,10 +216,11 @@ func (t *TableOfContent) Shrink() error {
 		}
 	}
 	t.dirty = true
-	if err := t.Sync(); err != nil {
+	if err := t.sync(); err != nil {
 		return fmt.Errorf("[toc] Sync error: %v", err)
 	}
-	return nil
+	deferError = false
+	return ctx.Err()
 }
 
 func (t *TableOfContent) updateToc() error {
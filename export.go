@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/pack/wad/sbk"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// actions that only produce files, other actions of resources change wad
+var exportActions = map[string]bool{
+	"obj":        true,
+	"gltf":       true,
+	"fbx":        true,
+	"gltf_all":   true,
+	"fbx_all":    true,
+	"png":        true,
+	"wav":        true,
+	"vag":        true,
+	"asjson":     true,
+	"exportfont": true,
+	"asyaml":     true,
+	"dataasjson": true,
+}
+
+type exporter struct {
+	outDir  string
+	actions []string
+	query   url.Values
+	// output paths written in this run, used to not overwrite results of same named tags
+	written map[string]bool
+
+	filesCount, errorsCount int
+}
+
+// exportMain runs same resource actions as web ui and stores results into directory tree:
+// god_of_war_browser export -iso GOW.iso -wads "R_*.WAD" -tags "MDL_*" -actions gltf,fbx -out export
+func exportMain(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var source sourceFlags
+	var wadsGlob, tagsGlob, actions, query, outDir string
+	source.register(fs)
+	fs.StringVar(&wadsGlob, "wads", "*", "Glob of wad file names")
+	fs.StringVar(&tagsGlob, "tags", "*", "Glob of tag names inside of wad")
+	fs.StringVar(&actions, "actions", "gltf", "Comma separated list of actions ("+strings.Join(exportActionsList(), ", ")+")")
+	fs.StringVar(&query, "query", "", "Additional action parameters in url query format (animations=1&gfx=0&pal=1)")
+	fs.StringVar(&outDir, "out", "export", "Output directory")
+	fs.Parse(args)
+
+	e := &exporter{
+		outDir:  outDir,
+		actions: strings.Split(actions, ","),
+		written: make(map[string]bool),
+	}
+	for _, action := range e.actions {
+		if !exportActions[action] {
+			log.Fatalf("Unknown export action %q, supported: %s", action, strings.Join(exportActionsList(), ", "))
+		}
+	}
+	if _, err := path.Match(wadsGlob, ""); err != nil {
+		log.Fatalf("Invalid wads glob %q: %v", wadsGlob, err)
+	}
+	if _, err := path.Match(tagsGlob, ""); err != nil {
+		log.Fatalf("Invalid tags glob %q: %v", tagsGlob, err)
+	}
+	var err error
+	if e.query, err = url.ParseQuery(query); err != nil {
+		log.Fatalf("Invalid query %q: %v", query, err)
+	}
+
+	gameDir, _, err := source.open(true)
+	if err == errNoSource {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open game data: %v", err)
+	}
+
+	if err := e.exportDirectory(gameDir, wadsGlob, tagsGlob); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	log.Printf("Exported %d files, %d errors", e.filesCount, e.errorsCount)
+	if e.errorsCount != 0 {
+		os.Exit(1)
+	}
+}
+
+func exportActionsList() []string {
+	list := make([]string, 0, len(exportActions))
+	for action := range exportActions {
+		list = append(list, action)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (e *exporter) exportDirectory(gameDir vfs.Directory, wadsGlob, tagsGlob string) error {
+	files, err := gameDir.List()
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, name := range files {
+		if !file_wad.IsWadFileName(name) {
+			continue
+		}
+		if matched, _ := path.Match(wadsGlob, name); !matched {
+			continue
+		}
+		data, err := pack.GetInstanceHandler(gameDir, name)
+		if err != nil {
+			log.Printf("[export] Failed to open %q: %v", name, err)
+			e.errorsCount++
+			continue
+		}
+		if wad, ok := data.(*file_wad.Wad); ok {
+			e.exportWad(wad, tagsGlob)
+		}
+	}
+	return nil
+}
+
+func (e *exporter) exportWad(wad *file_wad.Wad, tagsGlob string) {
+	// *_all actions export whole wad, so called once per wad
+	wholeWadDone := make(map[string]bool)
+	for _, node := range wad.Nodes {
+		// nodes without data are links to already loaded instances
+		if len(node.Tag.Data) == 0 {
+			continue
+		}
+		if matched, _ := path.Match(tagsGlob, node.Tag.Name); !matched {
+			continue
+		}
+		inst, _, err := wad.GetInstanceFromNode(node.Id)
+		if err != nil {
+			// tags without handler or broken instances are not exportable
+			continue
+		}
+		for _, action := range e.actions {
+			if wholeWadDone[action] {
+				continue
+			}
+			for _, query := range e.actionQueries(inst, action) {
+				if e.exportAction(wad, node, action, query) && strings.HasSuffix(action, "_all") {
+					wholeWadDone[action] = true
+				}
+			}
+		}
+	}
+}
+
+// actionQueries expands action into several calls when resource contains several outputs
+func (e *exporter) actionQueries(inst file_wad.File, action string) []url.Values {
+	if s, ok := inst.(*sbk.SBK); ok && (action == "wav" || action == "vag") && e.query.Get("snd") == "" {
+		queries := make([]url.Values, 0, len(s.Sounds))
+		for _, snd := range s.Sounds {
+			q := url.Values{}
+			for k, v := range e.query {
+				q[k] = v
+			}
+			q.Set("snd", snd.Name)
+			queries = append(queries, q)
+		}
+		return queries
+	}
+	return []url.Values{e.query}
+}
+
+// exportAction returns true if file was written
+func (e *exporter) exportAction(wad *file_wad.Wad, node *file_wad.Node, action string, query url.Values) (written bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[export] Panic in %s:%s action %q: %v", wad.Name(), node.Tag.Name, action, r)
+			e.errorsCount++
+		}
+	}()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/action/"+url.PathEscape(wad.Name())+"/"+fmt.Sprint(node.Tag.Id)+"/"+action+"?"+query.Encode(), nil)
+	if err := wad.WebHandlerCallResourceHttpAction(rec, req, node.Tag.Id, action); err != nil {
+		// resource has no actions at all
+		return false
+	}
+
+	_, params, _ := mime.ParseMediaType(rec.Header().Get("Content-Disposition"))
+	fileName := params["filename"]
+	if fileName == "" {
+		// action not supported by resource, or error reported instead of file
+		if body := strings.TrimSpace(rec.Body.String()); body != "" {
+			log.Printf("[export] %s:%s action %q: %s", wad.Name(), node.Tag.Name, action, body)
+			e.errorsCount++
+		}
+		return false
+	}
+
+	if rec.Body.Len() == 0 {
+		// exporters write headers before data, failures are only logged
+		log.Printf("[export] %s:%s action %q produced empty %q", wad.Name(), node.Tag.Name, action, fileName)
+		e.errorsCount++
+		return false
+	}
+
+	outPath := filepath.Join(e.outDir, wad.Name(), filepath.Base(fileName))
+	if e.written[outPath] {
+		outPath = filepath.Join(e.outDir, wad.Name(), fmt.Sprintf("%d-%s", node.Tag.Id, filepath.Base(fileName)))
+	}
+	e.written[outPath] = true
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+		log.Printf("[export] Failed to create directory for %q: %v", outPath, err)
+		e.errorsCount++
+		return false
+	}
+	if err := ioutil.WriteFile(outPath, rec.Body.Bytes(), 0666); err != nil {
+		log.Printf("[export] Failed to write %q: %v", outPath, err)
+		e.errorsCount++
+		return false
+	}
+	log.Printf("[export] %s:%s -> %s", wad.Name(), node.Tag.Name, outPath)
+	e.filesCount++
+	return true
+}
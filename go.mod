@@ -11,6 +11,7 @@ require (
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.5.0
+	github.com/hanwen/go-fuse/v2 v2.3.0
 	github.com/mogaika/bmfont v0.0.0-20171214121832-6d9c75ddf4e8
 	github.com/mogaika/fbx v0.1.0
 	github.com/mogaika/go-collada v0.0.0-20130926211746-391129030388
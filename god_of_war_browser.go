@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -43,40 +44,56 @@ import (
 	_ "github.com/mogaika/god_of_war_browser/pack/wad/txr"
 )
 
-func main() {
-	var addr, tocpath, dirpath, isopath, psarcpath, psversion, encoding string
-	var gowversion int
-	var parsecheck, listencodings bool
-	flag.StringVar(&addr, "i", ":8000", "Address of server")
-	flag.StringVar(&tocpath, "toc", "", "Path to folder with toc file")
-	flag.StringVar(&dirpath, "dir", "", "Path to unpacked wads and other stuff")
-	flag.StringVar(&isopath, "iso", "", "Path to iso file")
-	flag.StringVar(&psarcpath, "psarc", "", "Path to ps3 psarc file")
-	flag.StringVar(&psversion, "ps", "ps2", "Playstation version (ps2, ps3, psvita, pc)")
-	flag.IntVar(&gowversion, "gowversion", 0, "0 - auto, 1 - 'gow1', 2 - 'gow2', 2018 - 'gow2018'")
-	flag.BoolVar(&parsecheck, "parsecheck", false, "Check every file for parse errors (for devs)")
-	flag.BoolVar(&listencodings, "listencodings", false, "List text encodings")
-	flag.StringVar(&encoding, "encoding", "Windows 1252", "Select text encodings")
-	flag.Parse()
+// sourceFlags describes game data source shared by server and subcommands
+type sourceFlags struct {
+	tocpath, dirpath, isopath, psarcpath, psversion, encoding string
+	overlay                                                   string
+	gowversion                                                int
+}
 
-	var err error
-	var gameDir vfs.Directory
-	var driverDir vfs.Directory
+func (sf *sourceFlags) register(fs *flag.FlagSet) {
+	sf.registerPaths(fs, "", "")
+	fs.StringVar(&sf.psversion, "ps", "ps2", "Playstation version (ps2, ps3, psvita, pc)")
+	fs.IntVar(&sf.gowversion, "gowversion", 0, "0 - auto, 1 - 'gow1', 2 - 'gow2', 2018 - 'gow2018'")
+	fs.StringVar(&sf.encoding, "encoding", "Windows 1252", "Select text encodings")
+	fs.StringVar(&sf.overlay, "overlay", "", "Directory with mod files layered over game data, changes are written only there")
+}
 
-	if listencodings {
-		listEncodings()
-		return
+// registerPaths registers only location flags, so several sources can share version flags
+func (sf *sourceFlags) registerPaths(fs *flag.FlagSet, prefix string, usagePrefix string) {
+	fs.StringVar(&sf.tocpath, prefix+"toc", "", usagePrefix+"Path to folder with toc file")
+	fs.StringVar(&sf.dirpath, prefix+"dir", "", usagePrefix+"Path to unpacked wads and other stuff")
+	fs.StringVar(&sf.isopath, prefix+"iso", "", usagePrefix+"Path to iso file")
+	fs.StringVar(&sf.psarcpath, prefix+"psarc", "", usagePrefix+"Path to ps3 psarc file")
+}
+
+var errNoSource = errors.New("No game data source provided")
+
+// open configures versions and encoding, then opens game data source.
+// driverDir is set only for iso source without overlay.
+// If overlay provided, source opened read only and wrapped with vfs.Overlay
+func (sf *sourceFlags) open(readOnly bool) (gameDir vfs.Directory, driverDir vfs.Directory, err error) {
+	if sf.overlay != "" {
+		if gameDir, _, err = sf.openBase(true); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Using overlay directory %q", sf.overlay)
+		// driver directory is not returned, so nothing syncs or writes original image
+		return vfs.NewOverlay(gameDir, vfs.NewDirectoryDriver(sf.overlay)), nil, nil
 	}
-	if encoding != "" {
-		log.Printf("Setting encoding %q", encoding)
-		if err := config.SetEncoding(encoding); err != nil {
-			log.Printf("Failed to set encoding %q: %v", encoding, err)
+	return sf.openBase(readOnly)
+}
+
+func (sf *sourceFlags) openBase(readOnly bool) (gameDir vfs.Directory, driverDir vfs.Directory, err error) {
+	if sf.encoding != "" {
+		log.Printf("Setting encoding %q", sf.encoding)
+		if err := config.SetEncoding(sf.encoding); err != nil {
 			listEncodings()
-			return
+			return nil, nil, fmt.Errorf("Failed to set encoding %q: %v", sf.encoding, err)
 		}
 	}
 
-	switch psversion {
+	switch sf.psversion {
 	case "ps2":
 		config.SetPlayStationVersion(config.PS2)
 	case "ps3":
@@ -86,22 +103,24 @@ func main() {
 	case "pc":
 		config.SetPlayStationVersion(config.PC)
 	default:
-		log.Fatalf("Provide correct 'ps' parameter (ps2, ps3, psvita)")
+		return nil, nil, fmt.Errorf("Provide correct 'ps' parameter (ps2, ps3, psvita)")
 	}
 
-	config.SetGOWVersion(config.GOWVersion(gowversion))
+	config.SetGOWVersion(config.GOWVersion(sf.gowversion))
 
-	if psarcpath != "" {
+	if sf.psarcpath != "" {
 		if config.GetPlayStationVersion() != config.PS3 && config.GetPlayStationVersion() != config.PSVita {
-			log.Fatalf("Cannot use psarcpath when 'ps' is not ps3 or psvita")
+			return nil, nil, fmt.Errorf("Cannot use psarcpath when 'ps' is not ps3 or psvita")
 		}
-		f := vfs.NewDirectoryDriverFile(psarcpath)
+		f := vfs.NewDirectoryDriverFile(sf.psarcpath)
 		if err = f.Open(true); err == nil {
 			gameDir, err = psarc.NewPsarcDriver(f)
 		}
-	} else if isopath != "" {
-		f := vfs.NewDirectoryDriverFile(isopath)
-		if err = f.Open(false); err != nil {
+	} else if sf.isopath != "" {
+		f := vfs.NewDirectoryDriverFile(sf.isopath)
+		if readOnly {
+			err = f.Open(true)
+		} else if err = f.Open(false); err != nil {
 			log.Printf("Failed to open iso in rw mode, trying ro mode. (Probably emulator using same image)")
 			err = f.Open(true)
 		}
@@ -110,18 +129,64 @@ func main() {
 				gameDir, err = toc.NewTableOfContent(driverDir)
 			}
 		}
-	} else if tocpath != "" {
-		gameDir, err = toc.NewTableOfContent(vfs.NewDirectoryDriver(tocpath))
-	} else if dirpath != "" {
-		gameDir = vfs.NewDirectoryDriver(dirpath)
-		if gowversion == 0 {
-			log.Fatalf("You must provide 'gowversion' argument if you use directory driver")
+	} else if sf.tocpath != "" {
+		gameDir, err = toc.NewTableOfContent(vfs.NewDirectoryDriver(sf.tocpath))
+	} else if sf.dirpath != "" {
+		gameDir = vfs.NewDirectoryDriver(sf.dirpath)
+		if sf.gowversion == 0 {
+			return nil, nil, fmt.Errorf("You must provide 'gowversion' argument if you use directory driver")
 		}
 	} else {
-		flag.PrintDefaults()
+		return nil, nil, errNoSource
+	}
+	return gameDir, driverDir, err
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			exportMain(os.Args[2:])
+			return
+		case "apply":
+			applyMain(os.Args[2:])
+			return
+		case "patch":
+			patchMain(os.Args[2:])
+			return
+		case "diff":
+			diffMain(os.Args[2:])
+			return
+		case "hashcrack":
+			hashcrackMain(os.Args[2:])
+			return
+		case "mount":
+			mountMain(os.Args[2:])
+			return
+		}
+	}
+
+	var addr string
+	var parsecheck, listencodings bool
+	var source sourceFlags
+	flag.StringVar(&addr, "i", ":8000", "Address of server")
+	source.register(flag.CommandLine)
+	var parsecheckOut string
+	flag.BoolVar(&parsecheck, "parsecheck", false, "Check every file for parse errors, write report and exit (for devs)")
+	flag.StringVar(&parsecheckOut, "parsecheck-out", "parsecheck.json", "Path of -parsecheck json report")
+	flag.BoolVar(&listencodings, "listencodings", false, "List text encodings")
+	flag.Parse()
+
+	if listencodings {
+		listEncodings()
 		return
 	}
 
+	gameDir, driverDir, err := source.open(false)
+	if err == errNoSource {
+		flag.PrintDefaults()
+		return
+	}
 	if err != nil {
 		log.Fatalf("Cannot start god of war browser: %v", err)
 	}
@@ -132,9 +197,12 @@ func main() {
 		defer f.Close()
 	}
 
-	// parsecheck = true
 	if parsecheck {
-		parseCheck(gameDir)
+		if err := parseCheck(gameDir, parsecheckOut); err != nil {
+			log.Fatalf("Parsecheck failed: %v", err)
+		}
+		log.Printf("Parsecheck report written to %q", parsecheckOut)
+		return
 	}
 	status.Info("Starting web server on address '%s'", addr)
 
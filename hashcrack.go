@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/utils/hashcrack"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// hashcrackMain parses every wad to gather hashes shown as @hash(...),
+// prints json result of cracking and appends confirmed hits if -write provided:
+// god_of_war_browser hashcrack -iso GOW.iso -words words.txt -write
+func hashcrackMain(args []string) {
+	fs := flag.NewFlagSet("hashcrack", flag.ExitOnError)
+	var source sourceFlags
+	source.register(fs)
+	var wordsPath, hashes string
+	var write bool
+	fs.StringVar(&wordsPath, "words", "", "File with additional dictionary, one word per line")
+	fs.StringVar(&hashes, "hashes", "", "Comma separated hex hashes to crack instead of gathering them from game data")
+	fs.BoolVar(&write, "write", false, "Append confirmed hits to "+utils.HASHES_DUMP_FILE)
+	fs.Parse(args)
+
+	var words []string
+	if wordsPath != "" {
+		data, err := ioutil.ReadFile(wordsPath)
+		if err != nil {
+			log.Fatalf("Failed to read words: %v", err)
+		}
+		words = hashcrack.ParseWords(string(data))
+	}
+
+	utils.GameStringHashesWait()
+
+	var targets []uint32
+	if hashes != "" {
+		for _, s := range strings.Split(hashes, ",") {
+			hash, err := strconv.ParseUint(strings.TrimSpace(s), 16, 32)
+			if err != nil {
+				log.Fatalf("Invalid hash %q: %v", s, err)
+			}
+			targets = append(targets, uint32(hash))
+		}
+	} else {
+		gameDir, _, err := source.open(true)
+		if err == errNoSource {
+			fs.PrintDefaults()
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalf("Cannot open game data: %v", err)
+		}
+		if err := gatherUnresolvedHashes(gameDir); err != nil {
+			log.Fatalf("Failed to gather hashes: %v", err)
+		}
+		targets = utils.GameStringUnresolvedHashes()
+	}
+	log.Printf("Cracking %d hashes", len(targets))
+
+	result := hashcrack.NewCracker(targets, utils.GameStringKnownStrings(), words).Run()
+	for _, t := range result.Tiers {
+		log.Printf("Hashcrack %s: %d hits, %.0f candidates, %.2f expected collisions, confirmed %v",
+			t.Method, t.Hits, t.Candidates, t.ExpectedFalse, t.Confirmed)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		log.Fatalf("Failed to encode result: %v", err)
+	}
+
+	if write {
+		n, err := hashcrack.Append(utils.HASHES_DUMP_FILE, result.Hits)
+		if err != nil {
+			log.Fatalf("Failed to write hits: %v", err)
+		}
+		log.Printf("%d hits appended to %q", n, utils.HASHES_DUMP_FILE)
+	}
+}
+
+// gatherUnresolvedHashes loads every wad node, so names
+// which hash is unknown are remembered by GameStringUnhashNodes
+func gatherUnresolvedHashes(rootfs vfs.Directory) error {
+	packList, err := rootfs.List()
+	if err != nil {
+		return err
+	}
+	sort.Strings(packList)
+
+	for _, fname := range packList {
+		if !pack.HasHandler(fname) {
+			continue
+		}
+		data, err := parseCheckCall(func() (interface{}, error) {
+			return pack.GetInstanceHandler(rootfs, fname)
+		})
+		if err != nil {
+			log.Printf("Failed to load %q: %v", fname, err)
+			continue
+		}
+		wad, ok := data.(*file_wad.Wad)
+		if !ok {
+			continue
+		}
+		for _, node := range wad.Nodes {
+			if wad.GetNodeById(node.Id) != node || !file_wad.HasHandler(node) {
+				continue
+			}
+			// errors already reported by -parsecheck
+			parseCheckCall(func() (interface{}, error) {
+				inst, _, err := wad.GetInstanceFromNode(node.Id)
+				return inst, err
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mogaika/god_of_war_browser/pack/mount"
+)
+
+// mountMain serves game data as read-only filesystem until interrupted,
+// every wad also shown as directory of tags R_A.WAD.tags:
+// god_of_war_browser mount -iso GOW.iso /mnt/gow
+// god_of_war_browser mount -iso GOW.iso -layers /mnt/gowiso
+func mountMain(args []string) {
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	var source sourceFlags
+	source.register(fs)
+	var layers bool
+	fs.BoolVar(&layers, "layers", false, "Mount files of iso image as is instead of files listed by toc")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Printf("Usage: mount [flags] mountpoint")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	gameDir, driverDir, err := source.open(true)
+	if err == errNoSource {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open game data: %v", err)
+	}
+	if layers {
+		if driverDir == nil {
+			log.Fatalf("-layers requires -iso source without overlay")
+		}
+		gameDir = driverDir
+	}
+
+	m, err := mount.Mount(gameDir, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Cannot mount: %v", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		if err := m.Unmount(); err != nil {
+			log.Printf("Cannot unmount, probably filesystem is busy: %v", err)
+		}
+	}()
+	m.Wait()
+}
@@ -0,0 +1,316 @@
+// Package index collects names and references of nodes of every wad,
+// so resources can be searched across whole game
+package index
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/status"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// version 2 added content hash of files, version 3 added modification time
+const INDEX_CACHE_VERSION = 3
+const SEARCH_RESULTS_LIMIT = 500
+
+type Entry struct {
+	Wad        string
+	Name       string
+	TagId      wad.TagId
+	Tag        uint16
+	ServerId   uint32
+	Size       uint32
+	References []wad.Reference `json:",omitempty"`
+	Error      string          `json:",omitempty"`
+}
+
+type fileEntries struct {
+	// file considered unchanged if size and modification time are same,
+	// otherwise it read and compared by content hash, because wad can be
+	// changed without changing size and time of file inside of image
+	// changes on every write to image
+	Size    int64
+	ModTime time.Time
+	Hash    string
+	Entries []*Entry
+}
+
+type indexCache struct {
+	Version    int
+	GOWVersion config.GOWVersion
+	PSVersion  config.PSVersion
+	Files      map[string]*fileEntries
+}
+
+type Index struct {
+	cachePath string
+
+	lock     sync.RWMutex
+	building bool
+	files    map[string]*fileEntries
+	ready    bool
+	progress float32
+}
+
+type SearchResult struct {
+	Ready    bool
+	Progress float32
+	// nodes with matched name
+	Nodes []*Entry
+	// nodes which refer to matched name
+	Referencing []*Entry
+	Truncated   bool
+}
+
+func NewIndex(cachePath string) *Index {
+	return &Index{
+		cachePath: cachePath,
+		files:     make(map[string]*fileEntries),
+	}
+}
+
+func (idx *Index) loadCache() map[string]*fileEntries {
+	f, err := os.Open(idx.cachePath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		log.Printf("[index] Failed to read cache: %v", err)
+		return nil
+	}
+	var c indexCache
+	if err := json.NewDecoder(gr).Decode(&c); err != nil {
+		log.Printf("[index] Failed to decode cache: %v", err)
+		return nil
+	}
+	if c.Version != INDEX_CACHE_VERSION || c.GOWVersion != config.GetGOWVersion() || c.PSVersion != config.GetPlayStationVersion() {
+		return nil
+	}
+	return c.Files
+}
+
+func (idx *Index) saveCache() error {
+	f, err := os.Create(idx.cachePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	idx.lock.RLock()
+	err = json.NewEncoder(gw).Encode(&indexCache{
+		Version:    INDEX_CACHE_VERSION,
+		GOWVersion: config.GetGOWVersion(),
+		PSVersion:  config.GetPlayStationVersion(),
+		Files:      idx.files,
+	})
+	idx.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Build parses every changed wad of directory and stores result to cache file
+func (idx *Index) Build(d vfs.Directory) error {
+	names, err := d.List()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list files")
+	}
+	sort.Strings(names)
+
+	cached := idx.loadCache()
+
+	idx.lock.Lock()
+	idx.ready = false
+	idx.building = true
+	idx.files = make(map[string]*fileEntries)
+	idx.lock.Unlock()
+
+	for i, name := range names {
+		if !wad.IsWadFileName(name) {
+			continue
+		}
+		f, err := vfs.DirectoryGetFile(d, name)
+		if err != nil {
+			log.Printf("[index] Failed to get %q: %v", name, err)
+			continue
+		}
+		// time taken before reading, so change during reading detected on next build
+		modTime, hasModTime := vfs.GetModTime(f)
+
+		fe, ok := cached[name]
+		if !ok || !hasModTime || fe.Size != f.Size() || !fe.ModTime.Equal(modTime) {
+			hash, err := hashFile(f)
+			if err != nil {
+				log.Printf("[index] Failed to read %q: %v", name, err)
+				continue
+			}
+			if !ok || fe.Size != f.Size() || fe.Hash != hash {
+				status.Progress(float32(i)/float32(len(names)), "Indexing '%s'", name)
+				if fe, err = indexWad(d, name, f.Size(), hash); err != nil {
+					log.Printf("[index] Failed to index %q: %v", name, err)
+					continue
+				}
+			}
+			fe.ModTime = modTime
+		}
+
+		idx.lock.Lock()
+		// wad saved during build already reindexed by Update
+		if _, updated := idx.files[name]; !updated {
+			idx.files[name] = fe
+		}
+		idx.progress = float32(i+1) / float32(len(names))
+		idx.lock.Unlock()
+	}
+
+	idx.lock.Lock()
+	idx.ready = true
+	idx.building = false
+	idx.progress = 1
+	idx.lock.Unlock()
+	status.Info("Indexing of %d wads finished", len(idx.files))
+
+	return idx.saveCache()
+}
+
+// Update reindexes wad after it was saved or removed
+func (idx *Index) Update(d vfs.Directory, name string) {
+	if !wad.IsWadFileName(name) {
+		return
+	}
+
+	var fe *fileEntries
+	if f, err := vfs.DirectoryGetFile(d, name); err == nil {
+		modTime, _ := vfs.GetModTime(f)
+		hash, err := hashFile(f)
+		if err != nil {
+			log.Printf("[index] Failed to read %q: %v", name, err)
+			return
+		}
+		if fe, err = indexWad(d, name, f.Size(), hash); err != nil {
+			log.Printf("[index] Failed to index %q: %v", name, err)
+			return
+		}
+		fe.ModTime = modTime
+	}
+
+	idx.lock.Lock()
+	if fe != nil {
+		idx.files[name] = fe
+	} else {
+		delete(idx.files, name)
+	}
+	building := idx.building
+	idx.lock.Unlock()
+
+	// cache saved by build when it finished
+	if !building {
+		if err := idx.saveCache(); err != nil {
+			log.Printf("[index] Failed to save cache: %v", err)
+		}
+	}
+}
+
+func hashFile(f vfs.File) (string, error) {
+	r, err := vfs.OpenFileAndGetReader(f, true)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func indexWad(d vfs.Directory, name string, size int64, hash string) (*fileEntries, error) {
+	inst, err := pack.GetInstanceHandler(d, name)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := inst.(*wad.Wad)
+	if !ok {
+		return nil, errors.Errorf("%q is not wad", name)
+	}
+
+	fe := &fileEntries{Size: size, Hash: hash, Entries: make([]*Entry, 0, len(w.Nodes))}
+	for _, n := range w.Nodes {
+		e := &Entry{
+			Wad:   name,
+			Name:  n.Tag.Name,
+			TagId: n.Tag.Id,
+			Tag:   n.Tag.Tag,
+			Size:  n.Tag.Size,
+		}
+		refs, serverId, err := w.NodeReferences(n.Id)
+		e.References = refs
+		e.ServerId = serverId
+		if err != nil {
+			e.Error = err.Error()
+		}
+		fe.Entries = append(fe.Entries, e)
+	}
+	return fe, nil
+}
+
+// Search looks for case insensitive substring in node names and references
+func (idx *Index) Search(q string) *SearchResult {
+	q = strings.ToLower(q)
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	res := &SearchResult{
+		Ready:       idx.ready,
+		Progress:    idx.progress,
+		Nodes:       make([]*Entry, 0),
+		Referencing: make([]*Entry, 0),
+	}
+
+	names := make([]string, 0, len(idx.files))
+	for name := range idx.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, e := range idx.files[name].Entries {
+			if len(res.Nodes)+len(res.Referencing) >= SEARCH_RESULTS_LIMIT {
+				res.Truncated = true
+				return res
+			}
+			if strings.Contains(strings.ToLower(e.Name), q) {
+				res.Nodes = append(res.Nodes, e)
+				continue
+			}
+			for _, ref := range e.References {
+				if strings.Contains(strings.ToLower(ref.Name), q) {
+					res.Referencing = append(res.Referencing, e)
+					break
+				}
+			}
+		}
+	}
+	return res
+}
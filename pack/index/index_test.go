@@ -0,0 +1,146 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+func writeTestWad(t *testing.T, path string, names ...string) {
+	tags := make([]wad.Tag, len(names))
+	for i, name := range names {
+		tags[i] = wad.Tag{Tag: wad.TAG_GOW1_FILE_MC_DATA, Name: name, Data: make([]byte, 4)}
+	}
+	if err := ioutil.WriteFile(path, wad.MarshalTags(tags), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildSearch(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestWad(t, filepath.Join(dir, "R_A.WAD"), "TXR_Blade", "TXR_Other")
+	writeTestWad(t, filepath.Join(dir, "R_B.WAD"), "TXR_Blade")
+	cachePath := filepath.Join(dir, "cache.gz")
+
+	idx := NewIndex(cachePath)
+	if err := idx.Build(vfs.NewDirectoryDriver(dir)); err != nil {
+		t.Fatalf("Failed to build index: %v", err)
+	}
+	res := idx.Search("blade")
+	if !res.Ready || len(res.Nodes) != 2 || res.Nodes[0].Wad != "R_A.WAD" || res.Nodes[1].Wad != "R_B.WAD" {
+		t.Errorf("Unexpected search result %+v", res)
+	}
+
+	// cached index used when wads are not changed
+	idx = NewIndex(cachePath)
+	if files := idx.loadCache(); len(files) != 2 || len(files["R_A.WAD"].Entries) != 2 {
+		t.Errorf("Unexpected cache content %v", files)
+	}
+
+	// wad changed without changing size is reindexed
+	writeTestWad(t, filepath.Join(dir, "R_B.WAD"), "TXR_Bolts")
+	if err := idx.Build(vfs.NewDirectoryDriver(dir)); err != nil {
+		t.Fatalf("Failed to rebuild index: %v", err)
+	}
+	if res := idx.Search("blade"); len(res.Nodes) != 1 || res.Nodes[0].Wad != "R_A.WAD" {
+		t.Errorf("Changed wad not reindexed %+v", res)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestWad(t, filepath.Join(dir, "R_A.WAD"), "TXR_Blade")
+	d := vfs.NewDirectoryDriver(dir)
+
+	idx := NewIndex(filepath.Join(dir, "cache.gz"))
+	if err := idx.Build(d); err != nil {
+		t.Fatalf("Failed to build index: %v", err)
+	}
+
+	writeTestWad(t, filepath.Join(dir, "R_A.WAD"), "TXR_Saved")
+	writeTestWad(t, filepath.Join(dir, "R_NEW.WAD"), "TXR_Saved")
+	idx.Update(d, "R_A.WAD")
+	idx.Update(d, "R_NEW.WAD")
+	if res := idx.Search("saved"); len(res.Nodes) != 2 {
+		t.Errorf("Saved wads not reindexed %+v", res)
+	}
+	if files := idx.loadCache(); len(files) != 2 || files["R_A.WAD"].Entries[0].Name != "TXR_Saved" {
+		t.Errorf("Cache not updated %v", files)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "R_NEW.WAD")); err != nil {
+		t.Fatal(err)
+	}
+	idx.Update(d, "R_NEW.WAD")
+	if res := idx.Search("saved"); len(res.Nodes) != 1 || res.Nodes[0].Wad != "R_A.WAD" {
+		t.Errorf("Removed wad still indexed %+v", res)
+	}
+}
+
+func TestBuildModTime(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "R_A.WAD")
+	writeTestWad(t, path, "TXR_Blade")
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := vfs.NewDirectoryDriver(dir)
+	cachePath := filepath.Join(dir, "cache.gz")
+
+	if err := NewIndex(cachePath).Build(d); err != nil {
+		t.Fatalf("Failed to build index: %v", err)
+	}
+
+	// same size and time, so wad is not read and cached entries used
+	writeTestWad(t, path, "TXR_Bolts")
+	if err := os.Chtimes(path, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	idx := NewIndex(cachePath)
+	if err := idx.Build(d); err != nil {
+		t.Fatalf("Failed to rebuild index: %v", err)
+	}
+	if res := idx.Search("blade"); len(res.Nodes) != 1 {
+		t.Errorf("Wad with same size and time read again %+v", res)
+	}
+
+	changed := stat.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatal(err)
+	}
+	idx = NewIndex(cachePath)
+	if err := idx.Build(d); err != nil {
+		t.Fatalf("Failed to rebuild index: %v", err)
+	}
+	if res := idx.Search("bolts"); len(res.Nodes) != 1 {
+		t.Errorf("Wad with changed time not reindexed %+v", res)
+	}
+}
@@ -0,0 +1,251 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package mount
+
+import (
+	"context"
+	"io"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+const (
+	MODE_DIR  = fuse.S_IFDIR | 0555
+	MODE_FILE = fuse.S_IFREG | 0444
+)
+
+// Mount serves d at mountpoint until unmounted. Mount(2) used directly
+// when running as root, otherwise fusermount should be installed
+func Mount(d vfs.Directory, mountpoint string) (Mounted, error) {
+	timeout := time.Second
+	server, err := fs.Mount(mountpoint, &dirNode{d: d}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "god_of_war_browser",
+			Name:        "gowb",
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[mount] Mounted '%s' to '%s'", d.Name(), mountpoint)
+	return server, nil
+}
+
+func isWriteFlags(flags uint32) bool {
+	return flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0
+}
+
+func readData(data []byte, dest []byte, off int64) fuse.ReadResult {
+	if off >= int64(len(data)) {
+		return fuse.ReadResultData(nil)
+	}
+	return fuse.ReadResultData(data[off:][:copy(dest, data[off:])])
+}
+
+// dirNode is vfs directory
+type dirNode struct {
+	fs.Inode
+	d vfs.Directory
+}
+
+var _ = (fs.NodeReaddirer)((*dirNode)(nil))
+var _ = (fs.NodeLookuper)((*dirNode)(nil))
+var _ = (fs.NodeGetattrer)((*dirNode)(nil))
+
+func (n *dirNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = MODE_DIR
+	return 0
+}
+
+func (n *dirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	vfsLock.Lock()
+	entries, err := ListDirectory(n.d)
+	vfsLock.Unlock()
+	if err != nil {
+		log.Printf("[mount] Cannot list '%s': %v", n.d.Name(), err)
+		return nil, syscall.EIO
+	}
+
+	list := make([]fuse.DirEntry, len(entries))
+	for i, e := range entries {
+		list[i] = fuse.DirEntry{Name: e.Name, Mode: fuse.S_IFREG}
+		if e.IsDir {
+			list[i].Mode = fuse.S_IFDIR
+		}
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	// keep parsed wads between lookups
+	if child := n.GetChild(name); child != nil {
+		if ga, ok := child.Operations().(fs.NodeGetattrer); ok {
+			var attr fuse.AttrOut
+			ga.Getattr(ctx, nil, &attr)
+			out.Attr = attr.Attr
+		}
+		return child, 0
+	}
+
+	vfsLock.Lock()
+	entry, e, err := LookupEntry(n.d, name)
+	vfsLock.Unlock()
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+
+	switch {
+	case entry.Wad != "":
+		out.Mode = MODE_DIR
+		return n.NewInode(ctx, &wadNode{d: n.d, name: entry.Wad}, fs.StableAttr{Mode: fuse.S_IFDIR}), 0
+	case entry.IsDir:
+		out.Mode = MODE_DIR
+		return n.NewInode(ctx, &dirNode{d: e.(vfs.Directory)}, fs.StableAttr{Mode: fuse.S_IFDIR}), 0
+	default:
+		f := e.(vfs.File)
+		out.Mode = MODE_FILE
+		out.Size = uint64(f.Size())
+		return n.NewInode(ctx, &fileNode{f: f}, fs.StableAttr{Mode: fuse.S_IFREG}), 0
+	}
+}
+
+// fileNode is vfs file, opened only for time of read,
+// because vfs files cannot be opened twice
+type fileNode struct {
+	fs.Inode
+	f vfs.File
+}
+
+var _ = (fs.NodeGetattrer)((*fileNode)(nil))
+var _ = (fs.NodeOpener)((*fileNode)(nil))
+var _ = (fs.NodeReader)((*fileNode)(nil))
+
+func (n *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = MODE_FILE
+	out.Size = uint64(n.f.Size())
+	return 0
+}
+
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if isWriteFlags(flags) {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *fileNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	vfsLock.Lock()
+	defer vfsLock.Unlock()
+
+	if err := n.f.Open(true); err != nil {
+		log.Printf("[mount] Cannot open '%s': %v", n.f.Name(), err)
+		return nil, syscall.EIO
+	}
+	defer n.f.Close()
+
+	if off >= n.f.Size() {
+		return fuse.ReadResultData(nil), 0
+	}
+	read, err := n.f.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		log.Printf("[mount] Cannot read '%s': %v", n.f.Name(), err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:read]), 0
+}
+
+// wadNode is directory of wad tags, wad parsed on first access
+type wadNode struct {
+	fs.Inode
+	d    vfs.Directory
+	name string
+
+	names []string
+	data  map[string][]byte
+}
+
+var _ = (fs.NodeReaddirer)((*wadNode)(nil))
+var _ = (fs.NodeLookuper)((*wadNode)(nil))
+var _ = (fs.NodeGetattrer)((*wadNode)(nil))
+
+func (n *wadNode) load() syscall.Errno {
+	vfsLock.Lock()
+	defer vfsLock.Unlock()
+	if n.data != nil {
+		return 0
+	}
+	names, data, err := WadTags(n.d, n.name)
+	if err != nil {
+		log.Printf("[mount] Cannot load tags of '%s': %v", n.name, err)
+		return syscall.EIO
+	}
+	n.names, n.data = names, data
+	return 0
+}
+
+func (n *wadNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = MODE_DIR
+	return 0
+}
+
+func (n *wadNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if errno := n.load(); errno != 0 {
+		return nil, errno
+	}
+	list := make([]fuse.DirEntry, len(n.names))
+	for i, name := range n.names {
+		list[i] = fuse.DirEntry{Name: name, Mode: fuse.S_IFREG}
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *wadNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := n.load(); errno != 0 {
+		return nil, errno
+	}
+	data, ok := n.data[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	out.Mode = MODE_FILE
+	out.Size = uint64(len(data))
+	return n.NewInode(ctx, &tagNode{data: data}, fs.StableAttr{Mode: fuse.S_IFREG}), 0
+}
+
+// tagNode is raw data of wad tag
+type tagNode struct {
+	fs.Inode
+	data []byte
+}
+
+var _ = (fs.NodeGetattrer)((*tagNode)(nil))
+var _ = (fs.NodeOpener)((*tagNode)(nil))
+var _ = (fs.NodeReader)((*tagNode)(nil))
+
+func (n *tagNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = MODE_FILE
+	out.Size = uint64(len(n.data))
+	return 0
+}
+
+func (n *tagNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if isWriteFlags(flags) {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *tagNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	return readData(n.data, dest, off), 0
+}
@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package mount
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+)
+
+func TestMount(t *testing.T) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("fuse is not available:", err)
+	}
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, d := testDirectory(t)
+	defer os.RemoveAll(dir)
+	mnt, err := ioutil.TempDir("", "mountpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mnt)
+
+	m, err := Mount(d, mnt)
+	if err != nil {
+		// no permissions for mount(2) and no fusermount
+		t.Skip("Cannot mount:", err)
+	}
+	defer m.Unmount()
+
+	if data, err := ioutil.ReadFile(filepath.Join(mnt, "README.TXT")); err != nil || string(data) != "readme" {
+		t.Errorf("Unexpected file content %q: %v", data, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(mnt, "R_A.WAD.tags", "1_TXR_A.00000007")); err != nil || !bytes.Equal(data, testTxrData) {
+		t.Errorf("Unexpected tag content %v: %v", data, err)
+	}
+	if st, err := os.Stat(filepath.Join(mnt, "SUB")); err != nil || !st.IsDir() {
+		t.Errorf("Subdirectory not mounted: %v", err)
+	}
+	if names, err := ioutil.ReadDir(filepath.Join(mnt, "R_A.WAD.tags")); err != nil || len(names) != 2 {
+		t.Errorf("Unexpected tags %v: %v", names, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mnt, "README.TXT"), []byte("changed"), 0666); err == nil {
+		t.Errorf("Mount is writable")
+	}
+}
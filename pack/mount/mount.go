@@ -0,0 +1,106 @@
+// Package mount exposes vfs directory (toc, iso, psarc) as read-only
+// local filesystem. Every wad additionally shown as directory of its tags
+package mount
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// suffix of directory with tags of wad, R_A.WAD shown as R_A.WAD.tags
+const WAD_TAGS_SUFFIX = ".tags"
+
+// Mounted is filesystem served until unmounted
+type Mounted interface {
+	// Wait blocks until filesystem unmounted
+	Wait()
+	Unmount() error
+}
+
+// vfs drivers are not safe for concurrent use,
+// but filesystem requests are served in parallel
+var vfsLock sync.Mutex
+
+// TagFileName returns name of tag in wad directory: {id}_{name}.{server-id}.
+// Tags which are not server instances use tag type instead of server id
+func TagFileName(tag *wad.Tag) string {
+	name := strings.NewReplacer("/", "_", "\x00", "_").Replace(tag.Name)
+	if tag.Tag == wad.GetServerInstanceTag() && len(tag.Data) >= 4 {
+		return fmt.Sprintf("%d_%s.%.8x", tag.Id, name, binary.LittleEndian.Uint32(tag.Data))
+	}
+	return fmt.Sprintf("%d_%s.tag%.4x", tag.Id, name, tag.Tag)
+}
+
+// Entry is element of mounted directory
+type Entry struct {
+	Name  string
+	IsDir bool
+	// name of wad file if entry is directory of wad tags
+	Wad string
+}
+
+// ListDirectory returns elements of directory, with tags directory added after every wad
+func ListDirectory(d vfs.Directory) ([]Entry, error) {
+	names, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		e, err := d.GetElement(name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Name: name, IsDir: e.IsDirectory()})
+		if !e.IsDirectory() && wad.IsWadFileName(name) {
+			entries = append(entries, Entry{Name: name + WAD_TAGS_SUFFIX, IsDir: true, Wad: name})
+		}
+	}
+	return entries, nil
+}
+
+// LookupEntry returns element of directory, or wad of tags directory
+func LookupEntry(d vfs.Directory, name string) (Entry, vfs.Element, error) {
+	if e, err := d.GetElement(name); err == nil {
+		return Entry{Name: name, IsDir: e.IsDirectory()}, e, nil
+	}
+	wadName := strings.TrimSuffix(name, WAD_TAGS_SUFFIX)
+	if wadName == name || !wad.IsWadFileName(wadName) {
+		return Entry{}, nil, fmt.Errorf("Cannot find '%s'", name)
+	}
+	e, err := d.GetElement(wadName)
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	if e.IsDirectory() {
+		return Entry{}, nil, fmt.Errorf("'%s' is not wad file", wadName)
+	}
+	return Entry{Name: name, IsDir: true, Wad: wadName}, e, nil
+}
+
+// WadTags parses wad and returns data of tags by tag file names,
+// same data as dumped by web handler of tag
+func WadTags(d vfs.Directory, wadName string) (names []string, data map[string][]byte, err error) {
+	inst, err := pack.GetInstanceHandler(d, wadName)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, ok := inst.(*wad.Wad)
+	if !ok {
+		return nil, nil, fmt.Errorf("'%s' is not wad: %T", wadName, inst)
+	}
+	names = make([]string, 0, len(w.Tags))
+	data = make(map[string][]byte, len(w.Tags))
+	for i := range w.Tags {
+		name := TagFileName(&w.Tags[i])
+		names = append(names, name)
+		data[name] = w.Tags[i].Data
+	}
+	return names, data, nil
+}
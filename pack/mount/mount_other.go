@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package mount
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// Mount requires fuse, which is not available on this platform
+func Mount(d vfs.Directory, mountpoint string) (Mounted, error) {
+	return nil, fmt.Errorf("Mount is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,86 @@
+package mount
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+var testTxrData = []byte{7, 0, 0, 0, 'd', 'a', 't', 'a'}
+
+func writeTestWad(t *testing.T, path string, tags []wad.Tag) {
+	if err := ioutil.WriteFile(path, wad.MarshalTags(tags), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDirectory creates directory with R_A.WAD, README.TXT and SUB/
+func testDirectory(t *testing.T) (string, vfs.Directory) {
+	dir, err := ioutil.TempDir("", "mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestWad(t, filepath.Join(dir, "R_A.WAD"), []wad.Tag{
+		{Tag: wad.TAG_GOW1_FILE_MC_DATA, Name: "GFX/A", Data: []byte{1, 2, 3}},
+		{Tag: wad.TAG_GOW1_SERVER_INSTANCE, Name: "TXR_A", Data: testTxrData},
+	})
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.TXT"), []byte("readme"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "SUB"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	return dir, vfs.NewDirectoryDriver(dir)
+}
+
+func TestWadTags(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, d := testDirectory(t)
+	defer os.RemoveAll(dir)
+
+	entries, err := ListDirectory(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Entry{
+		"R_A.WAD":      {Name: "R_A.WAD"},
+		"R_A.WAD.tags": {Name: "R_A.WAD.tags", IsDir: true, Wad: "R_A.WAD"},
+		"README.TXT":   {Name: "README.TXT"},
+		"SUB":          {Name: "SUB", IsDir: true},
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+	for _, e := range entries {
+		if expected[e.Name] != e {
+			t.Errorf("Unexpected entry %+v", e)
+		}
+	}
+
+	if e, _, err := LookupEntry(d, "R_A.WAD.tags"); err != nil || e.Wad != "R_A.WAD" {
+		t.Errorf("Lookup of tags directory: %+v %v", e, err)
+	}
+	if _, _, err := LookupEntry(d, "README.TXT.tags"); err == nil {
+		t.Errorf("Tags directory of not wad file found")
+	}
+
+	names, data, err := WadTags(d, "R_A.WAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"0_GFX_A.tag006e", "1_TXR_A.00000007"}) {
+		t.Errorf("Unexpected tag names %v", names)
+	}
+	if !bytes.Equal(data["1_TXR_A.00000007"], testTxrData) {
+		t.Errorf("Unexpected tag data %v", data["1_TXR_A.00000007"])
+	}
+}
@@ -18,6 +18,12 @@ func SetHandler(format string, ldr FileLoader) {
 	gHandlers[strings.ToUpper(format)] = ldr
 }
 
+// HasHandler reports if handler registered for file extension
+func HasHandler(name string) bool {
+	_, found := gHandlers[strings.ToUpper(filepath.Ext(name))]
+	return found
+}
+
 func CallHandler(s utils.ResourceSource, r *io.SectionReader) (interface{}, error) {
 	ext := strings.ToUpper(filepath.Ext(s.Name()))
 
@@ -41,12 +47,40 @@ func (s *PackResSrc) Size() int64 {
 	return s.pf.Size()
 }
 
+func (s *PackResSrc) Directory() vfs.Directory {
+	return s.d
+}
+
+// DirectorySource implemented by sources which know directory of file
+type DirectorySource interface {
+	Directory() vfs.Directory
+}
+
+// SourceDirectory returns directory of source or nil if unknown
+func SourceDirectory(s utils.ResourceSource) vfs.Directory {
+	if ds, ok := s.(DirectorySource); ok {
+		return ds.Directory()
+	}
+	return nil
+}
+
+var gSaveHandlers []func(d vfs.Directory, name string)
+
+// OnSave registers function called after file saved through its resource source
+func OnSave(h func(d vfs.Directory, name string)) {
+	gSaveHandlers = append(gSaveHandlers, h)
+}
+
 func (s *PackResSrc) Save(in *io.SectionReader) error {
 	if f, err := vfs.DirectoryGetFile(s.d, s.pf.Name()); err != nil {
 		return fmt.Errorf("[pack] Cannot get file '%s': %v", s.pf.Name(), err)
-	} else {
-		return f.Copy(in)
+	} else if err := f.Copy(in); err != nil {
+		return err
+	}
+	for _, h := range gSaveHandlers {
+		h(s.d, s.pf.Name())
 	}
+	return nil
 }
 
 func GetInstanceHandler(d vfs.Directory, fileName string) (interface{}, error) {
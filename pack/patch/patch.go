@@ -0,0 +1,413 @@
+// Package patch creates and applies tag level deltas between original and modded game data,
+// so mods can be shared without redistributing game files
+package patch
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// version 2 added removed files, version 3 added changed files stored whole
+const PATCH_VERSION = 3
+const PATCH_MANIFEST_NAME = "patch.json"
+
+// Patch is stored as zip archive with json manifest and data entries
+type Patch struct {
+	Version int
+	Files   []*FilePatch
+}
+
+type FilePatch struct {
+	Name string
+	// empty for files not present in original data
+	OriginalHash string `json:",omitempty"`
+	// empty for removed files
+	ResultHash string `json:",omitempty"`
+	Removed    bool   `json:",omitempty"`
+	// zip entry with whole file content, used for new files
+	// and for changed files which are not wads
+	Data string `json:",omitempty"`
+	// list of tags of result wad
+	Tags      []TagOp           `json:",omitempty"`
+	HeapSizes map[string]uint32 `json:",omitempty"`
+}
+
+// TagOp copies Count tags of original wad starting from Source,
+// or adds new tag if Count is zero
+type TagOp struct {
+	Source wad.TagId `json:",omitempty"`
+	Count  int       `json:",omitempty"`
+
+	Tag        uint16 `json:",omitempty"`
+	Flags      uint16 `json:",omitempty"`
+	Name       string `json:",omitempty"`
+	GUID       []byte `json:",omitempty"`
+	HeaderTail []byte `json:",omitempty"`
+	// zip entry with tag data
+	Data string `json:",omitempty"`
+}
+
+func hashData(data []byte) string {
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+func readFile(d vfs.Directory, name string) ([]byte, error) {
+	f, err := vfs.DirectoryGetFile(d, name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := vfs.OpenFileAndGetReader(f, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(r)
+}
+
+func openWad(d vfs.Directory, name string) (*wad.Wad, error) {
+	inst, err := pack.GetInstanceHandler(d, name)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := inst.(*wad.Wad)
+	if !ok {
+		return nil, errors.Errorf("%q is not wad", name)
+	}
+	return w, nil
+}
+
+func tagKey(t *wad.Tag) string {
+	return fmt.Sprintf("%d:%d:%q:%x:%x:%s", t.Tag, t.Flags, t.Name, t.GUID, t.HeaderTail, hashData(t.Data))
+}
+
+type creator struct {
+	zw    *zip.Writer
+	patch Patch
+}
+
+func (c *creator) writeEntry(name string, data []byte) error {
+	w, err := c.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Create writes patch which turns original files into modded ones.
+// Changed wads stored as tag deltas, other changed files stored whole
+func Create(original, modded vfs.Directory, out io.Writer) error {
+	names, err := modded.List()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list modded files")
+	}
+	sort.Strings(names)
+	originalNames, err := original.List()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list original files")
+	}
+	sort.Strings(originalNames)
+
+	c := &creator{
+		zw:    zip.NewWriter(out),
+		patch: Patch{Version: PATCH_VERSION},
+	}
+
+	for _, name := range names {
+		moddedData, err := readFile(modded, name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read modded %q", name)
+		}
+		fp := &FilePatch{Name: name, ResultHash: hashData(moddedData)}
+
+		if _, err := original.GetElement(name); err != nil {
+			fp.Data = fmt.Sprintf("files/%s", name)
+			if err := c.writeEntry(fp.Data, moddedData); err != nil {
+				return err
+			}
+			log.Printf("[patch] New file %q", name)
+			c.patch.Files = append(c.patch.Files, fp)
+			continue
+		}
+
+		originalData, err := readFile(original, name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read original %q", name)
+		}
+		fp.OriginalHash = hashData(originalData)
+		if fp.OriginalHash == fp.ResultHash {
+			continue
+		}
+
+		ow, err := openWad(original, name)
+		var mw *wad.Wad
+		if err == nil {
+			mw, err = openWad(modded, name)
+		}
+		if err != nil {
+			fp.Data = fmt.Sprintf("files/%s", name)
+			if err := c.writeEntry(fp.Data, moddedData); err != nil {
+				return err
+			}
+			log.Printf("[patch] Changed file %q is not wad, stored whole: %v", name, err)
+		} else if err := c.diffWad(ow, mw, fp); err != nil {
+			return errors.Wrapf(err, "Failed to diff %q", name)
+		}
+		c.patch.Files = append(c.patch.Files, fp)
+	}
+
+	for _, name := range originalNames {
+		if _, err := modded.GetElement(name); err == nil {
+			continue
+		}
+		originalData, err := readFile(original, name)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read original %q", name)
+		}
+		log.Printf("[patch] Removed file %q", name)
+		c.patch.Files = append(c.patch.Files, &FilePatch{Name: name, OriginalHash: hashData(originalData), Removed: true})
+	}
+
+	manifest, err := json.MarshalIndent(&c.patch, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := c.writeEntry(PATCH_MANIFEST_NAME, manifest); err != nil {
+		return err
+	}
+	return c.zw.Close()
+}
+
+func (c *creator) diffWad(ow, mw *wad.Wad, fp *FilePatch) error {
+	originalTags := make(map[string][]wad.TagId)
+	for i := range ow.Tags {
+		key := tagKey(&ow.Tags[i])
+		originalTags[key] = append(originalTags[key], ow.Tags[i].Id)
+	}
+
+	prev := wad.TagId(-2)
+	changed := 0
+	for i := range mw.Tags {
+		t := &mw.Tags[i]
+
+		// prefer following original tag, so unchanged ranges merged into one op
+		var source wad.TagId = -1
+		for _, id := range originalTags[tagKey(t)] {
+			if id == prev+1 {
+				source = id
+				break
+			} else if source == -1 {
+				source = id
+			}
+		}
+
+		if source != -1 {
+			if last := len(fp.Tags) - 1; last >= 0 && fp.Tags[last].Count != 0 && source == prev+1 {
+				fp.Tags[last].Count++
+			} else {
+				fp.Tags = append(fp.Tags, TagOp{Source: source, Count: 1})
+			}
+			prev = source
+			continue
+		}
+
+		op := TagOp{
+			Tag:        t.Tag,
+			Flags:      t.Flags,
+			Name:       t.Name,
+			HeaderTail: t.HeaderTail,
+		}
+		if t.GUID != [0x10]byte{} {
+			op.GUID = t.GUID[:]
+		}
+		if t.Data != nil {
+			op.Data = fmt.Sprintf("tags/%s/%d", fp.Name, t.Id)
+			if err := c.writeEntry(op.Data, t.Data); err != nil {
+				return err
+			}
+		}
+		fp.Tags = append(fp.Tags, op)
+		prev = -2
+		changed++
+	}
+
+	for name, size := range mw.HeapSizes {
+		if originalSize, ok := ow.HeapSizes[name]; !ok || originalSize != size {
+			if fp.HeapSizes == nil {
+				fp.HeapSizes = make(map[string]uint32)
+			}
+			fp.HeapSizes[name] = size
+		}
+	}
+
+	log.Printf("[patch] %q: %d tags of %d changed", fp.Name, changed, len(mw.Tags))
+	return nil
+}
+
+// verifySource checks result of wad save before writing it
+type verifySource struct {
+	utils.ResourceSource
+	hash string
+}
+
+func (vs *verifySource) Directory() vfs.Directory {
+	return pack.SourceDirectory(vs.ResourceSource)
+}
+
+func (vs *verifySource) Save(in *io.SectionReader) error {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if hash := hashData(data); hash != vs.hash {
+		return errors.Errorf("Result hash mismatch %s != %s", hash, vs.hash)
+	}
+	return vs.ResourceSource.Save(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+}
+
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		}
+	}
+	return nil, errors.Errorf("Entry %q not found in patch", name)
+}
+
+// Apply replays patch onto clean game data. All files are checked
+// before writing, already patched files are skipped
+func Apply(d vfs.Directory, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open patch")
+	}
+	manifest, err := readZipEntry(zr, PATCH_MANIFEST_NAME)
+	if err != nil {
+		return err
+	}
+	var p Patch
+	if err := json.Unmarshal(manifest, &p); err != nil {
+		return errors.Wrapf(err, "Failed to parse patch manifest")
+	}
+	if p.Version < 1 || p.Version > PATCH_VERSION {
+		return errors.Errorf("Unsupported patch version %d", p.Version)
+	}
+
+	pending := make([]*FilePatch, 0, len(p.Files))
+	for _, fp := range p.Files {
+		var hash string
+		if _, err := d.GetElement(fp.Name); err == nil {
+			data, err := readFile(d, fp.Name)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to read %q", fp.Name)
+			}
+			hash = hashData(data)
+		}
+
+		switch hash {
+		case fp.ResultHash:
+			log.Printf("[patch] %q already patched", fp.Name)
+		case fp.OriginalHash:
+			pending = append(pending, fp)
+		default:
+			return errors.Errorf("%q differs from original data", fp.Name)
+		}
+	}
+
+	for _, fp := range pending {
+		if err := applyFile(d, zr, fp); err != nil {
+			return errors.Wrapf(err, "Failed to patch %q", fp.Name)
+		}
+		log.Printf("[patch] Patched %q", fp.Name)
+	}
+	return nil
+}
+
+func applyFile(d vfs.Directory, zr *zip.Reader, fp *FilePatch) error {
+	if fp.Removed {
+		return d.Remove(fp.Name)
+	}
+	if fp.Data != "" {
+		data, err := readZipEntry(zr, fp.Data)
+		if err != nil {
+			return err
+		}
+		f, err := vfs.DirectoryGetOrCreateFile(d, fp.Name)
+		if err != nil {
+			return err
+		}
+		return vfs.OpenFileAndCopy(f, bytes.NewReader(data))
+	}
+
+	w, err := openWad(d, fp.Name)
+	if err != nil {
+		return err
+	}
+	return applyWad(w, zr, fp)
+}
+
+// applyWad saves patched tags of wad, wad stays unchanged if save failed
+func applyWad(w *wad.Wad, zr *zip.Reader, fp *FilePatch) (err error) {
+	tags := make([]wad.Tag, 0, len(w.Tags))
+	for _, op := range fp.Tags {
+		if op.Count != 0 {
+			if op.Source < 0 || int(op.Source)+op.Count > len(w.Tags) {
+				return errors.Errorf("Tags range %d+%d out of wad", op.Source, op.Count)
+			}
+			tags = append(tags, w.Tags[op.Source:int(op.Source)+op.Count]...)
+			continue
+		}
+
+		t := wad.Tag{
+			Tag:        op.Tag,
+			Flags:      op.Flags,
+			Name:       op.Name,
+			HeaderTail: op.HeaderTail,
+		}
+		copy(t.GUID[:], op.GUID)
+		if op.Data != "" {
+			if t.Data, err = readZipEntry(zr, op.Data); err != nil {
+				return err
+			}
+		}
+		tags = append(tags, t)
+	}
+	heapSizes := w.HeapSizes
+	w.HeapSizes = make(map[string]uint32, len(heapSizes)+len(fp.HeapSizes))
+	for name, size := range heapSizes {
+		w.HeapSizes[name] = size
+	}
+	for name, size := range fp.HeapSizes {
+		w.HeapSizes[name] = size
+	}
+
+	source := w.Source
+	w.Source = &verifySource{ResourceSource: source, hash: fp.ResultHash}
+	defer func() { w.Source = source }()
+	if err := w.Save(tags); err != nil {
+		w.HeapSizes = heapSizes
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package patch
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+func rawTag(name string, data string) wad.Tag {
+	return wad.Tag{Tag: wad.TAG_GOW1_FILE_RAW_DATA, Name: name, Data: []byte(data)}
+}
+
+func writeTestDir(t *testing.T, files map[string][]byte) string {
+	dir, err := ioutil.TempDir("", "patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCreateApply(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	original := wad.MarshalTags([]wad.Tag{
+		rawTag("A", "first"), rawTag("B", "second"), rawTag("C", "third"), rawTag("D", "fourth")})
+	modded := wad.MarshalTags([]wad.Tag{
+		rawTag("A", "first"), rawTag("B", "second changed"), rawTag("NEW", "inserted"), rawTag("C", "third"), rawTag("D", "fourth")})
+
+	originalDir := writeTestDir(t, map[string][]byte{"R_TEST.WAD": original, "R_SAME.WAD": original, "OLD.TXT": []byte("old"), "CHANGED.TXT": []byte("before")})
+	defer os.RemoveAll(originalDir)
+	moddedDir := writeTestDir(t, map[string][]byte{"R_TEST.WAD": modded, "R_SAME.WAD": original, "NEW.TXT": []byte("mod"), "CHANGED.TXT": []byte("after")})
+	defer os.RemoveAll(moddedDir)
+
+	var patchBuf bytes.Buffer
+	if err := Create(vfs.NewDirectoryDriver(originalDir), vfs.NewDirectoryDriver(moddedDir), &patchBuf); err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(patchBuf.Bytes()), int64(patchBuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manifest, new file, changed file and two changed tags, removed file has no data
+	if len(zr.File) != 5 {
+		t.Errorf("Unexpected patch entries count %d", len(zr.File))
+	}
+
+	for i := 0; i < 2; i++ {
+		// second apply must skip already patched files
+		if err := Apply(vfs.NewDirectoryDriver(originalDir), bytes.NewReader(patchBuf.Bytes()), int64(patchBuf.Len())); err != nil {
+			t.Fatalf("Failed to apply patch (%d): %v", i, err)
+		}
+	}
+
+	for name, expected := range map[string][]byte{"R_TEST.WAD": modded, "R_SAME.WAD": original, "NEW.TXT": []byte("mod"), "CHANGED.TXT": []byte("after")} {
+		if data, err := ioutil.ReadFile(filepath.Join(originalDir, name)); err != nil {
+			t.Errorf("Failed to read %s: %v", name, err)
+		} else if !bytes.Equal(data, expected) {
+			t.Errorf("Result %s mismatch", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(originalDir, "OLD.TXT")); !os.IsNotExist(err) {
+		t.Errorf("Removed file still exists: %v", err)
+	}
+}
+
+type testSource struct {
+	name  string
+	saved bool
+}
+
+func (s *testSource) Name() string                    { return s.name }
+func (s *testSource) Size() int64                     { return 0 }
+func (s *testSource) Save(in *io.SectionReader) error { s.saved = true; return nil }
+
+func TestApplyWadFailedSave(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	data := wad.MarshalTags([]wad.Tag{rawTag("A", "first")})
+	src := &testSource{name: "R_TEST.WAD"}
+	w, err := wad.NewWad(bytes.NewReader(data), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.HeapSizes = map[string]uint32{"main": 1}
+
+	fp := &FilePatch{
+		Name:       "R_TEST.WAD",
+		ResultHash: "not a hash of result",
+		Tags:       []TagOp{{Source: 0, Count: 1}},
+		HeapSizes:  map[string]uint32{"main": 2},
+	}
+	if err := applyWad(w, nil, fp); err == nil {
+		t.Fatalf("Result with wrong hash saved")
+	}
+	if src.saved {
+		t.Errorf("Source written")
+	}
+	if w.Source != utils.ResourceSource(src) {
+		t.Errorf("Source of wad not restored")
+	}
+	if w.HeapSizes["main"] != 1 {
+		t.Errorf("Heap sizes not restored: %v", w.HeapSizes)
+	}
+}
@@ -0,0 +1,247 @@
+// Package refgraph builds graph of references between wad nodes,
+// names not resolved inside wad are looked up in wads listed by RSRCS
+package refgraph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+const (
+	EDGE_CONTAINS  = "contains"  // node of subgroup
+	EDGE_REFERENCE = "reference" // name used by instance
+	EDGE_WAD       = "wad"       // wad listed by rsrcs
+)
+
+const (
+	DIRECTION_DEPENDENCIES = "dependencies"
+	DIRECTION_DEPENDENTS   = "dependents"
+)
+
+type Node struct {
+	Id       string
+	Wad      string
+	TagId    wad.TagId // NODE_INVALID for wad itself
+	Name     string
+	ServerId uint32
+	Error    string `json:",omitempty"`
+}
+
+// Edge from dependent to dependency, To is empty for dangling reference
+type Edge struct {
+	From string
+	To   string
+	Kind string
+	Name string
+}
+
+type Graph struct {
+	Wad      string
+	Nodes    []*Node
+	Edges    []*Edge
+	Dangling []*Edge
+
+	nodes map[string]*Node
+}
+
+// TagNodeId returns id of graph node for wad tag
+func TagNodeId(wadName string, tagId wad.TagId) string {
+	return fmt.Sprintf("%s/%d", wadName, tagId)
+}
+
+func newGraph(wadName string) *Graph {
+	return &Graph{
+		Wad:      wadName,
+		Nodes:    make([]*Node, 0),
+		Edges:    make([]*Edge, 0),
+		Dangling: make([]*Edge, 0),
+		nodes:    make(map[string]*Node),
+	}
+}
+
+func (g *Graph) addNode(n *Node) *Node {
+	if existing, ok := g.nodes[n.Id]; ok {
+		return existing
+	}
+	g.nodes[n.Id] = n
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+func (g *Graph) addEdge(e *Edge) {
+	g.Edges = append(g.Edges, e)
+	if e.To == "" {
+		g.Dangling = append(g.Dangling, e)
+	}
+}
+
+type builder struct {
+	dir  vfs.Directory
+	g    *Graph
+	wads map[string]*wad.Wad
+}
+
+func (b *builder) openWad(name string) (*wad.Wad, error) {
+	if w, ok := b.wads[name]; ok {
+		if w == nil {
+			return nil, errors.Errorf("Wad %q not found", name)
+		}
+		return w, nil
+	}
+	b.wads[name] = nil
+	w, err := wad.OpenWad(b.dir, name)
+	if err != nil {
+		return nil, err
+	}
+	b.wads[name] = w
+	return w, nil
+}
+
+func (b *builder) addWadNode(wadName string, w *wad.Wad, n *wad.Node) (*Node, []wad.Reference) {
+	gn := &Node{
+		Id:    TagNodeId(wadName, n.Tag.Id),
+		Wad:   wadName,
+		TagId: n.Tag.Id,
+		Name:  n.Tag.Name,
+	}
+	refs, serverId, err := w.InstanceReferences(n.Id)
+	gn.ServerId = serverId
+	if err != nil {
+		gn.Error = err.Error()
+	}
+	return b.g.addNode(gn), refs
+}
+
+// Build collects references of every node of wad
+func Build(d vfs.Directory, wadName string) (*Graph, error) {
+	b := &builder{dir: d, g: newGraph(wadName), wads: make(map[string]*wad.Wad)}
+	w, err := b.openWad(wadName)
+	if err != nil {
+		return nil, err
+	}
+
+	type nodeRefs struct {
+		node *wad.Node
+		gn   *Node
+		refs []wad.Reference
+	}
+	nodes := make([]nodeRefs, 0, len(w.Nodes))
+	for _, n := range w.Nodes {
+		if w.GetNodeById(n.Id) != n {
+			// link to previously loaded node with same name
+			continue
+		}
+		gn, refs := b.addWadNode(wadName, w, n)
+		nodes = append(nodes, nodeRefs{node: n, gn: gn, refs: refs})
+	}
+	// names not presented in this wad looked up in wads listed by rsrcs
+	r := wad.NewNameResolver(w, b.openWad)
+
+	for _, nr := range nodes {
+		for _, subId := range nr.node.SubGroupNodes {
+			sub := w.GetNodeById(subId)
+			b.g.addEdge(&Edge{From: nr.gn.Id, To: TagNodeId(wadName, sub.Tag.Id), Kind: EDGE_CONTAINS, Name: sub.Tag.Name})
+		}
+		for _, ref := range nr.refs {
+			e := &Edge{From: nr.gn.Id, Name: ref.Name}
+			switch ref.Kind {
+			case wad.REF_WAD:
+				e.Kind = EDGE_WAD
+				e.To = b.resolveWad(wad.RsrcsWadName(wadName, ref.Name))
+			default:
+				e.Kind = EDGE_REFERENCE
+				if ow, n := r.Resolve(nr.node.Id, ref.Name); n != nil {
+					gn, _ := b.addWadNode(ow.Name(), ow, n)
+					e.To = gn.Id
+				}
+			}
+			b.g.addEdge(e)
+		}
+	}
+	return b.g, nil
+}
+
+func (b *builder) resolveWad(name string) string {
+	if _, err := b.dir.GetElement(name); err != nil {
+		return ""
+	}
+	return b.g.addNode(&Node{Id: name, Wad: name, TagId: wad.NODE_INVALID, Name: name}).Id
+}
+
+// Subgraph returns nodes reachable from node in direction of dependencies or dependents
+func (g *Graph) Subgraph(id string, direction string) (*Graph, error) {
+	if _, ok := g.nodes[id]; !ok {
+		return nil, errors.Errorf("Node %q not found", id)
+	}
+	if direction != DIRECTION_DEPENDENCIES && direction != DIRECTION_DEPENDENTS {
+		return nil, errors.Errorf("Unknown direction %q", direction)
+	}
+
+	visited := map[string]bool{id: true}
+	queue := []string{id}
+	sub := newGraph(g.Wad)
+	sub.addNode(g.nodes[id])
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			var next string
+			if direction == DIRECTION_DEPENDENCIES && e.From == cur {
+				next = e.To
+			} else if direction == DIRECTION_DEPENDENTS && e.To == cur {
+				next = e.From
+			} else {
+				continue
+			}
+			sub.addEdge(e)
+			if next != "" && !visited[next] {
+				visited[next] = true
+				sub.addNode(g.nodes[next])
+				queue = append(queue, next)
+			}
+		}
+	}
+	return sub, nil
+}
+
+// WriteDot writes graph in graphviz format, dangling references are red
+func (g *Graph) WriteDot(w io.Writer) error {
+	fmt.Fprintf(w, "digraph %q {\n\trankdir=LR;\n\tnode [shape=box];\n", g.Wad)
+	ids := make([]string, 0, len(g.nodes))
+	for id := range g.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		n := g.nodes[id]
+		switch {
+		case n.TagId == wad.NODE_INVALID:
+			fmt.Fprintf(w, "\t%q [label=%q, shape=folder];\n", id, n.Name)
+		case n.Wad != g.Wad:
+			fmt.Fprintf(w, "\t%q [label=%q, style=dashed];\n", id, n.Wad+"\n"+n.Name)
+		default:
+			fmt.Fprintf(w, "\t%q [label=%q];\n", id, n.Name)
+		}
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Kind == EDGE_CONTAINS {
+			style = ", style=dotted"
+		}
+		if e.To == "" {
+			missing := "missing:" + e.Name
+			fmt.Fprintf(w, "\t%q [label=%q, color=red];\n", missing, e.Name)
+			fmt.Fprintf(w, "\t%q -> %q [color=red%s];\n", e.From, missing, style)
+		} else {
+			fmt.Fprintf(w, "\t%q -> %q [label=%q%s];\n", e.From, e.To, e.Kind, style)
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
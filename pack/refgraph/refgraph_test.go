@@ -0,0 +1,105 @@
+package refgraph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	_ "github.com/mogaika/god_of_war_browser/pack/wad/rsrcs"
+	"github.com/mogaika/god_of_war_browser/pack/wad/txr"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+func writeTestWad(t *testing.T, path string, tags []wad.Tag) {
+	if err := ioutil.WriteFile(path, wad.MarshalTags(tags), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	dir, err := ioutil.TempDir("", "refgraph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	txrData := make([]byte, 88)
+	binary.LittleEndian.PutUint32(txrData, txr.TXR_MAGIC)
+	copy(txrData[4:], "GFX_A")
+	copy(txrData[28:], "PAL_B")
+	copy(txrData[52:], "TXR_MISSING")
+	binary.LittleEndian.PutUint32(txrData[84:], 0x10000)
+	rsrcsData := append(utils.StringToBytesBuffer("R_B", 24, false), utils.StringToBytesBuffer("R_MISSING", 24, false)...)
+
+	writeTestWad(t, filepath.Join(dir, "R_A.WAD"), []wad.Tag{
+		{Tag: wad.TAG_GOW1_RSRCS, Name: "RSRCS", Data: rsrcsData},
+		{Tag: wad.TAG_GOW1_FILE_MC_DATA, Name: "GFX_A", Data: []byte{1}},
+		{Tag: wad.TAG_GOW1_SERVER_INSTANCE, Name: "TXR_A", Data: txrData},
+	})
+	writeTestWad(t, filepath.Join(dir, "R_B.WAD"), []wad.Tag{
+		{Tag: wad.TAG_GOW1_FILE_MC_DATA, Name: "PAL_B", Data: []byte{1}},
+	})
+
+	g, err := Build(vfs.NewDirectoryDriver(dir), "R_A.WAD")
+	if err != nil {
+		t.Fatalf("Failed to build graph: %v", err)
+	}
+
+	dangling := make(map[string]bool)
+	for _, e := range g.Dangling {
+		dangling[e.Name] = true
+	}
+	if len(dangling) != 2 || !dangling["TXR_MISSING"] || !dangling["R_MISSING"] {
+		t.Errorf("Unexpected dangling references %v", dangling)
+	}
+
+	deps, err := g.Subgraph(TagNodeId("R_A.WAD", 2), DIRECTION_DEPENDENCIES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, n := range deps.Nodes {
+		found[n.Id] = true
+	}
+	if len(found) != 3 || !found[TagNodeId("R_A.WAD", 1)] || !found[TagNodeId("R_B.WAD", 0)] {
+		t.Errorf("Unexpected dependencies %v", found)
+	}
+
+	users, err := g.Subgraph(TagNodeId("R_A.WAD", 1), DIRECTION_DEPENDENTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Nodes) != 2 || users.Nodes[1].Name != "TXR_A" {
+		t.Errorf("Unexpected dependents %v", users.Nodes)
+	}
+
+	var dot bytes.Buffer
+	if err := g.WriteDot(&dot); err != nil || !bytes.Contains(dot.Bytes(), []byte(`"missing:TXR_MISSING"`)) {
+		t.Errorf("Unexpected dot output %s", dot.String())
+	}
+
+	// validation resolves names same way as graph
+	w, err := wad.OpenWad(vfs.NewDirectoryDriver(dir), "R_A.WAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, issue := range w.Validate().Issues {
+		if issue.Check == "references" {
+			messages = append(messages, issue.Message)
+		}
+	}
+	if len(messages) != 2 || !strings.Contains(messages[0], "TXR_MISSING") || !strings.Contains(messages[1], "R_MISSING.WAD") {
+		t.Errorf("Unexpected reference issues %v", messages)
+	}
+}
@@ -2,6 +2,7 @@ package anm
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
 	"os"
 
@@ -36,6 +37,8 @@ type AnimActStateDescr struct {
 	CountOfSomething uint16
 	FrameTime        float32
 	Data             interface{}
+	// set when stream was not decoded, only for versions with unverified stream layouts
+	Error string `json:",omitempty"`
 }
 
 type AnimAct struct {
@@ -73,16 +76,69 @@ func u16(d []byte, off uint32) uint16 {
 	return binary.LittleEndian.Uint16(d[off : off+2])
 }
 
+// checkRange returns error if [off, off+size) is out of data
+func checkRange(data []byte, off uint32, size uint32, what string) error {
+	if uint64(off)+uint64(size) > uint64(len(data)) {
+		return fmt.Errorf("%s at 0x%x (size 0x%x) is out of file (size 0x%x)", what, off, size, len(data))
+	}
+	return nil
+}
+
+// validateTables checks that group and act tables are inside of file
+func validateTables(data []byte) error {
+	if err := checkRange(data, 0, 0x18, "Header"); err != nil {
+		return err
+	}
+	dataTypesCount := uint32(u16(data, 0x10))
+	groupsCount := uint32(u16(data, 0x12))
+	if err := checkRange(data, 0x18, (groupsCount+dataTypesCount)*4, "Groups and data types tables"); err != nil {
+		return err
+	}
+	for i := uint32(0); i < groupsCount; i++ {
+		groupOffset := u32(data, 0x18+i*4)
+		if err := checkRange(data, groupOffset, 0x30, fmt.Sprintf("Group %d", i)); err != nil {
+			return err
+		}
+		rawGroup := data[groupOffset:]
+		if u32(rawGroup, 8)&0x20000 != 0 {
+			continue
+		}
+		actsCount := u32(rawGroup, 0xc)
+		if err := checkRange(rawGroup, 0x30, actsCount*4, fmt.Sprintf("Group %d acts table", i)); err != nil {
+			return err
+		}
+		for j := uint32(0); j < actsCount; j++ {
+			actOffset := u32(rawGroup, 0x30+j*4)
+			if err := checkRange(rawGroup, actOffset, 0x64+dataTypesCount*0x14,
+				fmt.Sprintf("Group %d act %d", i, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func NewFromData(data []byte) (*Animations, error) {
-	a := &Animations{
+	return newFromData(data, false)
+}
+
+// newFromData parses animation tables. When lenientStreams is set, stream which
+// can not be decoded is left without data and with error, instead of failing whole file
+func newFromData(data []byte, lenientStreams bool) (a *Animations, err error) {
+	if err := validateTables(data); err != nil {
+		return nil, err
+	}
+
+	a = &Animations{
 		DataTypes: make([]AnimDatatype, u16(data, 0x10)),
 		Groups:    make([]AnimGroup, u16(data, 0x12)),
 	}
 
+	// stream decoders do not check bounds
 	defer func() {
-		/*if r := recover(); r != nil {
-			utils.LogDump("Animation parsing panic: %v", r)
-		}*/
+		if r := recover(); r != nil {
+			a, err = nil, fmt.Errorf("Animation parsing panic: %v", r)
+		}
 	}()
 
 	flags := u32(data, 8)
@@ -154,43 +210,12 @@ func NewFromData(data []byte) (*Animations, error) {
 					_l.Printf("   . . . . . . . . . . STATE '%d'  FrameTime: %f  unk0: 0x%x  unk4: 0x%x . . . . . . . . . . . . . . . . . . . . . . . . . . . ",
 						iStateDescr, sd.FrameTime, sd.Unk0, u32(rawActStateDescr, 4))
 
-					//log.Println(iStateDescr, a.DataTypes, a.DataTypes[iStateDescr].TypeId)
-					switch a.DataTypes[iStateDescr].TypeId {
-					case DATATYPE_TEXUREPOS:
-						data := make([]*AnimState8Texturepos, sd.CountOfSomething)
-						for i := 0; i < int(sd.CountOfSomething); i++ {
-							data[i] = AnimState8TextureposFromBuf(&a.DataTypes[iStateDescr], rawAct[sd.OffsetToData:], i)
-						}
-						sd.Data = data
-					case DATATYPE_SKINNING:
-						_l.Printf("ROTATION ANIMATIONS COUNT: %v", int(u16(rawAct, 0x8e)))
-						_l.Printf("SIZE ANIMATIONS COUNT: %v", int(u16(rawAct, 0x8e)))
-						_l.Printf("POSITION ANIMATIONS COUNT: %v", int(u16(rawAct, 0x7a)))
-						_l.Printf("descr: %+#v", a.DataTypes[iStateDescr])
-						_l.Printf("SUBELEMENT UPDATES OR OFFETS OR STATES COUNT(0xA2): %v", int(u16(rawAct, 0xa2)))
-
-						data := make([]*AnimState0Skinning, sd.CountOfSomething)
-						for i := 0; i < int(sd.CountOfSomething); i++ {
-							skinAnim := AnimState0SkinningFromBuf(rawAct[sd.OffsetToData:], i, _l)
-							skinAnim.ParseRotations(rawAct[sd.OffsetToData:], i, _l)
-							data[i] = skinAnim
-						}
-						for i := 0; i < int(u16(rawAct, 0x7a)); i++ {
-							skinAnim := AnimState0SkinningFromBuf(rawAct[sd.OffsetToData:], i, _l)
-							skinAnim.ParsePositions(rawAct[sd.OffsetToData:], i, _l, rawAct)
-							data = append(data, skinAnim)
-						}
-						sd.Data = data
-					case DATATYPE_TEXTURESHEET:
-						buf := rawAct[sd.OffsetToData:]
-						data := make([]uint32, u16(buf, 4))
-						dataBuf := buf[u16(buf, 0xa):]
-						for i := range data {
-							data[i] = u32(dataBuf, uint32(i*4))
-						}
-						sd.Data = data
+					if !lenientStreams {
+						a.decodeState(iStateDescr, sd, rawAct, _l)
+					} else if err := a.decodeStateSafe(iStateDescr, sd, rawAct, _l); err != nil {
+						sd.Data = nil
+						sd.Error = err.Error()
 					}
-
 				}
 			}
 		}
@@ -199,6 +224,84 @@ func NewFromData(data []byte) (*Animations, error) {
 	return a, nil
 }
 
+// decodeState decodes stream of act state, stream decoders do not check bounds and panic
+func (a *Animations) decodeState(iStateDescr int, sd *AnimActStateDescr, rawAct []byte, _l *utils.Logger) {
+	switch a.DataTypes[iStateDescr].TypeId {
+	case DATATYPE_TEXUREPOS:
+		data := make([]*AnimState8Texturepos, sd.CountOfSomething)
+		for i := 0; i < int(sd.CountOfSomething); i++ {
+			data[i] = AnimState8TextureposFromBuf(&a.DataTypes[iStateDescr], rawAct[sd.OffsetToData:], i)
+		}
+		sd.Data = data
+	case DATATYPE_SKINNING:
+		_l.Printf("ROTATION ANIMATIONS COUNT: %v", int(u16(rawAct, 0x8e)))
+		_l.Printf("SIZE ANIMATIONS COUNT: %v", int(u16(rawAct, 0x8e)))
+		_l.Printf("POSITION ANIMATIONS COUNT: %v", int(u16(rawAct, 0x7a)))
+		_l.Printf("descr: %+#v", a.DataTypes[iStateDescr])
+		_l.Printf("SUBELEMENT UPDATES OR OFFETS OR STATES COUNT(0xA2): %v", int(u16(rawAct, 0xa2)))
+
+		data := make([]*AnimState0Skinning, sd.CountOfSomething)
+		for i := 0; i < int(sd.CountOfSomething); i++ {
+			skinAnim := AnimState0SkinningFromBuf(rawAct[sd.OffsetToData:], i, _l)
+			skinAnim.ParseRotations(rawAct[sd.OffsetToData:], i, _l)
+			data[i] = skinAnim
+		}
+		for i := 0; i < int(u16(rawAct, 0x7a)); i++ {
+			skinAnim := AnimState0SkinningFromBuf(rawAct[sd.OffsetToData:], i, _l)
+			skinAnim.ParsePositions(rawAct[sd.OffsetToData:], i, _l, rawAct)
+			data = append(data, skinAnim)
+		}
+		sd.Data = data
+	case DATATYPE_TEXTURESHEET:
+		buf := rawAct[sd.OffsetToData:]
+		data := make([]uint32, u16(buf, 4))
+		dataBuf := buf[u16(buf, 0xa):]
+		for i := range data {
+			data[i] = u32(dataBuf, uint32(i*4))
+		}
+		sd.Data = data
+	}
+}
+
+// decodeStateSafe checks that state headers are inside of act and converts decoder panic to error
+func (a *Animations) decodeStateSafe(iStateDescr int, sd *AnimActStateDescr, rawAct []byte, _l *utils.Logger) (err error) {
+	if err := checkRange(rawAct, sd.OffsetToData, uint32(sd.CountOfSomething)*0xc, "States"); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Stream of type %d parsing panic: %v", a.DataTypes[iStateDescr].TypeId, r)
+		}
+	}()
+	a.decodeState(iStateDescr, sd, rawAct, _l)
+	return nil
+}
+
+// StreamErrors returns errors of streams which were not decoded
+func (anm *Animations) StreamErrors() []string {
+	result := make([]string, 0)
+	for _, g := range anm.Groups {
+		for _, act := range g.Acts {
+			for i, sd := range act.StateDescrs {
+				if sd.Error != "" {
+					result = append(result, fmt.Sprintf("group %q act %q state %d: %s", g.Name, act.Name, i, sd.Error))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// DataTypeIndex returns index of state descriptor of data type or -1
+func (anm *Animations) DataTypeIndex(typeId uint16) int {
+	for i, dt := range anm.DataTypes {
+		if dt.TypeId == typeId {
+			return i
+		}
+	}
+	return -1
+}
+
 func (anm *Animations) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	return anm, nil
 }
@@ -207,4 +310,7 @@ func init() {
 	wad.SetHandler(config.GOW1, ANIMATIONS_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
 		return NewFromData(wrsrc.Tag.Data)
 	})
+	wad.SetHandler(config.GOW2, ANIMATIONS_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
+		return NewFromDataGOW2(wrsrc.Tag.Data)
+	})
 }
@@ -0,0 +1,121 @@
+package anm
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+)
+
+// header with one data type and one external group
+func testAnimationData() []byte {
+	data := make([]byte, 0x20+0x30)
+	binary.LittleEndian.PutUint32(data[0:], ANIMATIONS_MAGIC)
+	binary.LittleEndian.PutUint16(data[0x10:], 1)
+	binary.LittleEndian.PutUint16(data[0x12:], 1)
+	binary.LittleEndian.PutUint32(data[0x18:], 0x20)
+	binary.LittleEndian.PutUint16(data[0x1c:], DATATYPE_SKINNING)
+
+	group := data[0x20:]
+	binary.LittleEndian.PutUint32(group[8:], 0x20000)
+	copy(group[0x14:], "external")
+	return data
+}
+
+func TestParseExternalGroup(t *testing.T) {
+	a, err := NewFromData(testAnimationData())
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if len(a.Groups) != 1 || a.Groups[0].Name != "external" || !a.Groups[0].IsExternal {
+		t.Errorf("Unexpected groups %+v", a.Groups)
+	}
+}
+
+func TestParseCorrupted(t *testing.T) {
+	data := testAnimationData()
+	// group offset out of file
+	binary.LittleEndian.PutUint32(data[0x18:], 0x1000)
+	if _, err := NewFromData(data); err == nil {
+		t.Errorf("Corrupted group offset accepted")
+	}
+
+	data = testAnimationData()
+	// internal group with act out of file
+	binary.LittleEndian.PutUint32(data[0x20+8:], 0)
+	binary.LittleEndian.PutUint32(data[0x20+0xc:], 1)
+	data = append(data, 0xff, 0xff, 0, 0)
+	if _, err := NewFromData(data); err == nil {
+		t.Errorf("Corrupted act offset accepted")
+	}
+}
+
+// animation with internal group of one act with texture position and skinning streams.
+// Skinning states are out of act, so only texture position stream can be decoded
+func testActAnimationData() []byte {
+	data := make([]byte, 0x140)
+	binary.LittleEndian.PutUint32(data[0:], ANIMATIONS_MAGIC)
+	binary.LittleEndian.PutUint16(data[0x10:], 2)
+	binary.LittleEndian.PutUint16(data[0x12:], 1)
+	binary.LittleEndian.PutUint32(data[0x18:], 0x30)
+	binary.LittleEndian.PutUint16(data[0x1c:], DATATYPE_TEXUREPOS)
+	binary.LittleEndian.PutUint16(data[0x20:], DATATYPE_SKINNING)
+
+	group := data[0x30:]
+	binary.LittleEndian.PutUint32(group[0xc:], 1)
+	copy(group[0x14:], "internal")
+	binary.LittleEndian.PutUint32(group[0x30:], 0x40)
+
+	act := group[0x40:]
+	copy(act[0x24:], "idle")
+	binary.LittleEndian.PutUint16(act[0x64+2:], 1)
+	binary.LittleEndian.PutUint32(act[0x64+8:], 0x90)
+	binary.LittleEndian.PutUint16(act[0x64+0x14+2:], 1)
+	binary.LittleEndian.PutUint32(act[0x64+0x14+8:], 0x1000)
+
+	// texture position state with default bitmap, two frames of u offset
+	state := act[0x90:]
+	binary.LittleEndian.PutUint16(state[4:], 2)
+	binary.LittleEndian.PutUint16(state[0xa:], 0xc)
+	binary.LittleEndian.PutUint32(state[0xc:], math.Float32bits(0.25))
+	binary.LittleEndian.PutUint32(state[0x10:], math.Float32bits(0.5))
+	return data
+}
+
+func TestParseGOW2Streams(t *testing.T) {
+	if _, err := NewFromData(testActAnimationData()); err == nil {
+		t.Errorf("GOW1 decoder accepted broken skinning stream")
+	}
+
+	a, err := NewFromDataGOW2(testActAnimationData())
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	act := a.Groups[0].Acts[0]
+	if act.Name != "idle" {
+		t.Errorf("Unexpected act %q", act.Name)
+	}
+	tex, ok := act.StateDescrs[0].Data.([]*AnimState8Texturepos)
+	if !ok || len(tex) != 1 || !reflect.DeepEqual(tex[0].Stream.Samples[0], []float32{0.25, 0.5}) {
+		t.Errorf("Texture position stream not decoded: %+v", act.StateDescrs[0])
+	}
+	if act.StateDescrs[1].Data != nil || act.StateDescrs[1].Error == "" {
+		t.Errorf("Broken skinning stream not reported: %+v", act.StateDescrs[1])
+	}
+	if errs := a.StreamErrors(); len(errs) != 1 {
+		t.Errorf("Unexpected stream errors %v", errs)
+	}
+}
+
+func TestGOW2Handler(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW2)
+
+	node := &wad.Node{Tag: &wad.Tag{Tag: wad.GetServerInstanceTag(), Data: testActAnimationData()}}
+	if !wad.HasHandler(node) {
+		t.Errorf("GOW2 animation has no handler")
+	}
+}
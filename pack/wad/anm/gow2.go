@@ -0,0 +1,9 @@
+package anm
+
+// NewFromDataGOW2 parses GOW2 animation. Header, group and act tables are read
+// with GOW1 layout and bounds checked. Streams decoded by GOW1 decoders, stream
+// which does not fit into act is kept without data and reported by StreamErrors,
+// so one unknown stream layout does not hide other streams of animation
+func NewFromDataGOW2(data []byte) (*Animations, error) {
+	return newFromData(data, true)
+}
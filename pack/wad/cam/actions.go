@@ -0,0 +1,22 @@
+package cam
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
+	"github.com/mogaika/god_of_war_browser/webutils"
+)
+
+func (r *Rail) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, req *http.Request, action string) {
+	switch action {
+	case "gltf":
+		webutils.WriteFileHeaders(w, wrsrc.Tag.Name+".glb")
+		if doc, err := r.ExportGLTFDefault(wrsrc); err != nil {
+			log.Printf("Error when exporting camera rail as gltf: %v", err)
+		} else if err := gltfutils.ExportBinary(w, doc); err != nil {
+			log.Printf("Failed to encode gltf: %v", err)
+		}
+	}
+}
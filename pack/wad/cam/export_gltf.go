@@ -0,0 +1,104 @@
+package cam
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
+)
+
+// meaning of rail floats is unknown, so points are spread evenly in time
+const RAIL_GLTF_POINT_TIME = 1.0
+
+type GLTFRailExported struct {
+	CameraNode uint32
+	PathNode   uint32
+}
+
+func (r *Rail) ExportGLTF(wrsrc *wad.WadNodeRsrc, gltfCacher *gltfutils.GLTFCacher) (*GLTFRailExported, error) {
+	doc := gltfCacher.Doc
+	tfre := &GLTFRailExported{}
+	defer gltfCacher.AddCache(wrsrc.Tag.Id, tfre)
+
+	points := r.Points()
+	vertices := make([][3]float32, len(points))
+	times := make([]float32, len(points))
+	rotations := make([][4]float32, len(points))
+	prev := mgl32.QuatIdent()
+	for i, p := range points {
+		vertices[i] = p
+		times[i] = float32(i) * RAIL_GLTF_POINT_TIME
+		q := mgl32.Mat4ToQuat(r.Matrices[i]).Normalize()
+		// keep shortest interpolation path between neighbour keys
+		if i != 0 && q.Dot(prev) < 0 {
+			q = q.Scale(-1)
+		}
+		rotations[i] = [4]float32{q.V[0], q.V[1], q.V[2], q.W}
+		prev = q
+	}
+
+	doc.Meshes = append(doc.Meshes, &gltf.Mesh{
+		Name: wrsrc.Name(),
+		Primitives: []*gltf.Primitive{{
+			Attributes: map[string]uint32{"POSITION": modeler.WritePosition(doc, vertices)},
+			Mode:       gltf.PrimitiveLineStrip,
+		}},
+	})
+	tfre.PathNode = uint32(len(doc.Nodes))
+	doc.Nodes = append(doc.Nodes, &gltf.Node{
+		Name: wrsrc.Name() + " path",
+		Mesh: gltf.Index(uint32(len(doc.Meshes) - 1)),
+	})
+
+	doc.Cameras = append(doc.Cameras, &gltf.Camera{
+		Name: wrsrc.Name(),
+		Perspective: &gltf.Perspective{
+			Yfov:  math.Pi / 3,
+			Znear: 0.1,
+		},
+	})
+	tfre.CameraNode = uint32(len(doc.Nodes))
+	doc.Nodes = append(doc.Nodes, &gltf.Node{
+		Name:        wrsrc.Name() + " camera",
+		Camera:      gltf.Index(uint32(len(doc.Cameras) - 1)),
+		Translation: vertices[0],
+		Rotation:    rotations[0],
+		Extras:      map[string]interface{}{"floats": r.Floats},
+	})
+
+	input := modeler.WriteAccessor(doc, gltf.TargetNone, times)
+	doc.Accessors[input].Min = []float32{times[0]}
+	doc.Accessors[input].Max = []float32{times[len(times)-1]}
+
+	doc.Animations = append(doc.Animations, &gltf.Animation{
+		Name: wrsrc.Name(),
+		Samplers: []*gltf.AnimationSampler{
+			{Input: input, Output: modeler.WriteAccessor(doc, gltf.TargetNone, vertices), Interpolation: gltf.InterpolationLinear},
+			{Input: input, Output: modeler.WriteAccessor(doc, gltf.TargetNone, rotations), Interpolation: gltf.InterpolationLinear},
+		},
+		Channels: []*gltf.Channel{
+			{Sampler: gltf.Index(0), Target: gltf.ChannelTarget{Node: gltf.Index(tfre.CameraNode), Path: gltf.TRSTranslation}},
+			{Sampler: gltf.Index(1), Target: gltf.ChannelTarget{Node: gltf.Index(tfre.CameraNode), Path: gltf.TRSRotation}},
+		},
+	})
+
+	return tfre, nil
+}
+
+func (r *Rail) ExportGLTFDefault(wrsrc *wad.WadNodeRsrc) (*gltf.Document, error) {
+	gltfCacher := gltfutils.NewCacher()
+	doc := gltfCacher.Doc
+
+	tfre, err := r.ExportGLTF(wrsrc, gltfCacher)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, tfre.PathNode, tfre.CameraNode)
+
+	return doc, nil
+}
@@ -3,48 +3,80 @@ package cam
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 
+	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/pack/wad"
 
 	"github.com/go-gl/mathgl/mgl32"
 )
 
+const RAIL_HEADER_SIZE = 0x10
+
+// Camera rail have no server instance id, it is stored as raw data tag
+const RAIL_TAG = wad.TAG_GOW1_FILE_RAW_DATA
+
 type Rail struct {
 	Matrices []mgl32.Mat4
 	Floats   []float32
 }
 
+// IsRail checks header of raw data tag, because raw data tag is shared with other formats
+func IsRail(data []byte) bool {
+	if len(data) < RAIL_HEADER_SIZE {
+		return false
+	}
+	count := int(binary.LittleEndian.Uint32(data[0:]))
+	return count != 0 && len(data) >= RAIL_HEADER_SIZE+count*0x44 &&
+		binary.LittleEndian.Uint32(data[4:]) == 0 &&
+		binary.LittleEndian.Uint32(data[8:]) == 0xffff_ffff &&
+		binary.LittleEndian.Uint32(data[0xc:]) == 0xffff_ffff
+}
+
 func (r *Rail) FromData(data []byte) error {
-	count := binary.LittleEndian.Uint32(data[0:])
+	if !IsRail(data) {
+		return fmt.Errorf("Not a camera rail")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[0:]))
 	r.Matrices = make([]mgl32.Mat4, count)
 	r.Floats = make([]float32, count)
 
-	unk04 := binary.LittleEndian.Uint32(data[4:])
-	unk08 := binary.LittleEndian.Uint32(data[8:])
-	unk0c := binary.LittleEndian.Uint32(data[0xc:])
-	if unk04 != 0 || unk08 != 0xffff_ffff || unk0c != 0xffff_ffff {
-		panic([]uint32{unk04, unk08, unk0c})
-	}
-
-	if err := binary.Read(bytes.NewReader(data[8:8+count*0x40]), binary.LittleEndian, r.Matrices); err != nil {
+	floatsStart := RAIL_HEADER_SIZE + count*0x40
+	if err := binary.Read(bytes.NewReader(data[RAIL_HEADER_SIZE:floatsStart]), binary.LittleEndian, r.Matrices); err != nil {
 		return err
 	}
-	if err := binary.Read(bytes.NewReader(data[8+count*0x40:]), binary.LittleEndian, r.Floats); err != nil {
+	if err := binary.Read(bytes.NewReader(data[floatsStart:]), binary.LittleEndian, r.Floats); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Points returns camera positions along the rail
+func (r *Rail) Points() []mgl32.Vec3 {
+	points := make([]mgl32.Vec3, len(r.Matrices))
+	for i, m := range r.Matrices {
+		points[i] = m.Col(3).Vec3()
+	}
+	return points
+}
+
 func (r *Rail) Marshal(rsrc *wad.WadNodeRsrc) (interface{}, error) {
-	return r, nil
+	return struct {
+		*Rail
+		Points []mgl32.Vec3
+	}{r, r.Points()}, nil
 }
 
-/*
 func init() {
-	wad.SetTagHandler(112, func(rsrc *wad.WadNodeRsrc) (wad.File, error) {
+	// only rails claimed, other raw data tags stay without handler.
+	// GOW2 wad parser does not create nodes for tags of unknown types,
+	// so GOW2 rails can be registered only after their tag type is found
+	wad.SetTagDataHandler(config.GOW1, RAIL_TAG, IsRail, func(rsrc *wad.WadNodeRsrc) (wad.File, error) {
 		r := &Rail{}
-		err := r.FromData(rsrc.Tag.Data)
-		return r, err
+		if err := r.FromData(rsrc.Tag.Data); err != nil {
+			return nil, err
+		}
+		return r, nil
 	})
 }
-*/
@@ -0,0 +1,82 @@
+package cam
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+)
+
+// testRailData returns rail with points translated by (i, 2*i, 3*i)
+func testRailData(count int) []byte {
+	data := make([]byte, RAIL_HEADER_SIZE+count*0x44)
+	binary.LittleEndian.PutUint32(data[0:], uint32(count))
+	binary.LittleEndian.PutUint32(data[8:], 0xffffffff)
+	binary.LittleEndian.PutUint32(data[0xc:], 0xffffffff)
+	for i := 0; i < count; i++ {
+		m := mgl32.Translate3D(float32(i), float32(2*i), float32(3*i))
+		for j, v := range m {
+			binary.LittleEndian.PutUint32(data[RAIL_HEADER_SIZE+i*0x40+j*4:], math.Float32bits(v))
+		}
+		binary.LittleEndian.PutUint32(data[RAIL_HEADER_SIZE+count*0x40+i*4:], math.Float32bits(float32(i)/2))
+	}
+	return data
+}
+
+func TestIsRail(t *testing.T) {
+	if !IsRail(testRailData(3)) {
+		t.Errorf("Rail not recognized")
+	}
+	for name, data := range map[string][]byte{
+		"short":       testRailData(3)[:RAIL_HEADER_SIZE+3*0x44-1],
+		"empty":       testRailData(0),
+		"header only": testRailData(3)[:RAIL_HEADER_SIZE-1],
+		"text":        []byte("some script text of raw data tag"),
+	} {
+		if IsRail(data) {
+			t.Errorf("Data %q recognized as rail", name)
+		}
+	}
+}
+
+func TestFromData(t *testing.T) {
+	var r Rail
+	if err := r.FromData(testRailData(3)); err != nil {
+		t.Fatal(err)
+	}
+	// matrices start after markers of header
+	for i, p := range r.Points() {
+		if expected := (mgl32.Vec3{float32(i), float32(2 * i), float32(3 * i)}); p != expected {
+			t.Errorf("Point %d is %v, expected %v", i, p, expected)
+		}
+		if r.Floats[i] != float32(i)/2 {
+			t.Errorf("Float %d is %v", i, r.Floats[i])
+		}
+	}
+	if err := r.FromData([]byte("not a rail")); err == nil {
+		t.Errorf("Not a rail accepted")
+	}
+}
+
+func TestHandlerClaimsOnlyRails(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	rail := &wad.Node{Tag: &wad.Tag{Tag: RAIL_TAG, Data: testRailData(2)}}
+	raw := &wad.Node{Tag: &wad.Tag{Tag: RAIL_TAG, Data: []byte("some script text of raw data tag")}}
+	if !wad.HasHandler(rail) {
+		t.Errorf("Rail has no handler")
+	}
+	if wad.HasHandler(raw) {
+		t.Errorf("Raw data tag claimed by rail handler")
+	}
+
+	config.SetGOWVersion(config.GOW2)
+	if wad.HasHandler(rail) {
+		t.Errorf("GOW1 rail handler used for GOW2")
+	}
+}
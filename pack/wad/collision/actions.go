@@ -14,10 +14,21 @@ import (
 	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/pack/wad"
 	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
+	"github.com/mogaika/god_of_war_browser/webutils"
 )
 
 func (c *Collision) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.Request, action string) {
 	log.Println(c.ShapeName, action)
+	if action == "gltf" {
+		webutils.WriteFileHeaders(w, wrsrc.Tag.Name+".glb")
+		if doc, err := c.ExportGLTFDefault(wrsrc); err != nil {
+			log.Printf("Error when exporting collision as gltf: %v", err)
+		} else if err := gltfutils.ExportBinary(w, doc); err != nil {
+			log.Printf("Failed to encode gltf: %v", err)
+		}
+		return
+	}
 	switch c.ShapeName {
 	case "SheetHdr":
 		c.Shape.(*ShapeRibSheet).HttpAction(wrsrc, w, r, action)
@@ -46,6 +57,17 @@ func (rib *ShapeRibSheet) FromModel(wrsrc *wad.WadNodeRsrc, gltfReader io.Reader
 	default:
 		return fmt.Errorf("Unsupported playstation version")
 	}
+	// Marshal writes GOW1 layout of rib sheet, GOW2 sheet replaced
+	// only when this layout keeps all of its data
+	switch config.GetGOWVersion() {
+	case config.GOW1:
+	case config.GOW2:
+		if err := checkRewritable(wrsrc.Tag.Data); err != nil {
+			return errors.Wrapf(err, "GOW2 rib sheet cannot be written in GOW1 layout")
+		}
+	default:
+		return fmt.Errorf("Unsupported game version")
+	}
 
 	doc := &gltf.Document{}
 	if err := gltf.NewDecoder(gltfReader).Decode(doc); err != nil {
@@ -159,11 +181,9 @@ func (rib *ShapeRibSheet) FromModel(wrsrc *wad.WadNodeRsrc, gltfReader io.Reader
 		rib.Some9Points[i] = mgl32.Vec3(v)
 	}
 
-	wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
 		wrsrc.Tag.Id: rib.Marshal(),
 	})
-
-	return nil
 }
 
 func getBboxForVertices(vertices [][3]float32) [2][3]float32 {
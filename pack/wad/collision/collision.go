@@ -129,16 +129,32 @@ func (c *Collision) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 }
 
 func init() {
-	wad.SetHandler(config.GOW1, COLLISION_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
-		fpath := filepath.Join("logs", wrsrc.Wad.Name(), fmt.Sprintf("%.4d-%s.enz.obj", wrsrc.Tag.Id, wrsrc.Tag.Name))
-		os.MkdirAll(filepath.Dir(fpath), 0777)
-		f, _ := os.Create(fpath)
-		defer f.Close()
+	wad.SetHandler(config.GOW1, COLLISION_MAGIC, newFromTag)
+	// GOW2 shapes parsed by GOW1 parsers only when header fits GOW1 layout
+	wad.SetHandler(config.GOW2, COLLISION_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
+		if err := checkGOW2Header(wrsrc.Tag.Data); err != nil {
+			return nil, fmt.Errorf("Unsupported GOW2 collision: %v", err)
+		}
+		return newFromTag(wrsrc)
+	})
+}
 
-		// f := ioutil.Discard
+func newFromTag(wrsrc *wad.WadNodeRsrc) (c wad.File, err error) {
+	fpath := filepath.Join("logs", wrsrc.Wad.Name(), fmt.Sprintf("%.4d-%s.enz.obj", wrsrc.Tag.Id, wrsrc.Tag.Name))
+	os.MkdirAll(filepath.Dir(fpath), 0777)
+	f, _ := os.Create(fpath)
+	defer f.Close()
 
-		bs := utils.NewBufStack("collision", wrsrc.Tag.Data)
+	// f := ioutil.Discard
 
-		return NewFromData(bs, f)
-	})
+	// buffer stack panics on out of range access
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("Collision parsing panic: %v", r)
+		}
+	}()
+
+	bs := utils.NewBufStack("collision", wrsrc.Tag.Data)
+
+	return NewFromData(bs, f)
 }
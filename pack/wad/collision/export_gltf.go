@@ -0,0 +1,281 @@
+package collision
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
+)
+
+const convexEpsilon = 0.001
+
+type GLTFCollisionExported struct {
+	Nodes []uint32
+}
+
+// convexFromPlanes calculates faces of convex polyhedron defined by planes in Hesse normal form.
+// Vertices of every face are ordered counter-clockwise around plane normal
+func convexFromPlanes(planes []mgl32.Vec4) [][]mgl32.Vec3 {
+	normals := make([]mgl32.Vec3, len(planes))
+	distances := make([]float32, len(planes))
+	for i, p := range planes {
+		l := p.Vec3().Len()
+		if l == 0 {
+			continue
+		}
+		normals[i] = p.Vec3().Mul(1 / l)
+		distances[i] = p[3] / l
+	}
+
+	inside := func(p mgl32.Vec3) bool {
+		for i := range planes {
+			if normals[i].Dot(p)+distances[i] > convexEpsilon {
+				return false
+			}
+		}
+		return true
+	}
+
+	facePoints := make([][]mgl32.Vec3, len(planes))
+	for i := 0; i < len(planes); i++ {
+		for j := i + 1; j < len(planes); j++ {
+			for k := j + 1; k < len(planes); k++ {
+				n1, n2, n3 := normals[i], normals[j], normals[k]
+				denom := n1.Dot(n2.Cross(n3))
+				if math.Abs(float64(denom)) < 1e-6 {
+					continue
+				}
+				p := n2.Cross(n3).Mul(-distances[i]).
+					Add(n3.Cross(n1).Mul(-distances[j])).
+					Add(n1.Cross(n2).Mul(-distances[k])).
+					Mul(1 / denom)
+				if !inside(p) {
+					continue
+				}
+				for _, plane := range []int{i, j, k} {
+					facePoints[plane] = appendUniquePoint(facePoints[plane], p)
+				}
+			}
+		}
+	}
+
+	faces := make([][]mgl32.Vec3, 0, len(planes))
+	for i, points := range facePoints {
+		if len(points) < 3 {
+			continue
+		}
+		center := mgl32.Vec3{}
+		for _, p := range points {
+			center = center.Add(p)
+		}
+		center = center.Mul(1 / float32(len(points)))
+
+		axisX := points[0].Sub(center).Normalize()
+		axisY := normals[i].Cross(axisX)
+		angle := func(p mgl32.Vec3) float64 {
+			d := p.Sub(center)
+			return math.Atan2(float64(d.Dot(axisY)), float64(d.Dot(axisX)))
+		}
+		sort.Slice(points, func(a, b int) bool { return angle(points[a]) < angle(points[b]) })
+		faces = append(faces, points)
+	}
+	return faces
+}
+
+func appendUniquePoint(points []mgl32.Vec3, p mgl32.Vec3) []mgl32.Vec3 {
+	for _, v := range points {
+		if v.Sub(p).Len() < convexEpsilon {
+			return points
+		}
+	}
+	return append(points, p)
+}
+
+// writeFaces triangulates convex faces as fans
+func writeFaces(doc *gltf.Document, faces [][]mgl32.Vec3, material *uint32) *gltf.Primitive {
+	vertices := make([][3]float32, 0)
+	indices := make([]uint32, 0)
+	for _, face := range faces {
+		base := uint32(len(vertices))
+		for _, v := range face {
+			vertices = append(vertices, v)
+		}
+		for i := uint32(1); i+1 < uint32(len(face)); i++ {
+			indices = append(indices, base, base+i, base+i+1)
+		}
+	}
+	return &gltf.Primitive{
+		Indices:    gltf.Index(modeler.WriteIndices(doc, indices)),
+		Attributes: map[string]uint32{"POSITION": modeler.WritePosition(doc, vertices)},
+		Material:   material,
+	}
+}
+
+func sphereFaces(center mgl32.Vec3, radius float32, rings, segments int) [][]mgl32.Vec3 {
+	point := func(ring, segment int) mgl32.Vec3 {
+		theta := math.Pi * float64(ring) / float64(rings)
+		phi := 2 * math.Pi * float64(segment) / float64(segments)
+		return center.Add(mgl32.Vec3{
+			float32(math.Sin(theta) * math.Cos(phi)),
+			float32(math.Cos(theta)),
+			float32(math.Sin(theta) * math.Sin(phi)),
+		}.Mul(radius))
+	}
+	faces := make([][]mgl32.Vec3, 0, rings*segments)
+	for r := 0; r < rings; r++ {
+		for s := 0; s < segments; s++ {
+			faces = append(faces, []mgl32.Vec3{point(r, s), point(r, s+1), point(r+1, s+1), point(r+1, s)})
+		}
+	}
+	return faces
+}
+
+func addDebugMaterial(doc *gltf.Document, name string, color [4]float32) *uint32 {
+	doc.Materials = append(doc.Materials, &gltf.Material{
+		Name:        name,
+		DoubleSided: true,
+		AlphaMode:   gltf.AlphaBlend,
+		PBRMetallicRoughness: &gltf.PBRMetallicRoughness{
+			BaseColorFactor: &color,
+		},
+	})
+	return gltf.Index(uint32(len(doc.Materials) - 1))
+}
+
+func (tfce *GLTFCollisionExported) addMeshNode(doc *gltf.Document, name string, primitives []*gltf.Primitive, extras map[string]interface{}) {
+	doc.Meshes = append(doc.Meshes, &gltf.Mesh{
+		Name:       name,
+		Primitives: primitives,
+	})
+	tfce.Nodes = append(tfce.Nodes, uint32(len(doc.Nodes)))
+	doc.Nodes = append(doc.Nodes, &gltf.Node{
+		Name:   name,
+		Mesh:   gltf.Index(uint32(len(doc.Meshes) - 1)),
+		Extras: extras,
+	})
+}
+
+func (rib *ShapeRibSheet) exportGLTF(doc *gltf.Document, name string, tfce *GLTFCollisionExported) {
+	vertices := make([][3]float32, len(rib.Some9Points))
+	for i, p := range rib.Some9Points {
+		vertices[i] = p
+	}
+	positions := modeler.WritePosition(doc, vertices)
+
+	materialIndices := make(map[uint16][]uint32)
+	for _, t := range rib.Some7TrianglesIndex {
+		materialIndices[t.MaterialIndex] = append(materialIndices[t.MaterialIndex],
+			uint32(t.Indexes[0]), uint32(t.Indexes[1]), uint32(t.Indexes[2]))
+	}
+	for _, q := range rib.Some8QuadsIndex {
+		materialIndices[q.MaterialIndex] = append(materialIndices[q.MaterialIndex],
+			uint32(q.Indexes[0]), uint32(q.Indexes[1]), uint32(q.Indexes[2]),
+			uint32(q.Indexes[0]), uint32(q.Indexes[2]), uint32(q.Indexes[3]))
+	}
+
+	primitives := make([]*gltf.Primitive, 0, len(materialIndices))
+	for iMaterial := range rib.Some4Materials {
+		indices, ok := materialIndices[uint16(iMaterial)]
+		if !ok {
+			continue
+		}
+		m := &rib.Some4Materials[iMaterial]
+		primitives = append(primitives, &gltf.Primitive{
+			Indices:    gltf.Index(modeler.WriteIndices(doc, indices)),
+			Attributes: map[string]uint32{"POSITION": positions},
+			Material:   addDebugMaterial(doc, m.Name, m.EditorColor),
+		})
+	}
+
+	tfce.addMeshNode(doc, name, primitives, nil)
+}
+
+func (bh *ShapeBallHull) exportGLTF(doc *gltf.Document, name string, tfce *GLTFCollisionExported) {
+	color := [4]float32{1, 1, 1, 0.3}
+	switch bh.Type {
+	case 0:
+		color = [4]float32{1, 0, 0, 0.3}
+	case 1:
+		color = [4]float32{0, 0, 1, 0.3}
+	case 2:
+		color = [4]float32{0, 1, 1, 0.3}
+	case 3:
+		color = [4]float32{0, 1, 0, 0.3}
+	}
+	material := addDebugMaterial(doc, fmt.Sprintf("%s type %d", name, bh.Type), color)
+
+	// shapes are in space of object joint, joint is stored in extras
+	for iMesh, mesh := range bh.Meshes {
+		tfce.addMeshNode(doc, fmt.Sprintf("%s mesh %d", name, iMesh),
+			[]*gltf.Primitive{writeFaces(doc, convexFromPlanes(mesh.Planes), material)},
+			map[string]interface{}{"joint": mesh.Joint})
+	}
+	for iBall, ball := range bh.Balls {
+		tfce.addMeshNode(doc, fmt.Sprintf("%s ball %d", name, iBall),
+			[]*gltf.Primitive{writeFaces(doc, sphereFaces(ball.Coord.Vec3(), ball.Coord[3], 7, 7), material)},
+			map[string]interface{}{"joint": ball.Joint})
+	}
+}
+
+func (dh *ShapeDbgHdr) exportGLTF(doc *gltf.Document, name string, tfce *GLTFCollisionExported) {
+	material := addDebugMaterial(doc, name, [4]float32{0.7, 0, 0.7, 0.3})
+	for iMesh, mesh := range dh.Meshes {
+		vertices := make([][3]float32, len(mesh.Vertices))
+		for i, v := range mesh.Vertices {
+			vertices[i] = v.Vec3()
+		}
+		tfce.addMeshNode(doc, fmt.Sprintf("%s dbg %d", name, iMesh), []*gltf.Primitive{{
+			Indices:    gltf.Index(modeler.WriteIndices(doc, mesh.Indices)),
+			Attributes: map[string]uint32{"POSITION": modeler.WritePosition(doc, vertices)},
+			Material:   material,
+			Mode:       gltf.PrimitiveLines,
+		}}, nil)
+	}
+}
+
+func (c *Collision) ExportGLTF(wrsrc *wad.WadNodeRsrc, gltfCacher *gltfutils.GLTFCacher) (*GLTFCollisionExported, error) {
+	tfce := &GLTFCollisionExported{}
+	defer gltfCacher.AddCache(wrsrc.Tag.Id, tfce)
+
+	// resolves rib material colors and debug mesh of ballhull
+	if _, err := c.Marshal(wrsrc); err != nil {
+		return nil, err
+	}
+
+	doc := gltfCacher.Doc
+	switch shape := c.Shape.(type) {
+	case *ShapeRibSheet:
+		shape.exportGLTF(doc, wrsrc.Name(), tfce)
+	case *ShapeBallHull:
+		shape.exportGLTF(doc, wrsrc.Name(), tfce)
+		if shape.DbgMesh != nil {
+			shape.DbgMesh.exportGLTF(doc, wrsrc.Name(), tfce)
+		}
+	case *ShapeDbgHdr:
+		shape.exportGLTF(doc, wrsrc.Name(), tfce)
+	default:
+		return nil, fmt.Errorf("Unsupported shape %q", c.ShapeName)
+	}
+
+	return tfce, nil
+}
+
+func (c *Collision) ExportGLTFDefault(wrsrc *wad.WadNodeRsrc) (*gltf.Document, error) {
+	gltfCacher := gltfutils.NewCacher()
+	doc := gltfCacher.Doc
+
+	tfce, err := c.ExportGLTF(wrsrc, gltfCacher)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, tfce.Nodes...)
+
+	return doc, nil
+}
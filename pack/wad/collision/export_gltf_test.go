@@ -0,0 +1,36 @@
+package collision
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestConvexFromPlanesCube(t *testing.T) {
+	planes := []mgl32.Vec4{
+		{1, 0, 0, -1}, {-1, 0, 0, -1},
+		{0, 2, 0, -2}, {0, -1, 0, -1},
+		{0, 0, 1, -1}, {0, 0, -1, -1},
+	}
+
+	faces := convexFromPlanes(planes)
+	if len(faces) != 6 {
+		t.Fatalf("Expected 6 faces, got %d", len(faces))
+	}
+	for iFace, face := range faces {
+		if len(face) != 4 {
+			t.Fatalf("Expected 4 vertices for face %d, got %d", iFace, len(face))
+		}
+		normal := face[1].Sub(face[0]).Cross(face[2].Sub(face[1]))
+		if normal.Dot(planes[iFace].Vec3()) <= 0 {
+			t.Errorf("Face %d is not facing outside: %v", iFace, face)
+		}
+		for _, v := range face {
+			for i := range v {
+				if v[i] != 1 && v[i] != -1 {
+					t.Errorf("Unexpected vertex %v of face %d", v, iFace)
+				}
+			}
+		}
+	}
+}
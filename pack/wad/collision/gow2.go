@@ -0,0 +1,104 @@
+package collision
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+// checkSections verifies that declared file size fits into data
+// and section offsets are ascending inside of file
+func checkSections(data []byte, headerSize int, fileSizeOffset int, offsets []int) error {
+	if len(data) < headerSize {
+		return fmt.Errorf("Header (size 0x%x) is out of file (size 0x%x)", headerSize, len(data))
+	}
+	fileSize := binary.LittleEndian.Uint32(data[fileSizeOffset:])
+	if fileSize < uint32(headerSize) || fileSize > uint32(len(data)) {
+		return fmt.Errorf("File size 0x%x is out of range [0x%x, 0x%x]", fileSize, headerSize, len(data))
+	}
+	prev := uint32(headerSize)
+	for _, off := range offsets {
+		offset := binary.LittleEndian.Uint32(data[off:])
+		if offset < prev || offset > fileSize {
+			return fmt.Errorf("Section offset 0x%x at 0x%x is not in range [0x%x, 0x%x]", offset, off, prev, fileSize)
+		}
+		prev = offset
+	}
+	return nil
+}
+
+// checkGOW2Header verifies header of GOW2 collision before parsing it with GOW1 shape parsers:
+// shape name, file size and section offsets, for rib sheet also header constants
+func checkGOW2Header(data []byte) error {
+	if len(data) < 16 {
+		return fmt.Errorf("File of size 0x%x is too small", len(data))
+	}
+	if magic := binary.LittleEndian.Uint32(data); magic != COLLISION_MAGIC {
+		return fmt.Errorf("Unexpected magic 0x%x", magic)
+	}
+	switch {
+	case utils.BytesToString(data[8:16]) == "BallHull":
+		offsets := make([]int, 11)
+		for i := range offsets {
+			offsets[i] = 0x3c + i*4
+		}
+		return checkSections(data, BALLHULL_HEADER_SIZE, 0x10, offsets)
+	case utils.BytesToString(data[4:12]) == "SheetHdr":
+		if err := checkSections(data, RIBSHEET_HEADER_SIZE, 0xc,
+			[]int{0x64, 0x68, 0x6c, 0x70, 0x74, 0x78, 0x7c, 0x80, 0x84, 0x8c}); err != nil {
+			return err
+		}
+		rib := &ShapeRibSheet{
+			Unk0x10: binary.LittleEndian.Uint32(data[0x10:]),
+			Unk0x14: binary.LittleEndian.Uint32(data[0x14:]),
+			Unk0x4c: binary.LittleEndian.Uint16(data[0x4c:]),
+			Unk0x4e: binary.LittleEndian.Uint16(data[0x4e:]),
+			Unk0x58: binary.LittleEndian.Uint16(data[0x58:]),
+		}
+		return rib.checkHeaderConstants()
+	case utils.BytesToString(data[4:12]) == "mCDbgHdr":
+		return checkSections(data, 0x20, 0xc, []int{0x10, 0x14, 0x18, 0x1c})
+	default:
+		return fmt.Errorf("Unknown shape type")
+	}
+}
+
+func parseRibSheet(data []byte) (rib *ShapeRibSheet, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rib, err = nil, fmt.Errorf("Rib sheet parsing panic: %v", r)
+		}
+	}()
+	return NewRibSheet(utils.NewBufStack("ribsheet", data), ioutil.Discard)
+}
+
+// checkRewritable verifies that rib sheet written by Marshal keeps everything of original data:
+// header is same except of sizes and offsets, and sheet parsed back equals to original one
+func checkRewritable(original []byte) error {
+	rib, err := parseRibSheet(original)
+	if err != nil {
+		return err
+	}
+	remarshaled := rib.Marshal()
+	for i := 0; i < RIBSHEET_HEADER_SIZE; i++ {
+		// file size and sections offsets
+		if (i >= 0xc && i < 0x10) || i >= 0x64 {
+			continue
+		}
+		if original[i] != remarshaled[i] {
+			return fmt.Errorf("Header byte at 0x%x is not written back (0x%.2x != 0x%.2x)", i, remarshaled[i], original[i])
+		}
+	}
+	reparsed, err := parseRibSheet(remarshaled)
+	if err != nil {
+		return err
+	}
+	reparsed.FileSize = rib.FileSize
+	if !reflect.DeepEqual(rib, reparsed) {
+		return fmt.Errorf("Rib sheet changes after rewriting")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package collision
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func testRibSheetData() []byte {
+	rib := &ShapeRibSheet{
+		Unk0x10:       0x1f,
+		Unk0x14:       0x02140201,
+		Unk0x4c:       0x40,
+		Unk0x4e:       0x40,
+		Some3CxtNames: []string{"CXT_test"},
+		Some4Materials: []RibMaterial{
+			{Name: "MAT_test", Values: map[string]interface{}{"Damage": uint32(3)}},
+		},
+		Some5FlagMaps: []RibMaterialField{{Name: "Damage", Type: 0, MaxValue: 7}},
+		Some9Points:   []mgl32.Vec3{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}},
+	}
+	return rib.Marshal()
+}
+
+func TestGOW2HeaderRibSheet(t *testing.T) {
+	data := testRibSheetData()
+	if err := checkGOW2Header(data); err != nil {
+		t.Fatalf("Valid rib sheet rejected: %v", err)
+	}
+
+	for _, off := range []int{0xc, 0x70} {
+		broken := append([]byte{}, data...)
+		binary.LittleEndian.PutUint32(broken[off:], uint32(len(data)+4))
+		if err := checkGOW2Header(broken); err == nil {
+			t.Errorf("Broken value at 0x%x accepted", off)
+		}
+	}
+
+	broken := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(broken[0x10:], 0x20)
+	if err := checkGOW2Header(broken); err == nil {
+		t.Errorf("Broken header constant accepted")
+	}
+
+	if err := checkGOW2Header(data[:0x40]); err == nil {
+		t.Errorf("Truncated header accepted")
+	}
+}
+
+func TestGOW2RibSheetRewritable(t *testing.T) {
+	data := testRibSheetData()
+	if err := checkRewritable(data); err != nil {
+		t.Fatalf("Rib sheet written by Marshal is not rewritable: %v", err)
+	}
+
+	changed := append([]byte{}, data...)
+	changed[0x60] = 0x11
+	if err := checkRewritable(changed); err == nil {
+		t.Errorf("Unknown header byte is lost when rewriting, but check passed")
+	}
+}
@@ -148,6 +148,23 @@ type ShapeRibSheet struct {
 	Some10              []RibZone
 }
 
+// checkHeaderConstants verifies fields that are constant for every rib sheet
+func (rib *ShapeRibSheet) checkHeaderConstants() error {
+	switch {
+	case rib.Unk0x10 != 0x1f:
+		return fmt.Errorf("Unexpected Unk0x10 0x%x", rib.Unk0x10)
+	case rib.Unk0x14 != 0x02140201:
+		return fmt.Errorf("Unexpected Unk0x14 0x%x", rib.Unk0x14)
+	case rib.Unk0x4c != 0x40:
+		return fmt.Errorf("Unexpected Unk0x4c 0x%x", rib.Unk0x4c)
+	case rib.Unk0x4e != 0x40:
+		return fmt.Errorf("Unexpected Unk0x4e 0x%x", rib.Unk0x4e)
+	case rib.Unk0x58 != 0:
+		return fmt.Errorf("Unexpected Unk0x58 0x%x", rib.Unk0x58)
+	}
+	return nil
+}
+
 func NewRibSheet(bs *utils.BufStack, wrtw io.Writer) (*ShapeRibSheet, error) {
 	headerbs := bs.SubBuf("header", 0).SetSize(RIBSHEET_HEADER_SIZE)
 
@@ -196,20 +213,8 @@ func NewRibSheet(bs *utils.BufStack, wrtw io.Writer) (*ShapeRibSheet, error) {
 	offsetToSome9 := headerbs.LU32(0x84)
 	offsetToSome10 := headerbs.LU32(0x8c)
 
-	if rib.Unk0x10 != 0x1f {
-		panic("Unk0x10")
-	}
-	if rib.Unk0x14 != 0x02140201 {
-		panic("Unk0x14")
-	}
-	if rib.Unk0x4c != 0x40 {
-		panic("Unk0x4c")
-	}
-	if rib.Unk0x4e != 0x40 {
-		panic("Unk0x4e")
-	}
-	if rib.Unk0x58 != 0 {
-		panic("Unk0x58")
+	if err := rib.checkHeaderConstants(); err != nil {
+		return nil, err
 	}
 
 	// utils.LogDump(rib)
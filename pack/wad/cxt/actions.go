@@ -2,6 +2,8 @@ package cxt
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -10,6 +12,7 @@ import (
 	"github.com/mogaika/fbx/builders/bfbx73"
 
 	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/status"
 	"github.com/mogaika/god_of_war_browser/utils/fbxbuilder"
 	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
 	"github.com/mogaika/god_of_war_browser/webutils"
@@ -27,28 +30,7 @@ func (cxt *Chunk) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *h
 			}
 		}
 	case "gltf_all":
-		gltfCacher := gltfutils.NewCacher()
-		doc := gltfCacher.Doc
-
-		for _, node := range wrsrc.Wad.Nodes {
-			if !strings.HasPrefix(node.Tag.Name, "CXT_") {
-				continue
-			}
-			inst, _, err := wrsrc.Wad.GetInstanceFromNode(node.Id)
-			if err != nil {
-				log.Panicf("Failed to load cxt %s: %v", node.Tag.Name, err)
-			}
-
-			_, err = inst.(*Chunk).ExportGLTF(wrsrc.Wad.GetNodeResourceByNodeId(node.Id), gltfCacher)
-			if err != nil {
-				log.Panicf("Failed to encode %q: %v", node.Tag.Name, err)
-			}
-		}
-
-		webutils.WriteFileHeaders(w, wrsrc.Wad.Name()+".glb")
-		if err := gltfutils.ExportBinary(w, doc); err != nil {
-			log.Printf("Failed to encode gltf: %v", err)
-		}
+		exportAll(wrsrc, w, r, "glTF", wrsrc.Wad.Name()+".glb", exportGLTFAll)
 	case "fbx":
 		var buf bytes.Buffer
 		// Export zip
@@ -56,24 +38,93 @@ func (cxt *Chunk) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *h
 		// log.Printf("Error when exporting cxt: %v", cxt.ExportFbxDefault(wrsrc).Export(&buf))
 		webutils.WriteFile(w, bytes.NewReader(buf.Bytes()), wrsrc.Tag.Name+".zip")
 	case "fbx_all":
-		f := fbxbuilder.NewFBXBuilder(filepath.Join(wrsrc.Wad.Name(), wrsrc.Name()))
+		exportAll(wrsrc, w, r, "fbx", wrsrc.Wad.Name()+".zip", exportFBXAll)
+	}
+}
 
-		for _, node := range wrsrc.Wad.Nodes {
-			if !strings.HasPrefix(node.Tag.Name, "CXT_") {
-				continue
-			}
-			inst, _, err := wrsrc.Wad.GetInstanceFromNode(node.Id)
-			if err != nil {
-				log.Panicf("Can't load cxt %s: %v", node.Tag.Name, err)
-			}
+// exportAll writes export of every cxt of wad, or starts job
+// which result can be downloaded later if job parameter provided
+func exportAll(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.Request,
+	format string, fileName string, export func(ctx context.Context, wrsrc *wad.WadNodeRsrc) ([]byte, error)) {
+	if r.URL.Query().Get("job") == "" {
+		if data, err := export(r.Context(), wrsrc); err != nil {
+			log.Printf("Failed to export %s as %s: %v", wrsrc.Wad.Name(), format, err)
+			webutils.WriteError(w, err)
+		} else {
+			webutils.WriteFile(w, bytes.NewReader(data), fileName)
+		}
+		return
+	}
 
-			fe := inst.(*Chunk).ExportFbx(wrsrc.Wad.GetNodeResourceByNodeId(node.Id), f)
-			f.AddConnections(bfbx73.C("OO", fe.FbxModelId, 0))
+	job := status.StartJob(fmt.Sprintf("Export %s as %s", wrsrc.Wad.Name(), format), func(ctx context.Context) (interface{}, error) {
+		data, err := export(ctx, wrsrc)
+		if err != nil {
+			return nil, err
 		}
+		return status.NewJobFile(fileName, data), nil
+	})
+	webutils.WriteJson(w, job)
+}
 
-		var buf bytes.Buffer
-		// Export zip
-		log.Printf("Error when exporting wad(cxt array): %v", f.WriteZip(&buf, wrsrc.Wad.Name()+".fbx"))
-		webutils.WriteFile(w, bytes.NewReader(buf.Bytes()), wrsrc.Wad.Name()+".zip")
+func cxtNodes(wrsrc *wad.WadNodeRsrc) []*wad.Node {
+	nodes := make([]*wad.Node, 0)
+	for _, node := range wrsrc.Wad.Nodes {
+		if strings.HasPrefix(node.Tag.Name, "CXT_") {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func exportGLTFAll(ctx context.Context, wrsrc *wad.WadNodeRsrc) ([]byte, error) {
+	gltfCacher := gltfutils.NewCacher()
+	doc := gltfCacher.Doc
+
+	nodes := cxtNodes(wrsrc)
+	for i, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		status.JobProgress(ctx, float32(i)/float32(len(nodes)), "Exporting %s", node.Tag.Name)
+		inst, _, err := wrsrc.Wad.GetInstanceFromNode(node.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load cxt %s: %v", node.Tag.Name, err)
+		}
+
+		_, err = inst.(*Chunk).ExportGLTF(wrsrc.Wad.GetNodeResourceByNodeId(node.Id), gltfCacher)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode %q: %v", node.Tag.Name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gltfutils.ExportBinary(&buf, doc); err != nil {
+		return nil, fmt.Errorf("Failed to encode gltf: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func exportFBXAll(ctx context.Context, wrsrc *wad.WadNodeRsrc) ([]byte, error) {
+	f := fbxbuilder.NewFBXBuilder(filepath.Join(wrsrc.Wad.Name(), wrsrc.Name()))
+
+	nodes := cxtNodes(wrsrc)
+	for i, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		status.JobProgress(ctx, float32(i)/float32(len(nodes)), "Exporting %s", node.Tag.Name)
+		inst, _, err := wrsrc.Wad.GetInstanceFromNode(node.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Can't load cxt %s: %v", node.Tag.Name, err)
+		}
+
+		fe := inst.(*Chunk).ExportFbx(wrsrc.Wad.GetNodeResourceByNodeId(node.Id), f)
+		f.AddConnections(bfbx73.C("OO", fe.FbxModelId, 0))
+	}
+
+	var buf bytes.Buffer
+	if err := f.WriteZip(&buf, wrsrc.Wad.Name()+".fbx"); err != nil {
+		return nil, fmt.Errorf("Error when exporting wad(cxt array): %v", err)
 	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,214 @@
+package wad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_RESIZED = "resized"
+	DIFF_CHANGED = "changed"
+)
+
+// limit of reported field changes per tag
+const DIFF_MAX_FIELDS = 256
+
+// longer strings (base64 of images for example) are not included into diff
+const DIFF_MAX_VALUE_LENGTH = 128
+
+// packages of instances with small enough marshaled form to compare it field by field
+var diffMarshalPackages = map[string]bool{
+	"mat":   true,
+	"txr":   true,
+	"obj":   true,
+	"inst":  true,
+	"light": true,
+	"twk":   true,
+}
+
+type FieldDiff struct {
+	Path string
+	A, B interface{}
+}
+
+type TagDiff struct {
+	Status       string
+	Tag          uint16
+	Name         string
+	IdA, IdB     TagId
+	SizeA, SizeB uint32
+	Fields       []FieldDiff `json:",omitempty"`
+	FieldsError  string      `json:",omitempty"`
+}
+
+type WadDiff struct {
+	A, B string
+	Tags []TagDiff
+}
+
+func diffTagKey(t *Tag) string {
+	return fmt.Sprintf("%d:%s", t.Tag, t.Name)
+}
+
+// Diff aligns tags of wads by name and tag type and reports changed ones
+func Diff(a, b *Wad) *WadDiff {
+	d := &WadDiff{A: a.Name(), B: b.Name(), Tags: make([]TagDiff, 0)}
+
+	bTags := make(map[string][]TagId)
+	for i := range b.Tags {
+		key := diffTagKey(&b.Tags[i])
+		bTags[key] = append(bTags[key], b.Tags[i].Id)
+	}
+	matched := make(map[TagId]bool)
+
+	for i := range a.Tags {
+		ta := &a.Tags[i]
+		key := diffTagKey(ta)
+		if len(bTags[key]) == 0 {
+			d.Tags = append(d.Tags, TagDiff{Status: DIFF_REMOVED, Tag: ta.Tag, Name: ta.Name,
+				IdA: ta.Id, IdB: NODE_INVALID, SizeA: ta.Size})
+			continue
+		}
+		tb := &b.Tags[bTags[key][0]]
+		bTags[key] = bTags[key][1:]
+		matched[tb.Id] = true
+
+		td := TagDiff{Tag: ta.Tag, Name: ta.Name, IdA: ta.Id, IdB: tb.Id, SizeA: ta.Size, SizeB: tb.Size}
+		if ta.Size != tb.Size {
+			td.Status = DIFF_RESIZED
+		} else if !bytes.Equal(ta.Data, tb.Data) {
+			td.Status = DIFF_CHANGED
+		} else {
+			continue
+		}
+		if err := diffTagFields(a, b, ta, tb, &td); err != nil {
+			td.FieldsError = err.Error()
+		}
+		d.Tags = append(d.Tags, td)
+	}
+
+	for i := range b.Tags {
+		if tb := &b.Tags[i]; !matched[tb.Id] {
+			d.Tags = append(d.Tags, TagDiff{Status: DIFF_ADDED, Tag: tb.Tag, Name: tb.Name,
+				IdA: NODE_INVALID, IdB: tb.Id, SizeB: tb.Size})
+		}
+	}
+	return d
+}
+
+// marshalTagForDiff returns nil if tag instance is not comparable by fields
+func marshalTagForDiff(w *Wad, t *Tag) (result interface{}, err error) {
+	if t.NodeId == NODE_INVALID {
+		return nil, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Marshal panic: %v", r)
+		}
+	}()
+
+	inst, _, err := w.GetInstanceFromNode(t.NodeId)
+	if err != nil {
+		// tags without handlers are compared only by size and data
+		return nil, nil
+	}
+	rt := reflect.TypeOf(inst)
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if !diffMarshalPackages[path.Base(rt.PkgPath())] {
+		return nil, nil
+	}
+
+	val, err := inst.Marshal(w.GetNodeResourceByTagId(t.Id))
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func diffTagFields(a, b *Wad, ta, tb *Tag, td *TagDiff) error {
+	va, err := marshalTagForDiff(a, ta)
+	if err != nil {
+		return fmt.Errorf("%s: %v", a.Name(), err)
+	}
+	vb, err := marshalTagForDiff(b, tb)
+	if err != nil {
+		return fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if va == nil || vb == nil {
+		return nil
+	}
+	diffJsonValues("", va, vb, &td.Fields)
+	return nil
+}
+
+func diffJsonShortValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok && len(s) > DIFF_MAX_VALUE_LENGTH {
+		return fmt.Sprintf("<%d chars>", len(s))
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Sprintf("<%T>", v)
+	}
+	return v
+}
+
+func diffJsonValues(p string, a, b interface{}, out *[]FieldDiff) {
+	if len(*out) >= DIFF_MAX_FIELDS {
+		return
+	}
+
+	switch va := a.(type) {
+	case map[string]interface{}:
+		if vb, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(va)+len(vb))
+			for k := range va {
+				keys = append(keys, k)
+			}
+			for k := range vb {
+				if _, ok := va[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				kp := k
+				if p != "" {
+					kp = p + "." + k
+				}
+				diffJsonValues(kp, va[k], vb[k], out)
+			}
+			return
+		}
+	case []interface{}:
+		if vb, ok := b.([]interface{}); ok {
+			if len(va) != len(vb) {
+				*out = append(*out, FieldDiff{Path: p + ".length", A: len(va), B: len(vb)})
+			}
+			for i := 0; i < len(va) && i < len(vb); i++ {
+				diffJsonValues(p+"["+strconv.Itoa(i)+"]", va[i], vb[i], out)
+			}
+			return
+		}
+	default:
+		if reflect.DeepEqual(a, b) {
+			return
+		}
+	}
+	*out = append(*out, FieldDiff{Path: p, A: diffJsonShortValue(a), B: diffJsonShortValue(b)})
+}
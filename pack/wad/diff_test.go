@@ -0,0 +1,60 @@
+package wad
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+)
+
+type testSource string
+
+func (s testSource) Name() string                    { return string(s) }
+func (s testSource) Size() int64                     { return 0 }
+func (s testSource) Save(in *io.SectionReader) error { return nil }
+
+func newTestWad(t *testing.T, name string, tags []Tag) *Wad {
+	w, err := NewWad(bytes.NewReader(MarshalTags(tags)), testSource(name))
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return w
+}
+
+func TestDiff(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	raw := func(name, data string) Tag {
+		return Tag{Tag: TAG_GOW1_FILE_RAW_DATA, Name: name, Data: []byte(data)}
+	}
+	a := newTestWad(t, "A.WAD", []Tag{raw("SAME", "1"), raw("RESIZED", "12"), raw("CHANGED", "ab"), raw("REMOVED", "x")})
+	b := newTestWad(t, "B.WAD", []Tag{raw("SAME", "1"), raw("ADDED", "y"), raw("RESIZED", "123"), raw("CHANGED", "ac")})
+
+	statuses := make(map[string]string)
+	for _, td := range Diff(a, b).Tags {
+		statuses[td.Name] = td.Status
+	}
+	expected := map[string]string{"RESIZED": DIFF_RESIZED, "CHANGED": DIFF_CHANGED, "REMOVED": DIFF_REMOVED, "ADDED": DIFF_ADDED}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Unexpected diff %v", statuses)
+	}
+}
+
+func TestDiffJsonValues(t *testing.T) {
+	a := map[string]interface{}{"Same": 1.0, "Value": 1.0, "List": []interface{}{1.0, 2.0}}
+	b := map[string]interface{}{"Same": 1.0, "Value": 2.0, "List": []interface{}{1.0, 3.0, 4.0}}
+
+	var fields []FieldDiff
+	diffJsonValues("", a, b, &fields)
+	expected := []FieldDiff{
+		{Path: "List.length", A: 2, B: 3},
+		{Path: "List[1]", A: 2.0, B: 3.0},
+		{Path: "Value", A: 1.0, B: 2.0},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Unexpected fields diff %v", fields)
+	}
+}
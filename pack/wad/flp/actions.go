@@ -32,14 +32,10 @@ func (f *FLP) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.
 			return
 		}
 
-		if err := f.StaticLabels[id].ParseJson([]byte(r.PostFormValue("sl"))); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to load static label"))
+		if err := f.UpdateStaticLabel(wrsrc, id, []byte(r.PostFormValue("sl"))); err != nil {
+			webutils.WriteError(w, err)
 			return
 		}
-
-		wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
-			wrsrc.Tag.Id: f.marshalBufferWithHeader().Bytes(),
-		})
 	case "importbmfont":
 		if strings.ToUpper(r.Method) != "POST" {
 			return
@@ -70,7 +66,7 @@ func (f *FLP) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.
 			webutils.WriteError(w, errors.Wrapf(err, "Failed to open zip reader"))
 			return
 		}
-		if err := f.actionImportBmFont(wrsrc, zr, scale); err != nil {
+		if err := f.ImportBmFontZip(wrsrc, zr, scale); err != nil {
 			webutils.WriteError(w, errors.Wrapf(err, "Failed to import font"))
 			return
 		}
@@ -132,65 +128,86 @@ func (f *FLP) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.
 	case "asjson":
 		webutils.WriteJsonFile(w, f, wrsrc.Name())
 	case "fromjson":
-		newFlp := &FLP{}
-		currentFlpInstance = newFlp
-
-		if err := webutils.ReadJsonFile(r, "data", newFlp); err != nil {
+		data, err := webutils.ReadFile(r, "data")
+		if err != nil {
+			webutils.WriteError(w, err)
+			return
+		}
+		if err := f.FromJson(wrsrc, data); err != nil {
 			webutils.WriteError(w, err)
+			return
 		}
+	}
+}
 
-		// decompile scripts
-		for _, d6 := range newFlp.Datas6 {
-			for _, d6s1s2 := range d6.Sub1.FrameScriptLables {
-				for _, d6s1s2s1 := range d6s1s2.Subs {
-					if err := d6s1s2s1.Script.FromDecompiled(); err != nil {
-						webutils.WriteError(w, errors.Wrapf(err, "Failed upload d6 d6s1s2s1 script"))
-						return
-					}
-				}
-			}
-			for _, d6s2 := range d6.Sub2s {
-				if err := d6s2.Script.FromDecompiled(); err != nil {
-					webutils.WriteError(w, errors.Wrapf(err, "Failed upload d6 d6s2 script"))
-					return
+func (f *FLP) UpdateStaticLabel(wrsrc *wad.WadNodeRsrc, id int, data []byte) error {
+	if id < 0 || id >= len(f.StaticLabels) {
+		return errors.Errorf("Static label %d not found", id)
+	}
+	if err := f.StaticLabels[id].ParseJson(data); err != nil {
+		return errors.Wrapf(err, "Failed to load static label")
+	}
+
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: f.marshalBufferWithHeader().Bytes(),
+	})
+}
+
+// FromJson replaces flp with one from json representation, scripts are compiled from decompiled form
+func (f *FLP) FromJson(wrsrc *wad.WadNodeRsrc, data []byte) error {
+	newFlp := &FLP{}
+	currentFlpInstance = newFlp
+
+	if err := json.Unmarshal(data, newFlp); err != nil {
+		return errors.Wrapf(err, "Failed to unmarshal")
+	}
+
+	// decompile scripts
+	for _, d6 := range newFlp.Datas6 {
+		for _, d6s1s2 := range d6.Sub1.FrameScriptLables {
+			for _, d6s1s2s1 := range d6s1s2.Subs {
+				if err := d6s1s2s1.Script.FromDecompiled(); err != nil {
+					return errors.Wrapf(err, "Failed upload d6 d6s1s2s1 script")
 				}
 			}
 		}
-		for _, d7 := range newFlp.Datas7 {
-			for _, d6s1s2 := range d7.FrameScriptLables {
-				for _, d6s1s2s1 := range d6s1s2.Subs {
-					if err := d6s1s2s1.Script.FromDecompiled(); err != nil {
-						webutils.WriteError(w, errors.Wrapf(err, "Failed upload d7 d6s1s2s1 script"))
-						return
-					}
-				}
+		for _, d6s2 := range d6.Sub2s {
+			if err := d6s2.Script.FromDecompiled(); err != nil {
+				return errors.Wrapf(err, "Failed upload d6 d6s2 script")
 			}
 		}
-		for _, d6s1s2 := range newFlp.Data8.FrameScriptLables {
+	}
+	for _, d7 := range newFlp.Datas7 {
+		for _, d6s1s2 := range d7.FrameScriptLables {
 			for _, d6s1s2s1 := range d6s1s2.Subs {
 				if err := d6s1s2s1.Script.FromDecompiled(); err != nil {
-					webutils.WriteError(w, errors.Wrapf(err, "Failed upload d8 d6s1s2s1 script"))
-					return
+					return errors.Wrapf(err, "Failed upload d7 d6s1s2s1 script")
 				}
 			}
 		}
+	}
+	for _, d6s1s2 := range newFlp.Data8.FrameScriptLables {
+		for _, d6s1s2s1 := range d6s1s2.Subs {
+			if err := d6s1s2s1.Script.FromDecompiled(); err != nil {
+				return errors.Wrapf(err, "Failed upload d8 d6s1s2s1 script")
+			}
+		}
+	}
 
-		newFLPBuf := newFlp.marshalBufferWithHeader()
+	newFLPBuf := newFlp.marshalBufferWithHeader()
 
-		// testing
-		// ioutil.WriteFile("/tmp/testupload.FLP", newFLPBuf.Bytes(), 0777)
-		// double check validity of file
+	// testing
+	// ioutil.WriteFile("/tmp/testupload.FLP", newFLPBuf.Bytes(), 0777)
+	// double check validity of file
 
-		if _, err := NewFromData(newFLPBuf.Bytes()); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to check validity of file"))
-			return
-		}
+	if _, err := NewFromData(newFLPBuf.Bytes()); err != nil {
+		return errors.Wrapf(err, "Failed to check validity of file")
+	}
 
-		if err := wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
-			wrsrc.Tag.Id: newFLPBuf.Bytes(),
-		}); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to write tag"))
-			return
-		}
+	if err := wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: newFLPBuf.Bytes(),
+	}); err != nil {
+		return errors.Wrapf(err, "Failed to write tag")
 	}
+	return nil
 }
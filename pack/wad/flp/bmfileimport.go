@@ -55,7 +55,7 @@ func getBmfFromArchive(zr *zip.Reader) (*bmfont.Font, error) {
 	return bmfont.NewFontFromBuf(raw)
 }
 
-func (f *FLP) actionImportBmFont(wrsrc *wad.WadNodeRsrc, zr *zip.Reader, scale float32) error {
+func (f *FLP) ImportBmFontZip(wrsrc *wad.WadNodeRsrc, zr *zip.Reader, scale float32) error {
 	if f.Fonts == nil || len(f.Fonts) == 0 {
 		return nil
 	}
@@ -242,6 +242,29 @@ type Marshaled struct {
 	Textures        map[string]interface{}
 }
 
+// References returns texture names of mesh parts. In gow2 textures are
+// taken from subnodes of go object instead, so names are not references
+func (f *FLP) References(wrsrc *wad.WadNodeRsrc) []wad.Reference {
+	if config.GetGOWVersion() != config.GOW1 {
+		return nil
+	}
+	refs := make([]wad.Reference, 0)
+	addMeshRef := func(mpr *MeshPartReference) {
+		for _, m := range mpr.Materials {
+			refs = append(refs, wad.NodeRef(m.TextureName))
+		}
+	}
+	for i := range f.MeshPartReferences {
+		addMeshRef(&f.MeshPartReferences[i])
+	}
+	for _, font := range f.Fonts {
+		for i := range font.MeshesRefs {
+			addMeshRef(&font.MeshesRefs[i])
+		}
+	}
+	return refs
+}
+
 func (f *FLP) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	mrsh := &Marshaled{
 		FLP:      f,
@@ -480,6 +480,11 @@ func (f *FLP) marshalBufferHeader(fm *FlpMarshaler) {
 	}
 }
 
+// MarshalToBinary returns tag data of flp
+func (f *FLP) MarshalToBinary() []byte {
+	return f.marshalBufferWithHeader().Bytes()
+}
+
 func (f *FLP) marshalBufferWithHeader() *bytes.Buffer {
 	if config.GetPlayStationVersion() == config.PS3 {
 		log.Panicf("Unsupported playstation version")
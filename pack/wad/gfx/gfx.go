@@ -241,6 +241,31 @@ func (gfx *GFX) MarshalToBinary() ([]byte, error) {
 	return buf, nil
 }
 
+// GS texture width and height stored as log2 in TEX0 register
+const GS_MAX_TEXTURE_SIZE = 1024
+
+func isPowerOfTwo(v uint32) bool {
+	return v != 0 && v&(v-1) == 0
+}
+
+func (gfx *GFX) Validate(wrsrc *wad.WadNodeRsrc) []string {
+	if config.GetPlayStationVersion() != config.PS2 {
+		return nil
+	}
+	var errs []string
+	for _, dim := range []struct {
+		name  string
+		value uint32
+	}{{"Width", gfx.Width}, {"Height", gfx.RealHeight}} {
+		if !isPowerOfTwo(dim.value) {
+			errs = append(errs, fmt.Sprintf("%s %d is not power of two", dim.name, dim.value))
+		} else if dim.value > GS_MAX_TEXTURE_SIZE {
+			errs = append(errs, fmt.Sprintf("%s %d is bigger than GS limit %d", dim.name, dim.value, GS_MAX_TEXTURE_SIZE))
+		}
+	}
+	return errs
+}
+
 func (gfx *GFX) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	return gfx, nil
 }
@@ -11,8 +11,16 @@ const (
 	TAG_GOW2018_AUTOPAD      = 0x19 // pad to 0x10000 bytes
 
 	TAG_GOW2018_SIZE = 0x60
+
+	GOW2018_AUTOPAD_ALIGN = 0x10000
 )
 
+// autoPadSize returns data size of autopad tag placed at pos
+func autoPadSize(pos int) int {
+	dataStart := pos + TAG_GOW2018_SIZE
+	return (GOW2018_AUTOPAD_ALIGN - dataStart%GOW2018_AUTOPAD_ALIGN) % GOW2018_AUTOPAD_ALIGN
+}
+
 func (w *Wad) gow2018parseTag(tag *Tag, currentNode *NodeId, newGroupTag *bool, addNode func(tag *Tag) *Node) error {
 	switch tag.Tag {
 	case TAG_GOW2018_SERVER_INSTANCE:
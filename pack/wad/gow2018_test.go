@@ -0,0 +1,119 @@
+package wad
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+)
+
+func TestGOW2018TagRoundTrip(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW2018)
+
+	buf := make([]byte, TAG_GOW2018_SIZE)
+	for i := range buf {
+		buf[i] = byte(i + 1)
+	}
+	copy(buf[0x18:0x50], make([]byte, 0x38))
+	copy(buf[0x18:], "MG_hero")
+
+	tag := UnmarshalTag(buf)
+	if tag.Name != "MG_hero" {
+		t.Fatalf("Unexpected name %q", tag.Name)
+	}
+	if marshaled := MarshalTag(&tag); !bytes.Equal(marshaled, buf) {
+		t.Errorf("Header changed after round trip:\n%x\n%x", buf, marshaled)
+	}
+}
+
+func TestGOW2018AutoPadSize(t *testing.T) {
+	for _, pos := range []int{0, 0x10, 0x10000 - TAG_GOW2018_SIZE, 0x12340} {
+		if end := pos + TAG_GOW2018_SIZE + autoPadSize(pos); end%GOW2018_AUTOPAD_ALIGN != 0 {
+			t.Errorf("Autopad at 0x%x ends at unaligned 0x%x", pos, end)
+		}
+	}
+	if size := autoPadSize(0x10000 - TAG_GOW2018_SIZE); size != 0 {
+		t.Errorf("Expected empty autopad, got 0x%x", size)
+	}
+}
+
+type savedSource struct {
+	data []byte
+}
+
+func (s *savedSource) Name() string { return "R_TEST.WAD" }
+func (s *savedSource) Size() int64  { return int64(len(s.data)) }
+func (s *savedSource) Save(in *io.SectionReader) error {
+	data, err := io.ReadAll(in)
+	s.data = data
+	return err
+}
+
+func TestGOW2018SaveReload(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW2018)
+
+	tag := func(tag uint16, name string, data []byte, seed byte) Tag {
+		t := Tag{Tag: tag, Flags: uint16(seed), Name: name, Data: data}
+		for i := range t.GUID {
+			t.GUID[i] = seed + byte(i)
+		}
+		t.HeaderTail = make([]byte, TAG_GOW2018_SIZE-0x50)
+		for i := range t.HeaderTail {
+			t.HeaderTail[i] = 0x80 + seed + byte(i)
+		}
+		return t
+	}
+	tags := []Tag{
+		tag(TAG_GOW2018_SERVER_INSTANCE, "before", []byte{1, 2, 3}, 1),
+		tag(TAG_GOW2018_AUTOPAD, "autopad", nil, 2),
+		tag(TAG_GOW2018_SERVER_INSTANCE, "after", bytes.Repeat([]byte{4}, 0x20), 3),
+		tag(TAG_GOW2018_DCClientGUID, "guid", []byte{5}, 4),
+	}
+
+	src := &savedSource{}
+	w, err := NewWad(bytes.NewReader(MarshalTags(tags)), src)
+	if err != nil {
+		t.Fatalf("Failed to load wad: %v", err)
+	}
+	if err := w.UpdateTagsData(map[TagId][]byte{0: bytes.Repeat([]byte{6}, 0x123)}); err != nil {
+		t.Fatalf("Failed to save wad: %v", err)
+	}
+	tags[0].Data = bytes.Repeat([]byte{6}, 0x123)
+
+	reloaded, err := NewWad(bytes.NewReader(src.data), src)
+	if err != nil {
+		t.Fatalf("Failed to reload saved wad: %v", err)
+	}
+	if len(reloaded.Tags) != len(tags) {
+		t.Fatalf("Expected %d tags, got %d", len(tags), len(reloaded.Tags))
+	}
+	for i, expected := range tags {
+		got := reloaded.Tags[i]
+		if got.Tag != expected.Tag || got.Flags != expected.Flags || got.Name != expected.Name {
+			t.Errorf("Tag %d header changed: %+v", i, got)
+		}
+		if got.GUID != expected.GUID {
+			t.Errorf("Tag %d GUID changed: %x != %x", i, got.GUID, expected.GUID)
+		}
+		if !bytes.Equal(got.HeaderTail, expected.HeaderTail) {
+			t.Errorf("Tag %d header tail changed: %x != %x", i, got.HeaderTail, expected.HeaderTail)
+		}
+		if expected.Tag != TAG_GOW2018_AUTOPAD && !bytes.Equal(got.Data, expected.Data) {
+			t.Errorf("Tag %d data changed", i)
+		}
+	}
+
+	pad := reloaded.Tags[1]
+	if int(pad.Size) != autoPadSize(int(pad.DebugPos)) {
+		t.Errorf("Autopad size 0x%x does not match its position 0x%x", pad.Size, pad.DebugPos)
+	}
+	if pos := reloaded.Tags[2].DebugPos; pos%GOW2018_AUTOPAD_ALIGN != 0 {
+		t.Errorf("Tag after autopad placed at unaligned 0x%x", pos)
+	}
+	if !bytes.Equal(MarshalTags(reloaded.Tags), src.data) {
+		t.Errorf("Saved wad changes after second round trip")
+	}
+}
@@ -15,6 +15,12 @@ func GetServerInstanceTag() uint16 {
 	}
 }
 
+// IsHeapSizeTag reports if tag is entity count tag, which size is count
+// of entities of heap named by tag and not size of data
+func IsHeapSizeTag(tag *Tag) bool {
+	return isZeroSizedTag(tag)
+}
+
 func isZeroSizedTag(tag *Tag) bool {
 	switch config.GetGOWVersion() {
 	case config.GOW1:
@@ -27,3 +33,20 @@ func isZeroSizedTag(tag *Tag) bool {
 		panic("unknwn")
 	}
 }
+
+func isAutoPadTag(tag *Tag) bool {
+	return config.GetGOWVersion() == config.GOW2018 && tag.Tag == TAG_GOW2018_AUTOPAD
+}
+
+func getGroupTags() (start uint16, end uint16) {
+	switch config.GetGOWVersion() {
+	case config.GOW1:
+		return TAG_GOW1_FILE_GROUP_START, TAG_GOW1_FILE_GROUP_END
+	case config.GOW2:
+		return TAG_GOW2_FILE_GROUP_START, TAG_GOW2_FILE_GROUP_END
+	case config.GOW2018:
+		return TAG_GOW2018_FILE_GROUP_START, TAG_GOW2018_FILE_GROUP_END
+	default:
+		panic("unknwn")
+	}
+}
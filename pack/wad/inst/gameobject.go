@@ -53,6 +53,10 @@ type Ajax struct {
 	Object  interface{}
 }
 
+func (inst *Instance) References(wrsrc *wad.WadNodeRsrc) []wad.Reference {
+	return []wad.Reference{wad.NodeRef(inst.Object)}
+}
+
 func (inst *Instance) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	scripts := make([]interface{}, 0)
 
@@ -140,6 +140,14 @@ type Ajax struct {
 	Animations      interface{}
 }
 
+func (mat *Material) References(wrsrc *wad.WadNodeRsrc) []wad.Reference {
+	refs := make([]wad.Reference, 0, len(mat.Layers))
+	for _, l := range mat.Layers {
+		refs = append(refs, wad.NodeRef(l.Texture))
+	}
+	return refs
+}
+
 func (mat *Material) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	res := Ajax{
 		Mat:             mat,
@@ -1,6 +1,7 @@
 package mesh
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 
@@ -31,5 +32,27 @@ func (mesh *Mesh) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *h
 				log.Printf("Failed to encode gltf: %v", err)
 			}
 		}
+	case "fromgltf":
+		gltfReader, _, err := r.FormFile("model")
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		defer gltfReader.Close()
+		if err := mesh.ImportGLTF(wrsrc, gltfReader); err != nil {
+			log.Printf("[mesh] Error importing gltf: %v", err)
+			fmt.Fprintln(w, "mesh import error:", err)
+		}
+	case "fromcollada":
+		colladaReader, _, err := r.FormFile("model")
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		defer colladaReader.Close()
+		if err := mesh.ImportCollada(wrsrc, colladaReader); err != nil {
+			log.Printf("[mesh] Error importing collada: %v", err)
+			fmt.Fprintln(w, "mesh import error:", err)
+		}
 	}
 }
@@ -1,10 +1,800 @@
 package mesh
 
-import "github.com/mogaika/go-collada"
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
 
-func NewGOW1ps2MeshFromCollada(c *collada.Collada) (m *Mesh, err error) {
-	m = &Mesh{}
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/mogaika/go-collada"
+	"github.com/pkg/errors"
 
-	return
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	file_mat "github.com/mogaika/god_of_war_browser/pack/wad/mat"
+	"github.com/mogaika/god_of_war_browser/pack/wad/mesh/common"
+)
 
+// go-collada do not parse controllers, so skin declared here
+type ColladaSkin struct {
+	Source          collada.Uri       `xml:"source,attr"`
+	BindShapeMatrix *collada.Float4x4 `xml:"bind_shape_matrix"`
+	Sources         []*collada.Source `xml:"source"`
+	Joints          struct {
+		Input []*collada.InputUnshared `xml:"input"`
+	} `xml:"joints"`
+	VertexWeights struct {
+		Count  int                    `xml:"count,attr"`
+		Input  []*collada.InputShared `xml:"input"`
+		VCount collada.Ints           `xml:"vcount"`
+		V      collada.Ints           `xml:"v"`
+	} `xml:"vertex_weights"`
+}
+
+type ColladaController struct {
+	Id   collada.Id   `xml:"id,attr"`
+	Name string       `xml:"name,attr"`
+	Skin *ColladaSkin `xml:"skin"`
+}
+
+type ColladaDocument struct {
+	*collada.Collada
+	Controllers []*ColladaController
+}
+
+func LoadColladaDocument(r io.Reader) (*ColladaDocument, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := collada.LoadDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse collada")
+	}
+
+	var controllers struct {
+		Controllers []*ColladaController `xml:"library_controllers>controller"`
+	}
+	if err := xml.Unmarshal(data, &controllers); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse collada controllers")
+	}
+
+	return &ColladaDocument{Collada: c, Controllers: controllers.Controllers}, nil
+}
+
+// collada arrays can be separated by any whitespace, go-collada expects single spaces
+func colladaFloats(v collada.Values) []float32 {
+	fields := strings.Fields(v.V)
+	result := make([]float32, len(fields))
+	for i, f := range fields {
+		value, _ := strconv.ParseFloat(f, 32)
+		result[i] = float32(value)
+	}
+	return result
+}
+
+func colladaInts(v collada.Values) []int {
+	fields := strings.Fields(v.V)
+	result := make([]int, len(fields))
+	for i, f := range fields {
+		result[i], _ = strconv.Atoi(f)
+	}
+	return result
+}
+
+func colladaUriId(uri collada.Uri) collada.Id {
+	return collada.Id(strings.TrimPrefix(string(uri), "#"))
+}
+
+// row-major collada matrix
+func colladaMatrix(f []float32) mgl32.Mat4 {
+	var m mgl32.Mat4
+	copy(m[:], f)
+	return m.Transpose()
+}
+
+func colladaNodeMatrix(node *collada.Node) mgl32.Mat4 {
+	result := mgl32.Ident4()
+	for _, m := range node.Matrix {
+		result = result.Mul4(colladaMatrix(colladaFloats(m.Values)))
+	}
+	for _, t := range node.Translate {
+		f := colladaFloats(t.Values)
+		if len(f) == 3 {
+			result = result.Mul4(mgl32.Translate3D(f[0], f[1], f[2]))
+		}
+	}
+	for _, r := range node.Rotate {
+		f := colladaFloats(r.Values)
+		if len(f) == 4 {
+			result = result.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(f[3]), mgl32.Vec3{f[0], f[1], f[2]}.Normalize()))
+		}
+	}
+	for _, s := range node.Scale {
+		f := colladaFloats(s.Values)
+		if len(f) == 3 {
+			result = result.Mul4(mgl32.Scale3D(f[0], f[1], f[2]))
+		}
+	}
+	return result
+}
+
+type colladaAccessor struct {
+	Accessor struct {
+		Stride int `xml:"stride,attr"`
+	} `xml:"accessor"`
+}
+
+type colladaInstanceMaterials struct {
+	InstanceMaterial []struct {
+		Symbol string      `xml:"symbol,attr"`
+		Target collada.Uri `xml:"target,attr"`
+	} `xml:"instance_material"`
+}
+
+type colladaImporter struct {
+	doc         *ColladaDocument
+	materialIds map[string]int
+	jointId     func(name string) (int, bool)
+
+	sources     map[collada.Id]*collada.Source
+	vertices    map[collada.Id]*collada.Vertices
+	geometries  map[collada.Id]*collada.Geometry
+	controllers map[collada.Id]*ColladaController
+	materials   map[collada.Id]*collada.Material
+	nodes       map[string]*collada.Node // by id and sid
+
+	axis mgl32.Mat4
+	mesh *common.Mesh
+}
+
+func (ci *colladaImporter) addNodes(nodes []*collada.Node) {
+	for _, node := range nodes {
+		if node.Id != "" {
+			ci.nodes[string(node.Id)] = node
+		}
+		if node.Sid != "" {
+			ci.nodes[node.Sid] = node
+		}
+		ci.addNodes(node.Node)
+	}
+}
+
+func (ci *colladaImporter) index() {
+	for _, lib := range ci.doc.LibraryGeometries {
+		for _, g := range lib.Geometry {
+			ci.geometries[g.Id] = g
+			if g.Mesh == nil {
+				continue
+			}
+			for _, s := range g.Mesh.Source {
+				ci.sources[s.Id] = s
+			}
+			ci.vertices[g.Mesh.Vertices.Id] = &g.Mesh.Vertices
+		}
+	}
+	for _, c := range ci.doc.Controllers {
+		ci.controllers[c.Id] = c
+		if c.Skin != nil {
+			for _, s := range c.Skin.Sources {
+				ci.sources[s.Id] = s
+			}
+		}
+	}
+	for _, lib := range ci.doc.LibraryMaterials {
+		for _, m := range lib.Material {
+			ci.materials[m.Id] = m
+		}
+	}
+	for _, lib := range ci.doc.LibraryVisualScenes {
+		for _, vs := range lib.VisualScene {
+			ci.addNodes(vs.Node)
+		}
+	}
+}
+
+// floats of source grouped by accessor stride
+func (ci *colladaImporter) sourceValues(uri collada.Uri, defaultStride int) ([][]float32, error) {
+	s, ok := ci.sources[colladaUriId(uri)]
+	if !ok || s.FloatArray == nil {
+		return nil, fmt.Errorf("Float source %q not found", uri)
+	}
+	stride := defaultStride
+	var accessor colladaAccessor
+	if err := xml.Unmarshal([]byte("<t>"+s.TechniqueCommon.XML+"</t>"), &accessor); err == nil && accessor.Accessor.Stride != 0 {
+		stride = accessor.Accessor.Stride
+	}
+	floats := colladaFloats(s.FloatArray.Values)
+	result := make([][]float32, len(floats)/stride)
+	for i := range result {
+		result[i] = floats[i*stride : (i+1)*stride]
+	}
+	return result, nil
+}
+
+func (ci *colladaImporter) materialIndex(symbol string, bindings map[string]collada.Id) int {
+	id, ok := bindings[symbol]
+	if !ok {
+		id = collada.Id(symbol)
+	}
+	candidates := []string{string(id), strings.TrimSuffix(string(id), "-material")}
+	if m, ok := ci.materials[id]; ok && m.Name != "" {
+		candidates = append([]string{m.Name}, candidates...)
+	}
+	for _, name := range candidates {
+		if index, ok := ci.materialIds[name]; ok {
+			return index
+		}
+	}
+	return -1
+}
+
+// returns global joints and weights of skin for every position index
+func (ci *colladaImporter) skinWeights(skin *ColladaSkin) ([][2]uint32, []float32, error) {
+	var jointNames []string
+	var weights []float32
+	for _, input := range skin.Joints.Input {
+		if input.Semantic == "JOINT" {
+			s, ok := ci.sources[colladaUriId(input.Source)]
+			if !ok {
+				return nil, nil, fmt.Errorf("Joints source %q not found", input.Source)
+			}
+			if s.NameArray != nil {
+				jointNames = strings.Fields(s.NameArray.V)
+			} else if s.IdRefArray != nil {
+				jointNames = strings.Fields(s.IdRefArray.V)
+			}
+		}
+	}
+
+	jointOffset, weightOffset, stride := -1, -1, 0
+	for _, input := range skin.VertexWeights.Input {
+		switch input.Semantic {
+		case "JOINT":
+			jointOffset = int(input.Offset)
+		case "WEIGHT":
+			weightOffset = int(input.Offset)
+			s, ok := ci.sources[colladaUriId(input.Source)]
+			if !ok || s.FloatArray == nil {
+				return nil, nil, fmt.Errorf("Weights source %q not found", input.Source)
+			}
+			weights = colladaFloats(s.FloatArray.Values)
+		}
+		if int(input.Offset)+1 > stride {
+			stride = int(input.Offset) + 1
+		}
+	}
+	if jointOffset < 0 || weightOffset < 0 {
+		return nil, nil, fmt.Errorf("Skin without joints or weights")
+	}
+
+	globalJoints := make([]uint32, len(jointNames))
+	for i, name := range jointNames {
+		id, ok := ci.jointId(name)
+		if !ok {
+			// skins can reference joint node by id or sid
+			if node, found := ci.nodes[name]; found {
+				id, ok = ci.jointId(node.Name)
+			}
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("Unknown joint %q", name)
+		}
+		globalJoints[i] = uint32(id)
+	}
+
+	vcount := colladaInts(skin.VertexWeights.VCount.Values)
+	v := colladaInts(skin.VertexWeights.V.Values)
+
+	type influence struct {
+		joint  uint32
+		weight float32
+	}
+	resultJoints := make([][2]uint32, len(vcount))
+	resultWeights := make([]float32, len(vcount))
+	pos := 0
+	for iVertex, count := range vcount {
+		influences := make([]influence, 0, count)
+		for i := 0; i < count; i++ {
+			if pos+stride > len(v) {
+				return nil, nil, fmt.Errorf("Vertex weights out of bounds")
+			}
+			joint, weight := v[pos+jointOffset], v[pos+weightOffset]
+			pos += stride
+			// -1 means bind shape
+			if joint < 0 || joint >= len(globalJoints) || weight >= len(weights) {
+				continue
+			}
+			influences = append(influences, influence{globalJoints[joint], weights[weight]})
+		}
+		sort.SliceStable(influences, func(i, j int) bool { return influences[i].weight > influences[j].weight })
+
+		switch {
+		case len(influences) == 0:
+			return nil, nil, fmt.Errorf("Vertex %d without joints", iVertex)
+		case len(influences) == 1 || influences[1].weight <= 0:
+			resultJoints[iVertex] = [2]uint32{influences[0].joint, influences[0].joint}
+			resultWeights[iVertex] = 1
+		default:
+			w0, w1 := influences[0].weight, influences[1].weight
+			resultJoints[iVertex] = [2]uint32{influences[0].joint, influences[1].joint}
+			resultWeights[iVertex] = w0 / (w0 + w1)
+		}
+	}
+
+	return resultJoints, resultWeights, nil
+}
+
+type colladaPrimitives struct {
+	material string
+	inputs   []*collada.InputShared
+	// polygons as lists of vertices (each vertex is stride indexes)
+	polygons [][]int
+}
+
+func colladaMeshPrimitives(m *collada.Mesh) ([]*colladaPrimitives, error) {
+	result := make([]*colladaPrimitives, 0)
+	stride := func(inputs []*collada.InputShared) int {
+		s := 0
+		for _, input := range inputs {
+			if int(input.Offset)+1 > s {
+				s = int(input.Offset) + 1
+			}
+		}
+		return s
+	}
+
+	for _, t := range m.Triangles {
+		if t.P == nil {
+			continue
+		}
+		p := &colladaPrimitives{material: t.Material, inputs: t.Input}
+		s := stride(t.Input)
+		indexes := colladaInts(t.P.Values)
+		for i := 0; i+s*3 <= len(indexes); i += s * 3 {
+			p.polygons = append(p.polygons, indexes[i:i+s*3])
+		}
+		result = append(result, p)
+	}
+	for _, pl := range m.Polylist {
+		if pl.P == nil || pl.VCount == nil {
+			continue
+		}
+		p := &colladaPrimitives{material: pl.Material, inputs: pl.Input}
+		s := stride(pl.Input)
+		indexes := colladaInts(pl.P.Values)
+		pos := 0
+		for _, count := range colladaInts(pl.VCount.Values) {
+			if pos+count*s > len(indexes) {
+				return nil, fmt.Errorf("Polylist indexes out of bounds")
+			}
+			p.polygons = append(p.polygons, indexes[pos:pos+count*s])
+			pos += count * s
+		}
+		result = append(result, p)
+	}
+	for _, pg := range m.Polygons {
+		p := &colladaPrimitives{material: pg.Material, inputs: pg.Input}
+		for _, polygon := range pg.P {
+			p.polygons = append(p.polygons, colladaInts(polygon.Values))
+		}
+		result = append(result, p)
+	}
+	if len(m.Tristrips) != 0 || len(m.Trifans) != 0 {
+		return nil, fmt.Errorf("Tristrips and trifans are not supported, triangulate mesh before export")
+	}
+	return result, nil
+}
+
+// objectFromPrimitives converts primitives into object, polygons are triangulated as fans
+func (ci *colladaImporter) objectFromPrimitives(m *collada.Mesh, p *colladaPrimitives,
+	transform mgl32.Mat4, skinJoints [][2]uint32, skinWeights []float32) (*common.Object, error) {
+
+	type attribute struct {
+		offset int
+		values [][]float32
+	}
+	var position, normal, texcoord, color *attribute
+	vertexOffset := -1
+	stride := 0
+	texcoordSet := uint(0)
+
+	for _, input := range p.inputs {
+		if int(input.Offset)+1 > stride {
+			stride = int(input.Offset) + 1
+		}
+		switch input.Semantic {
+		case "VERTEX":
+			vertexOffset = int(input.Offset)
+			vertices, ok := ci.vertices[colladaUriId(input.Source)]
+			if !ok {
+				vertices = &m.Vertices
+			}
+			for _, vInput := range vertices.Input {
+				values, err := ci.sourceValues(vInput.Source, 3)
+				if err != nil {
+					return nil, err
+				}
+				switch vInput.Semantic {
+				case "POSITION":
+					position = &attribute{int(input.Offset), values}
+				case "NORMAL":
+					normal = &attribute{int(input.Offset), values}
+				case "TEXCOORD":
+					texcoord = &attribute{int(input.Offset), values}
+				case "COLOR":
+					color = &attribute{int(input.Offset), values}
+				}
+			}
+		case "NORMAL", "TEXCOORD", "COLOR":
+			values, err := ci.sourceValues(input.Source, 3)
+			if err != nil {
+				return nil, err
+			}
+			a := &attribute{int(input.Offset), values}
+			switch input.Semantic {
+			case "NORMAL":
+				normal = a
+			case "TEXCOORD":
+				// use lowest texcoord set
+				if texcoord == nil || input.Set < texcoordSet {
+					texcoord, texcoordSet = a, input.Set
+				}
+			case "COLOR":
+				color = a
+			}
+		}
+	}
+	if position == nil || vertexOffset < 0 {
+		return nil, fmt.Errorf("Primitives without positions")
+	}
+
+	o := &common.Object{
+		Indexes:        make([]int, 0),
+		InstancesCount: 1,
+		LayersCount:    1,
+		MaterialIndex:  -1,
+	}
+	if normal != nil {
+		o.Normals = make([]common.Normal, 0)
+	}
+	if texcoord != nil {
+		o.UVs = [][]common.UV{make([]common.UV, 0)}
+	}
+	if color != nil {
+		o.BlendColors = [][]common.RGBA{make([]common.RGBA, 0)}
+	}
+	var joints [][2]uint32
+	var weights []float32
+
+	value := func(a *attribute, tuple []int) ([]float32, error) {
+		index := tuple[a.offset]
+		if index < 0 || index >= len(a.values) {
+			return nil, fmt.Errorf("Index %d out of source size %d", index, len(a.values))
+		}
+		return a.values[index], nil
+	}
+
+	normalTransform := transform.Mat3().Inv().Transpose()
+	vertexByTuple := make(map[string]int)
+	addVertex := func(tuple []int) (int, error) {
+		key := fmt.Sprint(tuple)
+		if index, ok := vertexByTuple[key]; ok {
+			return index, nil
+		}
+
+		pos, err := value(position, tuple)
+		if err != nil {
+			return 0, err
+		}
+		o.Vertices = append(o.Vertices, common.Vertex{
+			Position: mgl32.TransformCoordinate(mgl32.Vec3{pos[0], pos[1], pos[2]}, transform),
+			Weight:   1,
+		})
+		if normal != nil {
+			n, err := value(normal, tuple)
+			if err != nil {
+				return 0, err
+			}
+			nv := normalTransform.Mul3x1(mgl32.Vec3{n[0], n[1], n[2]})
+			if nv.Len() > 0.0001 {
+				nv = nv.Normalize()
+			}
+			o.Normals = append(o.Normals, nv)
+		}
+		if texcoord != nil {
+			uv, err := value(texcoord, tuple)
+			if err != nil {
+				return 0, err
+			}
+			// collada uv origin is bottom left
+			o.UVs[0] = append(o.UVs[0], common.UV{uv[0], 1 - uv[1]})
+		}
+		if color != nil {
+			c, err := value(color, tuple)
+			if err != nil {
+				return 0, err
+			}
+			rgba := common.RGBA{A: 0xff}
+			for i, f := range c {
+				b := uint8(mgl32.Clamp(f, 0, 1)*255 + 0.5)
+				switch i {
+				case 0:
+					rgba.R = b
+				case 1:
+					rgba.G = b
+				case 2:
+					rgba.B = b
+				case 3:
+					rgba.A = b
+				}
+			}
+			o.BlendColors[0] = append(o.BlendColors[0], rgba)
+		}
+		if skinJoints != nil {
+			vertex := tuple[vertexOffset]
+			if vertex >= len(skinJoints) {
+				return 0, fmt.Errorf("Vertex %d out of skin weights", vertex)
+			}
+			joints = append(joints, skinJoints[vertex])
+			weights = append(weights, skinWeights[vertex])
+		}
+
+		vertexByTuple[key] = len(o.Vertices) - 1
+		return len(o.Vertices) - 1, nil
+	}
+
+	for _, polygon := range p.polygons {
+		vertices := make([]int, 0, len(polygon)/stride)
+		for i := 0; i+stride <= len(polygon); i += stride {
+			index, err := addVertex(polygon[i : i+stride])
+			if err != nil {
+				return nil, err
+			}
+			vertices = append(vertices, index)
+		}
+		for i := 2; i < len(vertices); i++ {
+			o.Indexes = append(o.Indexes, vertices[0], vertices[i-1], vertices[i])
+		}
+	}
+
+	if skinJoints != nil {
+		if err := common.FillJointMap(o, joints, weights); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (ci *colladaImporter) addObject(o *common.Object, name string) {
+	part, lod, _, _, ok := common.ParseObjectName(name)
+	if !ok {
+		part, lod = 0, 0
+	}
+	for len(ci.mesh.Parts) <= part {
+		ci.mesh.Parts = append(ci.mesh.Parts, &common.Part{LodGroups: make([]*common.LodGroup, 0)})
+	}
+	p := ci.mesh.Parts[part]
+	for len(p.LodGroups) <= lod {
+		p.LodGroups = append(p.LodGroups, &common.LodGroup{Objects: make([]*common.Object, 0)})
+	}
+	o.PartIndex = part
+	o.LodGroupIndex = lod
+	o.ObjectIndex = len(p.LodGroups[lod].Objects)
+	p.LodGroups[lod].Objects = append(p.LodGroups[lod].Objects, o)
+}
+
+func (ci *colladaImporter) addGeometry(name string, geometryUrl collada.Uri, bindMaterial *collada.BindMaterial,
+	transform mgl32.Mat4, skin *ColladaSkin) error {
+	geometry, ok := ci.geometries[colladaUriId(geometryUrl)]
+	if !ok {
+		return fmt.Errorf("Geometry %q not found", geometryUrl)
+	}
+	if geometry.Mesh == nil {
+		return nil
+	}
+
+	bindings := make(map[string]collada.Id)
+	if bindMaterial != nil {
+		var instances colladaInstanceMaterials
+		if err := xml.Unmarshal([]byte("<t>"+bindMaterial.TechniqueCommon.XML+"</t>"), &instances); err == nil {
+			for _, im := range instances.InstanceMaterial {
+				bindings[im.Symbol] = colladaUriId(im.Target)
+			}
+		}
+	}
+
+	var skinJoints [][2]uint32
+	var skinWeights []float32
+	if skin != nil {
+		var err error
+		if skinJoints, skinWeights, err = ci.skinWeights(skin); err != nil {
+			return errors.Wrapf(err, "Failed to read skin of %q", name)
+		}
+	}
+
+	primitives, err := colladaMeshPrimitives(geometry.Mesh)
+	if err != nil {
+		return errors.Wrapf(err, "Geometry %q", geometry.Id)
+	}
+	for _, p := range primitives {
+		o, err := ci.objectFromPrimitives(geometry.Mesh, p, transform, skinJoints, skinWeights)
+		if err != nil {
+			return errors.Wrapf(err, "Geometry %q material %q", geometry.Id, p.material)
+		}
+		if len(o.Indexes) == 0 {
+			continue
+		}
+		o.MaterialIndex = ci.materialIndex(p.material, bindings)
+		ci.addObject(o, name)
+	}
+	return nil
+}
+
+func (ci *colladaImporter) walk(nodes []*collada.Node, parent mgl32.Mat4) error {
+	for _, node := range nodes {
+		transform := parent.Mul4(colladaNodeMatrix(node))
+		name := node.Name
+		if name == "" {
+			name = string(node.Id)
+		}
+
+		for _, ig := range node.InstanceGeometry {
+			if err := ci.addGeometry(name, ig.Url, ig.BindMaterial, transform, nil); err != nil {
+				return err
+			}
+		}
+		for _, ic := range node.InstanceController {
+			controller, ok := ci.controllers[colladaUriId(ic.Url)]
+			if !ok || controller.Skin == nil {
+				return fmt.Errorf("Skin controller %q not found", ic.Url)
+			}
+			// skinned geometry placed by joints, only bind shape applied
+			bindShape := mgl32.Ident4()
+			if controller.Skin.BindShapeMatrix != nil {
+				if f := colladaFloats(controller.Skin.BindShapeMatrix.Values); len(f) == 16 {
+					bindShape = colladaMatrix(f)
+				}
+			}
+			if err := ci.addGeometry(name, controller.Skin.Source, ic.BindMaterial,
+				ci.axis.Mul4(bindShape), controller.Skin); err != nil {
+				return err
+			}
+		}
+
+		if err := ci.walk(node.Node, transform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommonFromCollada converts visual scene of collada document.
+// materialIds maps collada material names into mesh material indexes,
+// jointId resolves skin joint names into object joint ids
+func CommonFromCollada(doc *ColladaDocument, materialIds map[string]int, jointId func(name string) (int, bool)) (*common.Mesh, error) {
+	ci := &colladaImporter{
+		doc:         doc,
+		materialIds: materialIds,
+		jointId:     jointId,
+		sources:     make(map[collada.Id]*collada.Source),
+		vertices:    make(map[collada.Id]*collada.Vertices),
+		geometries:  make(map[collada.Id]*collada.Geometry),
+		controllers: make(map[collada.Id]*ColladaController),
+		materials:   make(map[collada.Id]*collada.Material),
+		nodes:       make(map[string]*collada.Node),
+		axis:        mgl32.Ident4(),
+		mesh:        &common.Mesh{Parts: make([]*common.Part, 0)},
+	}
+	ci.index()
+
+	if doc.Asset != nil {
+		switch doc.Asset.UpAxis {
+		case collada.Zup:
+			// (x, y, z) => (x, z, -y)
+			ci.axis = mgl32.Mat4{1, 0, 0, 0, 0, 0, -1, 0, 0, 1, 0, 0, 0, 0, 0, 1}
+		case collada.Xup:
+			// (x, y, z) => (-y, x, z)
+			ci.axis = mgl32.Mat4{0, 1, 0, 0, -1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+		}
+	}
+
+	var scene *collada.VisualScene
+	for _, lib := range doc.LibraryVisualScenes {
+		for _, vs := range lib.VisualScene {
+			if scene == nil {
+				scene = vs
+			}
+			if doc.Scene != nil && doc.Scene.InstanceVisualScene != nil &&
+				colladaUriId(doc.Scene.InstanceVisualScene.Url) == vs.Id {
+				scene = vs
+			}
+		}
+	}
+	if scene == nil {
+		return nil, fmt.Errorf("Document without visual scene")
+	}
+
+	if err := ci.walk(scene.Node, ci.axis); err != nil {
+		return nil, err
+	}
+	if len(ci.mesh.Parts) == 0 {
+		return nil, fmt.Errorf("Document without geometry")
+	}
+	return ci.mesh, nil
+}
+
+// NewGOW1ps2MeshFromCollada converts collada document into ps2 mesh, using template mesh for unknown fields
+func NewGOW1ps2MeshFromCollada(c *ColladaDocument, materialIds map[string]int,
+	jointId func(name string) (int, bool), template *Mesh) (*Mesh, error) {
+	cm, err := CommonFromCollada(c, materialIds, jointId)
+	if err != nil {
+		return nil, err
+	}
+	return NewGOW1MeshFromCommon(cm, template)
+}
+
+// implemented by obj.Object, mesh package can't import it
+type jointsResolver interface {
+	JointIdByName(name string) (int, bool)
+}
+
+// importResolvers returns material indexes of parent model and joints resolver of parent object
+func importResolvers(wrsrc *wad.WadNodeRsrc) (map[string]int, func(string) (int, bool)) {
+	materialIds := make(map[string]int)
+	jointId := func(string) (int, bool) { return 0, false }
+
+	if wrsrc.Node.Parent == wad.NODE_INVALID {
+		return materialIds, jointId
+	}
+	mdlNode := wrsrc.Wad.GetNodeById(wrsrc.Node.Parent)
+	materialIndex := 0
+	for _, id := range mdlNode.SubGroupNodes {
+		inst, _, err := wrsrc.Wad.GetInstanceFromNode(id)
+		if err != nil {
+			continue
+		}
+		if _, ok := inst.(*file_mat.Material); ok {
+			materialIds[wrsrc.Wad.GetNodeById(id).Tag.Name] = materialIndex
+			materialIndex++
+		}
+	}
+
+	if mdlNode.Parent == wad.NODE_INVALID {
+		return materialIds, jointId
+	}
+	if inst, _, err := wrsrc.Wad.GetInstanceFromNode(mdlNode.Parent); err == nil {
+		if resolver, ok := inst.(jointsResolver); ok {
+			jointId = resolver.JointIdByName
+		}
+	}
+	return materialIds, jointId
+}
+
+// ImportCollada replaces mesh with geometry of collada document, current mesh used as template
+func (m *Mesh) ImportCollada(wrsrc *wad.WadNodeRsrc, colladaReader io.Reader) error {
+	if config.GetGOWVersion() != config.GOW1 {
+		return fmt.Errorf("Only GOW1 meshes supported")
+	}
+
+	doc, err := LoadColladaDocument(colladaReader)
+	if err != nil {
+		return err
+	}
+
+	materialIds, jointId := importResolvers(wrsrc)
+	newMesh, err := NewGOW1ps2MeshFromCollada(doc, materialIds, jointId, m)
+	if err != nil {
+		return err
+	}
+
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: newMesh.MarshalBuffer().Bytes(),
+	})
 }
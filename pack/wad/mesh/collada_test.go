@@ -0,0 +1,122 @@
+package mesh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/mogaika/god_of_war_browser/config"
+)
+
+const testColladaQuad = `<?xml version="1.0" encoding="utf-8"?>
+<COLLADA xmlns="http://www.collada.org/2005/11/COLLADASchema" version="1.4.1">
+  <asset><unit name="meter" meter="1"/><up_axis>Z_UP</up_axis></asset>
+  <library_materials>
+    <material id="stone-material" name="MAT_stone"><instance_effect url="#stone-effect"/></material>
+  </library_materials>
+  <library_geometries>
+    <geometry id="quad-mesh" name="quad">
+      <mesh>
+        <source id="quad-positions">
+          <float_array id="quad-positions-array" count="12">0 0 0  1 0 0
+            1 0 2  0 0 2</float_array>
+          <technique_common><accessor source="#quad-positions-array" count="4" stride="3"/></technique_common>
+        </source>
+        <source id="quad-uvs">
+          <float_array id="quad-uvs-array" count="8">0 0 1 0 1 1 0 1</float_array>
+          <technique_common><accessor source="#quad-uvs-array" count="4" stride="2"/></technique_common>
+        </source>
+        <vertices id="quad-vertices"><input semantic="POSITION" source="#quad-positions"/></vertices>
+        <polylist material="stone-symbol" count="1">
+          <input semantic="VERTEX" source="#quad-vertices" offset="0"/>
+          <input semantic="TEXCOORD" source="#quad-uvs" offset="1" set="0"/>
+          <vcount>4</vcount>
+          <p>0 0 1 1 2 2 3 3</p>
+        </polylist>
+      </mesh>
+    </geometry>
+  </library_geometries>
+  <library_controllers>
+    <controller id="quad-skin">
+      <skin source="#quad-mesh">
+        <bind_shape_matrix>1 0 0 0 0 1 0 0 0 0 1 0 0 0 0 1</bind_shape_matrix>
+        <source id="quad-skin-joints">
+          <Name_array id="quad-skin-joints-array" count="2">root hand</Name_array>
+        </source>
+        <source id="quad-skin-weights">
+          <float_array id="quad-skin-weights-array" count="3">1 0.75 0.25</float_array>
+        </source>
+        <joints><input semantic="JOINT" source="#quad-skin-joints"/></joints>
+        <vertex_weights count="4">
+          <input semantic="JOINT" source="#quad-skin-joints" offset="0"/>
+          <input semantic="WEIGHT" source="#quad-skin-weights" offset="1"/>
+          <vcount>1 1 2 1</vcount>
+          <v>0 0 0 0 1 1 0 2 1 0</v>
+        </vertex_weights>
+      </skin>
+    </controller>
+  </library_controllers>
+  <library_visual_scenes>
+    <visual_scene id="Scene" name="Scene">
+      <node id="root" sid="root" name="root" type="JOINT">
+        <node id="hand" sid="hand" name="hand" type="JOINT"/>
+      </node>
+      <node id="p0_lod0_o0_i0" name="p0_lod0_o0_i0" type="NODE">
+        <instance_controller url="#quad-skin">
+          <skeleton>#root</skeleton>
+          <bind_material><technique_common>
+            <instance_material symbol="stone-symbol" target="#stone-material"/>
+          </technique_common></bind_material>
+        </instance_controller>
+      </node>
+    </visual_scene>
+  </library_visual_scenes>
+  <scene><instance_visual_scene url="#Scene"/></scene>
+</COLLADA>`
+
+func TestColladaImport(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+
+	doc, err := LoadColladaDocument(strings.NewReader(testColladaQuad))
+	if err != nil {
+		t.Fatalf("Failed to load collada: %v", err)
+	}
+
+	joints := map[string]int{"root": 3, "hand": 7}
+	cm, err := CommonFromCollada(doc, map[string]int{"MAT_stone": 2}, func(name string) (int, bool) {
+		id, ok := joints[name]
+		return id, ok
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert collada: %v", err)
+	}
+
+	o := cm.Parts[0].LodGroups[0].Objects[0]
+	if o.MaterialIndex != 2 {
+		t.Errorf("Material index %d, expected 2", o.MaterialIndex)
+	}
+	if len(o.Vertices) != 4 || len(o.Indexes) != 6 {
+		t.Fatalf("Got %d vertices and %d indexes, expected quad", len(o.Vertices), len(o.Indexes))
+	}
+	// z up converted to y up
+	if p := o.Vertices[2].Position; !p.ApproxEqual(mgl32.Vec3{1, 2, 0}) {
+		t.Errorf("Vertex 2 position %v", p)
+	}
+	if uv := o.UVs[0][3]; uv != [2]float32{0, 0} {
+		t.Errorf("Vertex 3 uv %v, expected flipped v", uv)
+	}
+
+	// vertex 2: root 0.25, hand 0.75
+	v := o.Vertices[2]
+	jm := o.JointMaps[0]
+	if jm[v.JointsIndexes[0]] != 7 || jm[v.JointsIndexes[1]] != 3 || v.Weight < 0.74 || v.Weight > 0.76 {
+		t.Errorf("Vertex 2 skin %v %v, joint map %v", v.JointsIndexes, v.Weight, jm)
+	}
+
+	parsed := compileAndParse(t, cm)
+	compareTriangles(t, "compiled", o, parsed.AsCommonMesh().Parts[0].LodGroups[0].Objects[0])
+	if id := parsed.Parts[0].Groups[0].Objects[0].MaterialId; id != 2 {
+		t.Errorf("Compiled material id %d, expected 2", id)
+	}
+}
@@ -25,7 +25,7 @@ type Object struct {
 	Indexes     []int
 	JointMaps   [][]uint32 // one map per instance (each instance has it's own jointsmap)
 
-	MaterialIndex int
+	MaterialIndex int // -1 if unknown
 	PartIndex     int
 	LodGroupIndex int
 	ObjectIndex   int
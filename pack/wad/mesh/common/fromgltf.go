@@ -1,12 +1,502 @@
 package common
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/pkg/errors"
 	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
 )
 
-func FromGLTF(d *gltf.Document) (*Mesh, error) {
-	scene := d.Scenes[*d.Scene]
+// name of meshes produced by mesh exporter
+var gltfObjectNameRegexp = regexp.MustCompile(`p(\d+)_lod(\d+)_o(\d+)_i(\d+)$`)
+
+// ParseObjectName returns indexes encoded into object name by exporter
+func ParseObjectName(name string) (part, lod, object, instance int, ok bool) {
+	match := gltfObjectNameRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return 0, 0, 0, 0, false
+	}
+	part, _ = strconv.Atoi(match[1])
+	lod, _ = strconv.Atoi(match[2])
+	object, _ = strconv.Atoi(match[3])
+	instance, _ = strconv.Atoi(match[4])
+	return part, lod, object, instance, true
+}
+
+type gltfPrimitive struct {
+	doc       *gltf.Document
+	node      *gltf.Node
+	primitive *gltf.Primitive
+	transform mgl32.Mat4
+	jointId   func(name string) (int, bool)
+
+	part, lod, object, instance int
+}
+
+func gltfNodeLocalMatrix(n *gltf.Node) mgl32.Mat4 {
+	if m := n.MatrixOrDefault(); m != gltf.DefaultMatrix {
+		return mgl32.Mat4(m)
+	}
+	t := n.TranslationOrDefault()
+	r := n.RotationOrDefault()
+	s := n.ScaleOrDefault()
+	return mgl32.Translate3D(t[0], t[1], t[2]).
+		Mul4(mgl32.Quat{V: mgl32.Vec3{r[0], r[1], r[2]}, W: r[3]}.Mat4()).
+		Mul4(mgl32.Scale3D(s[0], s[1], s[2]))
+}
+
+func (gp *gltfPrimitive) readVertices(o *Object) error {
+	doc := gp.doc
+	attrs := gp.primitive.Attributes
+
+	posAccessor, ok := attrs["POSITION"]
+	if !ok {
+		return fmt.Errorf("Primitive without POSITION attribute")
+	}
+	positions, err := modeler.ReadPosition(doc, doc.Accessors[posAccessor], nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read positions")
+	}
+
+	// skinned meshes ignore node transform
+	applyTransform := gp.node.Skin == nil
+	o.Vertices = make([]Vertex, len(positions))
+	for i, pos := range positions {
+		p := mgl32.Vec3(pos)
+		if applyTransform {
+			p = mgl32.TransformCoordinate(p, gp.transform)
+		}
+		o.Vertices[i].Position = p
+		o.Vertices[i].Weight = 1.0
+	}
+
+	if gp.primitive.Indices != nil {
+		indices, err := modeler.ReadIndices(doc, doc.Accessors[*gp.primitive.Indices], nil)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read indices")
+		}
+		o.Indexes = make([]int, len(indices))
+		for i, index := range indices {
+			o.Indexes[i] = int(index)
+		}
+	} else {
+		o.Indexes = make([]int, len(positions))
+		for i := range o.Indexes {
+			o.Indexes[i] = i
+		}
+	}
+
+	if normAccessor, ok := attrs["NORMAL"]; ok {
+		normals, err := modeler.ReadNormal(doc, doc.Accessors[normAccessor], nil)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read normals")
+		}
+		normalTransform := gp.transform.Mat3().Inv().Transpose()
+		o.Normals = make([]Normal, len(normals))
+		for i, n := range normals {
+			normal := mgl32.Vec3(n)
+			if applyTransform {
+				normal = normalTransform.Mul3x1(normal)
+			}
+			if normal.Len() > 0.0001 {
+				normal = normal.Normalize()
+			}
+			o.Normals[i] = normal
+		}
+	}
+
+	for iLayer := 0; ; iLayer++ {
+		uvAccessor, ok := attrs[fmt.Sprintf("TEXCOORD_%d", iLayer)]
+		if !ok {
+			break
+		}
+		uvs, err := modeler.ReadTextureCoord(doc, doc.Accessors[uvAccessor], nil)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read uv layer %d", iLayer)
+		}
+		layer := make([]UV, len(uvs))
+		for i, uv := range uvs {
+			layer[i] = UV(uv)
+		}
+		o.UVs = append(o.UVs, layer)
+	}
+
+	o.LayersCount = len(o.UVs)
+	if o.LayersCount == 0 {
+		o.LayersCount = 1
+	}
+
+	return nil
+}
+
+// colors of instance, one array per layer
+func (gp *gltfPrimitive) readColors(layers int) ([][]RGBA, error) {
+	doc := gp.doc
+	result := make([][]RGBA, 0, layers)
+	for iLayer := 0; iLayer < layers; iLayer++ {
+		colorAccessor, ok := gp.primitive.Attributes[fmt.Sprintf("COLOR_%d", iLayer)]
+		if !ok {
+			if iLayer == 0 {
+				return nil, nil
+			}
+			// reuse colors of first layer
+			result = append(result, result[0])
+			continue
+		}
+		colors, err := modeler.ReadColor(doc, doc.Accessors[colorAccessor], nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read colors layer %d", iLayer)
+		}
+		layer := make([]RGBA, len(colors))
+		for i, c := range colors {
+			layer[i] = RGBA{R: c[0], G: c[1], B: c[2], A: c[3]}
+		}
+		result = append(result, layer)
+	}
+	return result, nil
+}
+
+// returns global joints and weights for every vertex
+// result sorted by weight, only two most influencing joints used
+func (gp *gltfPrimitive) readSkin(count int) ([][2]uint32, []float32, error) {
+	doc := gp.doc
+	jointsAccessor, hasJoints := gp.primitive.Attributes["JOINTS_0"]
+	weightsAccessor, hasWeights := gp.primitive.Attributes["WEIGHTS_0"]
+	if !hasJoints || !hasWeights {
+		return nil, nil, nil
+	}
+
+	joints, err := modeler.ReadJoints(doc, doc.Accessors[jointsAccessor], nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to read joints")
+	}
+	weights, err := modeler.ReadWeights(doc, doc.Accessors[weightsAccessor], nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to read weights")
+	}
+	if len(joints) != count || len(weights) != count {
+		return nil, nil, fmt.Errorf("Joints (%d) or weights (%d) count != vertices count (%d)",
+			len(joints), len(weights), count)
+	}
+
+	skinJoints, err := gp.skinJoints()
+	if err != nil {
+		return nil, nil, err
+	}
+	globalJoint := func(iVertex, i int) (uint32, error) {
+		j := joints[iVertex][i]
+		if skinJoints == nil {
+			return uint32(j), nil
+		}
+		if int(j) >= len(skinJoints) {
+			return 0, fmt.Errorf("Vertex %d uses joint %d, skin has only %d joints", iVertex, j, len(skinJoints))
+		}
+		return skinJoints[j], nil
+	}
+
+	resultJoints := make([][2]uint32, count)
+	resultWeights := make([]float32, count)
+	for iVertex := range joints {
+		order := []int{0, 1, 2, 3}
+		sort.SliceStable(order, func(i, j int) bool {
+			return weights[iVertex][order[i]] > weights[iVertex][order[j]]
+		})
+		w0, w1 := weights[iVertex][order[0]], weights[iVertex][order[1]]
+		j0, err := globalJoint(iVertex, order[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		j1, err := globalJoint(iVertex, order[1])
+		if err != nil && w1 > 0 {
+			return nil, nil, err
+		}
+		if w1 <= 0 {
+			j1 = j0
+			w0, w1 = 1, 0
+		}
+		resultJoints[iVertex] = [2]uint32{j0, j1}
+		resultWeights[iVertex] = w0 / (w0 + w1)
+	}
+	return resultJoints, resultWeights, nil
+}
+
+// skinJoints returns joint id for every joint of node skin, resolved by joint node name.
+// Returns nil for primitives without skin, their joints indexes are joint ids (mesh exporter output)
+func (gp *gltfPrimitive) skinJoints() ([]uint32, error) {
+	if gp.node.Skin == nil {
+		return nil, nil
+	}
+	if gp.jointId == nil {
+		return nil, fmt.Errorf("Skinned mesh %q has no object to resolve joints", gp.node.Name)
+	}
+	skin := gp.doc.Skins[*gp.node.Skin]
+	result := make([]uint32, len(skin.Joints))
+	for i, iNode := range skin.Joints {
+		name := gp.doc.Nodes[iNode].Name
+		id, ok := gp.jointId(name)
+		if !ok {
+			return nil, fmt.Errorf("Cannot resolve skin joint %q", name)
+		}
+		result[i] = uint32(id)
+	}
+	return result, nil
+}
+
+// FillJointMap converts global joints into local indexes of joint map.
+// First joint index of vertex limited to 16 entries, second one to 64 entries
+func FillJointMap(o *Object, joints [][2]uint32, weights []float32) error {
+	local := make(map[uint32]uint16)
+	jm := make([]uint32, 0)
+	add := func(joint uint32) {
+		if _, ok := local[joint]; !ok {
+			local[joint] = uint16(len(jm))
+			jm = append(jm, joint)
+		}
+	}
+	// blended vertices require low index for one of joints
+	for i, j := range joints {
+		if j[0] != j[1] && weights[i] < 1 {
+			add(j[0])
+		}
+	}
+	for _, j := range joints {
+		add(j[0])
+		add(j[1])
+	}
+	if len(jm) > 64 {
+		return fmt.Errorf("Object uses too many joints (%d > 64)", len(jm))
+	}
+
+	for i, j := range joints {
+		v := &o.Vertices[i]
+		l0, l1 := local[j[0]], local[j[1]]
+		w := weights[i]
+		if l0 > 15 {
+			if j[0] == j[1] || w >= 1 {
+				// single joint, pass it as second joint with zero weight
+				l0, l1, w = 0, l0, 0
+			} else if l1 <= 15 {
+				l0, l1, w = l1, l0, 1-w
+			} else {
+				return fmt.Errorf("Vertex %d blends joints %d and %d which both not fit into first 16 joint map entries",
+					i, j[0], j[1])
+			}
+		}
+		v.JointsIndexes = [2]uint16{l0, l1}
+		v.Weight = w
+	}
+	o.JointMaps = [][]uint32{jm}
+	return nil
+}
+
+// instanceJointMap builds joint map for instance using global joints of first instance
+// as correspondence between joint map entries and joints of instance
+func instanceJointMap(o *Object, first, joints [][2]uint32) ([]uint32, error) {
+	jm := make([]uint32, len(o.JointMaps[0]))
+	copy(jm, o.JointMaps[0])
+	if joints == nil {
+		return jm, nil
+	}
+	if len(joints) != len(first) {
+		return nil, fmt.Errorf("Instance vertices count %d != %d", len(joints), len(first))
+	}
+	for i, v := range o.Vertices {
+		for _, slot := range v.JointsIndexes {
+			for k := range first[i] {
+				if o.JointMaps[0][slot] == first[i][k] {
+					jm[slot] = joints[i][k]
+					break
+				}
+			}
+		}
+	}
+	return jm, nil
+}
+
+func (gp *gltfPrimitive) toObject() (*Object, [][2]uint32, error) {
+	o := &Object{
+		PartIndex:      gp.part,
+		LodGroupIndex:  gp.lod,
+		ObjectIndex:    gp.object,
+		InstancesCount: 1,
+		MaterialIndex:  -1,
+	}
+	if gp.primitive.Mode != gltf.PrimitiveTriangles {
+		return nil, nil, fmt.Errorf("Only triangles primitives supported")
+	}
+
+	if err := gp.readVertices(o); err != nil {
+		return nil, nil, err
+	}
+
+	joints, weights, err := gp.readSkin(len(o.Vertices))
+	if err != nil {
+		return nil, nil, err
+	}
+	if joints != nil {
+		if err := FillJointMap(o, joints, weights); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	colors, err := gp.readColors(o.LayersCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	if colors != nil {
+		o.BlendColors = colors
+	}
+
+	return o, joints, nil
+}
+
+func (gp *gltfPrimitive) addInstance(o *Object, first [][2]uint32) error {
+	o.InstancesCount++
+
+	if o.JointMaps != nil {
+		joints, _, err := gp.readSkin(len(o.Vertices))
+		if err != nil {
+			return err
+		}
+		jm, err := instanceJointMap(o, first, joints)
+		if err != nil {
+			return err
+		}
+		o.JointMaps = append(o.JointMaps, jm)
+	}
+
+	if o.BlendColors != nil {
+		colors, err := gp.readColors(o.LayersCount)
+		if err != nil {
+			return err
+		}
+		if colors == nil {
+			colors = o.BlendColors[:o.LayersCount]
+		}
+		o.BlendColors = append(o.BlendColors, colors...)
+	}
+	return nil
+}
+
+// FromGLTF reads meshes of default scene.
+// Meshes named like exporter does (p0_lod0_o0_i0) are placed into same part, lod group, object and instance.
+// Other primitives are appended as new objects of first part and lod group.
+// Skin joints resolved to joint ids by node names using jointId,
+// joints indexes of primitives without skin used as joint ids
+func FromGLTF(d *gltf.Document, jointId func(name string) (int, bool)) (*Mesh, error) {
+	if len(d.Scenes) == 0 {
+		return nil, fmt.Errorf("Document without scenes")
+	}
+	scene := d.Scenes[0]
+	if d.Scene != nil {
+		scene = d.Scenes[*d.Scene]
+	}
+
+	primitives := make([]*gltfPrimitive, 0)
+	nextObject := make(map[[2]int]int)
+
+	var walk func(iNode uint32, parent mgl32.Mat4)
+	walk = func(iNode uint32, parent mgl32.Mat4) {
+		node := d.Nodes[iNode]
+		transform := parent.Mul4(gltfNodeLocalMatrix(node))
+
+		if node.Mesh != nil {
+			mesh := d.Meshes[*node.Mesh]
+			part, lod, object, instance, ok := ParseObjectName(mesh.Name)
+			if !ok || len(mesh.Primitives) != 1 {
+				part, lod, object, instance = 0, 0, -1, 0
+			}
+
+			for _, primitive := range mesh.Primitives {
+				gp := &gltfPrimitive{
+					doc: d, node: node, primitive: primitive, transform: transform, jointId: jointId,
+					part: part, lod: lod, object: object, instance: instance,
+				}
+				primitives = append(primitives, gp)
+				if object >= nextObject[[2]int{part, lod}] {
+					nextObject[[2]int{part, lod}] = object + 1
+				}
+			}
+		}
+
+		for _, child := range node.Children {
+			walk(child, transform)
+		}
+	}
+	for _, iNode := range scene.Nodes {
+		walk(iNode, mgl32.Ident4())
+	}
+
+	// assign object index for unnamed primitives
+	for _, gp := range primitives {
+		if gp.object == -1 {
+			key := [2]int{gp.part, gp.lod}
+			gp.object = nextObject[key]
+			nextObject[key]++
+		}
+	}
+
+	sort.SliceStable(primitives, func(i, j int) bool {
+		a, b := primitives[i], primitives[j]
+		if a.part != b.part {
+			return a.part < b.part
+		}
+		if a.lod != b.lod {
+			return a.lod < b.lod
+		}
+		if a.object != b.object {
+			return a.object < b.object
+		}
+		return a.instance < b.instance
+	})
+
+	m := &Mesh{Parts: make([]*Part, 0)}
+	firstJoints := make(map[*Object][][2]uint32)
+	for _, gp := range primitives {
+		for len(m.Parts) <= gp.part {
+			m.Parts = append(m.Parts, &Part{LodGroups: make([]*LodGroup, 0)})
+		}
+		part := m.Parts[gp.part]
+		for len(part.LodGroups) <= gp.lod {
+			part.LodGroups = append(part.LodGroups, &LodGroup{Objects: make([]*Object, 0)})
+		}
+		lod := part.LodGroups[gp.lod]
+		for len(lod.Objects) <= gp.object {
+			lod.Objects = append(lod.Objects, nil)
+		}
+
+		if o := lod.Objects[gp.object]; o != nil {
+			if err := gp.addInstance(o, firstJoints[o]); err != nil {
+				return nil, errors.Wrapf(err, "Failed to read instance %q of object %d", gp.node.Name, gp.object)
+			}
+		} else {
+			o, joints, err := gp.toObject()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to read object %q", gp.node.Name)
+			}
+			firstJoints[o] = joints
+			lod.Objects[gp.object] = o
+		}
+	}
+
+	// drop holes in objects numeration
+	for _, part := range m.Parts {
+		for _, lod := range part.LodGroups {
+			objects := make([]*Object, 0, len(lod.Objects))
+			for _, o := range lod.Objects {
+				if o != nil {
+					o.ObjectIndex = len(objects)
+					objects = append(objects, o)
+				}
+			}
+			lod.Objects = objects
+		}
+	}
 
-	_ = scene
-	return nil, nil
+	return m, nil
 }
@@ -0,0 +1,116 @@
+package dmacompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mogaika/god_of_war_browser/ps2/dma"
+	"github.com/mogaika/god_of_war_browser/ps2/vif"
+)
+
+// DetectLayout reads layout of first packet of first dma program of existing object.
+// objectData is object data including header of headerSize bytes
+// (dma addresses are relative to object start)
+func DetectLayout(objectData []byte, headerSize uint32) (Layout, error) {
+	l := Layout{UV: -1, RGBA: -1, Norm: -1, XYZW: -1}
+
+	if uint32(len(objectData)) < headerSize+0x20 {
+		return l, fmt.Errorf("Object data too small")
+	}
+	tag := dma.NewTag(binary.LittleEndian.Uint64(objectData[headerSize:]))
+	if tag.ID() != dma.DMA_TAG_REF {
+		return l, fmt.Errorf("First dma tag is not REF: %v", tag)
+	}
+	start := tag.Addr()
+	end := start + tag.QWC()*0x10
+	if end > uint32(len(objectData)) {
+		return l, fmt.Errorf("Dma tag %v out of object data", tag)
+	}
+
+	// microprogram address injected into next tag
+	nextTagPos := headerSize + 0x10
+	mscal := vif.NewCode(binary.LittleEndian.Uint32(objectData[nextTagPos+0xc:]))
+	if mscal.Cmd() == vif.VIF_CMD_MSCAL {
+		l.MicroProgram = mscal.Imm()
+	}
+
+	type unpack struct {
+		components, width uint32
+		target            uint16
+		num               int
+	}
+	unpacks := make([]unpack, 0, 8)
+	var meta []byte
+
+	data := objectData[start:end]
+	for pos := uint32(0); pos+4 <= uint32(len(data)); {
+		code := vif.NewCode(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if code.Cmd() > 0x60 {
+			components := uint32((code.Cmd()>>2)&0x3) + 1
+			width := []uint32{32, 16, 8, 4}[code.Cmd()&0x3]
+			size := components * ((width * uint32(code.Num())) / 8)
+			u := unpack{components: components, width: width, target: code.Imm() & 0x3ff, num: int(code.Num())}
+
+			if width == 32 && components == 4 && meta == nil {
+				for _, base := range VUBuffersBases {
+					if u.target == base {
+						meta = data[pos : pos+size]
+					}
+				}
+			}
+			unpacks = append(unpacks, u)
+			pos = ((pos + size + 3) / 4) * 4
+		} else {
+			switch code.Cmd() {
+			case vif.VIF_CMD_STCYCL:
+				if cl := int(code.Imm() & 0xff); cl > l.Stride {
+					l.Stride = cl
+				}
+			case vif.VIF_CMD_STROW:
+				pos += 0x10
+			case vif.VIF_CMD_MSCAL:
+				pos = uint32(len(data))
+			}
+		}
+	}
+
+	if meta == nil || len(meta) < 0x10 {
+		return l, fmt.Errorf("Vertex meta not found")
+	}
+	copy(l.MetaFlags[:], meta[4:12])
+	base := int(unpacks[0].target)
+	for _, b := range VUBuffersBases {
+		if int(b) <= base {
+			base = int(b)
+		}
+	}
+	blocks := len(meta) / 0x10
+
+	for _, u := range unpacks {
+		offset := int(u.target) - base - blocks
+		switch {
+		case u.width == 32 && u.components == 4:
+			if int(u.target) != base {
+				l.Boundaries = uint16(int(u.target) - base)
+			}
+		case u.components == 2:
+			l.UV = offset
+			l.UVWide = u.width == 32
+		case u.width == 8 && u.components == 4:
+			l.RGBA = offset
+		case u.width == 8 && u.components == 3:
+			l.Norm = offset
+		case u.width == 16 && u.components == 4:
+			l.XYZW = offset
+		}
+	}
+
+	if l.Stride == 0 {
+		l.Stride = 1
+	}
+	if l.XYZW < 0 || l.XYZW >= l.Stride || l.Boundaries == 0 {
+		return l, fmt.Errorf("Unsupported layout %+v", l)
+	}
+	return l, nil
+}
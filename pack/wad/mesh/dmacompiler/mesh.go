@@ -1,10 +1,402 @@
 package dmacompiler
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/mogaika/god_of_war_browser/ps2/dma"
+)
+
+const (
+	GSFixedPoint8  = 16.0
+	GSFixedPoint24 = 4096.0
+
+	// size of one of three vu1 buffers used by mesh microprogram
+	VUBufferSize = 0x155
+
+	// vertex meta block limits
+	MaxBlockVertices = 0xff
+	MaxJoint1Index   = 0xf  // block[13] >> 4
+	MaxJoint2Index   = 0x3f // block[12] >> 2
+)
+
+var VUBuffersBases = []uint16{0, 0x155, 0x2ab}
+
 type Vertex struct {
 	X, Y, Z float32
-	Skip    bool
-	BoneId  int
+	Weight  float32   // weight of Joints[0], Joints[1] gets 1.0 - Weight
+	Joints  [2]uint16 // indexes in object joint map
+	Normal  [3]float32
+}
+
+// One program per instance or texture layer.
+// Programs share vertex positions, but have own uvs and colors.
+type Program struct {
+	UVs  [][2]float32 // per source vertex, can be nil
+	RGBA [][4]uint8   // per source vertex, can be nil. Alpha in ps2 format (0x80 = opaque)
+}
+
+// Layout of vu buffer expected by microprogram.
+// Vertex attributes are interleaved (stcycl cl=Stride, wl=1) right after vertex meta blocks
+type Layout struct {
+	// offsets of attributes inside vertex (in qwords), -1 if attribute not used
+	UV, RGBA, Norm, XYZW int
+	Stride               int
+	// use 32 bit signed unpack for uv instead of 16 bit
+	UVWide bool
+	// offset of boundaries sphere from start of vu buffer
+	Boundaries uint16
+	// address of microprogram passed in MSCAL
+	MicroProgram uint16
+	// copied to block[4:12] of every vertex meta block
+	// (matflags count, texture/rgb flags, matflags)
+	MetaFlags [8]byte
+}
+
+func DefaultLayout(hasUV, hasRGBA, hasNorm bool) Layout {
+	l := Layout{UV: -1, RGBA: -1, Norm: -1, Boundaries: 0xf4}
+	// keep order of gs registers: st, rgbaq, xyz
+	if hasUV {
+		l.UV = l.Stride
+		l.Stride++
+	}
+	if hasRGBA {
+		l.RGBA = l.Stride
+		l.Stride++
+	}
+	if hasNorm {
+		l.Norm = l.Stride
+		l.Stride++
+	}
+	l.XYZW = l.Stride
+	l.Stride++
+
+	// matflags: 0x2 - have texture, 0x1 - not gui, 0x5 - end mark
+	if hasUV {
+		l.MetaFlags = [8]byte{0x03, 0, 0x2e, 0x30, 0x12, 0x05}
+	} else {
+		l.MetaFlags = [8]byte{0x02, 0, 0x26, 0x20, 0x51}
+	}
+	return l
+}
+
+type Object struct {
+	Vertices  []Vertex
+	Indexes   []int // triangles list
+	Programs  []Program
+	JointMaps [][]uint32 // one per instance, same length
+	Layout    Layout
 }
 
 type Compiled struct {
+	// dma tags, joint maps and vif streams, placed right after object header
+	Data                  []byte
+	DmaTagsCountPerPacket uint32
+	PacketsCount          int
+	NextFreeVUBufferId    uint16
+	VerticesCount         int // count of unique source vertices used by strips
+}
+
+type compiler struct {
+	o     *Object
+	strip []StripVertex
+}
+
+func (o *Object) validate() error {
+	l := &o.Layout
+	if l.Stride <= 0 || l.XYZW < 0 || l.XYZW >= l.Stride {
+		return fmt.Errorf("Invalid layout stride %d xyzw %d", l.Stride, l.XYZW)
+	}
+	for _, off := range []int{l.UV, l.RGBA, l.Norm} {
+		if off >= l.Stride {
+			return fmt.Errorf("Attribute offset %d out of stride %d", off, l.Stride)
+		}
+	}
+	if l.Boundaries == 0 || l.Boundaries >= VUBufferSize {
+		return fmt.Errorf("Invalid boundaries offset 0x%x", l.Boundaries)
+	}
+	if len(o.Programs) == 0 {
+		return fmt.Errorf("Object without dma programs")
+	}
+	if len(o.JointMaps) == 0 || len(o.Programs)%len(o.JointMaps) != 0 {
+		return fmt.Errorf("Joint maps count %d do not match programs count %d", len(o.JointMaps), len(o.Programs))
+	}
+	for _, jm := range o.JointMaps {
+		if len(jm) != len(o.JointMaps[0]) {
+			return fmt.Errorf("Joint maps have different length")
+		}
+	}
+	for iVertex, v := range o.Vertices {
+		if v.Joints[0] > MaxJoint1Index || v.Joints[1] > MaxJoint2Index {
+			return fmt.Errorf("Vertex %d joints %v out of meta block limits (%d, %d)",
+				iVertex, v.Joints, MaxJoint1Index, MaxJoint2Index)
+		}
+		if int(v.Joints[0]) >= len(o.JointMaps[0]) || int(v.Joints[1]) >= len(o.JointMaps[0]) {
+			return fmt.Errorf("Vertex %d joints %v out of joint map (%d)", iVertex, v.Joints, len(o.JointMaps[0]))
+		}
+		for _, f := range []float32{v.X, v.Y, v.Z} {
+			if f*GSFixedPoint8 > math.MaxInt16 || f*GSFixedPoint8 < math.MinInt16 {
+				return fmt.Errorf("Vertex %d position %v out of fixed point range", iVertex, [3]float32{v.X, v.Y, v.Z})
+			}
+		}
+	}
+	for iProgram, p := range o.Programs {
+		if p.UVs != nil && len(p.UVs) != len(o.Vertices) {
+			return fmt.Errorf("Program %d uvs count %d != vertices count %d", iProgram, len(p.UVs), len(o.Vertices))
+		}
+		if p.RGBA != nil && len(p.RGBA) != len(o.Vertices) {
+			return fmt.Errorf("Program %d colors count %d != vertices count %d", iProgram, len(p.RGBA), len(o.Vertices))
+		}
+	}
+	for _, index := range o.Indexes {
+		if index < 0 || index >= len(o.Vertices) {
+			return fmt.Errorf("Index %d out of vertices count %d", index, len(o.Vertices))
+		}
+	}
+	return nil
+}
+
+func (c *compiler) blocksCount(vs []StripVertex) int {
+	blocks := 0
+	inBlock := 0
+	for i := range vs {
+		if i == 0 || inBlock == MaxBlockVertices ||
+			c.o.Vertices[vs[i].Index].Joints != c.o.Vertices[vs[i-1].Index].Joints {
+			blocks++
+			inBlock = 0
+		}
+		inBlock++
+	}
+	return blocks
+}
+
+func (c *compiler) fits(vs []StripVertex) bool {
+	if len(vs) > 0xff {
+		return false
+	}
+	return c.blocksCount(vs)+len(vs)*c.o.Layout.Stride <= int(c.o.Layout.Boundaries)
+}
+
+func (c *compiler) metaBlocks(vs []StripVertex) []byte {
+	var buf bytes.Buffer
+	var block [0x10]byte
+	flush := func(count int, joints [2]uint16, first bool) {
+		block = [0x10]byte{}
+		block[0] = byte(count)
+		copy(block[4:12], c.o.Layout.MetaFlags[:])
+		block[4] &= 0xf
+		block[5] = 0
+		if first {
+			block[5] = 0x40
+		}
+		block[12] = byte(joints[1] << 2)
+		block[13] = byte(joints[0] << 4)
+		if joints[0] == 0 && joints[1] == 0 {
+			block[15] = 0x80
+		}
+		buf.Write(block[:])
+	}
+
+	start := 0
+	for i := 1; i <= len(vs); i++ {
+		if i == len(vs) || i-start == MaxBlockVertices ||
+			c.o.Vertices[vs[i].Index].Joints != c.o.Vertices[vs[start].Index].Joints {
+			flush(i-start, c.o.Vertices[vs[start].Index].Joints, start == 0)
+			start = i
+		}
+	}
+	b := buf.Bytes()
+	b[len(b)-0x10+1] = 0x80 // last block
+	return b
+}
+
+func boundaries(o *Object, vs []StripVertex) [4]float32 {
+	min := [3]float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	max := [3]float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for _, sv := range vs {
+		v := &o.Vertices[sv.Index]
+		for i, f := range [3]float32{v.X, v.Y, v.Z} {
+			if f < min[i] {
+				min[i] = f
+			}
+			if f > max[i] {
+				max[i] = f
+			}
+		}
+	}
+	var result [4]float32
+	for i := range min {
+		result[i] = (min[i] + max[i]) / 2
+	}
+	for _, sv := range vs {
+		v := &o.Vertices[sv.Index]
+		dx, dy, dz := v.X-result[0], v.Y-result[1], v.Z-result[2]
+		if r := float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz))); r > result[3] {
+			result[3] = r
+		}
+	}
+	return result
+}
+
+func (c *compiler) packetVif(p *Program, vs []StripVertex, buffer int) []byte {
+	l := &c.o.Layout
+	var buf bytes.Buffer
+	base := VUBuffersBases[buffer]
+	meta := c.metaBlocks(vs)
+	blocks := uint16(len(meta) / 0x10)
+	n := len(vs)
+
+	writeVif(&buf, vifStcycl(uint8(l.Stride), 1))
+	if l.UV >= 0 {
+		target := base + blocks + uint16(l.UV)
+		if l.UVWide {
+			writeUnpack(&buf, VIF_UNPACK_V2_32, n, target, true)
+		} else {
+			writeUnpack(&buf, VIF_UNPACK_V2_16, n, target, true)
+		}
+		for _, sv := range vs {
+			var uv [2]float32
+			if p.UVs != nil {
+				uv = p.UVs[sv.Index]
+			}
+			if l.UVWide {
+				binary.Write(&buf, binary.LittleEndian, [2]int32{
+					int32(math.Round(float64(uv[0] * GSFixedPoint24))),
+					int32(math.Round(float64(uv[1] * GSFixedPoint24)))})
+			} else {
+				binary.Write(&buf, binary.LittleEndian, [2]int16{
+					int16(math.Round(float64(uv[0] * GSFixedPoint24))),
+					int16(math.Round(float64(uv[1] * GSFixedPoint24)))})
+			}
+		}
+	}
+	if l.RGBA >= 0 {
+		writeUnpack(&buf, VIF_UNPACK_V4_8, n, base+blocks+uint16(l.RGBA), false)
+		for _, sv := range vs {
+			rgba := [4]uint8{0x80, 0x80, 0x80, 0x80}
+			if p.RGBA != nil {
+				rgba = p.RGBA[sv.Index]
+			}
+			buf.Write(rgba[:])
+		}
+	}
+	if l.Norm >= 0 {
+		writeUnpack(&buf, VIF_UNPACK_V3_8, n, base+blocks+uint16(l.Norm), true)
+		for _, sv := range vs {
+			norm := c.o.Vertices[sv.Index].Normal
+			for _, f := range norm {
+				buf.WriteByte(byte(int8(math.Round(float64(clamp(f, -1, 1) * 127)))))
+			}
+		}
+		alignBuffer(&buf, 4)
+	}
+
+	writeUnpack(&buf, VIF_UNPACK_V4_16, n, base+blocks+uint16(l.XYZW), true)
+	for _, sv := range vs {
+		v := &c.o.Vertices[sv.Index]
+		flags := uint16(math.Round(float64(clamp(v.Weight, 0, 1) * GSFixedPoint24)))
+		if sv.Skip {
+			flags |= 0x8000
+		}
+		binary.Write(&buf, binary.LittleEndian, [4]uint16{
+			uint16(int16(math.Round(float64(v.X * GSFixedPoint8)))),
+			uint16(int16(math.Round(float64(v.Y * GSFixedPoint8)))),
+			uint16(int16(math.Round(float64(v.Z * GSFixedPoint8)))),
+			flags})
+	}
+
+	writeVif(&buf, vifStcycl(1, 1))
+	writeUnpack(&buf, VIF_UNPACK_V4_32, int(blocks), base, true)
+	buf.Write(meta)
+	writeUnpack(&buf, VIF_UNPACK_V4_32, 1, base+l.Boundaries, true)
+	for _, f := range boundaries(c.o, vs) {
+		binary.Write(&buf, binary.LittleEndian, math.Float32bits(f))
+	}
+
+	alignBuffer(&buf, 0x10)
+	return buf.Bytes()
+}
+
+// Compile converts object into dma programs with vif streams, compatible with
+// ps2 mesh microprogram. Result addresses are relative to object start,
+// headerSize is size of object header before compiled data
+func Compile(o *Object, headerSize uint32) (*Compiled, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	if !o.Layout.UVWide {
+		// 16 bit fixed point can hold only [-8;8) range
+		for _, p := range o.Programs {
+			for _, uv := range p.UVs {
+				if uv[0]*GSFixedPoint24 >= math.MaxInt16 || uv[0]*GSFixedPoint24 < math.MinInt16 ||
+					uv[1]*GSFixedPoint24 >= math.MaxInt16 || uv[1]*GSFixedPoint24 < math.MinInt16 {
+					o.Layout.UVWide = true
+				}
+			}
+		}
+	}
+
+	c := &compiler{o: o, strip: Stripify(o.Indexes)}
+	if len(c.strip) == 0 {
+		return nil, fmt.Errorf("Object have no triangles")
+	}
+
+	packets, err := c.splitPackets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Compiled{
+		DmaTagsCountPerPacket: uint32(len(packets) + 1),
+		PacketsCount:          len(packets),
+		NextFreeVUBufferId:    uint16(len(packets) % len(VUBuffersBases)),
+	}
+
+	used := make(map[int]struct{})
+	for _, sv := range c.strip {
+		used[sv.Index] = struct{}{}
+	}
+	result.VerticesCount = len(used)
+
+	tagsSize := uint32(len(o.Programs)) * result.DmaTagsCountPerPacket * 0x10
+	jointMapsSize := uint32(len(o.JointMaps) * len(o.JointMaps[0]) * 4)
+	dataOffset := tagsSize + ((jointMapsSize+0xf)/0x10)*0x10
+
+	var tags, data bytes.Buffer
+	for iProgram := range o.Programs {
+		for iPacket, packet := range packets {
+			vifData := c.packetVif(&o.Programs[iProgram], packet, iPacket%len(VUBuffersBases))
+			addr := headerSize + dataOffset + uint32(data.Len())
+			data.Write(vifData)
+
+			// flush previous packet before unpacking next one
+			inject := [2]uint32{0, 0}
+			if iPacket != 0 {
+				inject[1] = vifMscal(o.Layout.MicroProgram)
+			}
+			writeDmaTag(&tags, dma.DMA_TAG_REF, uint32(len(vifData)/0x10), addr, inject)
+		}
+		writeDmaTag(&tags, dma.DMA_TAG_RET, 0, 0, [2]uint32{0, vifMscal(o.Layout.MicroProgram)})
+	}
+
+	for _, jm := range o.JointMaps {
+		binary.Write(&tags, binary.LittleEndian, jm)
+	}
+	alignBuffer(&tags, 0x10)
+	result.Data = append(tags.Bytes(), data.Bytes()...)
+
+	return result, nil
+}
+
+func clamp(f, min, max float32) float32 {
+	if f < min {
+		return min
+	}
+	if f > max {
+		return max
+	}
+	return f
 }
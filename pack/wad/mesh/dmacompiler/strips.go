@@ -0,0 +1,112 @@
+package dmacompiler
+
+import "fmt"
+
+// Vertex of triangle strip. Skip means "do not emit triangle on this vertex"
+type StripVertex struct {
+	Index int
+	Skip  bool
+}
+
+// Stripify converts triangle list into strips with skip flags, in same order as triangles provided.
+// Triangles produced by strip (i-2, i-1, i) are continued without restarting strip,
+// so indexes exported from ps2 mesh converted back into same vertex sequence.
+func Stripify(indexes []int) []StripVertex {
+	result := make([]StripVertex, 0, len(indexes))
+
+	for i := 0; i+2 < len(indexes); i += 3 {
+		tria := [3]int{indexes[i], indexes[i+1], indexes[i+2]}
+		l := len(result)
+
+		continued := false
+		if l >= 2 {
+			a, b := result[l-2].Index, result[l-1].Index
+			// prefer exact continuation, then any rotation sharing last edge
+			for rot := 0; rot < 3 && !continued; rot++ {
+				t := [3]int{tria[rot], tria[(rot+1)%3], tria[(rot+2)%3]}
+				if t[0] == a && t[1] == b {
+					result = append(result, StripVertex{Index: t[2]})
+					continued = true
+				}
+			}
+			for rot := 0; rot < 3 && !continued; rot++ {
+				t := [3]int{tria[rot], tria[(rot+1)%3], tria[(rot+2)%3]}
+				if t[1] == a && t[0] == b {
+					result = append(result, StripVertex{Index: t[2]})
+					continued = true
+				}
+			}
+			if !continued && tria[0] == b {
+				result = append(result,
+					StripVertex{Index: tria[1], Skip: true},
+					StripVertex{Index: tria[2]})
+				continued = true
+			}
+		}
+		if !continued {
+			result = append(result,
+				StripVertex{Index: tria[0], Skip: true},
+				StripVertex{Index: tria[1], Skip: true},
+				StripVertex{Index: tria[2]})
+		}
+	}
+
+	return result
+}
+
+// vertex can start new packet only if it restarts strip
+func isStripRestart(strip []StripVertex, i int) bool {
+	return i+1 < len(strip) && strip[i].Skip && strip[i+1].Skip
+}
+
+// splitPackets splits strip into packets which fit vu buffer.
+// Packets are cut on strip restarts when possible, otherwise long strip continued
+// in next packet with two duplicated skipped vertices.
+func (c *compiler) splitPackets() ([][]StripVertex, error) {
+	strip := c.strip
+	packets := make([][]StripVertex, 0)
+
+	for start := 0; start < len(strip); {
+		end := start
+		for end < len(strip) && c.fits(strip[start:end+1]) {
+			end++
+		}
+		if end == len(strip) {
+			packets = append(packets, strip[start:end])
+			break
+		}
+		if end-start < 4 {
+			return nil, fmt.Errorf("Vu buffer too small for packet (stride %d)", c.o.Layout.Stride)
+		}
+
+		cut := -1
+		for i := end; i > start; i-- {
+			if isStripRestart(strip, i) {
+				cut = i
+				break
+			}
+		}
+		if cut != -1 {
+			packets = append(packets, strip[start:cut])
+			start = cut
+			continue
+		}
+
+		// keep triangles winding order by cutting at even distance from strip start
+		cut = end
+		if (cut-start)%2 != 0 {
+			cut--
+		}
+		packets = append(packets, strip[start:cut])
+
+		next := make([]StripVertex, 0, len(strip)-cut+2)
+		next = append(next,
+			StripVertex{Index: strip[cut-2].Index, Skip: true},
+			StripVertex{Index: strip[cut-1].Index, Skip: true})
+		next = append(next, strip[cut:]...)
+		strip = next
+		start = 0
+	}
+
+	return packets, nil
+}
@@ -0,0 +1,55 @@
+package dmacompiler
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/mogaika/god_of_war_browser/ps2/vif"
+)
+
+// unpack commands (0x60 | components-1 << 2 | width)
+const (
+	VIF_UNPACK_V2_32 = 0x64
+	VIF_UNPACK_V2_16 = 0x65
+	VIF_UNPACK_V3_8  = 0x6a
+	VIF_UNPACK_V4_32 = 0x6c
+	VIF_UNPACK_V4_16 = 0x6d
+	VIF_UNPACK_V4_8  = 0x6e
+)
+
+func vifCode(cmd uint8, num uint8, imm uint16) uint32 {
+	return uint32(cmd)<<24 | uint32(num)<<16 | uint32(imm)
+}
+
+func vifStcycl(cl, wl uint8) uint32 {
+	return vifCode(vif.VIF_CMD_STCYCL, 0, uint16(wl)<<8|uint16(cl))
+}
+
+func vifMscal(addr uint16) uint32 {
+	return vifCode(vif.VIF_CMD_MSCAL, 0, addr)
+}
+
+func writeVif(buf *bytes.Buffer, code uint32) {
+	binary.Write(buf, binary.LittleEndian, code)
+}
+
+// same flags as original game data: tops addressing enabled
+func writeUnpack(buf *bytes.Buffer, cmd uint8, num int, target uint16, signed bool) {
+	imm := (target & 0x3ff) | 0x8000
+	if !signed {
+		imm |= 0x4000
+	}
+	writeVif(buf, vifCode(cmd, uint8(num), imm))
+}
+
+func writeDmaTag(buf *bytes.Buffer, id uint8, qwc uint32, addr uint32, inject [2]uint32) {
+	tag := uint64(qwc&0xffff) | uint64(id&0x7)<<28 | uint64(addr&0x7fffffff)<<32
+	binary.Write(buf, binary.LittleEndian, tag)
+	binary.Write(buf, binary.LittleEndian, inject)
+}
+
+func alignBuffer(buf *bytes.Buffer, align int) {
+	if pad := buf.Len() % align; pad != 0 {
+		buf.Write(make([]byte, align-pad))
+	}
+}
@@ -113,7 +113,12 @@ func (m *Mesh) ExportGLTF(wrsrc *wad.WadNodeRsrc, gltfCacher *gltfutils.GLTFCach
 					}
 					if colorAccessors != nil {
 						for iLayer := 0; iLayer < object.LayersCount; iLayer++ {
-							attributes[fmt.Sprintf("COLOR_%d", iLayer)] = colorAccessors[iLayer]
+							// colors stored per dma program (instance and layer)
+							iColors := iInstance*object.LayersCount + iLayer
+							if iColors >= len(colorAccessors) {
+								iColors = iLayer
+							}
+							attributes[fmt.Sprintf("COLOR_%d", iLayer)] = colorAccessors[iColors]
 						}
 					}
 
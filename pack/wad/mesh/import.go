@@ -0,0 +1,225 @@
+package mesh
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/qmuntal/gltf"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/pack/wad/mesh/common"
+	"github.com/mogaika/god_of_war_browser/pack/wad/mesh/dmacompiler"
+)
+
+// value used by game for last lod group
+const DefaultHideDistance = -34359738368.0
+
+// returns template element for index, last one if index out of range
+func templateIndex(index, count int) int {
+	if index >= count {
+		return count - 1
+	}
+	return index
+}
+
+// firstTemplateObject returns any object of mesh, used when part or group have no objects
+func (m *Mesh) firstTemplateObject() *Object {
+	for iPart := range m.Parts {
+		for iGroup := range m.Parts[iPart].Groups {
+			if objects := m.Parts[iPart].Groups[iGroup].Objects; len(objects) != 0 {
+				return &objects[0]
+			}
+		}
+	}
+	return nil
+}
+
+// importLayout uses vu layout of template object when it fits attributes of imported object
+func importLayout(template *Object, hasUV, hasRGBA, hasNorm bool) dmacompiler.Layout {
+	l, err := dmacompiler.DetectLayout(template.Marshal().Bytes(), OBJECT_GOW1_HEADER_SIZE)
+	if err == nil && (!hasUV || l.UV >= 0) {
+		return l
+	}
+	def := dmacompiler.DefaultLayout(hasUV, hasRGBA, hasNorm)
+	def.MicroProgram = l.MicroProgram
+	return def
+}
+
+func objectFromCommon(co *common.Object, template *Object) (*Object, error) {
+	if co.InstancesCount > 1 && co.LayersCount > 1 {
+		return nil, fmt.Errorf("Object can't have instances (%d) and layers (%d) at same time",
+			co.InstancesCount, co.LayersCount)
+	}
+
+	do := &dmacompiler.Object{
+		Vertices:  make([]dmacompiler.Vertex, len(co.Vertices)),
+		Indexes:   co.Indexes,
+		Programs:  make([]dmacompiler.Program, co.InstancesCount*co.LayersCount),
+		JointMaps: co.JointMaps,
+	}
+	for i, v := range co.Vertices {
+		dv := &do.Vertices[i]
+		dv.X, dv.Y, dv.Z = v.Position[0], v.Position[1], v.Position[2]
+		dv.Weight = v.Weight
+		dv.Joints = v.JointsIndexes
+		if co.Normals != nil {
+			dv.Normal = co.Normals[i]
+		}
+	}
+
+	if do.JointMaps == nil {
+		// not skinned, attach to same joint as template
+		joint := uint32(0)
+		if len(template.JointMappers) != 0 && len(template.JointMappers[0]) != 0 {
+			joint = template.JointMappers[0][0]
+		}
+		do.JointMaps = make([][]uint32, co.InstancesCount)
+		for i := range do.JointMaps {
+			do.JointMaps[i] = []uint32{joint}
+		}
+		for i := range do.Vertices {
+			do.Vertices[i].Joints = [2]uint16{0, 0}
+			do.Vertices[i].Weight = 1
+		}
+	}
+
+	for iProgram := range do.Programs {
+		p := &do.Programs[iProgram]
+		if iLayer := iProgram % co.LayersCount; iLayer < len(co.UVs) {
+			p.UVs = make([][2]float32, len(co.UVs[iLayer]))
+			for i, uv := range co.UVs[iLayer] {
+				p.UVs[i] = uv
+			}
+		}
+		if iProgram < len(co.BlendColors) {
+			p.RGBA = make([][4]uint8, len(co.BlendColors[iProgram]))
+			for i, c := range co.BlendColors[iProgram] {
+				// ps2 alpha range is [0;0x80]
+				p.RGBA[i] = [4]uint8{c.R, c.G, c.B, uint8(math.Round(float64(c.A) * 128.0 / 255.0))}
+			}
+		}
+	}
+
+	do.Layout = importLayout(template, co.UVs != nil, co.BlendColors != nil, co.Normals != nil)
+
+	compiled, err := dmacompiler.Compile(do, OBJECT_GOW1_HEADER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	materialId := template.MaterialId
+	if co.MaterialIndex >= 0 {
+		materialId = uint16(co.MaterialIndex)
+	}
+
+	return &Object{
+		Type:                  template.Type,
+		DmaTagsCountPerPacket: compiled.DmaTagsCountPerPacket,
+		MaterialId:            materialId,
+		JointMapElementsCount: uint16(len(do.JointMaps[0])),
+		JointMappers:          do.JointMaps,
+		InstancesCount:        uint32(co.InstancesCount),
+		Flags:                 template.Flags,
+		FlagsMask:             template.FlagsMask,
+		TextureLayersCount:    uint8(co.LayersCount),
+		TotalDmaProgramsCount: uint8(len(do.Programs)),
+		NextFreeVUBufferId:    compiled.NextFreeVUBufferId,
+		Unk1c:                 uint16(compiled.PacketsCount),
+		SourceVerticesCount:   uint16(compiled.VerticesCount),
+		RawDmaAndJointsData:   compiled.Data,
+	}, nil
+}
+
+// NewGOW1MeshFromCommon builds ps2 mesh from common mesh.
+// Unknown fields, materials and vu layouts taken from template mesh
+// (part, group or object with same index, or last one)
+func NewGOW1MeshFromCommon(cm *common.Mesh, template *Mesh) (*Mesh, error) {
+	anyTemplateObject := template.firstTemplateObject()
+	if anyTemplateObject == nil {
+		return nil, fmt.Errorf("Template mesh without objects")
+	}
+
+	m := &Mesh{
+		Parts:           make([]Part, len(cm.Parts)),
+		Vectors:         template.Vectors,
+		Unk0c:           template.Unk0c,
+		Unk10:           template.Unk10,
+		Unk14:           template.Unk14,
+		Flags0x20:       template.Flags0x20,
+		NameOfRootJoint: template.NameOfRootJoint,
+		Unk28:           template.Unk28,
+		Unk2c:           template.Unk2c,
+		Unk30:           template.Unk30,
+		BaseBoneIndex:   template.BaseBoneIndex,
+	}
+
+	for iPart, cPart := range cm.Parts {
+		part := &m.Parts[iPart]
+		var tPart *Part
+		if len(template.Parts) != 0 {
+			tPart = &template.Parts[templateIndex(iPart, len(template.Parts))]
+			part.Unk00 = tPart.Unk00
+			part.JointId = tPart.JointId
+		}
+
+		part.Groups = make([]Group, len(cPart.LodGroups))
+		for iGroup, cGroup := range cPart.LodGroups {
+			group := &part.Groups[iGroup]
+			group.HideDistance = cGroup.HideDistance
+			if group.HideDistance == 0 {
+				group.HideDistance = DefaultHideDistance
+			}
+
+			var tGroup *Group
+			if tPart != nil && len(tPart.Groups) != 0 {
+				tGroup = &tPart.Groups[templateIndex(iGroup, len(tPart.Groups))]
+			}
+
+			group.Objects = make([]Object, len(cGroup.Objects))
+			for iObject, cObject := range cGroup.Objects {
+				tObject := anyTemplateObject
+				if tGroup != nil && len(tGroup.Objects) != 0 {
+					tObject = &tGroup.Objects[templateIndex(iObject, len(tGroup.Objects))]
+				}
+
+				object, err := objectFromCommon(cObject, tObject)
+				if err != nil {
+					return nil, errors.Wrapf(err, "Failed to convert part %d group %d object %d", iPart, iGroup, iObject)
+				}
+				group.Objects[iObject] = *object
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// ImportGLTF replaces mesh with meshes from gltf document, current mesh used as template
+func (m *Mesh) ImportGLTF(wrsrc *wad.WadNodeRsrc, gltfReader io.Reader) error {
+	if config.GetGOWVersion() != config.GOW1 {
+		return fmt.Errorf("Only GOW1 meshes supported")
+	}
+
+	doc := &gltf.Document{}
+	if err := gltf.NewDecoder(gltfReader).Decode(doc); err != nil {
+		return errors.Wrapf(err, "Failed to read gltf")
+	}
+
+	_, jointId := importResolvers(wrsrc)
+	cm, err := common.FromGLTF(doc, jointId)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read meshes")
+	}
+
+	newMesh, err := NewGOW1MeshFromCommon(cm, m)
+	if err != nil {
+		return err
+	}
+
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: newMesh.MarshalBuffer().Bytes(),
+	})
+}
@@ -0,0 +1,191 @@
+package mesh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	"github.com/mogaika/god_of_war_browser/pack/wad/mesh/common"
+)
+
+// grid of quads big enough to be split into several packets
+func testGridObject(size int) *common.Object {
+	o := &common.Object{
+		InstancesCount: 1,
+		LayersCount:    1,
+		JointMaps:      [][]uint32{{10, 11, 12, 13, 30, 31, 32}},
+		UVs:            [][]common.UV{make([]common.UV, 0)},
+		BlendColors:    [][]common.RGBA{make([]common.RGBA, 0)},
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			o.Vertices = append(o.Vertices, common.Vertex{
+				Position:      common.Position{float32(x) - 5, float32(y) * 0.5, float32(x*y) / 16},
+				Weight:        []float32{1, 0.75, 0.5, 0.25}[(x/3)%4],
+				JointsIndexes: [2]uint16{uint16(y % 4), uint16(4 + x%3)},
+			})
+			o.UVs[0] = append(o.UVs[0], common.UV{float32(x) / 4, float32(y) / 8})
+			o.BlendColors[0] = append(o.BlendColors[0], common.RGBA{
+				R: uint8(x * 8), G: uint8(y * 8), B: 0x80, A: []uint8{0, 0xff}[(x+y)%2]})
+		}
+	}
+	for y := 0; y+1 < size; y++ {
+		for x := 0; x+1 < size; x++ {
+			i := y*size + x
+			o.Indexes = append(o.Indexes, i, i+1, i+size, i+1, i+size+1, i+size)
+		}
+	}
+	return o
+}
+
+// triangleKeys returns description of every triangle, independent of vertex order and local joint indexes
+func triangleKeys(o *common.Object, instance int) []string {
+	vertexKey := func(i int) string {
+		v := &o.Vertices[i]
+		weights := make(map[uint32]float32)
+		jm := o.JointMaps[instance]
+		weights[jm[v.JointsIndexes[0]]] += v.Weight
+		weights[jm[v.JointsIndexes[1]]] += 1 - v.Weight
+		joints := make([]string, 0, 2)
+		for joint, weight := range weights {
+			if weight > 0.001 {
+				joints = append(joints, fmt.Sprintf("%d:%.2f", joint, weight))
+			}
+		}
+		sort.Strings(joints)
+
+		key := fmt.Sprintf("%.3f,%.3f,%.3f %v", v.Position[0], v.Position[1], v.Position[2], joints)
+		if o.UVs != nil {
+			uv := o.UVs[0][i]
+			key += fmt.Sprintf(" uv %.4f,%.4f", uv[0], uv[1])
+		}
+		// parsed objects without colors have empty color layers
+		if iColors := instance * o.LayersCount; iColors < len(o.BlendColors) && len(o.BlendColors[iColors]) != 0 {
+			c := o.BlendColors[iColors][i]
+			key += fmt.Sprintf(" rgba %d,%d,%d,%d", c.R, c.G, c.B, c.A)
+		}
+		return key
+	}
+
+	result := make([]string, 0, len(o.Indexes)/3)
+	for i := 0; i+2 < len(o.Indexes); i += 3 {
+		keys := []string{vertexKey(o.Indexes[i]), vertexKey(o.Indexes[i+1]), vertexKey(o.Indexes[i+2])}
+		sort.Strings(keys)
+		result = append(result, strings.Join(keys, " | "))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func compareTriangles(t *testing.T, stage string, expected, got *common.Object) {
+	e, g := triangleKeys(expected, 0), triangleKeys(got, 0)
+	if len(e) != len(g) {
+		t.Fatalf("%s: triangles count %d, expected %d", stage, len(g), len(e))
+	}
+	for i := range e {
+		if e[i] != g[i] {
+			t.Fatalf("%s: triangle %d mismatch:\n got      %s\n expected %s", stage, i, g[i], e[i])
+		}
+	}
+}
+
+func compileAndParse(t *testing.T, cm *common.Mesh) *Mesh {
+	template := &Mesh{
+		Parts: []Part{{Groups: []Group{{Objects: []Object{{Type: 0xe, Flags: 0x10}}}}}},
+	}
+	m, err := NewGOW1MeshFromCommon(cm, template)
+	if err != nil {
+		t.Fatalf("Failed to build mesh: %v", err)
+	}
+	parsed, err := NewFromData(m.MarshalBuffer().Bytes(), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse compiled mesh: %v", err)
+	}
+	return parsed
+}
+
+func TestGLTFImportRoundTrip(t *testing.T) {
+	config.SetGOWVersion(config.GOW1)
+
+	source := testGridObject(16)
+	cm := &common.Mesh{Parts: []*common.Part{{LodGroups: []*common.LodGroup{{
+		Objects: []*common.Object{source}}}}}}
+
+	parsed := compileAndParse(t, cm)
+	if packets := len(parsed.Parts[0].Groups[0].Objects[0].Packets[0]); packets < 2 {
+		t.Errorf("Expected object split into several packets, got %d", packets)
+	}
+	compareTriangles(t, "compiled", source, parsed.AsCommonMesh().Parts[0].LodGroups[0].Objects[0])
+
+	tag := &wad.Tag{Name: "MESH_test"}
+	wrsrc := &wad.WadNodeRsrc{Tag: tag, Node: &wad.Node{Tag: tag}}
+	doc, err := parsed.ExportGLTFDefault(wrsrc)
+	if err != nil {
+		t.Fatalf("Failed to export gltf: %v", err)
+	}
+
+	imported, err := common.FromGLTF(doc, nil)
+	if err != nil {
+		t.Fatalf("Failed to import gltf: %v", err)
+	}
+	compareTriangles(t, "imported", source, imported.Parts[0].LodGroups[0].Objects[0])
+
+	reparsed := compileAndParse(t, imported)
+	compareTriangles(t, "recompiled", source, reparsed.AsCommonMesh().Parts[0].LodGroups[0].Objects[0])
+}
+
+// skinned triangle with skin joints order not matching joint ids, as other tools export it
+func testSkinnedGLTF() *gltf.Document {
+	doc := gltf.NewDocument()
+	attributes := map[string]uint32{
+		"POSITION":  modeler.WritePosition(doc, [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}),
+		"JOINTS_0":  modeler.WriteJoints(doc, [][4]uint8{{0, 0, 0, 0}, {1, 0, 0, 0}, {0, 1, 0, 0}}),
+		"WEIGHTS_0": modeler.WriteWeights(doc, [][4]float32{{1, 0, 0, 0}, {1, 0, 0, 0}, {0.5, 0.5, 0, 0}}),
+	}
+	doc.Meshes = []*gltf.Mesh{{Name: "arm", Primitives: []*gltf.Primitive{{Attributes: attributes}}}}
+	doc.Nodes = []*gltf.Node{
+		{Name: "arm", Mesh: gltf.Index(0), Skin: gltf.Index(0)},
+		{Name: "Hand"},
+		{Name: "Shoulder"},
+	}
+	doc.Skins = []*gltf.Skin{{Joints: []uint32{1, 2}}}
+	doc.Scenes[0].Nodes = []uint32{0, 1, 2}
+	return doc
+}
+
+func TestGLTFImportSkinJoints(t *testing.T) {
+	joints := map[string]int{"Shoulder": 3, "Hand": 7}
+	jointId := func(name string) (int, bool) {
+		id, ok := joints[name]
+		return id, ok
+	}
+
+	imported, err := common.FromGLTF(testSkinnedGLTF(), jointId)
+	if err != nil {
+		t.Fatalf("Failed to import gltf: %v", err)
+	}
+	o := imported.Parts[0].LodGroups[0].Objects[0]
+	jm := o.JointMaps[0]
+	for i, expected := range []uint32{7, 3, 7} {
+		if joint := jm[o.Vertices[i].JointsIndexes[0]]; joint != expected {
+			t.Errorf("Vertex %d bound to joint %d, expected %d", i, joint, expected)
+		}
+	}
+	if v := o.Vertices[2]; jm[v.JointsIndexes[1]] == jm[v.JointsIndexes[0]] || v.Weight != 0.5 {
+		t.Errorf("Vertex 2 blend lost: %+v", v)
+	}
+
+	delete(joints, "Hand")
+	if _, err := common.FromGLTF(testSkinnedGLTF(), jointId); err == nil {
+		t.Errorf("Unresolved skin joint accepted")
+	}
+	if _, err := common.FromGLTF(testSkinnedGLTF(), nil); err == nil {
+		t.Errorf("Skinned mesh imported without joints resolver")
+	}
+}
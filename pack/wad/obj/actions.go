@@ -1,6 +1,7 @@
 package obj
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 
@@ -11,11 +12,13 @@ import (
 )
 
 func (obj *Object) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.Request, action string) {
+	// gltf always contained skinning animations, fbx gets them only on request
+	animations := r.URL.Query().Get("animations")
 	switch action {
 	case "gltf":
 		webutils.WriteFileHeaders(w, wrsrc.Tag.Name+".glb")
 
-		if doc, err := obj.ExportGLTFDefault(wrsrc); err != nil {
+		if doc, err := obj.ExportGLTFDefault(wrsrc, animations != "0"); err != nil {
 			log.Printf("Error when exporting object as gltf: %v", err)
 		} else {
 			if err := gltfutils.ExportBinary(w, doc); err != nil {
@@ -24,11 +27,19 @@ func (obj *Object) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *
 		}
 	case "fbx":
 		webutils.WriteFileHeaders(w, wrsrc.Tag.Name+".fbx")
-		if err := obj.ExportFbxDefault(wrsrc).Write(w); err != nil {
+		if err := obj.ExportFbxDefault(wrsrc, animations != "" && animations != "0").Write(w); err != nil {
 			log.Printf("Error when exporting object as fbx: %v", err)
 		}
 	case "import":
-		panic("TODO")
-
+		gltfReader, _, err := r.FormFile("model")
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		defer gltfReader.Close()
+		if err := obj.ImportGLTF(wrsrc, gltfReader); err != nil {
+			log.Printf("[obj] Error importing skeleton: %v", err)
+			fmt.Fprintln(w, "skeleton import error:", err)
+		}
 	}
 }
@@ -2,6 +2,7 @@ package obj
 
 import (
 	"log"
+	"math"
 	"path/filepath"
 
 	"github.com/mogaika/god_of_war_browser/utils"
@@ -12,6 +13,7 @@ import (
 	"github.com/mogaika/fbx/builders/bfbx73"
 
 	"github.com/mogaika/god_of_war_browser/pack/wad"
+	file_anm "github.com/mogaika/god_of_war_browser/pack/wad/anm"
 	file_mdl "github.com/mogaika/god_of_war_browser/pack/wad/mdl"
 	file_mesh "github.com/mogaika/god_of_war_browser/pack/wad/mesh"
 	"github.com/mogaika/god_of_war_browser/utils/fbxbuilder"
@@ -276,6 +278,10 @@ func (o *Object) ExportFbx(wrsrc *wad.WadNodeRsrc, f *fbxbuilder.FBXBuilder) *Fb
 						}
 					}
 				}
+			case *file_anm.Animations:
+				if f.ExportAnimations {
+					fe.AddAnimation(o, inst.(*file_anm.Animations), f)
+				}
 			}
 		}
 	}
@@ -283,8 +289,9 @@ func (o *Object) ExportFbx(wrsrc *wad.WadNodeRsrc, f *fbxbuilder.FBXBuilder) *Fb
 	return fe
 }
 
-func (o *Object) ExportFbxDefault(wrsrc *wad.WadNodeRsrc) *fbxbuilder.FBXBuilder {
+func (o *Object) ExportFbxDefault(wrsrc *wad.WadNodeRsrc, withAnimations bool) *fbxbuilder.FBXBuilder {
 	f := fbxbuilder.NewFBXBuilder(filepath.Join(wrsrc.Wad.Name(), wrsrc.Name()))
+	f.ExportAnimations = withAnimations
 
 	fe := o.ExportFbx(wrsrc, f)
 
@@ -292,3 +299,136 @@ func (o *Object) ExportFbxDefault(wrsrc *wad.WadNodeRsrc) *fbxbuilder.FBXBuilder
 
 	return f
 }
+
+const (
+	FBX_KTIME_SECOND           = 46186158000
+	FBX_KEY_INTERPOLATION_LINE = 0x00000104 // linear | tangent auto
+	// right and next left tangent weights packed as two uint16
+	// in 1/10000 units, 3333 is default weight 1/3
+	FBX_KEY_DEFAULT_WEIGHTS = 3333<<16 | 3333
+)
+
+func fbxAnimationCurve(f *fbxbuilder.FBXBuilder, times []int64, values []float32) *fbx.Node {
+	flags := []int32{FBX_KEY_INTERPOLATION_LINE}
+	// RightSlope:0, NextLeftSlope:0, weights, velocities:0
+	attrData := []float32{0, 0, math.Float32frombits(FBX_KEY_DEFAULT_WEIGHTS), 0}
+
+	return fbx.NewNode("AnimationCurve", f.GenerateId(), "\x00\x01AnimCurve", "").AddNodes(
+		fbx.NewNode("Default", float64(values[0])),
+		fbx.NewNode("KeyVer", int32(4008)),
+		fbx.NewNode("KeyTime", times),
+		fbx.NewNode("KeyValueFloat", values),
+		fbx.NewNode("KeyAttrFlags", flags),
+		fbx.NewNode("KeyAttrDataFloat", attrData),
+		fbx.NewNode("KeyAttrRefCount", []int32{int32(len(times))}),
+	)
+}
+
+// addAnimationCurves creates curve node with curve per component and attaches it to model property
+func addAnimationCurves(f *fbxbuilder.FBXBuilder, layerId, modelId int64,
+	property, name string, times []int64, values []mgl32.Vec3) {
+	curveNode := fbx.NewNode("AnimationCurveNode", f.GenerateId(), name+"\x00\x01AnimCurveNode", "").AddNodes(
+		bfbx73.Properties70().AddNodes(
+			bfbx73.P("d|X", "Number", "", "A", float64(values[0][0])),
+			bfbx73.P("d|Y", "Number", "", "A", float64(values[0][1])),
+			bfbx73.P("d|Z", "Number", "", "A", float64(values[0][2])),
+		),
+	)
+	curveNodeId := curveNode.Properties[0].(int64)
+	f.AddObjects(curveNode)
+	f.AddConnections(
+		bfbx73.C("OO", curveNodeId, layerId),
+		bfbx73.C("OP", curveNodeId, modelId, property),
+	)
+
+	for iComponent, component := range []string{"d|X", "d|Y", "d|Z"} {
+		componentValues := make([]float32, len(values))
+		for i, v := range values {
+			componentValues[i] = v[iComponent]
+		}
+		curve := fbxAnimationCurve(f, times, componentValues)
+		f.AddObjects(curve)
+		f.AddConnections(bfbx73.C("OP", curve.Properties[0].(int64), curveNodeId, component))
+	}
+}
+
+func framesToKTime(frames []int, frameTime float32) []int64 {
+	result := make([]int64, len(frames))
+	for i, frame := range frames {
+		result[i] = int64(float64(frame) * float64(frameTime) * FBX_KTIME_SECOND)
+	}
+	return result
+}
+
+// unwrapEuler removes 360 degrees jumps between sequential rotations
+func unwrapEuler(values []mgl32.Vec3) {
+	for i := 1; i < len(values); i++ {
+		for c := 0; c < 3; c++ {
+			for values[i][c]-values[i-1][c] > 180 {
+				values[i][c] -= 360
+			}
+			for values[i][c]-values[i-1][c] < -180 {
+				values[i][c] += 360
+			}
+		}
+	}
+}
+
+// AddAnimation creates animation stack for every act with skinning data
+func (fe *FbxExporter) AddAnimation(o *Object, ganim *file_anm.Animations, f *fbxbuilder.FBXBuilder) {
+	skinInit := o.renderSkinningInit()
+
+	jointModelId := func(jointId int) int64 {
+		if len(o.Joints) == 1 {
+			return fe.FbxModelId
+		}
+		return fe.Joints[jointId].FbxLimbNodeId
+	}
+
+	skinningActs(ganim, func(group *file_anm.AnimGroup, act *file_anm.AnimAct,
+		descr *file_anm.AnimActStateDescr, data []*file_anm.AnimState0Skinning) {
+		name := group.Name + " " + act.Name
+		stop := int64(float64(act.Duration) * FBX_KTIME_SECOND)
+
+		stack := fbx.NewNode("AnimationStack", f.GenerateId(), name+"\x00\x01AnimStack", "").AddNodes(
+			bfbx73.Properties70().AddNodes(
+				bfbx73.P("LocalStop", "KTime", "Time", "", stop),
+				bfbx73.P("ReferenceStop", "KTime", "Time", "", stop),
+			),
+		)
+		layer := fbx.NewNode("AnimationLayer", f.GenerateId(), name+"\x00\x01AnimLayer", "")
+		layerId := layer.Properties[0].(int64)
+		f.AddObjects(stack, layer)
+		f.AddConnections(bfbx73.C("OO", layerId, stack.Properties[0].(int64)))
+
+		rendered := file_anm.RenderSkinningData(
+			int(act.Duration/descr.FrameTime), data, skinInit)
+
+		for _, iJoint := range sortedStreams(rendered.Rotation) {
+			stream := rendered.Rotation[iJoint]
+			if iJoint >= len(o.Joints) {
+				continue
+			}
+			values := make([]mgl32.Vec3, len(stream.Values))
+			for i, v := range stream.Values {
+				values[i] = o.GetEulerAnimatedRotationForJoint(iJoint, v)
+			}
+			unwrapEuler(values)
+			addAnimationCurves(f, layerId, jointModelId(iJoint), "Lcl Rotation", "R",
+				framesToKTime(stream.Index, descr.FrameTime), values)
+		}
+
+		for _, iJoint := range sortedStreams(rendered.Position) {
+			stream := rendered.Position[iJoint]
+			if iJoint >= len(o.Joints) {
+				continue
+			}
+			values := make([]mgl32.Vec3, len(stream.Values))
+			for i, v := range stream.Values {
+				values[i] = mgl32.Vec3{v[0], v[1], v[2]}
+			}
+			addAnimationCurves(f, layerId, jointModelId(iJoint), "Lcl Translation", "T",
+				framesToKTime(stream.Index, descr.FrameTime), values)
+		}
+	})
+}
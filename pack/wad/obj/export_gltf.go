@@ -2,8 +2,7 @@ package obj
 
 import (
 	"log"
-
-	"github.com/go-gl/mathgl/mgl32"
+	"sort"
 
 	"github.com/qmuntal/gltf"
 	"github.com/qmuntal/gltf/modeler"
@@ -11,7 +10,6 @@ import (
 	"github.com/mogaika/god_of_war_browser/pack/wad"
 	file_anm "github.com/mogaika/god_of_war_browser/pack/wad/anm"
 	file_mdl "github.com/mogaika/god_of_war_browser/pack/wad/mdl"
-	"github.com/mogaika/god_of_war_browser/utils"
 	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
 )
 
@@ -32,113 +30,129 @@ func (tfoe *GLTFObjectExported) addModel(doc *gltf.Document, gmdle *file_mdl.GLT
 	}
 }
 
-func (tfoe *GLTFObjectExported) addAnimation(o *Object, doc *gltf.Document, ganim *file_anm.Animations) error {
-	dataTypeIndex := -1
-	for dti, dt := range ganim.DataTypes {
-		if dt.TypeId == file_anm.DATATYPE_SKINNING {
-			dataTypeIndex = dti
-			break
+// writeAnimationInput writes keyframes time accessor, animation input requires bounds
+func writeAnimationInput(doc *gltf.Document, input []float32) uint32 {
+	id := modeler.WriteAccessor(doc, gltf.TargetNone, input)
+	doc.Accessors[id].Min = []float32{input[0]}
+	doc.Accessors[id].Max = []float32{input[len(input)-1]}
+	return id
+}
+
+func addAnimationChannel(doc *gltf.Document, anim *gltf.Animation,
+	node uint32, path gltf.TRSProperty, input []float32, output interface{}) {
+	anim.Samplers = append(anim.Samplers, &gltf.AnimationSampler{
+		Input:         writeAnimationInput(doc, input),
+		Output:        modeler.WriteAccessor(doc, gltf.TargetNone, output),
+		Interpolation: gltf.InterpolationLinear,
+	})
+	anim.Channels = append(anim.Channels, &gltf.Channel{
+		Sampler: gltf.Index(uint32(len(anim.Samplers) - 1)),
+		Target: gltf.ChannelTarget{
+			Node: gltf.Index(node),
+			Path: path,
+		},
+	})
+}
+
+// getAnimation returns animation by name, so object and material acts with same name are merged
+func getAnimation(doc *gltf.Document, name string) *gltf.Animation {
+	for _, anim := range doc.Animations {
+		if anim.Name == name {
+			return anim
 		}
 	}
-	if dataTypeIndex == -1 {
-		return nil
+	anim := &gltf.Animation{
+		Name:     name,
+		Samplers: make([]*gltf.AnimationSampler, 0),
+		Channels: make([]*gltf.Channel, 0),
+	}
+	doc.Animations = append(doc.Animations, anim)
+	return anim
+}
+
+func framesToTime(frames []int, frameTime float32) []float32 {
+	result := make([]float32, len(frames))
+	for i, frame := range frames {
+		result[i] = float32(frame) * frameTime
 	}
+	return result
+}
 
-	var skinInit file_anm.RenderSkinningInit
-	skinInit.Rotation = make([][4]float32, 0, len(o.Joints))
-	for _, vec := range o.Vectors5 {
-		skinInit.Rotation = append(skinInit.Rotation,
-			[4]float32{float32(vec[0]), float32(vec[1]), float32(vec[2]), float32(vec[3])})
+// sortedStreams returns joint ids of rendered streams in stable order
+func sortedStreams(streams map[int]*file_anm.RenderedSkinningStream) []int {
+	ids := make([]int, 0, len(streams))
+	for id := range streams {
+		ids = append(ids, id)
 	}
+	sort.Ints(ids)
+	return ids
+}
 
-	skinInit.Position = make([][4]float32, 0, len(o.Joints))
-	for _, vec := range o.Vectors4 {
-		skinInit.Position = append(skinInit.Position, vec)
+// skinningActs calls f for every act of animation that have skinning data
+func skinningActs(ganim *file_anm.Animations,
+	f func(group *file_anm.AnimGroup, act *file_anm.AnimAct, descr *file_anm.AnimActStateDescr, data []*file_anm.AnimState0Skinning)) {
+	dataTypeIndex := ganim.DataTypeIndex(file_anm.DATATYPE_SKINNING)
+	if dataTypeIndex == -1 {
+		return
 	}
 
 	for iGroup := range ganim.Groups {
 		group := &ganim.Groups[iGroup]
-
 		for iAct := range group.Acts {
 			act := &group.Acts[iAct]
 			descr := &act.StateDescrs[dataTypeIndex]
 
-			data := descr.Data.([]*file_anm.AnimState0Skinning)
-			if data == nil || len(data) == 0 {
+			data, _ := descr.Data.([]*file_anm.AnimState0Skinning)
+			if len(data) == 0 || descr.FrameTime <= 0 {
 				continue
 			}
+			f(group, act, descr, data)
+		}
+	}
+}
 
-			gltfAnim := &gltf.Animation{
-				Name:     group.Name + " " + act.Name,
-				Samplers: make([]*gltf.AnimationSampler, 0),
-				Channels: make([]*gltf.Channel, 0),
-			}
-			doc.Animations = append(doc.Animations, gltfAnim)
-
-			rendered := file_anm.RenderSkinningData(
-				int(act.Duration/descr.FrameTime), data, skinInit)
+// addAnimation exports skinning acts. Uv animations of materials (texturepos)
+// are not exported: gltf animation channels can target only nodes, and
+// KHR_animation_pointer is not supported by gltf library
+func (tfoe *GLTFObjectExported) addAnimation(o *Object, doc *gltf.Document, ganim *file_anm.Animations) error {
+	skinInit := o.renderSkinningInit()
 
-			for iJoint, stream := range rendered.Rotation {
-				input := make([]float32, 0, len(stream.Index))
-				for _, frame := range stream.Index {
-					input = append(input, float32(frame)*descr.FrameTime)
-				}
+	skinningActs(ganim, func(group *file_anm.AnimGroup, act *file_anm.AnimAct,
+		descr *file_anm.AnimActStateDescr, data []*file_anm.AnimState0Skinning) {
+		gltfAnim := getAnimation(doc, group.Name+" "+act.Name)
 
-				output := make([][4]float32, 0, len(stream.Values)*4)
-				for _, v := range stream.Values {
-					var q mgl32.Quat
-
-					if o.Joints[iJoint].IsQuaterion {
-						q = mgl32.Quat{
-							V: mgl32.Vec3{v[0], v[1], v[2]}.Mul(quat_to_float),
-							W: v[3] * quat_to_float,
-						}
-					} else {
-						q = utils.EulerToQuat(
-							mgl32.Vec3{v[0], v[1], v[2]}.Mul(quat_to_float * 360.0),
-						)
-					}
+		rendered := file_anm.RenderSkinningData(
+			int(act.Duration/descr.FrameTime), data, skinInit)
 
-					q = q.Normalize()
-					output = append(output, [4]float32{q.V[0], q.V[1], q.V[2], q.W})
-				}
+		for _, iJoint := range sortedStreams(rendered.Rotation) {
+			stream := rendered.Rotation[iJoint]
+			if iJoint >= len(o.Joints) {
+				continue
+			}
 
-				// utils.LogDump(input, output)
-				gltfAnim.Samplers = append(gltfAnim.Samplers, &gltf.AnimationSampler{
-					Input:         modeler.WriteAccessor(doc, gltf.TargetNone, input),
-					Output:        modeler.WriteAccessor(doc, gltf.TargetNone, output),
-					Interpolation: gltf.InterpolationLinear,
-				})
-				gltfAnim.Channels = append(gltfAnim.Channels, &gltf.Channel{
-					Sampler: gltf.Index(uint32(len(gltfAnim.Samplers) - 1)),
-					Target: gltf.ChannelTarget{
-						Node: gltf.Index(tfoe.JointNodes[iJoint]),
-						Path: gltf.TRSRotation,
-					},
-				})
+			output := make([][4]float32, 0, len(stream.Values))
+			for _, v := range stream.Values {
+				q := o.GetQuaterionAnimatedRotationForJoint(iJoint, v)
+				output = append(output, [4]float32{q.V[0], q.V[1], q.V[2], q.W})
 			}
+			addAnimationChannel(doc, gltfAnim, tfoe.JointNodes[iJoint], gltf.TRSRotation,
+				framesToTime(stream.Index, descr.FrameTime), output)
+		}
 
-			for iJoint, stream := range rendered.Position {
-				input := make([]float32, 0, len(stream.Index))
-				for _, frame := range stream.Index {
-					input = append(input, float32(frame)*descr.FrameTime)
-				}
+		for _, iJoint := range sortedStreams(rendered.Position) {
+			stream := rendered.Position[iJoint]
+			if iJoint >= len(o.Joints) {
+				continue
+			}
 
-				gltfAnim.Samplers = append(gltfAnim.Samplers, &gltf.AnimationSampler{
-					Input:         modeler.WriteAccessor(doc, gltf.TargetNone, input),
-					Output:        modeler.WriteAccessor(doc, gltf.TargetNone, stream.Values),
-					Interpolation: gltf.InterpolationLinear,
-				})
-				gltfAnim.Channels = append(gltfAnim.Channels, &gltf.Channel{
-					Sampler: gltf.Index(uint32(len(gltfAnim.Samplers) - 1)),
-					Target: gltf.ChannelTarget{
-						Node: gltf.Index(tfoe.JointNodes[iJoint]),
-						Path: gltf.TRSTranslation,
-					},
-				})
+			output := make([][3]float32, 0, len(stream.Values))
+			for _, v := range stream.Values {
+				output = append(output, [3]float32{v[0], v[1], v[2]})
 			}
+			addAnimationChannel(doc, gltfAnim, tfoe.JointNodes[iJoint], gltf.TRSTranslation,
+				framesToTime(stream.Index, descr.FrameTime), output)
 		}
-	}
+	})
 
 	return nil
 }
@@ -205,6 +219,9 @@ func (o *Object) ExportGLTF(wrsrc *wad.WadNodeRsrc, gltfCacher *gltfutils.GLTFCa
 				tfoe.addModel(doc, gmdle)
 
 			case *file_anm.Animations:
+				if gltfCacher.SkipAnimations {
+					continue
+				}
 				if err := tfoe.addAnimation(o, doc, inst); err != nil {
 					log.Panicf("Failed to export animations: %v", err)
 				}
@@ -215,8 +232,9 @@ func (o *Object) ExportGLTF(wrsrc *wad.WadNodeRsrc, gltfCacher *gltfutils.GLTFCa
 	return tfoe, nil
 }
 
-func (o *Object) ExportGLTFDefault(wrsrc *wad.WadNodeRsrc) (*gltf.Document, error) {
+func (o *Object) ExportGLTFDefault(wrsrc *wad.WadNodeRsrc, withAnimations bool) (*gltf.Document, error) {
 	gltfCacher := gltfutils.NewCacher()
+	gltfCacher.SkipAnimations = !withAnimations
 	doc := gltfCacher.Doc
 
 	tfoe, err := o.ExportGLTF(wrsrc, gltfCacher)
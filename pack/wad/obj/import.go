@@ -0,0 +1,309 @@
+package obj
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/pkg/errors"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	file_mdl "github.com/mogaika/god_of_war_browser/pack/wad/mdl"
+	file_mesh "github.com/mogaika/god_of_war_browser/pack/wad/mesh"
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+// flags of joints created by import: joint, has inverse bind pose matrix, quaterion rotation
+const JOINT_IMPORT_FLAGS = 0x20 | 0x80 | 0x8000
+
+// skin with most joints
+func gltfMainSkin(doc *gltf.Document) (*gltf.Skin, error) {
+	var skin *gltf.Skin
+	for _, s := range doc.Skins {
+		if skin == nil || len(s.Joints) > len(skin.Joints) {
+			skin = s
+		}
+	}
+	if skin == nil || len(skin.Joints) == 0 {
+		return nil, fmt.Errorf("Document without skin")
+	}
+	return skin, nil
+}
+
+// gltfSkinParents returns parent joint index for every skin joint (-1 for roots)
+func gltfSkinParents(doc *gltf.Document, skin *gltf.Skin) ([]int16, error) {
+	nodeParent := make(map[uint32]uint32)
+	for iNode, node := range doc.Nodes {
+		for _, child := range node.Children {
+			nodeParent[child] = uint32(iNode)
+		}
+	}
+	nodeJoint := make(map[uint32]int16)
+	for iJoint, iNode := range skin.Joints {
+		nodeJoint[iNode] = int16(iJoint)
+	}
+
+	parents := make([]int16, len(skin.Joints))
+	for iJoint, iNode := range skin.Joints {
+		parents[iJoint] = JOINT_CHILD_NONE
+		for n, ok := nodeParent[iNode]; ok; n, ok = nodeParent[n] {
+			if parentJoint, isJoint := nodeJoint[n]; isJoint {
+				parents[iJoint] = parentJoint
+				break
+			}
+		}
+	}
+
+	// game expects joints in depth-first order, parent before childs and subtrees without gaps
+	expected := int16(0)
+	var walk func(parent int16) error
+	walk = func(parent int16) error {
+		for iJoint := range parents {
+			if parents[iJoint] != parent {
+				continue
+			}
+			if int16(iJoint) != expected {
+				return fmt.Errorf("Skin joint %d %q is not in depth-first order (expected joint %d here)",
+					iJoint, doc.Nodes[skin.Joints[iJoint]].Name, expected)
+			}
+			expected++
+			if err := walk(int16(iJoint)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(JOINT_CHILD_NONE); err != nil {
+		return nil, err
+	}
+	return parents, nil
+}
+
+func isIdentMat(m mgl32.Mat4) bool {
+	return m.ApproxEqualThreshold(mgl32.Ident4(), 0.0001)
+}
+
+// SkeletonFromGLTF creates object with joints of gltf skin.
+// Flags and unknown fields of joints with same name are taken from current object
+func (obj *Object) SkeletonFromGLTF(doc *gltf.Document) (*Object, error) {
+	skin, err := gltfMainSkin(doc)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := gltfSkinParents(doc, skin)
+	if err != nil {
+		return nil, err
+	}
+
+	jointsCount := len(skin.Joints)
+	bindMatrices := make([][4][4]float32, jointsCount)
+	if skin.InverseBindMatrices != nil {
+		data, err := modeler.ReadAccessor(doc, doc.Accessors[*skin.InverseBindMatrices], nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read inverse bind matrices")
+		}
+		matrices, ok := data.([][4][4]float32)
+		if !ok {
+			return nil, fmt.Errorf("Inverse bind matrices accessor is not float mat4")
+		}
+		bindMatrices = matrices
+		if len(bindMatrices) != jointsCount {
+			return nil, fmt.Errorf("Inverse bind matrices count %d != joints count %d", len(bindMatrices), jointsCount)
+		}
+	} else {
+		for i := range bindMatrices {
+			bindMatrices[i] = [4][4]float32{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+		}
+	}
+
+	oldJoints := make(map[string]int, len(obj.Joints))
+	for i := range obj.Joints {
+		oldJoints[obj.Joints[i].Name] = i
+	}
+
+	newObj := &Object{
+		Joints:        make([]Joint, jointsCount),
+		File0x20:      obj.File0x20,
+		File0x24:      obj.File0x24,
+		rawHeader:     obj.rawHeader,
+		rawDataHeader: obj.rawDataHeader,
+		Matrixes1:     make([]mgl32.Mat4, jointsCount),
+		Matrixes2:     obj.Matrixes2,
+		Matrixes3:     make([]mgl32.Mat4, 0, jointsCount),
+		Vectors4:      make([]mgl32.Vec4, jointsCount),
+		Vectors5:      make([][4]int32, jointsCount),
+		Vectors6:      make([]mgl32.Vec4, jointsCount),
+		Vectors7:      make([]mgl32.Vec4, jointsCount),
+	}
+
+	invId := int16(0)
+	for iJoint, iNode := range skin.Joints {
+		node := doc.Nodes[iNode]
+		j := &newObj.Joints[iJoint]
+
+		// inverse of obj gltf export
+		var bindMat mgl32.Mat4
+		for row := range bindMatrices[iJoint] {
+			bindMat.SetRow(row, bindMatrices[iJoint][row])
+		}
+
+		*j = Joint{
+			Id:          int16(iJoint),
+			Name:        node.Name,
+			Parent:      parents[iJoint],
+			ChildsStart: JOINT_CHILD_NONE,
+			ChildsEnd:   JOINT_CHILD_NONE,
+			ExternalId:  JOINT_CHILD_NONE,
+			Flags:       JOINT_IMPORT_FLAGS,
+		}
+		w4, w6 := float32(0), float32(0)
+		if old, ok := oldJoints[node.Name]; ok {
+			oldJoint := &obj.Joints[old]
+			j.Flags = oldJoint.Flags
+			j.ExternalId = oldJoint.ExternalId
+			j.UnkCoeef = oldJoint.UnkCoeef
+			w4, w6 = obj.Vectors4[old][3], obj.Vectors6[old][3]
+			newObj.Vectors7[iJoint] = obj.Vectors7[old]
+			if !isIdentMat(bindMat) {
+				j.Flags |= 0x80
+			}
+		}
+		if j.Flags&0x8 != 0 && int(j.ExternalId) >= len(newObj.Matrixes2) {
+			return nil, fmt.Errorf("Joint %q references external matrix %d out of %d",
+				j.Name, j.ExternalId, len(newObj.Matrixes2))
+		}
+		j.IsExternal = j.Flags&0x8 != 0
+		j.IsSkinned = j.Flags&0x80 != 0
+		j.IsQuaterion = j.Flags&0x8000 != 0
+		j.InvId = invId
+		if j.IsSkinned {
+			newObj.Matrixes3 = append(newObj.Matrixes3, bindMat)
+			invId++
+		}
+
+		t := node.TranslationOrDefault()
+		r := node.RotationOrDefault()
+		s := node.ScaleOrDefault()
+		rotation := mgl32.Quat{V: mgl32.Vec3{r[0], r[1], r[2]}, W: r[3]}.Normalize()
+
+		newObj.Vectors4[iJoint] = mgl32.Vec4{t[0], t[1], t[2], w4}
+		newObj.Vectors6[iJoint] = mgl32.Vec4{s[0], s[1], s[2], w6}
+		if j.IsQuaterion {
+			for i, f := range [4]float32{rotation.V[0], rotation.V[1], rotation.V[2], rotation.W} {
+				newObj.Vectors5[iJoint][i] = int32(math.Round(float64(f / quat_to_float)))
+			}
+		} else {
+			euler := utils.QuatToEuler(rotation)
+			for i, f := range euler {
+				// radians to fraction of full turn
+				newObj.Vectors5[iJoint][i] = int32(math.Round(float64(f) / (2 * math.Pi) / quat_to_float))
+			}
+		}
+		if m := node.MatrixOrDefault(); m != gltf.DefaultMatrix {
+			return nil, fmt.Errorf("Joint %q uses matrix instead of TRS", j.Name)
+		}
+		newObj.Matrixes1[iJoint] = mgl32.Translate3D(t[0], t[1], t[2]).
+			Mul4(rotation.Mat4()).
+			Mul4(mgl32.Scale3D(s[0], s[1], s[2]))
+	}
+
+	// first child and next sibling
+	for iJoint := range newObj.Joints {
+		j := &newObj.Joints[iJoint]
+		for iNext := iJoint + 1; iNext < jointsCount; iNext++ {
+			if newObj.Joints[iNext].Parent == int16(iJoint) && j.ChildsStart == JOINT_CHILD_NONE {
+				j.ChildsStart = int16(iNext)
+			}
+			if newObj.Joints[iNext].Parent == j.Parent {
+				j.ChildsEnd = int16(iNext)
+				break
+			}
+		}
+	}
+
+	newObj.FillJoints()
+	return newObj, nil
+}
+
+// ValidateReferences checks that models and meshes of object do not reference joints out of skeleton
+func (obj *Object) ValidateReferences(wrsrc *wad.WadNodeRsrc) error {
+	jointsCount := uint32(len(obj.Joints))
+
+	validateMesh := func(name string, mesh *file_mesh.Mesh) error {
+		for iPart := range mesh.Parts {
+			part := &mesh.Parts[iPart]
+			if uint32(part.JointId) >= jointsCount {
+				return fmt.Errorf("Mesh %q part %d references joint %d, but object have %d joints",
+					name, iPart, part.JointId, jointsCount)
+			}
+			for iGroup := range part.Groups {
+				for iObject := range part.Groups[iGroup].Objects {
+					for _, jm := range part.Groups[iGroup].Objects[iObject].JointMappers {
+						for _, joint := range jm {
+							if joint >= jointsCount {
+								return fmt.Errorf("Mesh %q part %d group %d object %d references joint %d, but object have %d joints",
+									name, iPart, iGroup, iObject, joint, jointsCount)
+							}
+						}
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, id := range wrsrc.Node.SubGroupNodes {
+		mdlNode := wrsrc.Wad.GetNodeById(id)
+		inst, _, err := wrsrc.Wad.GetInstanceFromNode(mdlNode.Id)
+		if err != nil {
+			continue
+		}
+		model, ok := inst.(*file_mdl.Model)
+		if !ok {
+			continue
+		}
+		if model.JointsCount > jointsCount {
+			return fmt.Errorf("Model %q uses %d joints, but object have %d joints",
+				mdlNode.Tag.Name, model.JointsCount, jointsCount)
+		}
+		for _, meshNodeId := range mdlNode.SubGroupNodes {
+			meshNode := wrsrc.Wad.GetNodeById(meshNodeId)
+			if inst, _, err := wrsrc.Wad.GetInstanceFromNode(meshNode.Id); err == nil {
+				if mesh, ok := inst.(*file_mesh.Mesh); ok {
+					if err := validateMesh(meshNode.Tag.Name, mesh); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ImportGLTF replaces skeleton of object by skin from gltf document
+func (obj *Object) ImportGLTF(wrsrc *wad.WadNodeRsrc, gltfReader io.Reader) error {
+	if config.GetGOWVersion() != config.GOW1 {
+		return fmt.Errorf("Only GOW1 objects supported")
+	}
+
+	doc := &gltf.Document{}
+	if err := gltf.NewDecoder(gltfReader).Decode(doc); err != nil {
+		return errors.Wrapf(err, "Failed to read gltf")
+	}
+
+	newObj, err := obj.SkeletonFromGLTF(doc)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read skeleton")
+	}
+	if err := newObj.ValidateReferences(wrsrc); err != nil {
+		return err
+	}
+
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: newObj.MarshalBuffer().Bytes(),
+	})
+}
@@ -0,0 +1,136 @@
+package obj
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/qmuntal/gltf"
+
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+	file_anm "github.com/mogaika/god_of_war_browser/pack/wad/anm"
+	"github.com/mogaika/god_of_war_browser/utils/gltfutils"
+)
+
+func testSkeleton() *Object {
+	// root -> (arm -> hand), leg
+	o := &Object{
+		Joints: []Joint{
+			{Name: "root", Parent: -1, ChildsStart: 1, ChildsEnd: -1, Flags: 0x8020},
+			{Name: "arm", Parent: 0, ChildsStart: 2, ChildsEnd: 3, Flags: 0x80a0},
+			{Name: "hand", Parent: 1, ChildsStart: -1, ChildsEnd: -1, Flags: 0x80a0},
+			{Name: "leg", Parent: 0, ChildsStart: -1, ChildsEnd: -1, Flags: 0x80a0},
+		},
+		Matrixes3: []mgl32.Mat4{
+			mgl32.Translate3D(-1, 0, 0), mgl32.Translate3D(-2, 0, 0), mgl32.Translate3D(0, 1, 0)},
+		Vectors4: []mgl32.Vec4{{0, 0, 0, 1}, {1, 0, 0, 1}, {1, 0, 0, 1}, {0, -1, 0, 1}},
+		Vectors5: [][4]int32{{0, 0, 0, 1 << 14}, {0, 0, 11585, 11585}, {0, 0, 0, 1 << 14}, {0, 1 << 14, 0, 0}},
+		Vectors6: []mgl32.Vec4{{1, 1, 1, 1}, {1, 1, 1, 1}, {2, 2, 2, 1}, {1, 1, 1, 1}},
+		Vectors7: make([]mgl32.Vec4, 4),
+	}
+	invId := int16(0)
+	for i := range o.Joints {
+		j := &o.Joints[i]
+		j.Id = int16(i)
+		j.IsSkinned = j.Flags&0x80 != 0
+		j.IsQuaterion = j.Flags&0x8000 != 0
+		j.InvId = invId
+		if j.IsSkinned {
+			invId++
+		}
+	}
+	o.Matrixes1 = make([]mgl32.Mat4, len(o.Joints))
+	o.FillJoints()
+	return o
+}
+
+func TestSkeletonGLTFRoundTrip(t *testing.T) {
+	source := testSkeleton()
+
+	tag := &wad.Tag{Name: "OBJ_test"}
+	wrsrc := &wad.WadNodeRsrc{Tag: tag, Node: &wad.Node{Tag: tag}}
+	doc, err := source.ExportGLTFDefault(wrsrc, false)
+	if err != nil {
+		t.Fatalf("Failed to export gltf: %v", err)
+	}
+
+	imported, err := source.SkeletonFromGLTF(doc)
+	if err != nil {
+		t.Fatalf("Failed to import skeleton: %v", err)
+	}
+
+	parsed, err := NewFromData(imported.MarshalBuffer().Bytes(), "test")
+	if err != nil {
+		t.Fatalf("Failed to parse marshaled object: %v", err)
+	}
+
+	if len(parsed.Joints) != len(source.Joints) {
+		t.Fatalf("Joints count %d, expected %d", len(parsed.Joints), len(source.Joints))
+	}
+	for i := range source.Joints {
+		e, g := &source.Joints[i], &parsed.Joints[i]
+		if e.Name != g.Name || e.Parent != g.Parent || e.ChildsStart != g.ChildsStart ||
+			e.ChildsEnd != g.ChildsEnd || e.Flags != g.Flags || e.InvId != g.InvId {
+			t.Errorf("Joint %d mismatch: got %+v, expected %+v", i, g, e)
+		}
+		if !g.BindToJointMat.ApproxEqualThreshold(e.BindToJointMat, 0.0001) {
+			t.Errorf("Joint %d bind matrix %v, expected %v", i, g.BindToJointMat, e.BindToJointMat)
+		}
+		if !parsed.Vectors4[i].ApproxEqual(source.Vectors4[i]) || !parsed.Vectors6[i].ApproxEqual(source.Vectors6[i]) {
+			t.Errorf("Joint %d translation or scale mismatch", i)
+		}
+		if parsed.Vectors5[i] != source.Vectors5[i] {
+			t.Errorf("Joint %d rotation %v, expected %v", i, parsed.Vectors5[i], source.Vectors5[i])
+		}
+	}
+
+	// hand placed at x=1 in arm space
+	if p := parsed.Matrixes1[2].Mul4x1(mgl32.Vec4{0, 0, 0, 1}); !p.ApproxEqual(mgl32.Vec4{1, 0, 0, 1}) {
+		t.Errorf("Hand local matrix origin %v", p)
+	}
+}
+
+func TestSkinningAnimationGLTFExport(t *testing.T) {
+	source := testSkeleton()
+
+	tag := &wad.Tag{Name: "OBJ_test"}
+	wrsrc := &wad.WadNodeRsrc{Tag: tag, Node: &wad.Node{Tag: tag}}
+	gltfCacher := gltfutils.NewCacher()
+	tfoe, err := source.ExportGLTF(wrsrc, gltfCacher)
+	if err != nil {
+		t.Fatalf("Failed to export gltf: %v", err)
+	}
+
+	state := &file_anm.AnimState0Skinning{}
+	state.RotationStream.Manager.Count = 3
+	state.RotationStream.Samples = map[int]interface{}{
+		// joint 1, quaternion z and w
+		1*4 + 2: []float32{0, 6270, 11585},
+		1*4 + 3: []float32{1 << 14, 15137, 11585},
+	}
+	ganim := &file_anm.Animations{
+		DataTypes: []file_anm.AnimDatatype{{TypeId: file_anm.DATATYPE_SKINNING}},
+		Groups: []file_anm.AnimGroup{{Name: "grp", Acts: []file_anm.AnimAct{{
+			Name:        "swing",
+			Duration:    0.1,
+			StateDescrs: []file_anm.AnimActStateDescr{{FrameTime: 1.0 / 30.0, Data: []*file_anm.AnimState0Skinning{state}}},
+		}}}},
+	}
+
+	doc := gltfCacher.Doc
+	if err := tfoe.addAnimation(source, doc, ganim); err != nil {
+		t.Fatalf("Failed to export animation: %v", err)
+	}
+	if len(doc.Animations) != 1 || doc.Animations[0].Name != "grp swing" {
+		t.Fatalf("Unexpected animations %+v", doc.Animations)
+	}
+	anim := doc.Animations[0]
+	if len(anim.Channels) != 1 || *anim.Channels[0].Target.Node != tfoe.JointNodes[1] ||
+		anim.Channels[0].Target.Path != gltf.TRSRotation {
+		t.Fatalf("Unexpected channels %+v", anim.Channels)
+	}
+	input := doc.Accessors[anim.Samplers[0].Input]
+	if input.Count != 3 || input.Max[0] < 0.066 || input.Min[0] != 0 {
+		t.Errorf("Unexpected input accessor %+v", input)
+	}
+}
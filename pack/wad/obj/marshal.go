@@ -0,0 +1,80 @@
+package obj
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+const JOINT_NAME_SIZE = 0x18
+
+// MarshalBuffer serializes gow1 object. Unknown header fields copied from parsed file
+func (obj *Object) MarshalBuffer() *bytes.Buffer {
+	var header [HEADER_SIZE]byte
+	copy(header[:], obj.rawHeader)
+	if obj.rawHeader == nil {
+		binary.LittleEndian.PutUint32(header[0:], OBJECT_MAGIC)
+	}
+
+	dataOffset := uint32(HEADER_SIZE + len(obj.Joints)*(0x10+JOINT_NAME_SIZE))
+	if pad := dataOffset % 0x10; pad != 0 {
+		dataOffset += 0x10 - pad
+	}
+
+	binary.LittleEndian.PutUint32(header[0x1c:], uint32(len(obj.Joints)))
+	binary.LittleEndian.PutUint32(header[0x20:], obj.File0x20)
+	binary.LittleEndian.PutUint32(header[0x24:], obj.File0x24)
+	binary.LittleEndian.PutUint32(header[0x28:], dataOffset)
+
+	var result bytes.Buffer
+	result.Write(header[:])
+
+	for i := range obj.Joints {
+		j := &obj.Joints[i]
+		var jointBuf [0x10]byte
+		binary.LittleEndian.PutUint32(jointBuf[0:], j.Flags)
+		binary.LittleEndian.PutUint16(jointBuf[0x4:], uint16(j.ChildsStart))
+		binary.LittleEndian.PutUint16(jointBuf[0x6:], uint16(j.ChildsEnd))
+		binary.LittleEndian.PutUint16(jointBuf[0x8:], uint16(j.Parent))
+		binary.LittleEndian.PutUint16(jointBuf[0xa:], uint16(j.ExternalId))
+		binary.LittleEndian.PutUint32(jointBuf[0xc:], math.Float32bits(j.UnkCoeef))
+		result.Write(jointBuf[:])
+	}
+	for i := range obj.Joints {
+		result.Write(utils.StringToBytesBuffer(obj.Joints[i].Name, JOINT_NAME_SIZE, true))
+	}
+	result.Write(make([]byte, int(dataOffset)-result.Len()))
+
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, obj.Matrixes1)
+	mat2offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Matrixes2)
+	mat3offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Matrixes3)
+	vec4offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Vectors4)
+	vec5offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Vectors5)
+	vec6offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Vectors6)
+	vec7offset := DATA_HEADER_SIZE + uint32(data.Len())
+	binary.Write(&data, binary.LittleEndian, obj.Vectors7)
+
+	var dataHeader [DATA_HEADER_SIZE]byte
+	copy(dataHeader[:], obj.rawDataHeader)
+	binary.LittleEndian.PutUint32(dataHeader[0:], uint32(len(obj.Matrixes1)))
+	binary.LittleEndian.PutUint32(dataHeader[4:], mat2offset)
+	binary.LittleEndian.PutUint32(dataHeader[8:], uint32(len(obj.Matrixes2)))
+	binary.LittleEndian.PutUint32(dataHeader[12:], mat3offset)
+	binary.LittleEndian.PutUint32(dataHeader[16:], uint32(len(obj.Matrixes3)))
+	binary.LittleEndian.PutUint32(dataHeader[32:], vec4offset)
+	binary.LittleEndian.PutUint32(dataHeader[36:], vec5offset)
+	binary.LittleEndian.PutUint32(dataHeader[40:], vec6offset)
+	binary.LittleEndian.PutUint32(dataHeader[44:], vec7offset)
+
+	result.Write(dataHeader[:])
+	result.Write(data.Bytes())
+	return &result
+}
@@ -82,6 +82,10 @@ type Object struct {
 
 	dataOffset uint32
 
+	// unknown fields of headers, required for marshaling
+	rawHeader     []byte
+	rawDataHeader []byte
+
 	Matrixes1 []mgl32.Mat4 // idle parent local joint => local joint
 	Matrixes2 []mgl32.Mat4 // special mat4 for flag & 0x8 != 0 cases (external matrices). Used only as 3x3 matrix (rotation only?)
 
@@ -147,6 +151,10 @@ func NewFromData(buf []byte, objName string) (*Object, error) {
 
 	matdata := buf[dataOffset : dataOffset+DATA_HEADER_SIZE]
 
+	obj.dataOffset = dataOffset
+	obj.rawHeader = append([]byte{}, buf[:HEADER_SIZE]...)
+	obj.rawDataHeader = append([]byte{}, matdata...)
+
 	mat1count := binary.LittleEndian.Uint32(matdata[0:4])
 	mat2offset := binary.LittleEndian.Uint32(matdata[4:8])
 	mat2count := binary.LittleEndian.Uint32(matdata[8:12])
@@ -297,6 +305,16 @@ func (obj *Object) FillJoints() {
 	}
 }
 
+// JointIdByName used by mesh importers to resolve skin joints
+func (obj *Object) JointIdByName(name string) (int, bool) {
+	for i := range obj.Joints {
+		if obj.Joints[i].Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
 type ObjMarshal struct {
 	Data             *Object
 	Model            *mdl.Ajax
@@ -380,6 +398,42 @@ func (o *Object) GetEulerLocalRotationForJoint(jointId int) mgl32.Vec3 {
 	}
 }
 
+// rotation of joint in animation stream, same encoding as Vectors5
+func (o *Object) GetQuaterionAnimatedRotationForJoint(jointId int, v [4]float32) mgl32.Quat {
+	if o.Joints[jointId].IsQuaterion {
+		return mgl32.Quat{
+			V: mgl32.Vec3{v[0], v[1], v[2]}.Mul(quat_to_float),
+			W: v[3] * quat_to_float,
+		}.Normalize()
+	} else {
+		return utils.EulerToQuat(mgl32.Vec3{v[0], v[1], v[2]}.Mul(quat_to_float * 360.0)).Normalize()
+	}
+}
+
+// rotation of joint in animation stream, result in degrees
+func (o *Object) GetEulerAnimatedRotationForJoint(jointId int, v [4]float32) mgl32.Vec3 {
+	if o.Joints[jointId].IsQuaterion {
+		return utils.QuatToEuler(o.GetQuaterionAnimatedRotationForJoint(jointId, v)).Mul(180.0 / math.Pi)
+	} else {
+		return mgl32.Vec3{v[0], v[1], v[2]}.Mul(quat_to_float * 360.0)
+	}
+}
+
+// initial values of animation streams
+func (o *Object) renderSkinningInit() anm.RenderSkinningInit {
+	var skinInit anm.RenderSkinningInit
+	skinInit.Rotation = make([][4]float32, 0, len(o.Joints))
+	for _, vec := range o.Vectors5 {
+		skinInit.Rotation = append(skinInit.Rotation,
+			[4]float32{float32(vec[0]), float32(vec[1]), float32(vec[2]), float32(vec[3])})
+	}
+	skinInit.Position = make([][4]float32, 0, len(o.Joints))
+	for _, vec := range o.Vectors4 {
+		skinInit.Position = append(skinInit.Position, vec)
+	}
+	return skinInit
+}
+
 func init() {
 	wad.SetHandler(config.GOW1, OBJECT_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
 		return NewFromData(wrsrc.Tag.Data, wrsrc.Wad.Name()+":"+wrsrc.Tag.Name)
@@ -0,0 +1,163 @@
+package wad
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+const (
+	REF_NODE = "node" // name of node of same wad
+	REF_WAD  = "wad"  // name of other wad
+)
+
+type Reference struct {
+	Kind string
+	Name string
+}
+
+// Referencer implemented by files which refer to other resources by name
+type Referencer interface {
+	References(wrsrc *WadNodeRsrc) []Reference
+}
+
+func NodeRef(name string) Reference { return Reference{Kind: REF_NODE, Name: name} }
+
+// NodeReferences returns names referenced by node instance and names of node subgroup
+func (w *Wad) NodeReferences(id NodeId) ([]Reference, uint32, error) {
+	var refs []Reference
+	for _, subId := range w.GetNodeById(id).SubGroupNodes {
+		refs = append(refs, NodeRef(w.GetNodeById(subId).Tag.Name))
+	}
+	instRefs, serverId, err := w.InstanceReferences(id)
+	return append(refs, instRefs...), serverId, err
+}
+
+// InstanceReferences returns only names referenced by node instance
+func (w *Wad) InstanceReferences(id NodeId) (refs []Reference, serverId uint32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Loader panic: %v", r)
+		}
+	}()
+
+	inst, serverId, err := w.GetInstanceFromNode(id)
+	if err != nil {
+		return nil, serverId, err
+	}
+	if r, ok := inst.(Referencer); ok {
+		for _, ref := range r.References(w.GetNodeResourceByNodeId(id)) {
+			if ref.Name != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs, serverId, nil
+}
+
+// RsrcsWadName returns file name of wad listed by rsrcs of wad wadName,
+// rsrcs names stored without extension
+func RsrcsWadName(wadName, name string) string {
+	if filepath.Ext(name) == "" {
+		return name + filepath.Ext(wadName)
+	}
+	return name
+}
+
+// OpenWad loads wad file of directory
+func OpenWad(d vfs.Directory, name string) (*Wad, error) {
+	inst, err := pack.GetInstanceHandler(d, name)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := inst.(*Wad)
+	if !ok {
+		return nil, fmt.Errorf("%q is not wad", name)
+	}
+	return w, nil
+}
+
+// NameResolver looks for names in wad and then in wads listed by its rsrcs
+type NameResolver struct {
+	w         *Wad
+	open      func(name string) (*Wad, error)
+	rsrcsWads []string
+	opened    map[string]*Wad
+	// errors of opening rsrcs wads by file name
+	OpenErrors map[string]error
+}
+
+// NewNameResolver collects rsrcs wads of w, open loads them on first lookup.
+// If open is nil names are looked up only in w
+func NewNameResolver(w *Wad, open func(name string) (*Wad, error)) *NameResolver {
+	r := &NameResolver{
+		w:          w,
+		open:       open,
+		opened:     make(map[string]*Wad),
+		OpenErrors: make(map[string]error),
+	}
+	for _, n := range w.Nodes {
+		if w.GetNodeById(n.Id) != n {
+			continue
+		}
+		refs, _, _ := w.InstanceReferences(n.Id)
+		for _, ref := range refs {
+			if ref.Kind == REF_WAD {
+				if name := RsrcsWadName(w.Name(), ref.Name); name != w.Name() {
+					r.rsrcsWads = append(r.rsrcsWads, name)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// RsrcsWads returns file names of wads listed by rsrcs, except wad itself
+func (r *NameResolver) RsrcsWads() []string {
+	return r.rsrcsWads
+}
+
+func (r *NameResolver) openWad(name string) *Wad {
+	if w, ok := r.opened[name]; ok {
+		return w
+	}
+	w, err := r.open(name)
+	if err != nil {
+		r.OpenErrors[name] = err
+		w = nil
+	}
+	r.opened[name] = w
+	return w
+}
+
+// Resolve returns node with name as seen from node from and wad of this node,
+// nil if name is not found
+func (r *NameResolver) Resolve(from NodeId, name string) (*Wad, *Node) {
+	if n := r.w.ResolveName(from, name); n != nil {
+		return r.w, n
+	}
+	if r.open == nil {
+		return nil, nil
+	}
+	for _, wadName := range r.rsrcsWads {
+		if ow := r.openWad(wadName); ow != nil {
+			if n := ow.GetNodeByName(name, NodeId(len(ow.Nodes)-1), false); n != nil {
+				return ow, n
+			}
+		}
+	}
+	return nil, nil
+}
+
+// SortedOpenErrors returns names of rsrcs wads which failed to open
+func (r *NameResolver) SortedOpenErrors() []string {
+	names := make([]string, 0, len(r.OpenErrors))
+	for name := range r.OpenErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -40,21 +40,42 @@ func (rsrcs *RSRCS) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	return rsrcs, nil
 }
 
+func (rsrcs *RSRCS) References(wrsrc *wad.WadNodeRsrc) []wad.Reference {
+	refs := make([]wad.Reference, len(rsrcs.Wads))
+	for i, name := range rsrcs.Wads {
+		refs[i] = wad.Reference{Kind: wad.REF_WAD, Name: name}
+	}
+	return refs
+}
+
 func (rsrcs *RSRCS) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.Request, action string) {
 	switch action {
 	case "update":
-		rsrcs.Wads = make([]string, 0)
+		wads := make([]string, 0)
 
 		for i := 0; i < 6; i++ {
 			if wad := r.URL.Query().Get(fmt.Sprintf("wad%d", i)); wad != "" {
-				rsrcs.Wads = append(rsrcs.Wads, wad)
+				wads = append(wads, wad)
 			}
 		}
 
-		wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
-			wrsrc.Tag.Id: rsrcs.MarshalData().Bytes(),
-		})
+		if err := rsrcs.SetWads(wrsrc, wads); err != nil {
+			fmt.Fprintln(w, err)
+		}
+	}
+}
+
+func (rsrcs *RSRCS) SetWads(wrsrc *wad.WadNodeRsrc, wads []string) error {
+	for _, name := range wads {
+		if len(name) > 24 {
+			return fmt.Errorf("Wad name %q is longer than 24 symbols", name)
+		}
 	}
+	rsrcs.Wads = wads
+
+	return wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: rsrcs.MarshalData().Bytes(),
+	})
 }
 
 func init() {
@@ -0,0 +1,147 @@
+package sbk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/mogaika/god_of_war_browser/ps2/adpcm"
+	"github.com/mogaika/god_of_war_browser/ps2/vagp"
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+func (sbk *SBK) soundIndex(sndName string) (int, error) {
+	for i := range sbk.Sounds {
+		if sbk.Sounds[i].Name == sndName {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Cannot find sound %q", sndName)
+}
+
+// splice replaces data[start:end] with insert
+func splice(data []byte, start, end int, insert []byte) []byte {
+	result := make([]byte, 0, len(data)-(end-start)+len(insert))
+	result = append(result, data[:start]...)
+	result = append(result, insert...)
+	return append(result, data[end:]...)
+}
+
+// ReplaceVagSound returns tag data with vag of sound encoded from wave
+func (sbk *SBK) ReplaceVagSound(data []byte, sndName string, wave *utils.Wave) ([]byte, error) {
+	iSnd, err := sbk.soundIndex(sndName)
+	if err != nil {
+		return nil, err
+	}
+
+	start := sbk.Sounds[iSnd].StreamId
+	end := uint32(len(data))
+	for _, snd := range sbk.Sounds {
+		if snd.StreamId > start && snd.StreamId < end {
+			end = snd.StreamId
+		}
+	}
+
+	vag, err := vagp.NewVAGPFromReader(bytes.NewReader(data[start:end]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse vag of sound %q", sndName)
+	}
+	if err := vag.FromWave(wave); err != nil {
+		return nil, err
+	}
+	newVag := vag.MarshalBuffer().Bytes()
+	delta := int32(len(newVag)) - int32(end-start)
+
+	result := splice(data, int(start), int(end), newVag)
+	for i := range sbk.Sounds {
+		if sbk.Sounds[i].StreamId > start {
+			binary.LittleEndian.PutUint32(result[8+i*28+24:], uint32(int32(sbk.Sounds[i].StreamId)+delta))
+		}
+	}
+	return result, nil
+}
+
+// ReplaceBankSample returns tag data with bank sample of sound encoded from wave.
+// iCommand is index of sound command referencing sample, or -1 for first one.
+// Samples stored after replaced one are moved
+func (sbk *SBK) ReplaceBankSample(data []byte, sndName string, iCommand int, wave *utils.Wave) ([]byte, error) {
+	iSnd, err := sbk.soundIndex(sndName)
+	if err != nil {
+		return nil, err
+	}
+	b := sbk.Bank
+	if b.StreamBlock == nil {
+		return nil, fmt.Errorf("Bank without stream block")
+	}
+	if b.HeaderBlockStart > b.StreamBlockStart {
+		return nil, fmt.Errorf("Bank header after stream block not supported")
+	}
+
+	commands := b.BankSounds[sbk.Sounds[iSnd].StreamId].Commands
+	var ref *SampleRef
+	if iCommand < 0 {
+		for i := range commands {
+			if commands[i].SampleRef != nil {
+				ref = commands[i].SampleRef
+				break
+			}
+		}
+	} else if iCommand < len(commands) {
+		ref = commands[iCommand].SampleRef
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("Sound %q command %d do not reference sample", sndName, iCommand)
+	}
+
+	oldStream := b.StreamBlock.Raw()
+	if ref.AdpcmOffset+ref.AdpcmSize > uint32(len(oldStream)) {
+		return nil, fmt.Errorf("Sample %d:%d out of stream block", ref.AdpcmOffset, ref.AdpcmSize)
+	}
+	oldSample := oldStream[ref.AdpcmOffset : ref.AdpcmOffset+ref.AdpcmSize]
+
+	samples := utils.WaveResample(wave.Mono(), wave.SampleRate, BANK_SAMPLE_RATE)
+	newSample := adpcm.NewAdpcmEncoder().Pack(samples, adpcm.IsLooped(oldSample))
+	delta := int32(len(newSample)) - int32(ref.AdpcmSize)
+
+	newStream := splice(oldStream, int(ref.AdpcmOffset), int(ref.AdpcmOffset+ref.AdpcmSize), newSample)
+
+	bankStart := 8 + len(sbk.Sounds)*28
+	streamStart := bankStart + int(b.StreamBlockStart)
+	result := splice(data, streamStart, streamStart+int(b.StreamBlockSize), newStream)
+
+	bo := b.bo
+	bo.PutUint32(result[bankStart+0x14:], uint32(len(newStream)))
+
+	headerStart := bankStart + int(b.HeaderBlockStart)
+	if b.AdpcmSize == b.StreamBlockSize {
+		bo.PutUint32(result[headerStart+0x28:], uint32(len(newStream)))
+	}
+
+	// same sample can be referenced by many commands
+	patched := make(map[int]bool)
+	smpdStart := headerStart + int(b.SmpdStart)
+	for iBankSound := range b.BankSounds {
+		for _, cmd := range b.BankSounds[iBankSound].Commands {
+			r := cmd.SampleRef
+			if r == nil || patched[r.addr] {
+				continue
+			}
+			patched[r.addr] = true
+
+			refData := result[smpdStart+r.addr:]
+			switch {
+			case r.AdpcmOffset == ref.AdpcmOffset && r.AdpcmSize == ref.AdpcmSize:
+				bo.PutUint32(refData[20:], uint32(len(newSample)))
+			case r.AdpcmOffset >= ref.AdpcmOffset+ref.AdpcmSize:
+				bo.PutUint32(refData[16:], uint32(int32(r.AdpcmOffset)+delta))
+			case r.AdpcmOffset+r.AdpcmSize > ref.AdpcmOffset && r.AdpcmOffset < ref.AdpcmOffset+ref.AdpcmSize:
+				return nil, fmt.Errorf("Sample %d:%d overlaps replaced sample %d:%d",
+					r.AdpcmOffset, r.AdpcmSize, ref.AdpcmOffset, ref.AdpcmSize)
+			}
+		}
+	}
+
+	return result, nil
+}
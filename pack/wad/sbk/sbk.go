@@ -21,6 +21,9 @@ const SBK_SBLK_MAGIC = 0x18
 const SBK_VAG_MAGIC = 0x40018
 const GOW2_SBP_MAGIC = 0x00000015
 
+// sample rate used for bank samples
+const BANK_SAMPLE_RATE = 22050
+
 type Sound struct {
 	Name     string
 	StreamId uint32 // file offset for vag
@@ -64,6 +67,8 @@ type SampleRef struct {
 
 	AdpcmOffset uint32
 	AdpcmSize   uint32
+
+	addr int // offset in smpd block
 }
 
 func (s *SampleRef) Parse(bo binary.ByteOrder, b []byte) {
@@ -133,6 +138,7 @@ func (c *Command) Parse(bo binary.ByteOrder, bsRefs *utils.BufStack, b []byte) {
 	case 1:
 		c.SampleRef = &SampleRef{}
 		c.SampleRef.Parse(bo, bsRefs.Raw()[addr:])
+		c.SampleRef.addr = addr
 		bsRefs.SubBuf("cmd_1_sam", addr)
 	case 5:
 		c.UnkRef = &UnkRef{}
@@ -213,6 +219,8 @@ type Bank struct {
 	BankSounds    []BankSound
 
 	SmpdStart uint32
+
+	bo binary.ByteOrder
 }
 
 func (b *Bank) parseHeader(bo binary.ByteOrder, bsHeader *utils.BufStack) error {
@@ -258,6 +266,7 @@ func (sbk *SBK) loadBank(bsBank *utils.BufStack) error {
 		HeaderBlockSize:  bsBankInfo.EU32(bo, 0xc),
 		StreamBlockStart: bsBankInfo.EU32(bo, 0x10),
 		StreamBlockSize:  bsBankInfo.EU32(bo, 0x14),
+		bo:               bo,
 	}
 
 	bsBankHeader := bsBank.SubBuf("bank_header",
@@ -311,7 +320,7 @@ func (sbk *SBK) httpSendBankSMPD(w http.ResponseWriter, wrsrc *wad.WadNodeRsrc,
 
 	webutils.WriteFileHeaders(w, fmt.Sprintf("%s_%d_%d.WAV", wrsrc.Tag.Name, offset, size))
 
-	if err := utils.WaveWriteHeader(w, 1, 22050, uint32((size/16)*28*2)); err != nil {
+	if err := utils.WaveWriteHeader(w, 1, BANK_SAMPLE_RATE, uint32((size/16)*28*2)); err != nil {
 		webutils.WriteError(w, err)
 	}
 
@@ -366,6 +375,39 @@ func (sbk *SBK) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *htt
 		sbk.httpSendSound(w, wrsrc, sndName, true)
 	case "vag":
 		sbk.httpSendSound(w, wrsrc, sndName, false)
+	case "replace":
+		fileStream, _, err := r.FormFile("data")
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		defer fileStream.Close()
+
+		wave, err := utils.WaveRead(fileStream)
+		if err != nil {
+			fmt.Fprintln(w, "failed to read wave:", err)
+			return
+		}
+
+		var data []byte
+		if sbk.IsVagFiles {
+			data, err = sbk.ReplaceVagSound(wrsrc.Tag.Data, sndName, wave)
+		} else {
+			cmd := -1
+			if cmdParam := r.URL.Query().Get("cmd"); cmdParam != "" {
+				fmt.Sscan(cmdParam, &cmd)
+			}
+			data, err = sbk.ReplaceBankSample(wrsrc.Tag.Data, sndName, cmd, wave)
+		}
+		if err != nil {
+			log.Printf("[sbk] Error replacing sound: %v", err)
+			fmt.Fprintln(w, "sound replace error:", err)
+			return
+		}
+
+		if err := wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{wrsrc.Tag.Id: data}); err != nil {
+			fmt.Fprintln(w, "sound replace error:", err)
+		}
 	case "smpd":
 		var offset, size int
 		fmt.Sscan(r.URL.Query().Get("offset"), &offset)
@@ -9,7 +9,7 @@ import (
 	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/pack/wad"
 	"github.com/mogaika/god_of_war_browser/pack/wad/scr/store"
-	_ "github.com/mogaika/god_of_war_browser/pack/wad/scr/targets/entity"
+	"github.com/mogaika/god_of_war_browser/pack/wad/scr/targets/entity"
 	"github.com/mogaika/god_of_war_browser/utils"
 	"github.com/mogaika/god_of_war_browser/webutils"
 )
@@ -89,7 +89,82 @@ func (sp *ScriptParams) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter
 	}
 }
 
+// checkHeapSizes compares count of entities of SCR_Entities scripts
+// with entity count stored in zero sized tags, game allocates heap by this count
+func checkHeapSizes(w *wad.Wad, report *wad.ValidationReport) {
+	if len(w.HeapSizes) == 0 {
+		return
+	}
+	checkHeapEntities(w, countHeapEntities(w, nodeEntities), report)
+}
+
+// nodeEntities returns count of entities of SCR_Entities script node
+func nodeEntities(w *wad.Wad, n *wad.Node) int {
+	if len(n.Tag.Data) < HEADER_SIZE || binary.LittleEndian.Uint32(n.Tag.Data) != SCRIPT_MAGIC {
+		return 0
+	}
+	// loader errors already reported
+	inst, _, err := w.GetInstanceFromNode(n.Id)
+	if err != nil {
+		return 0
+	}
+	if sp, ok := inst.(*ScriptParams); ok {
+		if ents, ok := sp.Data.(*entity.Entities); ok {
+			return len(ents.Array)
+		}
+	}
+	return 0
+}
+
+// countHeapEntities sums entities by heaps. Heap allocated when game reads
+// entity count tag, so entities of following scripts placed in it.
+// Entities placed before any entity count tag counted for heap with empty name
+func countHeapEntities(w *wad.Wad, entities func(w *wad.Wad, n *wad.Node) int) map[string]int {
+	counts := make(map[string]int)
+	heap := ""
+	for i := range w.Tags {
+		t := &w.Tags[i]
+		if wad.IsHeapSizeTag(t) {
+			heap = t.Name
+			continue
+		}
+		if t.NodeId == wad.NODE_INVALID {
+			continue
+		}
+		if count := entities(w, w.GetNodeById(t.NodeId)); count != 0 {
+			counts[heap] += count
+		}
+	}
+	return counts
+}
+
+// checkHeapEntities compares entities of every heap with its own size
+func checkHeapEntities(w *wad.Wad, counts map[string]int, report *wad.ValidationReport) {
+	if count := counts[""]; count != 0 {
+		report.Add(wad.ISSUE_ERROR, "heapsizes", nil, "%d entities in SCR_Entities placed before any entity count tag", count)
+	}
+	heapTags := make(map[string]*wad.Tag)
+	names := make([]string, 0, len(w.HeapSizes))
+	for i := range w.Tags {
+		if t := &w.Tags[i]; wad.IsHeapSizeTag(t) {
+			if _, ok := heapTags[t.Name]; !ok {
+				names = append(names, t.Name)
+			}
+			heapTags[t.Name] = t
+		}
+	}
+	for _, name := range names {
+		count, heap := counts[name], w.HeapSizes[name]
+		if uint32(count) > heap {
+			report.Add(wad.ISSUE_ERROR, "heapsizes", heapTags[name], "%d entities in SCR_Entities of heap %q, but entity count tag allows only %d", count, name, heap)
+		} else if uint32(count) < heap {
+			report.Add(wad.ISSUE_WARNING, "heapsizes", heapTags[name], "%d entities in SCR_Entities of heap %q, but entity count tag reserves %d", count, name, heap)
+		}
+	}
+}
+
 func init() {
+	wad.SetWadCheck("heapsizes", checkHeapSizes)
 	wad.SetHandler(config.GOW1, SCRIPT_MAGIC, func(wrsrc *wad.WadNodeRsrc) (wad.File, error) {
 		return NewFromData(wrsrc.Tag.Data, wrsrc)
 	})
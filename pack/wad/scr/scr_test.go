@@ -0,0 +1,61 @@
+package scr
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+	"github.com/mogaika/god_of_war_browser/pack/wad"
+)
+
+type testSource string
+
+func (s testSource) Name() string                    { return string(s) }
+func (s testSource) Size() int64                     { return 0 }
+func (s testSource) Save(in *io.SectionReader) error { return nil }
+
+func TestCheckHeapEntities(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	heap := func(name string, size uint32) wad.Tag {
+		return wad.Tag{Tag: wad.TAG_GOW1_ENTITY_COUNT, Name: name, Size: size}
+	}
+	raw := func(name string) wad.Tag {
+		return wad.Tag{Tag: wad.TAG_GOW1_FILE_RAW_DATA, Name: name, Data: []byte{1}}
+	}
+	// total count of entities equals to sum of heaps, but they are placed in wrong heaps
+	data := wad.MarshalTags([]wad.Tag{
+		heap("HEAP_A", 3), raw("SCR_A"),
+		heap("HEAP_B", 1), raw("SCR_B"),
+	})
+	w, err := wad.NewWad(bytes.NewReader(data), testSource("R_TEST.WAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities := map[string]int{"SCR_A": 1, "SCR_B": 2}
+
+	counts := countHeapEntities(w, func(w *wad.Wad, n *wad.Node) int { return entities[n.Tag.Name] })
+	if len(counts) != 2 || counts["HEAP_A"] != 1 || counts["HEAP_B"] != 2 {
+		t.Fatalf("Unexpected entities of heaps %v", counts)
+	}
+
+	report := &wad.ValidationReport{}
+	checkHeapEntities(w, counts, report)
+	if len(report.Issues) != 2 {
+		t.Fatalf("Unexpected issues %+v", report.Issues)
+	}
+	for _, issue := range report.Issues {
+		expected := map[string]string{"HEAP_A": wad.ISSUE_WARNING, "HEAP_B": wad.ISSUE_ERROR}[issue.Name]
+		if issue.Level != expected {
+			t.Errorf("Unexpected issue %+v", issue)
+		}
+	}
+
+	report = &wad.ValidationReport{}
+	checkHeapEntities(w, map[string]int{"": 1, "HEAP_A": 3, "HEAP_B": 1}, report)
+	if len(report.Issues) != 1 || report.Issues[0].Level != wad.ISSUE_ERROR {
+		t.Errorf("Entities before entity count tags not reported: %+v", report.Issues)
+	}
+}
@@ -3,6 +3,7 @@ package twk
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -58,40 +59,44 @@ func (t *TWK) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.
 		}
 		defer eff.Close()
 
-		var fake TWK
-		if err := yaml.NewDecoder(eff).Decode(&fake); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to unmarshal yaml"))
+		if err := t.FromYaml(wrsrc, eff); err != nil {
+			webutils.WriteError(w, err)
 			return
 		}
+	}
+}
 
-		if fake.AbstractTree != nil {
-			// convert abstact tree json repr to tree
-			root, err := twktree.Root().MarshalTWK(fake.AbstractTree)
-			if err != nil {
-				webutils.WriteError(w, errors.Wrapf(err, "Failed to convert abstract tree"))
-				return
-			}
-			fake.Tree = root
-		}
+// FromYaml replaces tweak with one produced from yaml representation
+func (t *TWK) FromYaml(wrsrc *wad.WadNodeRsrc, r io.Reader) error {
+	var fake TWK
+	if err := yaml.NewDecoder(r).Decode(&fake); err != nil {
+		return errors.Wrapf(err, "Failed to unmarshal yaml")
+	}
 
-		var buffer bytes.Buffer
-		if err := fake.Produce(&buffer); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to produce binary"))
-			return
+	if fake.AbstractTree != nil {
+		// convert abstact tree json repr to tree
+		root, err := twktree.Root().MarshalTWK(fake.AbstractTree)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to convert abstract tree")
 		}
+		fake.Tree = root
+	}
 
-		utils.LogDump(buffer.Bytes())
+	var buffer bytes.Buffer
+	if err := fake.Produce(&buffer); err != nil {
+		return errors.Wrapf(err, "Failed to produce binary")
+	}
 
-		if _, err := NewTwkFromData(utils.NewBufStack("testtwk", buffer.Bytes())); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed sanity check"))
-			return
-		}
+	utils.LogDump(buffer.Bytes())
 
-		if err := wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
-			wrsrc.Tag.Id: buffer.Bytes(),
-		}); err != nil {
-			webutils.WriteError(w, errors.Wrapf(err, "Failed to write tag"))
-			return
-		}
+	if _, err := NewTwkFromData(utils.NewBufStack("testtwk", buffer.Bytes())); err != nil {
+		return errors.Wrapf(err, "Failed sanity check")
+	}
+
+	if err := wrsrc.Wad.UpdateTagsData(map[wad.TagId][]byte{
+		wrsrc.Tag.Id: buffer.Bytes(),
+	}); err != nil {
+		return errors.Wrapf(err, "Failed to write tag")
 	}
+	return nil
 }
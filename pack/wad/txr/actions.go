@@ -1,11 +1,13 @@
 package txr
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	_ "image/gif"
@@ -15,6 +17,7 @@ import (
 	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/pack/wad"
 	file_gfx "github.com/mogaika/god_of_war_browser/pack/wad/gfx"
+	"github.com/mogaika/god_of_war_browser/webutils"
 )
 
 func (txr *Texture) changeTexturePS2(wrsrc *wad.WadNodeRsrc, img image.Image, createNewPal bool) error {
@@ -151,6 +154,27 @@ func gfxSecondPaletteToGrayscale(palc *file_gfx.GFX) error {
 
 func (txr *Texture) HttpAction(wrsrc *wad.WadNodeRsrc, w http.ResponseWriter, r *http.Request, action string) {
 	switch action {
+	case "png":
+		q := r.URL.Query()
+		gfx, _ := strconv.Atoi(q.Get("gfx"))
+		pal, _ := strconv.Atoi(q.Get("pal"))
+
+		res, err := txr.Marshal(wrsrc)
+		if err != nil {
+			webutils.WriteError(w, err)
+			return
+		}
+		for _, img := range res.(*Ajax).Images {
+			if img.Gfx == gfx && img.Pal == pal {
+				name := wrsrc.Name()
+				if gfx != 0 || pal != 0 {
+					name = fmt.Sprintf("%s_%d_%d", name, gfx, pal)
+				}
+				webutils.WriteFile(w, bytes.NewReader(img.Image), name+".png")
+				return
+			}
+		}
+		webutils.WriteError(w, fmt.Errorf("Image gfx %d pal %d not found", gfx, pal))
 	case "upload":
 		q := r.URL.Query()
 		createNewPal := strings.ToLower(q.Get("create_new_pal")) == "true"
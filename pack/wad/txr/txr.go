@@ -238,6 +238,10 @@ func (txr *Texture) MarshalBlend(clrBlend []float32, wrsrc *wad.WadNodeRsrc) (in
 	return res, nil
 }
 
+func (t *Texture) References(wrsrc *wad.WadNodeRsrc) []wad.Reference {
+	return []wad.Reference{wad.NodeRef(t.GfxName), wad.NodeRef(t.PalName), wad.NodeRef(t.SubTxrName)}
+}
+
 func (t *Texture) Marshal(wrsrc *wad.WadNodeRsrc) (interface{}, error) {
 	return t.MarshalBlend(nil, wrsrc)
 }
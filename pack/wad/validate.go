@@ -0,0 +1,206 @@
+package wad
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mogaika/god_of_war_browser/pack"
+)
+
+const (
+	ISSUE_ERROR   = "error"
+	ISSUE_WARNING = "warning"
+)
+
+type Issue struct {
+	Level   string
+	Check   string
+	TagId   TagId // NODE_INVALID for issues of whole wad
+	Name    string
+	Message string
+}
+
+// ValidationReport returned by Save as error if changes produce errors
+type ValidationReport struct {
+	Wad    string
+	Issues []*Issue
+}
+
+// Validator implemented by files which can check own data,
+// every returned message is error
+type Validator interface {
+	Validate(wrsrc *WadNodeRsrc) []string
+}
+
+// WadCheck adds issues of whole wad to report
+type WadCheck func(w *Wad, report *ValidationReport)
+
+var gWadChecks = map[string]WadCheck{
+	"groups":     checkGroups,
+	"references": checkReferences,
+}
+
+// SetWadCheck registers check for packages which wad package cannot import
+func SetWadCheck(name string, check WadCheck) {
+	if _, ok := gWadChecks[name]; ok {
+		log.Panicf("Trying to override wad check %q", name)
+	}
+	gWadChecks[name] = check
+}
+
+func (r *ValidationReport) Add(level, check string, tag *Tag, format string, args ...interface{}) {
+	issue := &Issue{Level: level, Check: check, TagId: NODE_INVALID, Message: fmt.Sprintf(format, args...)}
+	if tag != nil {
+		issue.TagId = tag.Id
+		issue.Name = tag.Name
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+func (r *ValidationReport) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Level == ISSUE_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+func issueKey(issue *Issue) string {
+	// tag id not used, because it changes when tags inserted
+	return issue.Level + "|" + issue.Check + "|" + issue.Name + "|" + issue.Message
+}
+
+// Exclude returns report without issues presented in other report
+func (r *ValidationReport) Exclude(other *ValidationReport) *ValidationReport {
+	known := make(map[string]bool, len(other.Issues))
+	for _, issue := range other.Issues {
+		known[issueKey(issue)] = true
+	}
+	result := &ValidationReport{Wad: r.Wad}
+	for _, issue := range r.Issues {
+		if !known[issueKey(issue)] {
+			result.Issues = append(result.Issues, issue)
+		}
+	}
+	return result
+}
+
+func (r *ValidationReport) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Validation of %s failed:", r.Wad)
+	for _, issue := range r.Issues {
+		fmt.Fprintf(&sb, "\n%s [%s]", issue.Level, issue.Check)
+		if issue.TagId != NODE_INVALID {
+			fmt.Fprintf(&sb, " tag %d %q", issue.TagId, issue.Name)
+		}
+		fmt.Fprintf(&sb, ": %s", issue.Message)
+	}
+	return sb.String()
+}
+
+// ErrorDetails used by web to return report as json
+func (r *ValidationReport) ErrorDetails() interface{} {
+	return r
+}
+
+// Validate loads every node which has handler and runs registered checks
+func (w *Wad) Validate() *ValidationReport {
+	report := &ValidationReport{Wad: w.Name()}
+	for _, n := range w.Nodes {
+		if w.GetNodeById(n.Id) != n {
+			// link to previously loaded node
+			continue
+		}
+		if !HasHandler(n) {
+			continue
+		}
+		w.validateNode(n, report)
+	}
+
+	names := make([]string, 0, len(gWadChecks))
+	for name := range gWadChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		gWadChecks[name](w, report)
+	}
+	return report
+}
+
+func (w *Wad) validateNode(n *Node, report *ValidationReport) {
+	defer func() {
+		if r := recover(); r != nil {
+			report.Add(ISSUE_ERROR, "loader", n.Tag, "Loader panic: %v", r)
+		}
+	}()
+
+	inst, _, err := w.GetInstanceFromNode(n.Id)
+	if err != nil {
+		report.Add(ISSUE_ERROR, "loader", n.Tag, "%v", err)
+		return
+	}
+	if v, ok := inst.(Validator); ok {
+		for _, msg := range v.Validate(w.GetNodeResourceByNodeId(n.Id)) {
+			report.Add(ISSUE_ERROR, "data", n.Tag, "%s", msg)
+		}
+	}
+}
+
+func checkGroups(w *Wad, report *ValidationReport) {
+	start, end := getGroupTags()
+	depth := 0
+	for i := range w.Tags {
+		t := &w.Tags[i]
+		switch t.Tag {
+		case start:
+			depth++
+		case end:
+			if depth--; depth < 0 {
+				report.Add(ISSUE_ERROR, "groups", t, "Group end without group start")
+				depth = 0
+			}
+		}
+	}
+	if depth != 0 {
+		report.Add(ISSUE_ERROR, "groups", nil, "%d groups not ended", depth)
+	}
+}
+
+// checkReferences looks for referenced names in wad and in wads listed by rsrcs.
+// If directory of wad unknown, names not found in wad only reported as warnings
+func checkReferences(w *Wad, report *ValidationReport) {
+	d := pack.SourceDirectory(w.Source)
+	var open func(name string) (*Wad, error)
+	if d != nil {
+		open = func(name string) (*Wad, error) { return OpenWad(d, name) }
+	}
+	r := NewNameResolver(w, open)
+
+	for _, n := range w.Nodes {
+		if w.GetNodeById(n.Id) != n {
+			continue
+		}
+		// loader errors already reported
+		refs, _, _ := w.InstanceReferences(n.Id)
+		for _, ref := range refs {
+			if ref.Kind != REF_NODE {
+				continue
+			}
+			if _, found := r.Resolve(n.Id, ref.Name); found != nil {
+				continue
+			}
+			if d == nil {
+				report.Add(ISSUE_WARNING, "references", n.Tag, "Name %q not found in wad", ref.Name)
+			} else {
+				report.Add(ISSUE_ERROR, "references", n.Tag, "Name %q not found in wad and rsrcs wads", ref.Name)
+			}
+		}
+	}
+	for _, name := range r.SortedOpenErrors() {
+		report.Add(ISSUE_ERROR, "references", nil, "Failed to open rsrcs wad %q: %v", name, r.OpenErrors[name])
+	}
+}
@@ -0,0 +1,37 @@
+package wad
+
+import (
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/config"
+)
+
+func TestSaveValidation(t *testing.T) {
+	defer config.SetGOWVersion(config.GetGOWVersion())
+	config.SetGOWVersion(config.GOW1)
+
+	raw := Tag{Tag: TAG_GOW1_FILE_RAW_DATA, Name: "RAW", Data: []byte("a")}
+	groupStart := Tag{Tag: TAG_GOW1_FILE_GROUP_START}
+
+	w := newTestWad(t, "A.WAD", []Tag{raw})
+	err := w.InsertNewTags(1, []Tag{groupStart})
+	report, ok := err.(*ValidationReport)
+	if !ok {
+		t.Fatalf("Expected validation report, got %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Check != "groups" {
+		t.Errorf("Unexpected issues %v", report)
+	}
+	if len(w.Tags) != 1 {
+		t.Errorf("Wad changed by refused save")
+	}
+
+	// issues presented before change do not block save
+	w = newTestWad(t, "B.WAD", []Tag{groupStart, raw})
+	if !w.Validate().HasErrors() {
+		t.Errorf("Expected not ended group error")
+	}
+	if err := w.UpdateTagsData(map[TagId][]byte{1: []byte("b")}); err != nil {
+		t.Errorf("Save failed: %v", err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mogaika/god_of_war_browser/pack/wad/scr/entitycontext"
 
@@ -43,6 +45,20 @@ func SetTagHandler(tag uint16, ldr FileLoader) {
 	gTagHandlers[tag] = ldr
 }
 
+type tagDataHandler struct {
+	match func(data []byte) bool
+	ldr   FileLoader
+}
+
+var gTagDataHandlers map[uint64][]tagDataHandler = make(map[uint64][]tagDataHandler, 0)
+
+// SetTagDataHandler registers loader only for tags with data accepted by match,
+// for tags shared by different formats without server id (like raw data tag)
+func SetTagDataHandler(version config.GOWVersion, tag uint16, match func(data []byte) bool, ldr FileLoader) {
+	key := (uint64(version) << 32) | uint64(tag)
+	gTagDataHandlers[key] = append(gTagDataHandlers[key], tagDataHandler{match: match, ldr: ldr})
+}
+
 type NodeId int
 type TagId int
 
@@ -68,6 +84,10 @@ type Tag struct {
 	Data   []byte `json:"-"`
 	NodeId NodeId
 
+	// gow2018 only header fields, preserved on save
+	GUID       [0x10]byte `json:"-"`
+	HeaderTail []byte     `json:"-"`
+
 	DebugPos uint32
 }
 
@@ -80,13 +100,16 @@ type Node struct {
 	CachedServerId uint32
 }
 
-func (w *Wad) CallHandler(id NodeId) (File, uint32, error) {
-	var h FileLoader
-	var serverId uint32
-
-	n := w.GetNodeById(id)
+func findHandler(n *Node) (h FileLoader, serverId uint32) {
 	if han, ex := gTagHandlers[n.Tag.Tag]; ex {
 		h = han
+	} else if hans, ex := gTagDataHandlers[(uint64(config.GetGOWVersion())<<32)|uint64(n.Tag.Tag)]; ex {
+		for _, han := range hans {
+			if han.match(n.Tag.Data) {
+				h = han.ldr
+				break
+			}
+		}
 	} else if n.Tag.Tag == GetServerInstanceTag() {
 		if n.Tag.Data != nil && len(n.Tag.Data) >= 4 {
 			serverId = binary.LittleEndian.Uint32(n.Tag.Data)
@@ -95,6 +118,18 @@ func (w *Wad) CallHandler(id NodeId) (File, uint32, error) {
 			}
 		}
 	}
+	return h, serverId
+}
+
+// HasHandler reports if loader registered for node tag or server id
+func HasHandler(n *Node) bool {
+	h, _ := findHandler(n)
+	return h != nil
+}
+
+func (w *Wad) CallHandler(id NodeId) (File, uint32, error) {
+	n := w.GetNodeById(id)
+	h, serverId := findHandler(n)
 	if h == nil {
 		return nil, serverId, fmt.Errorf("Cannot find handler for tag %.4x (%s)", n.Tag.Tag, n.Tag.Name)
 	}
@@ -124,14 +159,16 @@ func (w *Wad) GetInstanceFromNode(nodeId NodeId) (File, uint32, error) {
 
 func UnmarshalTag(buf []byte) Tag {
 	if config.GetGOWVersion() == config.GOW2018 {
-		return Tag{
-			Tag:   binary.LittleEndian.Uint16(buf[0:2]),
-			Flags: binary.LittleEndian.Uint16(buf[2:4]),
-			Size:  binary.LittleEndian.Uint32(buf[4:8]),
-			// guid
-			Name:   utils.BytesToString(buf[0x18:0x50]),
-			NodeId: NODE_INVALID,
+		t := Tag{
+			Tag:        binary.LittleEndian.Uint16(buf[0:2]),
+			Flags:      binary.LittleEndian.Uint16(buf[2:4]),
+			Size:       binary.LittleEndian.Uint32(buf[4:8]),
+			Name:       utils.BytesToString(buf[0x18:0x50]),
+			HeaderTail: append([]byte(nil), buf[0x50:TAG_GOW2018_SIZE]...),
+			NodeId:     NODE_INVALID,
 		}
+		copy(t.GUID[:], buf[8:0x18])
+		return t
 	} else {
 		return Tag{
 			Tag:    binary.LittleEndian.Uint16(buf[0:2]),
@@ -144,6 +181,17 @@ func UnmarshalTag(buf []byte) Tag {
 }
 
 func MarshalTag(t *Tag) []byte {
+	if config.GetGOWVersion() == config.GOW2018 {
+		buf := make([]byte, TAG_GOW2018_SIZE)
+		binary.LittleEndian.PutUint16(buf[0:2], t.Tag)
+		binary.LittleEndian.PutUint16(buf[2:4], t.Flags)
+		binary.LittleEndian.PutUint32(buf[4:8], t.Size)
+		copy(buf[8:0x18], t.GUID[:])
+		copy(buf[0x18:0x50], utils.StringToBytesBuffer(t.Name, 0x38, false))
+		copy(buf[0x50:], t.HeaderTail)
+		return buf
+	}
+
 	buf := make([]byte, WAD_ITEM_SIZE)
 	binary.LittleEndian.PutUint16(buf[0:2], t.Tag)
 	binary.LittleEndian.PutUint16(buf[2:4], t.Flags)
@@ -361,17 +409,33 @@ func (w *Wad) GetNodeByName(name string, searchStart NodeId, searchForward bool)
 	return nil
 }
 
+// ResolveName looks for node same way as game does:
+// previously loaded nodes first, then any root node of wad
+func (w *Wad) ResolveName(from NodeId, name string) *Node {
+	if n := w.GetNodeByName(name, from, false); n != nil {
+		return n
+	}
+	return w.GetNodeByName(name, 0, true)
+}
+
 func alignToWadTag(pos int) int {
 	return ((pos + 15) / 16) * 16
 }
 
-func (w *Wad) Save(tags []Tag) error {
+// MarshalTags serializes tags to wad data. Zero sized tags keep their
+// size, size of other tags calculated from data
+func MarshalTags(tags []Tag) []byte {
 	var buf bytes.Buffer
 
 	for _, t := range tags {
-		if isZeroSizedTag(&t) {
-			t.Size = w.HeapSizes[t.Name]
-		} else {
+		switch {
+		case isZeroSizedTag(&t):
+			// size of zero sized tag is heap size, not size of data
+		case isAutoPadTag(&t):
+			// data size changes, so padding recalculated to keep following tags aligned
+			t.Data = make([]byte, autoPadSize(buf.Len()))
+			t.Size = uint32(len(t.Data))
+		default:
 			t.Size = uint32(len(t.Data))
 		}
 
@@ -381,6 +445,26 @@ func (w *Wad) Save(tags []Tag) error {
 			buf.Write(make([]byte, alignToWadTag(buf.Len())-buf.Len()))
 		}
 	}
+	return buf.Bytes()
+}
+
+func (w *Wad) Save(tags []Tag) error {
+	tags = append([]Tag(nil), tags...)
+	for i := range tags {
+		if isZeroSizedTag(&tags[i]) {
+			tags[i].Size = w.HeapSizes[tags[i].Name]
+		}
+	}
+	buf := bytes.NewBuffer(MarshalTags(tags))
+
+	check, err := NewWad(bytes.NewReader(buf.Bytes()), w.Source)
+	if err != nil {
+		return fmt.Errorf("Error when perfoming reload sanity check: %v", err)
+	}
+	// issues already presented in wad do not block save
+	if report := check.Validate().Exclude(w.Validate()); report.HasErrors() {
+		return report
+	}
 
 	w.flushCache()
 	// sanity check for not corrupting wad and also update wad structure to collect changes
@@ -394,28 +478,34 @@ func (w *Wad) Save(tags []Tag) error {
 	return w.Source.Save(io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len())))
 }
 
+// tags are changed on copy, so wad stays same if save refused
 func (w *Wad) InsertNewTags(insertAfterId TagId, newTags []Tag) error {
-	updatedTagsArray := append(w.Tags[:insertAfterId], append(newTags, w.Tags[insertAfterId:]...)...)
+	updatedTagsArray := make([]Tag, 0, len(w.Tags)+len(newTags))
+	updatedTagsArray = append(updatedTagsArray, w.Tags[:insertAfterId]...)
+	updatedTagsArray = append(updatedTagsArray, newTags...)
+	updatedTagsArray = append(updatedTagsArray, w.Tags[insertAfterId:]...)
 	return w.Save(updatedTagsArray)
 }
 
 func (w *Wad) UpdateTagInfo(updateTags map[TagId]Tag) error {
+	tags := append([]Tag(nil), w.Tags...)
 	for i, newTag := range updateTags {
-		t := &w.Tags[i]
+		t := &tags[i]
 		log.Printf("Updating tag %x-%s to %x-%s", t.Id, t.Name, newTag.Id, newTag.Name)
-		w.Tags[i] = newTag
+		tags[i] = newTag
 	}
-	return w.Save(w.Tags)
+	return w.Save(tags)
 }
 
 func (w *Wad) UpdateTagsData(updateData map[TagId][]byte) error {
+	tags := append([]Tag(nil), w.Tags...)
 	for i, newData := range updateData {
-		t := &w.Tags[i]
+		t := &tags[i]
 		log.Println("Changing size at ", t.Name, " from ", t.Size, " to ", len(newData))
 		t.Data = newData
 		t.Size = uint32(len(newData))
 	}
-	return w.Save(w.Tags)
+	return w.Save(tags)
 }
 
 func (w *Wad) flushCache() {
@@ -471,6 +561,15 @@ func (r *WadNodeRsrc) Size() int64 {
 	return int64(r.Node.Tag.Size)
 }
 
+// IsWadFileName checks extension of pack file same way as handlers registered
+func IsWadFileName(name string) bool {
+	switch strings.ToUpper(filepath.Ext(name)) {
+	case ".WAD", ".WAD_PS3", ".WAD_PSP2":
+		return true
+	}
+	return false
+}
+
 func init() {
 	pack.SetHandler(".WAD", func(p utils.ResourceSource, r *io.SectionReader) (interface{}, error) {
 		return NewWad(r, p)
@@ -1,93 +1,260 @@
 package main
 
 import (
-	"github.com/mogaika/god_of_war_browser/pack/wad/twk"
-	"github.com/mogaika/god_of_war_browser/pack/wad/twk/twktree"
-
-	//"encoding/binary"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/mogaika/god_of_war_browser/config"
 	"github.com/mogaika/god_of_war_browser/pack"
 	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
-
-	//	"github.com/mogaika/god_of_war_browser/pack/wad/mesh"
+	"github.com/mogaika/god_of_war_browser/pack/wad/anm"
+	"github.com/mogaika/god_of_war_browser/pack/wad/flp"
+	file_gfx "github.com/mogaika/god_of_war_browser/pack/wad/gfx"
+	"github.com/mogaika/god_of_war_browser/pack/wad/mesh"
+	"github.com/mogaika/god_of_war_browser/pack/wad/obj"
+	"github.com/mogaika/god_of_war_browser/pack/wad/rsrcs"
+	"github.com/mogaika/god_of_war_browser/pack/wad/twk"
+	"github.com/mogaika/god_of_war_browser/pack/wad/twk/twktree"
+	"github.com/mogaika/god_of_war_browser/pack/wad/txr"
 	"github.com/mogaika/god_of_war_browser/vfs"
 )
 
-func parseCheck(rootfs vfs.Directory) {
+const (
+	PARSECHECK_STAGE_PARSE     = "parse"
+	PARSECHECK_STAGE_REMARSHAL = "remarshal"
+	PARSECHECK_STAGE_TWKTREE   = "twktree"
+	PARSECHECK_STAGE_STREAMS   = "streams"
+)
+
+type parseCheckStats struct {
+	Parsed int
+	Failed int
+	// only for types which can be marshaled back
+	Remarshaled       int `json:",omitempty"`
+	RemarshalMismatch int `json:",omitempty"`
+	RemarshalFailed   int `json:",omitempty"`
+}
+
+type parseCheckFailure struct {
+	File  string
+	TagId file_wad.TagId // NODE_INVALID for pack file failures
+	Name  string         `json:",omitempty"`
+	Type  string
+	Stage string
+	Error string
+}
+
+// parseCheckReport can be compared with report of previous version
+// to find regressions of format parsers
+type parseCheckReport struct {
+	GOWVersion config.GOWVersion
+	PSVersion  config.PSVersion
+	Files      int
+	// key is "server 0x00000007", "tag 0x0071" or "pack .VAG"
+	Types         map[string]*parseCheckStats
+	Failures      []*parseCheckFailure
+	NameConflicts []string
+}
+
+func (r *parseCheckReport) stats(typ string) *parseCheckStats {
+	s, ok := r.Types[typ]
+	if !ok {
+		s = &parseCheckStats{}
+		r.Types[typ] = s
+	}
+	return s
+}
+
+func (r *parseCheckReport) fail(file string, tag *file_wad.Tag, typ, stage string, err error) {
+	f := &parseCheckFailure{File: file, TagId: file_wad.NODE_INVALID, Type: typ, Stage: stage, Error: err.Error()}
+	if tag != nil {
+		f.TagId = tag.Id
+		f.Name = tag.Name
+	}
+	r.Failures = append(r.Failures, f)
+}
+
+// parseCheck runs every registered handler on every file and wad node
+// and writes json report to outPath
+func parseCheck(rootfs vfs.Directory, outPath string) error {
 	packList, err := rootfs.List()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	sort.Strings(packList)
 
-	sort.Sort(sort.Reverse(sort.StringSlice(packList)))
+	report := &parseCheckReport{
+		GOWVersion:    config.GetGOWVersion(),
+		PSVersion:     config.GetPlayStationVersion(),
+		Types:         make(map[string]*parseCheckStats),
+		Failures:      make([]*parseCheckFailure, 0),
+		NameConflicts: make([]string, 0),
+	}
 
 	for _, fname := range packList {
-		if !strings.HasSuffix(fname, ".WAD") && !strings.HasSuffix(fname, ".wad_psp2") {
+		if !pack.HasHandler(fname) {
 			continue
 		}
 		log.Printf("Parsecheck %q", fname)
-		data, _ := pack.GetInstanceHandler(rootfs, fname)
-		if data == nil {
+		report.Files++
+
+		typ := "pack " + strings.ToUpper(filepath.Ext(fname))
+		data, err := parseCheckCall(func() (interface{}, error) {
+			return pack.GetInstanceHandler(rootfs, fname)
+		})
+		if err != nil {
+			report.stats(typ).Failed++
+			report.fail(fname, nil, typ, PARSECHECK_STAGE_PARSE, err)
 			continue
 		}
-		switch data.(type) {
-		case *file_wad.Wad:
-			wad := data.(*file_wad.Wad)
-			for _, node := range wad.Nodes {
-				if node.Parent != file_wad.NODE_INVALID {
-					continue
-				}
-
-				if strings.HasPrefix(node.Tag.Name, "TWK_") {
-					// log.Printf("Parsing %s:%s: %v", wad.Name(), node.Tag.Name, err)
-					fi, _, err := wad.GetInstanceFromNode(node.Id)
-					if err != nil {
-						// log.Printf("Failed to parse %s:%s: %v", wad.Name(), node.Tag.Name, err)
-					} else if tw, ok := fi.(*twk.TWK); !ok {
-						log.Printf("Failed to get twk from %s:%s", wad.Name(), node.Tag.Name)
-					} else {
-						if _, err := twktree.Root().UnmarshalTWK(tw.Tree); err != nil {
-							log.Printf("Failed to parse abstarct tree for %s:%s: %v", wad.Name(), node.Tag.Name, err)
-						}
-					}
-
-				}
-
-				/*
-					if strings.HasPrefix(node.Tag.Name, "ESC_") {
-						log.Printf("Parsing %s:%s: %v", wad.Name(), node.Tag.Name, err)
-						_, _, err := wad.GetInstanceFromNode(node.Id)
-						if err != nil {
-							log.Printf("Failed to parse %s:%s: %v", wad.Name(), node.Tag.Name, err)
-						}
-					}
-				*/
-
-				/*
-					if len(node.Tag.Data) != 0 {
-						for _, onode := range wad.Nodes {
-							if onode.Parent != file_wad.NODE_INVALID || len(onode.Tag.Data) == 0 {
-								continue
-							}
-
-							if onode.Tag.Id >= node.Tag.Id {
-								break
-							}
-
-							if node.Tag.Name == onode.Tag.Name {
-								log.Printf("Conflicting name %q [%d:%q:%d] [%d:%q:%d]",
-									wad.Name(),
-									node.Tag.Id, node.Tag.Name, node.Tag.Size,
-									onode.Tag.Id, onode.Tag.Name, node.Tag.Size)
-								break
-							}
-						}
-					}
-				*/
+		report.stats(typ).Parsed++
+
+		if wad, ok := data.(*file_wad.Wad); ok {
+			parseCheckWad(report, wad)
+		}
+	}
+
+	types := make([]string, 0, len(report.Types))
+	for typ := range report.Types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		s := report.Types[typ]
+		log.Printf("Parsecheck %s: %d parsed, %d failed, %d remarshaled, %d mismatched, %d remarshal failed",
+			typ, s.Parsed, s.Failed, s.Remarshaled, s.RemarshalMismatch, s.RemarshalFailed)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// parseCheckCall converts handler panic to error
+func parseCheckCall(f func() (interface{}, error)) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+func parseCheckWad(report *parseCheckReport, wad *file_wad.Wad) {
+	for _, node := range wad.Nodes {
+		if wad.GetNodeById(node.Id) != node || !file_wad.HasHandler(node) {
+			continue
+		}
+
+		var typ string
+		if node.Tag.Tag == file_wad.GetServerInstanceTag() {
+			typ = fmt.Sprintf("server 0x%.8x", binary.LittleEndian.Uint32(node.Tag.Data))
+		} else {
+			typ = fmt.Sprintf("tag 0x%.4x", node.Tag.Tag)
+		}
+		s := report.stats(typ)
+
+		inst, err := parseCheckCall(func() (interface{}, error) {
+			inst, _, err := wad.GetInstanceFromNode(node.Id)
+			return inst, err
+		})
+		if err != nil {
+			s.Failed++
+			report.fail(wad.Name(), node.Tag, typ, PARSECHECK_STAGE_PARSE, err)
+			continue
+		}
+		s.Parsed++
+
+		remarshaled, err := parseCheckCall(func() (interface{}, error) {
+			return parseCheckRemarshal(inst)
+		})
+		if err != nil {
+			s.RemarshalFailed++
+			report.fail(wad.Name(), node.Tag, typ, PARSECHECK_STAGE_REMARSHAL, err)
+		} else if b, ok := remarshaled.([]byte); ok {
+			s.Remarshaled++
+			if !bytes.Equal(b, node.Tag.Data) {
+				s.RemarshalMismatch++
+				report.fail(wad.Name(), node.Tag, typ, PARSECHECK_STAGE_REMARSHAL,
+					fmt.Errorf("Data mismatch at 0x%x (size %d, remarshaled %d)", firstDifference(b, node.Tag.Data), len(node.Tag.Data), len(b)))
+			}
+		}
+
+		if tw, ok := inst.(*twk.TWK); ok {
+			if _, err := twktree.Root().UnmarshalTWK(tw.Tree); err != nil {
+				report.fail(wad.Name(), node.Tag, typ, PARSECHECK_STAGE_TWKTREE, err)
 			}
 		}
+		// animations of versions with unverified stream layouts load without broken streams
+		if a, ok := inst.(*anm.Animations); ok {
+			for _, e := range a.StreamErrors() {
+				report.fail(wad.Name(), node.Tag, typ, PARSECHECK_STAGE_STREAMS, errors.New(e))
+			}
+		}
+	}
+
+	// data nodes which overwrite previous root node with same name
+	firstByName := make(map[string]*file_wad.Node)
+	for _, id := range wad.Roots {
+		node := wad.Nodes[id]
+		if len(node.Tag.Data) == 0 {
+			continue
+		}
+		if other, ok := firstByName[node.Tag.Name]; ok {
+			report.NameConflicts = append(report.NameConflicts, fmt.Sprintf("%s: %q tags %d and %d",
+				wad.Name(), node.Tag.Name, other.Tag.Id, node.Tag.Id))
+		} else {
+			firstByName[node.Tag.Name] = node
+		}
+	}
+}
+
+// parseCheckRemarshal returns binary form of instance,
+// or nil for types which cannot be written back
+func parseCheckRemarshal(inst interface{}) (interface{}, error) {
+	switch v := inst.(type) {
+	case *txr.Texture:
+		return v.MarshalToBinary(), nil
+	case *file_gfx.GFX:
+		return v.MarshalToBinary()
+	case *rsrcs.RSRCS:
+		return v.MarshalData().Bytes(), nil
+	case *mesh.Mesh:
+		return v.MarshalBuffer().Bytes(), nil
+	case *obj.Object:
+		return v.MarshalBuffer().Bytes(), nil
+	case *flp.FLP:
+		return v.MarshalToBinary(), nil
+	case *twk.TWK:
+		var buf bytes.Buffer
+		err := v.Produce(&buf)
+		return buf.Bytes(), err
+	}
+	return nil, nil
+}
+
+func firstDifference(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
 	}
+	return len(b)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_browser/pack/patch"
+	"github.com/mogaika/god_of_war_browser/vfs"
+)
+
+// patchMain creates or applies tag level patch between clean and modded game data:
+// god_of_war_browser patch create -orig-iso GOW.iso -iso MOD.iso -out mod.gowpatch
+// god_of_war_browser patch create -iso GOW.iso -overlay mod -out mod.gowpatch
+// god_of_war_browser patch apply -iso GOW.iso -patch mod.gowpatch
+func patchMain(args []string) {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "apply") {
+		fmt.Fprintln(os.Stderr, "Usage: patch create|apply [flags]")
+		os.Exit(2)
+	}
+	mode := args[0]
+
+	fs := flag.NewFlagSet("patch "+mode, flag.ExitOnError)
+	var source, original sourceFlags
+	var patchPath string
+	source.register(fs)
+	if mode == "create" {
+		original.registerPaths(fs, "orig-", "Clean game data: ")
+		fs.StringVar(&patchPath, "out", "mod.gowpatch", "Output patch file")
+	} else {
+		fs.StringVar(&patchPath, "patch", "", "Patch file")
+	}
+	fs.Parse(args[1:])
+
+	if mode == "create" {
+		original.psversion, original.gowversion, original.encoding = source.psversion, source.gowversion, source.encoding
+		originalDir, _, err := original.open(true)
+		if err != nil && err != errNoSource {
+			log.Fatalf("Cannot open clean game data: %v", err)
+		}
+		moddedDir, _, err := source.open(true)
+		if err == errNoSource {
+			fs.PrintDefaults()
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalf("Cannot open modded game data: %v", err)
+		}
+		if originalDir == nil {
+			// overlay is mod of game data under it
+			o, ok := moddedDir.(*vfs.Overlay)
+			if !ok {
+				fs.PrintDefaults()
+				os.Exit(2)
+			}
+			originalDir = o.Base()
+		}
+
+		f, err := os.Create(patchPath)
+		if err != nil {
+			log.Fatalf("Failed to create patch file: %v", err)
+		}
+		if err := patch.Create(originalDir, moddedDir, f); err != nil {
+			f.Close()
+			os.Remove(patchPath)
+			log.Fatalf("Failed to create patch: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write patch file: %v", err)
+		}
+		log.Printf("Patch written to %q", patchPath)
+		return
+	}
+
+	if patchPath == "" {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	f, err := os.Open(patchPath)
+	if err != nil {
+		log.Fatalf("Failed to open patch: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		log.Fatalf("Failed to stat patch: %v", err)
+	}
+
+	gameDir, driverDir, err := source.open(false)
+	if err == errNoSource {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Cannot open game data: %v", err)
+	}
+
+	if err := patch.Apply(gameDir, f, stat.Size()); err != nil {
+		log.Fatalf("Failed to apply patch: %v", err)
+	}
+	for _, d := range []vfs.Directory{gameDir, driverDir} {
+		if s, ok := d.(vfs.Syncer); ok {
+			if err := s.Sync(); err != nil {
+				log.Fatalf("Failed to sync: %v", err)
+			}
+		}
+	}
+	log.Printf("Patch applied")
+}
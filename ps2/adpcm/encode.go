@@ -0,0 +1,131 @@
+package adpcm
+
+import (
+	"math"
+)
+
+// flags stored in second byte of block
+const (
+	FLAG_LOOP_END    = 0x01 // jump to loop start, or stop if no repeat flag
+	FLAG_LOOP_REPEAT = 0x02
+	FLAG_LOOP_START  = 0x04
+)
+
+const BLOCK_SAMPLES = 28
+const BLOCK_SIZE = 16
+
+// in - 16 bit pcm samples
+// out - adpcm blocks
+type AdpcmEncoder struct {
+	// history of decoder, not of source samples
+	hist1 float64
+	hist2 float64
+}
+
+func NewAdpcmEncoder() *AdpcmEncoder {
+	return &AdpcmEncoder{}
+}
+
+// encodeWith quantizes samples using filter and shift, returns nibbles, error and decoder history
+func (e *AdpcmEncoder) encodeWith(samples *[BLOCK_SAMPLES]float64, filter, shift uint32) (
+	nibbles [BLOCK_SAMPLES]int8, sqError float64, hist1, hist2 float64) {
+
+	hist1, hist2 = e.hist1, e.hist2
+	step := float64(int(1) << (12 - shift))
+	for i, s := range samples {
+		predicted := hist1*vag_f[filter][0] + hist2*vag_f[filter][1]
+		n := math.Round((s - predicted) / step)
+		if n > 7 {
+			n = 7
+		} else if n < -8 {
+			n = -8
+		}
+		nibbles[i] = int8(n)
+
+		decoded := n*step + predicted
+		sqError += (s - decoded) * (s - decoded)
+		hist2 = hist1
+		hist1 = decoded
+	}
+	return
+}
+
+// PackBlock encodes up to 28 samples (rest is silence) into block,
+// filter and shift with lowest error are used
+func (e *AdpcmEncoder) PackBlock(samples []int16, flags byte) [BLOCK_SIZE]byte {
+	var fsamples [BLOCK_SAMPLES]float64
+	for i := range fsamples {
+		if i < len(samples) {
+			fsamples[i] = float64(samples[i])
+		}
+	}
+
+	bestFilter, bestShift := uint32(0), uint32(0)
+	var bestNibbles [BLOCK_SAMPLES]int8
+	bestError := math.Inf(1)
+	var bestHist1, bestHist2 float64
+
+	for filter := uint32(0); filter < uint32(len(vag_f)); filter++ {
+		for shift := uint32(0); shift <= 12; shift++ {
+			nibbles, sqError, hist1, hist2 := e.encodeWith(&fsamples, filter, shift)
+			if sqError < bestError {
+				bestError = sqError
+				bestFilter, bestShift = filter, shift
+				bestNibbles = nibbles
+				bestHist1, bestHist2 = hist1, hist2
+			}
+		}
+	}
+
+	e.hist1, e.hist2 = bestHist1, bestHist2
+
+	var block [BLOCK_SIZE]byte
+	block[0] = byte(bestFilter<<4 | bestShift)
+	block[1] = flags
+	for i := 0; i < BLOCK_SAMPLES; i += 2 {
+		block[2+i/2] = byte(bestNibbles[i])&0xf | byte(bestNibbles[i+1])<<4
+	}
+	return block
+}
+
+// Pack encodes samples into adpcm stream.
+// If loop is set, whole stream is repeated, otherwise last block stops voice
+func (e *AdpcmEncoder) Pack(samples []int16, loop bool) []byte {
+	blocksCount := (len(samples) + BLOCK_SAMPLES - 1) / BLOCK_SAMPLES
+	if blocksCount == 0 {
+		blocksCount = 1
+	}
+
+	result := make([]byte, 0, blocksCount*BLOCK_SIZE)
+	for iBlock := 0; iBlock < blocksCount; iBlock++ {
+		var flags byte
+		if loop {
+			flags = FLAG_LOOP_REPEAT
+			if iBlock == 0 {
+				flags |= FLAG_LOOP_START
+			}
+		}
+		if iBlock == blocksCount-1 {
+			flags |= FLAG_LOOP_END
+		}
+
+		start := iBlock * BLOCK_SAMPLES
+		end := start + BLOCK_SAMPLES
+		if end > len(samples) {
+			end = len(samples)
+		}
+		block := e.PackBlock(samples[start:end], flags)
+		result = append(result, block[:]...)
+	}
+	return result
+}
+
+// IsLooped returns true if stream contains loop start flag
+func IsLooped(packs []byte) bool {
+	for iBlock := 0; iBlock+BLOCK_SIZE <= len(packs); iBlock += BLOCK_SIZE {
+		if packs[iBlock] != 0xc0 && packs[iBlock+1]&FLAG_LOOP_START != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package adpcm
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	samples := make([]int16, 28*40+5)
+	for i := range samples {
+		f := math.Sin(float64(i)*0.05)*12000 + math.Sin(float64(i)*0.31)*4000
+		samples[i] = int16(f)
+	}
+
+	packed := NewAdpcmEncoder().Pack(samples, true)
+	if len(packed) != 41*BLOCK_SIZE {
+		t.Fatalf("Packed size %d, expected %d", len(packed), 41*BLOCK_SIZE)
+	}
+	if !IsLooped(packed) {
+		t.Errorf("Loop flag lost")
+	}
+	if flags := packed[len(packed)-BLOCK_SIZE+1]; flags != FLAG_LOOP_END|FLAG_LOOP_REPEAT {
+		t.Errorf("Last block flags %#x", flags)
+	}
+
+	unpacked, err := NewAdpcmStream().Unpack(packed)
+	if err != nil {
+		t.Fatalf("Failed to unpack: %v", err)
+	}
+
+	var signal, noise float64
+	for i, s := range samples {
+		d := float64(int16(binary.LittleEndian.Uint16(unpacked[i*2:])))
+		signal += float64(s) * float64(s)
+		noise += (float64(s) - d) * (float64(s) - d)
+	}
+	if snr := 10 * math.Log10(signal/noise); snr < 30 {
+		t.Errorf("Signal to noise ratio %.1f dB too low", snr)
+	}
+}
@@ -4,20 +4,25 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/mogaika/god_of_war_browser/ps2/adpcm"
 	"github.com/mogaika/god_of_war_browser/utils"
 )
 
+const HEADER_SIZE = 0x30
+
 type VAGP struct {
 	WaveData   []byte `json:"-"`
 	Channels   byte
 	SampleRate uint32
+
+	rawHeader [HEADER_SIZE]byte
 }
 
 func NewVAGPFromReader(r io.Reader) (*VAGP, error) {
-	var buf [0x30]byte
+	var buf [HEADER_SIZE]byte
 	if _, err := r.Read(buf[:]); err != nil {
 		return nil, err
 	}
@@ -30,6 +35,7 @@ func NewVAGPFromReader(r io.Reader) (*VAGP, error) {
 		Channels:   buf[0x1E],
 		SampleRate: binary.BigEndian.Uint32(buf[0x10:0x14]),
 		WaveData:   make([]byte, binary.BigEndian.Uint32(buf[0xC:0x10])),
+		rawHeader:  buf,
 	}
 
 	if _, err := r.Read(vagp.WaveData); err != nil {
@@ -59,3 +65,29 @@ func (vagp *VAGP) AsWave() (*bytes.Buffer, error) {
 
 	return &buf, nil
 }
+
+// FromWave replaces sound by wave, resampled to sample rate of vag. Loop flag is preserved
+func (vagp *VAGP) FromWave(wave *utils.Wave) error {
+	if vagp.Channels > 1 {
+		return fmt.Errorf("Not mono vag not supported")
+	}
+
+	samples := utils.WaveResample(wave.Mono(), wave.SampleRate, vagp.SampleRate)
+	// first block of vag is always silent
+	data := make([]byte, adpcm.BLOCK_SIZE)
+	vagp.WaveData = append(data, adpcm.NewAdpcmEncoder().Pack(samples, adpcm.IsLooped(vagp.WaveData))...)
+	return nil
+}
+
+func (vagp *VAGP) MarshalBuffer() *bytes.Buffer {
+	header := vagp.rawHeader
+	copy(header[:4], "VAGp")
+	binary.BigEndian.PutUint32(header[0xC:], uint32(len(vagp.WaveData)))
+	binary.BigEndian.PutUint32(header[0x10:], vagp.SampleRate)
+	header[0x1E] = vagp.Channels
+
+	var buf bytes.Buffer
+	buf.Write(header[:])
+	buf.Write(vagp.WaveData)
+	return &buf
+}
@@ -0,0 +1,321 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JOB_QUEUED    = "queued"
+	JOB_RUNNING   = "running"
+	JOB_DONE      = "done"
+	JOB_FAILED    = "failed"
+	JOB_CANCELLED = "cancelled"
+)
+
+// finished jobs kept in history
+const JOBS_HISTORY_LIMIT = 100
+
+// JobFunc should stop and return ctx.Err() when ctx cancelled
+type JobFunc func(ctx context.Context) (interface{}, error)
+
+// Job is long operation. Jobs executed one by one in order of start
+type Job struct {
+	Id       int
+	Name     string
+	State    string
+	Progress float32
+	Message  string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+	Result   interface{} `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+
+	f      JobFunc
+	cancel context.CancelFunc
+}
+
+// JobFile is result of job which produces file.
+// Data kept only in memory, so it is lost after restart
+type JobFile struct {
+	Name string
+	Size int
+	Data []byte `json:"-"`
+}
+
+func NewJobFile(name string, data []byte) *JobFile {
+	return &JobFile{Name: name, Size: len(data), Data: data}
+}
+
+func (j *Job) isFinished() bool {
+	return j.State == JOB_DONE || j.State == JOB_FAILED || j.State == JOB_CANCELLED
+}
+
+type jobContextKey struct{}
+
+var jobsLock sync.Mutex
+var jobsList []*Job
+var jobsNextId = 1
+var jobsWake = make(chan struct{}, 1)
+
+// path of jobs history, history not saved if empty
+var jobsFile string
+
+func init() {
+	go jobsWorker()
+}
+
+// StartJob adds job to queue and returns copy of it
+func StartJob(name string, f JobFunc) Job {
+	jobsLock.Lock()
+	j := &Job{Id: jobsNextId, Name: name, State: JOB_QUEUED, Created: time.Now(), f: f}
+	jobsNextId++
+	jobsList = append(jobsList, j)
+	snapshot := *j
+	jobsLock.Unlock()
+
+	broadcastJob(snapshot)
+	select {
+	case jobsWake <- struct{}{}:
+	default:
+	}
+	return snapshot
+}
+
+// Jobs returns copies of queued, running and finished jobs
+func Jobs() []Job {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	result := make([]Job, len(jobsList))
+	for i, j := range jobsList {
+		result[i] = *j
+	}
+	return result
+}
+
+func GetJob(id int) (Job, bool) {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	if j := findJob(id); j != nil {
+		return *j, true
+	}
+	return Job{}, false
+}
+
+func findJob(id int) *Job {
+	for _, j := range jobsList {
+		if j.Id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// CancelJob removes queued job or cancels context of running one
+func CancelJob(id int) error {
+	jobsLock.Lock()
+	j := findJob(id)
+	if j == nil {
+		jobsLock.Unlock()
+		return fmt.Errorf("Job %d not found", id)
+	}
+	switch j.State {
+	case JOB_QUEUED:
+		j.State = JOB_CANCELLED
+		j.Finished = time.Now()
+		saveJobs()
+	case JOB_RUNNING:
+		j.cancel()
+		jobsLock.Unlock()
+		return nil
+	default:
+		jobsLock.Unlock()
+		return fmt.Errorf("Job %d already %s", id, j.State)
+	}
+	snapshot := *j
+	jobsLock.Unlock()
+
+	broadcastJob(snapshot)
+	return nil
+}
+
+// JobProgress updates progress of job running with ctx,
+// or shows usual progress status if ctx is not job context
+func JobProgress(ctx context.Context, progress float32, format string, a ...interface{}) {
+	j, ok := ctx.Value(jobContextKey{}).(*Job)
+	if !ok {
+		Progress(progress, format, a...)
+		return
+	}
+	jobsLock.Lock()
+	j.Progress = progress
+	j.Message = fmt.Sprintf(format, a...)
+	snapshot := *j
+	jobsLock.Unlock()
+
+	broadcastJob(snapshot)
+}
+
+func broadcastJob(j Job) {
+	msg := fmt.Sprintf("Job #%d %s: %s", j.Id, j.Name, j.State)
+	if j.Error != "" {
+		msg += ": " + j.Error
+	} else if j.Message != "" && !j.isFinished() {
+		msg += ": " + j.Message
+	}
+	statusBroadcast <- &status{
+		Message:  msg,
+		Time:     time.Now(),
+		Type:     JOB,
+		Progress: j.Progress,
+		Job:      &j}
+}
+
+func nextQueuedJob() *Job {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	for _, j := range jobsList {
+		if j.State == JOB_QUEUED {
+			return j
+		}
+	}
+	return nil
+}
+
+func jobsWorker() {
+	for {
+		j := nextQueuedJob()
+		if j == nil {
+			<-jobsWake
+			continue
+		}
+		runJob(j)
+	}
+}
+
+func runJob(j *Job) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), jobContextKey{}, j))
+	defer cancel()
+
+	jobsLock.Lock()
+	j.State = JOB_RUNNING
+	j.Started = time.Now()
+	j.cancel = cancel
+	snapshot := *j
+	jobsLock.Unlock()
+	broadcastJob(snapshot)
+
+	result, err := callJob(ctx, j.f)
+
+	jobsLock.Lock()
+	j.Finished = time.Now()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		j.State = JOB_CANCELLED
+		j.Error = err.Error()
+	case err != nil:
+		j.State = JOB_FAILED
+		j.Error = err.Error()
+		// for example validation report of wad save
+		var detailer interface{ ErrorDetails() interface{} }
+		if errors.As(err, &detailer) {
+			j.Result = detailer.ErrorDetails()
+		}
+	default:
+		j.State = JOB_DONE
+		j.Progress = 1
+		j.Result = result
+	}
+	j.f = nil
+	snapshot = *j
+	saveJobs()
+	jobsLock.Unlock()
+
+	log.Printf("[status] Job #%d %q %s in %v", j.Id, j.Name, snapshot.State, snapshot.Finished.Sub(snapshot.Started))
+	broadcastJob(snapshot)
+}
+
+// callJob converts panic to error, exporters use log.Panicf
+func callJob(ctx context.Context, f JobFunc) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+	return f(ctx)
+}
+
+// LoadJobs loads history of jobs and saves it to path on every job finish.
+// Jobs which were not finished before restart marked as failed
+func LoadJobs(path string) error {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	jobsFile = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var loaded []*Job
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("Failed to parse jobs history %q: %v", path, err)
+	}
+	for _, j := range loaded {
+		if !j.isFinished() {
+			j.State = JOB_FAILED
+			j.Error = "Interrupted by restart"
+		}
+		if j.Id >= jobsNextId {
+			jobsNextId = j.Id + 1
+		}
+	}
+	jobsList = append(loaded, jobsList...)
+	return nil
+}
+
+// saveJobs should be called with jobsLock locked,
+// so job is saved to history before it is visible as finished
+func saveJobs() {
+	finished := 0
+	for _, j := range jobsList {
+		if j.isFinished() {
+			finished++
+		}
+	}
+	// drop oldest finished jobs
+	list := make([]*Job, 0, len(jobsList))
+	for _, j := range jobsList {
+		if j.isFinished() && finished > JOBS_HISTORY_LIMIT {
+			finished--
+			continue
+		}
+		list = append(list, j)
+	}
+	jobsList = list
+	if jobsFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(jobsList, "", "  ")
+	if err != nil {
+		log.Printf("[status] Failed to marshal jobs history: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(jobsFile+".tmp", data, 0666); err != nil {
+		log.Printf("[status] Failed to write jobs history: %v", err)
+		return
+	}
+	if err := os.Rename(jobsFile+".tmp", jobsFile); err != nil {
+		log.Printf("[status] Failed to write jobs history: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package status
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitJobState(t *testing.T, id int, state string) Job {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if j, _ := GetJob(id); j.State == state {
+			return j
+		}
+	}
+	j, _ := GetJob(id)
+	t.Fatalf("Job %d state %q, expected %q", id, j.State, state)
+	return j
+}
+
+func TestJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := LoadJobs(filepath.Join(dir, "jobs.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	blocking := StartJob("blocking", func(ctx context.Context) (interface{}, error) {
+		JobProgress(ctx, 0.5, "waiting")
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	queued := StartJob("queued", func(ctx context.Context) (interface{}, error) {
+		t.Errorf("Cancelled job executed")
+		return nil, nil
+	})
+	done := StartJob("done", func(ctx context.Context) (interface{}, error) {
+		return NewJobFile("a.txt", []byte("a")), nil
+	})
+
+	waitJobState(t, blocking.Id, JOB_RUNNING)
+	if err := CancelJob(queued.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelJob(blocking.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitJobState(t, blocking.Id, JOB_CANCELLED)
+	if j := waitJobState(t, done.Id, JOB_DONE); j.Result.(*JobFile).Size != 1 {
+		t.Errorf("Unexpected result %+v", j.Result)
+	}
+	if err := CancelJob(done.Id); err == nil {
+		t.Errorf("Finished job cancelled")
+	}
+
+	// history survives restart
+	jobsLock.Lock()
+	jobsList = nil
+	jobsLock.Unlock()
+	if err := LoadJobs(filepath.Join(dir, "jobs.json")); err != nil {
+		t.Fatal(err)
+	}
+	if j, ok := GetJob(done.Id); !ok || j.State != JOB_DONE || j.Name != "done" {
+		t.Errorf("Job not loaded from history: %+v", j)
+	}
+}
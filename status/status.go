@@ -15,6 +15,7 @@ const (
 	INFO = iota
 	ERROR
 	PROGRESS
+	JOB
 )
 
 type status struct {
@@ -22,6 +23,8 @@ type status struct {
 	Time     time.Time
 	Type     int
 	Progress float32
+	// only for JOB type
+	Job *Job `json:",omitempty"`
 }
 
 type client struct {
@@ -109,6 +109,16 @@ func Read40bitUint(o binary.ByteOrder, bin []byte) uint64 {
 	return o.Uint64(buf[:])
 }
 
+func Write40bitUint(o binary.ByteOrder, bin []byte, v uint64) {
+	var buf [8]byte
+	o.PutUint64(buf[:], v)
+	if o == binary.LittleEndian {
+		copy(bin[:5], buf[0:])
+	} else {
+		copy(bin[:5], buf[3:])
+	}
+}
+
 func Read24bitUint(o binary.ByteOrder, bin []byte) uint32 {
 	var buf [4]byte
 	if o == binary.LittleEndian {
@@ -118,3 +128,13 @@ func Read24bitUint(o binary.ByteOrder, bin []byte) uint32 {
 	}
 	return o.Uint32(buf[:])
 }
+
+func Write24bitUint(o binary.ByteOrder, bin []byte, v uint32) {
+	var buf [4]byte
+	o.PutUint32(buf[:], v)
+	if o == binary.LittleEndian {
+		copy(bin[:3], buf[0:])
+	} else {
+		copy(bin[:3], buf[1:])
+	}
+}
@@ -34,6 +34,9 @@ type FBXBuilder struct {
 
 	objects     *fbx.Node
 	connections *fbx.Node
+
+	// include animations of objects
+	ExportAnimations bool
 }
 
 func NewFBXBuilder(filename string) *FBXBuilder {
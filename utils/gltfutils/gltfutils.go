@@ -12,6 +12,8 @@ import (
 type GLTFCacher struct {
 	Doc   *gltf.Document
 	Cache map[wad.TagId]interface{}
+	// do not export skinning animations of objects
+	SkipAnimations bool
 }
 
 func NewCacher() *GLTFCacher {
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -27,8 +28,18 @@ func GameStringHashNodes(str string, initial uint32) uint32 {
 	return hash
 }
 
+const (
+	HASHES_DUMP_FILE  = "hashes.dump.txt"
+	STRINGS_DUMP_FILE = "strings.dump.txt"
+)
+
 var hashesMap sync.Map
 
+// hashes which were not resolved by GameStringUnhashNodes
+var unresolvedHashes sync.Map
+
+var hashesLoaded = make(chan struct{})
+
 func loadHashes(filename string) error {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -66,7 +77,35 @@ func loadHashes(filename string) error {
 
 		if init == 0 {
 			GameStringHashRemember(input)
+		} else {
+			chained = append(chained, chainedHash{init: init, suffix: input})
+		}
+	}
+}
+
+// chainedHash is hash of string with prefix which hash is init
+type chainedHash struct {
+	init   uint32
+	suffix string
+}
+
+var chained []chainedHash
+
+// resolveChainedHashes remembers chained strings which prefix is known,
+// prefix itself can be chained string
+func resolveChainedHashes() {
+	for progress := true; progress; {
+		progress = false
+		left := chained[:0]
+		for _, c := range chained {
+			if prefix, ok := hashesMap.Load(c.init); ok {
+				GameStringHashRemember(prefix.(string) + c.suffix)
+				progress = true
+			} else {
+				left = append(left, c)
+			}
 		}
+		chained = left
 	}
 }
 
@@ -104,16 +143,50 @@ func loadStringHashes(filename string) error {
 
 func init() {
 	go func() {
-		if err := loadHashes("hashes.dump.txt"); err != nil {
+		defer close(hashesLoaded)
+
+		if err := loadHashes(HASHES_DUMP_FILE); err != nil {
 			log.Printf("Failed to load hash file: %v", err)
 		}
 
-		if err := loadStringHashes("strings.dump.txt"); err != nil {
+		if err := loadStringHashes(STRINGS_DUMP_FILE); err != nil {
 			log.Printf("Failed to load string hashes file: %v", err)
 		}
+
+		resolveChainedHashes()
 	}()
 }
 
+// GameStringHashesWait waits until hash files loaded
+func GameStringHashesWait() {
+	<-hashesLoaded
+}
+
+// GameStringKnownStrings returns every string which hash is known
+func GameStringKnownStrings() []string {
+	result := make([]string, 0)
+	hashesMap.Range(func(key, value interface{}) bool {
+		result = append(result, value.(string))
+		return true
+	})
+	sort.Strings(result)
+	return result
+}
+
+// GameStringUnresolvedHashes returns hashes which were shown as @hash(...)
+// and still not known
+func GameStringUnresolvedHashes() []uint32 {
+	result := make([]uint32, 0)
+	unresolvedHashes.Range(func(key, value interface{}) bool {
+		if _, ok := hashesMap.Load(key); !ok {
+			result = append(result, key.(uint32))
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
 func GameStringUnhashGenerate(hash uint32) string {
 	s := ""
 	for hash != 0 {
@@ -134,6 +207,7 @@ func GameStringUnhashNodes(hash uint32) string {
 		unhashed := GameStringUnhashGenerate(hash)
 		for _, c := range unhashed {
 			if c < 0x20 || c >= 0x80 {
+				unresolvedHashes.Store(hash, struct{}{})
 				return fmt.Sprintf("@hash(%.8x)", hash)
 			}
 		}
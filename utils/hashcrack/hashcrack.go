@@ -0,0 +1,389 @@
+// Package hashcrack looks for strings of unknown GameStringHashNodes hashes
+package hashcrack
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+const (
+	METHOD_DICTIONARY  = "dictionary"
+	METHOD_AFFIX       = "affix"
+	METHOD_COMBINATION = "combination"
+)
+
+// affix should be part of several known names
+const AFFIX_MIN_COUNT = 3
+
+// tokens used in combinations of three tokens
+const TRIPLE_TOKENS_COUNT = 100
+
+var separators = []string{"", "_", " "}
+
+// Hit is string with hash equal to target hash.
+// If Prefix not empty, hit stored as chained hash of
+// rest of string with initial equal to hash of Prefix
+type Hit struct {
+	Hash      uint32
+	String    string
+	Prefix    string `json:",omitempty"`
+	Method    string
+	Confirmed bool
+}
+
+// Tier is one attack method. Hash is only 32 bits, so big amount of
+// candidates produces collisions which are not real names
+type Tier struct {
+	Method        string
+	Candidates    float64
+	ExpectedFalse float64
+	Hits          int
+	Confirmed     bool
+}
+
+type Result struct {
+	Targets int
+	Tiers   []*Tier
+	Hits    []*Hit
+	// several strings found for hash in same tier
+	Ambiguous map[string][]string
+}
+
+type Cracker struct {
+	// tier hits confirmed if expected collisions count
+	// is less then this part of tier hits
+	MaxFalseRate float64
+
+	targets  map[uint32]bool
+	known    map[uint32]string
+	words    []string
+	tokens   []string
+	suffixes []string
+	prefixes []string
+}
+
+// NewCracker prepares attacks on targets. known is list of names
+// which hash is remembered already, words are additional dictionary
+func NewCracker(targets []uint32, known []string, words []string) *Cracker {
+	c := &Cracker{
+		MaxFalseRate: 0.05,
+		targets:      make(map[uint32]bool),
+		known:        make(map[uint32]string),
+	}
+	for _, t := range targets {
+		c.targets[t] = true
+	}
+	for _, k := range known {
+		c.known[utils.GameStringHashNodes(k, 0)] = k
+	}
+
+	tokenCount := make(map[string]int)
+	for _, s := range append(append([]string{}, known...), words...) {
+		for _, t := range Tokenize(s) {
+			tokenCount[t]++
+		}
+	}
+	// tokens used once mostly are whole names, not words
+	c.tokens = sortedByCount(tokenCount, 2)
+
+	dict := make(map[string]bool)
+	for _, w := range append(append([]string{}, words...), sortedByCount(tokenCount, 1)...) {
+		dict[w] = true
+		dict[strings.ToUpper(w)] = true
+		dict[strings.ToLower(w)] = true
+	}
+	for w := range dict {
+		c.words = append(c.words, w)
+	}
+	sort.Strings(c.words)
+
+	c.suffixes, c.prefixes = knownAffixes(known)
+	for _, sep := range separators {
+		for i := 0; i < 100; i++ {
+			c.suffixes = append(c.suffixes, fmt.Sprintf("%s%d", sep, i))
+		}
+	}
+	c.suffixes = unique(c.suffixes)
+	return c
+}
+
+// Tokenize splits name to words by separators, case and digits.
+// "WadInfoGroup_01" splitted to Wad, Info, Group, 01
+func Tokenize(s string) []string {
+	tokens := make([]string, 0)
+	start := 0
+	runes := []rune(s)
+	for i := 0; i <= len(runes); i++ {
+		split := i == len(runes) || runes[i] == '_' || runes[i] == ' '
+		if !split && i > start {
+			prev, cur := runes[i-1], runes[i]
+			split = (unicode.IsLower(prev) && unicode.IsUpper(cur)) ||
+				(unicode.IsDigit(prev) != unicode.IsDigit(cur))
+		}
+		if split {
+			if i > start {
+				tokens = append(tokens, string(runes[start:i]))
+			}
+			start = i
+			if i < len(runes) && (runes[i] == '_' || runes[i] == ' ') {
+				start = i + 1
+			}
+		}
+	}
+	return tokens
+}
+
+// knownAffixes returns parts which added to known names produce other known names
+func knownAffixes(known []string) (suffixes []string, prefixes []string) {
+	set := make(map[string]bool, len(known))
+	for _, k := range known {
+		set[k] = true
+	}
+	suffixCount := make(map[string]int)
+	prefixCount := make(map[string]int)
+	for _, k := range known {
+		for i := 1; i < len(k); i++ {
+			if set[k[:i]] {
+				suffixCount[k[i:]]++
+			}
+			if set[k[i:]] {
+				prefixCount[k[:i]]++
+			}
+		}
+	}
+	return sortedByCount(suffixCount, AFFIX_MIN_COUNT), sortedByCount(prefixCount, AFFIX_MIN_COUNT)
+}
+
+func sortedByCount(counts map[string]int, min int) []string {
+	result := make([]string, 0)
+	for s, c := range counts {
+		if c >= min {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if counts[result[i]] != counts[result[j]] {
+			return counts[result[i]] > counts[result[j]]
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
+
+func unique(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	result := list[:0]
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// inverse of 127^n modulo 2^32, 127 is odd so it always exists
+func invPow127(n int) uint32 {
+	p := uint32(1)
+	for i := 0; i < n; i++ {
+		p *= 127
+	}
+	inv := p
+	for i := 0; i < 5; i++ {
+		inv *= 2 - p*inv
+	}
+	return inv
+}
+
+// tier collects candidates of one method
+type tier struct {
+	*Tier
+	candidates map[uint32]map[string]*Hit
+}
+
+func (t *tier) add(hash uint32, prefix, str string) {
+	m, ok := t.candidates[hash]
+	if !ok {
+		m = make(map[string]*Hit)
+		t.candidates[hash] = m
+	}
+	m[str] = &Hit{Hash: hash, String: str, Prefix: prefix, Method: t.Method}
+}
+
+// Run executes tiers from smaller candidates space to bigger.
+// Targets found by tier are not used in next tiers
+func (c *Cracker) Run() *Result {
+	result := &Result{
+		Targets:   len(c.targets),
+		Tiers:     make([]*Tier, 0),
+		Hits:      make([]*Hit, 0),
+		Ambiguous: make(map[string][]string),
+	}
+	for _, method := range []func(*tier){c.dictionary, c.affix, c.combination} {
+		if len(c.targets) == 0 {
+			break
+		}
+		t := &tier{Tier: &Tier{}, candidates: make(map[uint32]map[string]*Hit)}
+		method(t)
+		t.ExpectedFalse = t.Candidates * float64(len(c.targets)) / (1 << 32)
+
+		for hash, m := range t.candidates {
+			delete(c.targets, hash)
+			if len(m) != 1 {
+				list := make([]string, 0, len(m))
+				for s := range m {
+					list = append(list, s)
+				}
+				sort.Strings(list)
+				result.Ambiguous[fmt.Sprintf("%.8x", hash)] = list
+				continue
+			}
+			t.Hits++
+		}
+		t.Confirmed = t.ExpectedFalse <= c.MaxFalseRate*float64(t.Hits)
+		for _, m := range t.candidates {
+			if len(m) == 1 {
+				for _, hit := range m {
+					hit.Confirmed = t.Confirmed
+					result.Hits = append(result.Hits, hit)
+				}
+			}
+		}
+		result.Tiers = append(result.Tiers, t.Tier)
+	}
+	sort.Slice(result.Hits, func(i, j int) bool { return result.Hits[i].Hash < result.Hits[j].Hash })
+	return result
+}
+
+func (c *Cracker) dictionary(t *tier) {
+	t.Method = METHOD_DICTIONARY
+	for _, w := range c.words {
+		if h := utils.GameStringHashNodes(w, 0); c.targets[h] {
+			t.add(h, "", w)
+		}
+	}
+	t.Candidates = float64(len(c.words))
+}
+
+// affix checks known+suffix and prefix+known using
+// H(a+b) = H(a)*127^len(b) + H(b), so only pairs with target hash visited
+func (c *Cracker) affix(t *tier) {
+	t.Method = METHOD_AFFIX
+	for _, suffix := range c.suffixes {
+		hs := utils.GameStringHashNodes(suffix, 0)
+		inv := invPow127(len(suffix))
+		for target := range c.targets {
+			if base, ok := c.known[(target-hs)*inv]; ok {
+				t.add(target, base, base+suffix)
+			}
+		}
+	}
+
+	prefixes := make(map[uint32]string, len(c.prefixes))
+	for _, p := range c.prefixes {
+		prefixes[utils.GameStringHashNodes(p, 0)] = p
+	}
+	for hk, k := range c.known {
+		inv := invPow127(len(k))
+		for target := range c.targets {
+			if prefix, ok := prefixes[(target-hk)*inv]; ok {
+				t.add(target, "", prefix+k)
+			}
+		}
+	}
+	t.Candidates = float64(len(c.known)) * float64(len(c.suffixes)+len(c.prefixes))
+}
+
+// combination checks pairs of tokens joined by separator,
+// and triples of most used tokens
+func (c *Cracker) combination(t *tier) {
+	t.Method = METHOD_COMBINATION
+	tokens := make(map[uint32][]string, len(c.tokens))
+	for _, tok := range c.tokens {
+		h := utils.GameStringHashNodes(tok, 0)
+		tokens[h] = append(tokens[h], tok)
+	}
+	for _, sep := range separators {
+		for _, tok := range c.tokens {
+			second := sep + tok
+			hs := utils.GameStringHashNodes(second, 0)
+			inv := invPow127(len(second))
+			for target := range c.targets {
+				for _, first := range tokens[(target-hs)*inv] {
+					t.add(target, "", first+second)
+				}
+			}
+		}
+	}
+	t.Candidates = float64(len(c.tokens)) * float64(len(c.tokens)) * float64(len(separators))
+
+	top := c.tokens
+	if len(top) > TRIPLE_TOKENS_COUNT {
+		top = top[:TRIPLE_TOKENS_COUNT]
+	}
+	for _, sep := range separators {
+		for _, a := range top {
+			for _, b := range top {
+				prefix := a + sep + b + sep
+				hp := utils.GameStringHashNodes(prefix, 0)
+				for _, tok := range top {
+					if h := utils.GameStringHashNodes(tok, hp); c.targets[h] {
+						t.add(h, "", prefix+tok)
+					}
+				}
+			}
+		}
+	}
+	t.Candidates += math.Pow(float64(len(top)), 3) * float64(len(separators))
+}
+
+// Append writes confirmed hits to hashes dump file in format hash:initial:string
+// and remembers them, so they are resolved without restart
+func Append(path string, hits []*Hit) (int, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	written := 0
+	for _, hit := range hits {
+		if !hit.Confirmed {
+			continue
+		}
+		initial := uint32(0)
+		str := hit.String
+		if hit.Prefix != "" {
+			initial = utils.GameStringHashNodes(hit.Prefix, 0)
+			str = strings.TrimPrefix(hit.String, hit.Prefix)
+		}
+		if utils.GameStringHashNodes(str, initial) != hit.Hash {
+			return written, fmt.Errorf("Hash of %q not equal to %.8x", hit.String, hit.Hash)
+		}
+		if _, err := fmt.Fprintf(f, "%.8x:%.8x:%s\n", hit.Hash, initial, str); err != nil {
+			return written, err
+		}
+		utils.GameStringHashRemember(hit.String)
+		written++
+	}
+	return written, nil
+}
+
+// ParseWords returns dictionary words from text, one word per line.
+// Lines started with # are comments, same as in dump files
+func ParseWords(text string) []string {
+	words := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words
+}
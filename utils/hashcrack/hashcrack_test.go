@@ -0,0 +1,59 @@
+package hashcrack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mogaika/god_of_war_browser/utils"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("WadInfoGroup_01 Min")
+	if expected := []string{"Wad", "Info", "Group", "01", "Min"}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Tokenize=%v; expected %v", tokens, expected)
+	}
+}
+
+func TestCrack(t *testing.T) {
+	known := []string{"Hero", "Hero_Tween", "Enemy", "Enemy_Tween", "Boss", "Boss_Tween",
+		"Camera", "Camera Speed", "Blend Mode", "Move Mode", "Scale"}
+	names := []string{"Camera_Tween", "Camera Mode", "SCALE"}
+	targets := make([]uint32, len(names))
+	for i, name := range names {
+		targets[i] = utils.GameStringHashNodes(name, 0)
+	}
+
+	result := NewCracker(targets, known, nil).Run()
+	found := make(map[string]*Hit)
+	for _, hit := range result.Hits {
+		if !hit.Confirmed {
+			t.Errorf("Hit %+v not confirmed", hit)
+		}
+		found[hit.String] = hit
+	}
+	for _, name := range names {
+		if found[name] == nil {
+			t.Errorf("%q not found: %+v", name, result)
+		}
+	}
+	if hit := found["Camera_Tween"]; hit == nil || hit.Prefix != "Camera" || hit.Method != METHOD_AFFIX {
+		t.Fatalf("Unexpected affix hit %+v", hit)
+	}
+
+	dir, err := ioutil.TempDir("", "hashcrack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hashes.dump.txt")
+	if _, err := Append(path, []*Hit{found["Camera_Tween"]}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if expected := "a63ed371:5091dd05:_Tween\n"; string(data) != expected {
+		t.Errorf("Appended %q; expected %q", data, expected)
+	}
+}
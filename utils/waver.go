@@ -2,7 +2,9 @@ package utils
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 )
 
 func WaveWriteHeader(w io.Writer, channels uint16, sampleRate uint32, dataSize uint32) error {
@@ -36,3 +38,135 @@ func WaveWriteHeader(w io.Writer, channels uint16, sampleRate uint32, dataSize u
 	_, err := w.Write(buf[:])
 	return err
 }
+
+type Wave struct {
+	Channels   uint16
+	SampleRate uint32
+	Samples    [][]int16 // per channel
+}
+
+// WaveRead reads pcm (8, 16 bit) or float (32 bit) riff wave
+func WaveRead(r io.Reader) (*Wave, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("Not a riff wave file")
+	}
+
+	var format, bitsPerSample uint16
+	var wave *Wave
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("Data chunk not found: %v", err)
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:])
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("Failed to read chunk %q: %v", chunk[:4], err)
+		}
+		if size%2 != 0 {
+			// chunks are word aligned
+			var pad [1]byte
+			r.Read(pad[:])
+		}
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if len(data) < 16 {
+				return nil, fmt.Errorf("Fmt chunk too small")
+			}
+			format = binary.LittleEndian.Uint16(data[0:])
+			wave = &Wave{
+				Channels:   binary.LittleEndian.Uint16(data[2:]),
+				SampleRate: binary.LittleEndian.Uint32(data[4:]),
+			}
+			bitsPerSample = binary.LittleEndian.Uint16(data[14:])
+			// WAVE_FORMAT_EXTENSIBLE, format in first bytes of subformat guid
+			if format == 0xfffe && len(data) >= 26 {
+				format = binary.LittleEndian.Uint16(data[24:])
+			}
+			if wave.Channels == 0 {
+				return nil, fmt.Errorf("Wave without channels")
+			}
+		case "data":
+			if wave == nil {
+				return nil, fmt.Errorf("Data chunk before fmt chunk")
+			}
+			return wave, wave.decode(format, bitsPerSample, data)
+		}
+	}
+}
+
+func (w *Wave) decode(format, bitsPerSample uint16, data []byte) error {
+	sampleSize := int(bitsPerSample / 8)
+	switch {
+	case format == 1 && (bitsPerSample == 8 || bitsPerSample == 16):
+	case format == 3 && bitsPerSample == 32:
+	default:
+		return fmt.Errorf("Unsupported wave format %d with %d bits per sample", format, bitsPerSample)
+	}
+
+	frameSize := sampleSize * int(w.Channels)
+	framesCount := len(data) / frameSize
+	w.Samples = make([][]int16, w.Channels)
+	for iChannel := range w.Samples {
+		w.Samples[iChannel] = make([]int16, framesCount)
+	}
+	for iFrame := 0; iFrame < framesCount; iFrame++ {
+		for iChannel := range w.Samples {
+			b := data[iFrame*frameSize+iChannel*sampleSize:]
+			var s int16
+			switch bitsPerSample {
+			case 8:
+				s = (int16(b[0]) - 0x80) << 8
+			case 16:
+				s = int16(binary.LittleEndian.Uint16(b))
+			case 32:
+				f := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+				s = int16(math.Max(-32768, math.Min(32767, math.Round(f*32767))))
+			}
+			w.Samples[iChannel][iFrame] = s
+		}
+	}
+	return nil
+}
+
+// Mono returns average of all channels
+func (w *Wave) Mono() []int16 {
+	if len(w.Samples) == 1 {
+		return w.Samples[0]
+	}
+	result := make([]int16, len(w.Samples[0]))
+	for i := range result {
+		sum := 0
+		for _, channel := range w.Samples {
+			sum += int(channel[i])
+		}
+		result[i] = int16(sum / len(w.Samples))
+	}
+	return result
+}
+
+// WaveResample changes sample rate of samples using linear interpolation
+func WaveResample(samples []int16, from, to uint32) []int16 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	count := int(uint64(len(samples)) * uint64(to) / uint64(from))
+	result := make([]int16, count)
+	ratio := float64(from) / float64(to)
+	for i := range result {
+		pos := float64(i) * ratio
+		i0 := int(pos)
+		if i0 >= len(samples)-1 {
+			result[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(i0)
+		result[i] = int16(math.Round(float64(samples[i0])*(1-frac) + float64(samples[i0+1])*frac))
+	}
+	return result
+}
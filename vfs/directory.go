@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	path_ "path"
+	"time"
 )
 
 type DirectoryDriver struct {
@@ -93,6 +94,10 @@ func (ddf *DirectoryDriverFile) Init(parent Directory) {
 	}
 }
 
+func (ddf *DirectoryDriverFile) Path() string {
+	return ddf.path
+}
+
 func (ddf *DirectoryDriverFile) Name() string {
 	return path_.Base(ddf.path)
 }
@@ -109,6 +114,14 @@ func (ddf *DirectoryDriverFile) Size() int64 {
 	}
 }
 
+func (ddf *DirectoryDriverFile) ModTime() (time.Time, error) {
+	stat, err := os.Stat(ddf.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
 func (ddf *DirectoryDriverFile) Open(readonly bool) error {
 	if ddf.f == nil {
 		flags := 0
@@ -1,8 +1,10 @@
 package vfs
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 func OpenFileAndGetReader(f File, readonly bool) (*io.SectionReader, error) {
@@ -31,6 +33,33 @@ func OpenFileAndCopy(f File, src io.Reader) error {
 	}
 }
 
+// OpenFileAndCopyContext is OpenFileAndCopy which can be cancelled
+// if file implements ContextCopier
+func OpenFileAndCopyContext(ctx context.Context, f File, src io.Reader) error {
+	cc, ok := f.(ContextCopier)
+	if !ok {
+		return OpenFileAndCopy(f, src)
+	}
+	if err := f.Open(false); err != nil {
+		return fmt.Errorf("Cannot open file '%s': %v", f.Name(), err)
+	}
+	defer f.Close()
+	if err := cc.CopyContext(ctx, src); err != nil {
+		return fmt.Errorf("Cannot copy data to file '%s': %v", f.Name(), err)
+	}
+	return nil
+}
+
+// GetModTime returns time of last change of file if file implements ModTimer
+func GetModTime(f File) (time.Time, bool) {
+	if mt, ok := f.(ModTimer); ok {
+		if t, err := mt.ModTime(); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func DirectoryGetFile(d Directory, name string) (File, error) {
 	if f, err := d.GetElement(name); err != nil {
 		return nil, fmt.Errorf("Cannot open file '%s': %v", name, err)
@@ -40,3 +69,22 @@ func DirectoryGetFile(d Directory, name string) (File, error) {
 		return f.(File), nil
 	}
 }
+
+// element used to request creation of empty file
+type newFileElement struct {
+	name string
+}
+
+func (e *newFileElement) Init(parent Directory) {}
+func (e *newFileElement) Name() string          { return e.name }
+func (e *newFileElement) IsDirectory() bool     { return false }
+
+// DirectoryGetOrCreateFile returns file, empty file is created if it is not exists
+func DirectoryGetOrCreateFile(d Directory, name string) (File, error) {
+	if _, err := d.GetElement(name); err != nil {
+		if err := d.Add(&newFileElement{name: name}); err != nil {
+			return nil, fmt.Errorf("Cannot create file '%s': %v", name, err)
+		}
+	}
+	return DirectoryGetFile(d, name)
+}
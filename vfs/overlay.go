@@ -0,0 +1,368 @@
+package vfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	path_ "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// file of overlay directory with names of base files removed through overlay
+const OVERLAY_REMOVED_FILE = ".overlay_removed"
+
+// Overlay reads files of base directory (iso, toc, psarc) hidden by files of
+// overlay directory with same name. Changes written only to overlay directory,
+// so it contains changed and new files of mod
+type Overlay struct {
+	// nil if directory exists only in overlay
+	base  Directory
+	upper *DirectoryDriver
+}
+
+func NewOverlay(base Directory, upper *DirectoryDriver) *Overlay {
+	return &Overlay{base: base, upper: upper}
+}
+
+func (o *Overlay) Base() Directory {
+	return o.base
+}
+
+func (o *Overlay) Upper() *DirectoryDriver {
+	return o.upper
+}
+
+func (o *Overlay) Init(parent Directory) {}
+
+func (o *Overlay) Name() string {
+	if o.base != nil {
+		return o.base.Name()
+	}
+	return o.upper.Name()
+}
+
+func (o *Overlay) IsDirectory() bool {
+	return true
+}
+
+func (o *Overlay) removedPath() string {
+	return path_.Join(o.upper.Path(), OVERLAY_REMOVED_FILE)
+}
+
+func (o *Overlay) removed() (map[string]bool, error) {
+	result := make(map[string]bool)
+	f, err := os.Open(o.removedPath())
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if name := strings.TrimSuffix(s.Text(), "\r"); name != "" {
+			result[name] = true
+		}
+	}
+	return result, s.Err()
+}
+
+func (o *Overlay) markRemoved(name string) error {
+	if err := os.MkdirAll(o.upper.Path(), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.removedPath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, name)
+	return err
+}
+
+// upperStat returns nil if overlay has no element with name
+func (o *Overlay) upperStat(name string) os.FileInfo {
+	if name == OVERLAY_REMOVED_FILE {
+		return nil
+	}
+	if s, err := os.Stat(path_.Join(o.upper.Path(), name)); err == nil {
+		return s
+	}
+	return nil
+}
+
+func (o *Overlay) baseElement(name string) Element {
+	if o.base == nil {
+		return nil
+	}
+	if e, err := o.base.GetElement(name); err == nil {
+		return e
+	}
+	return nil
+}
+
+func (o *Overlay) List() ([]string, error) {
+	removed, err := o.removed()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read overlay removed list: %v", err)
+	}
+
+	result := make([]string, 0, 32)
+	listed := make(map[string]bool)
+	if o.base != nil {
+		names, err := o.base.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !removed[name] || o.upperStat(name) != nil {
+				result = append(result, name)
+				listed[name] = true
+			}
+		}
+	}
+
+	if _, err := os.Stat(o.upper.Path()); os.IsNotExist(err) {
+		return result, nil
+	}
+	names, err := o.upper.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !listed[name] && name != OVERLAY_REMOVED_FILE {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+func (o *Overlay) GetElement(name string) (Element, error) {
+	upperPath := path_.Join(o.upper.Path(), name)
+	if s := o.upperStat(name); s != nil {
+		be := o.baseElement(name)
+		if s.IsDir() {
+			bd, _ := be.(Directory)
+			return NewOverlay(bd, NewDirectoryDriver(upperPath)), nil
+		}
+		bf, _ := be.(File)
+		return &OverlayFile{name: name, base: bf, upper: NewDirectoryDriverFile(upperPath), upperPath: upperPath}, nil
+	}
+
+	removed, err := o.removed()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read overlay removed list: %v", err)
+	}
+	if o.base == nil || removed[name] {
+		return nil, fmt.Errorf("Cannot find '%s' in overlay", name)
+	}
+	e, err := o.base.GetElement(name)
+	if err != nil {
+		return nil, err
+	}
+	switch v := e.(type) {
+	case Directory:
+		return NewOverlay(v, NewDirectoryDriver(upperPath)), nil
+	case File:
+		return &OverlayFile{name: name, base: v, upperPath: upperPath}, nil
+	default:
+		return nil, fmt.Errorf("Unknown element type %T of '%s'", e, name)
+	}
+}
+
+func (o *Overlay) Add(e Element) error {
+	if err := os.MkdirAll(o.upper.Path(), os.ModePerm); err != nil {
+		return err
+	}
+	return o.upper.Add(e)
+}
+
+// Remove deletes element from overlay directory
+// and hides element with same name of base directory
+func (o *Overlay) Remove(name string) error {
+	inUpper := o.upperStat(name) != nil
+	if inUpper {
+		if err := o.upper.Remove(name); err != nil {
+			return err
+		}
+	}
+	if o.baseElement(name) != nil {
+		return o.markRemoved(name)
+	}
+	if !inUpper {
+		return fmt.Errorf("Cannot find '%s' in overlay", name)
+	}
+	return nil
+}
+
+// Changed returns names of files of overlay directory and removed base files
+func (o *Overlay) Changed() (changed []string, removed []string, err error) {
+	changed = make([]string, 0)
+	if _, err := os.Stat(o.upper.Path()); err == nil {
+		names, err := o.upper.List()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			if name != OVERLAY_REMOVED_FILE {
+				changed = append(changed, name)
+			}
+		}
+	}
+	sort.Strings(changed)
+
+	removedSet, err := o.removed()
+	if err != nil {
+		return nil, nil, err
+	}
+	removed = make([]string, 0, len(removedSet))
+	for name := range removedSet {
+		if o.upperStat(name) == nil {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return changed, removed, nil
+}
+
+// OverlayFile reads base file until it is written,
+// then file copied to overlay directory
+type OverlayFile struct {
+	name string
+	// nil if file exists only in overlay
+	base File
+	// nil until file copied to overlay
+	upper     *DirectoryDriverFile
+	upperPath string
+
+	opened   bool
+	readonly bool
+}
+
+func (of *OverlayFile) Init(parent Directory) {}
+
+func (of *OverlayFile) Name() string {
+	return of.name
+}
+
+func (of *OverlayFile) IsDirectory() bool {
+	return false
+}
+
+// InOverlay reports if file already copied to overlay directory
+func (of *OverlayFile) InOverlay() bool {
+	return of.upper != nil
+}
+
+func (of *OverlayFile) current() File {
+	if of.upper != nil {
+		return of.upper
+	}
+	return of.base
+}
+
+func (of *OverlayFile) Size() int64 {
+	return of.current().Size()
+}
+
+func (of *OverlayFile) ModTime() (time.Time, error) {
+	if t, ok := GetModTime(of.current()); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Modification time of '%s' is unknown", of.Name())
+}
+
+// Open of base file for writing delayed until first write
+func (of *OverlayFile) Open(readonly bool) error {
+	if of.opened {
+		return fmt.Errorf("File already opened")
+	}
+	if err := of.current().Open(readonly || of.upper == nil); err != nil {
+		return err
+	}
+	of.opened = true
+	of.readonly = readonly
+	return nil
+}
+
+func (of *OverlayFile) Close() error {
+	if !of.opened {
+		return nil
+	}
+	of.opened = false
+	return of.current().Close()
+}
+
+func (of *OverlayFile) Reader() (*io.SectionReader, error) {
+	return of.current().Reader()
+}
+
+func (of *OverlayFile) ReadAt(b []byte, off int64) (n int, err error) {
+	return of.current().ReadAt(b, off)
+}
+
+func (of *OverlayFile) createUpper(src io.Reader) error {
+	if err := os.MkdirAll(path_.Dir(of.upperPath), os.ModePerm); err != nil {
+		return err
+	}
+	upper := NewDirectoryDriverFile(of.upperPath)
+	if err := upper.Copy(src); err != nil {
+		return err
+	}
+	of.upper = upper
+	return nil
+}
+
+func (of *OverlayFile) Copy(src io.Reader) error {
+	if of.upper == nil {
+		if of.opened {
+			of.base.Close()
+			of.opened = false
+		}
+		return of.createUpper(src)
+	}
+	of.opened = false
+	return of.upper.Copy(src)
+}
+
+// copyUp copies base file to overlay and reopens it for writing
+func (of *OverlayFile) copyUp() error {
+	if !of.opened {
+		return fmt.Errorf("First you need to open file")
+	}
+	if of.readonly {
+		return fmt.Errorf("File opened in readonly mode")
+	}
+	r, err := of.base.Reader()
+	if err != nil {
+		return err
+	}
+	err = of.createUpper(r)
+	of.base.Close()
+	if err != nil {
+		of.opened = false
+		return fmt.Errorf("Cannot copy '%s' to overlay: %v", of.name, err)
+	}
+	return of.upper.Open(false)
+}
+
+func (of *OverlayFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if of.upper == nil {
+		if err := of.copyUp(); err != nil {
+			return 0, err
+		}
+	}
+	return of.upper.WriteAt(b, off)
+}
+
+func (of *OverlayFile) Sync() error {
+	if of.upper == nil {
+		return nil
+	}
+	return of.upper.Sync()
+}
@@ -0,0 +1,105 @@
+package vfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readOverlayFile(t *testing.T, d Directory, name string) string {
+	f, err := DirectoryGetFile(d, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenFileAndGetReader(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	basePath := filepath.Join(dir, "base")
+	os.Mkdir(basePath, 0777)
+	for name, data := range map[string]string{"A.TXT": "base a", "B.TXT": "base b"} {
+		if err := ioutil.WriteFile(filepath.Join(basePath, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := NewOverlay(NewDirectoryDriver(basePath), NewDirectoryDriver(filepath.Join(dir, "mod")))
+
+	if s := readOverlayFile(t, o, "A.TXT"); s != "base a" {
+		t.Errorf("Read %q from base", s)
+	}
+
+	// replace whole file
+	f, err := DirectoryGetFile(o, "A.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := OpenFileAndCopy(f, bytes.NewReader([]byte("mod a"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// partial write copies file to overlay first
+	f, err = DirectoryGetFile(o, "B.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Open(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("MOD"), 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = DirectoryGetOrCreateFile(o, "C.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := OpenFileAndCopy(f, bytes.NewReader([]byte("new c"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Remove("A.TXT"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readOverlayFile(t, o, "B.TXT"); s != "MODe b" {
+		t.Errorf("Read %q from overlay", s)
+	}
+	if s := readOverlayFile(t, o, "C.TXT"); s != "new c" {
+		t.Errorf("Read %q from overlay", s)
+	}
+	if _, err := o.GetElement("A.TXT"); err == nil {
+		t.Errorf("Removed file still available")
+	}
+	if list, _ := o.List(); !reflect.DeepEqual(list, []string{"B.TXT", "C.TXT"}) {
+		t.Errorf("Unexpected list %v", list)
+	}
+	if changed, removed, _ := o.Changed(); !reflect.DeepEqual(changed, []string{"B.TXT", "C.TXT"}) ||
+		!reflect.DeepEqual(removed, []string{"A.TXT"}) {
+		t.Errorf("Unexpected changes %v, removed %v", changed, removed)
+	}
+
+	for name, data := range map[string]string{"A.TXT": "base a", "B.TXT": "base b"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(basePath, name)); string(b) != data {
+			t.Errorf("Base file %s changed to %q", name, b)
+		}
+	}
+}
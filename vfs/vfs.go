@@ -1,7 +1,9 @@
 package vfs
 
 import (
+	"context"
 	"io"
+	"time"
 )
 
 // must contain only metadata (filename) as long as possible
@@ -35,5 +37,22 @@ type Syncer interface {
 	Sync() error
 }
 
+// SyncDeferrer implemented by directories which can postpone
+// writing of metadata to Sync
+type SyncDeferrer interface {
+	DeferSync(deferSync bool)
+}
+
+// ModTimer implemented by files which know time of last change,
+// files stored inside of image or archive report time of container
+type ModTimer interface {
+	ModTime() (time.Time, error)
+}
+
+// ContextCopier implemented by files which copy can take long time
+type ContextCopier interface {
+	CopyContext(ctx context.Context, src io.Reader) error
+}
+
 type ReadSeekerAt interface {
 }
@@ -95,6 +95,14 @@ function packLoad() {
         }
         dataPack.append(list);
 
+        dataPack.append($('<button>').text('Add new file').click(function() {
+            let fileName = prompt('Name of new file in pack (for example R_NEW.WAD)');
+            if (fileName) {
+                $(this).attr('href', '/upload/pack/' + fileName.toUpperCase());
+                uploadAjaxHandler.call(this);
+            }
+        }));
+
         if (defferedLoadingWad) {
             packLoadFile(defferedLoadingWad);
         }
@@ -184,12 +192,29 @@ function uploadAjaxHandler() {
             processData: false,
             contentType: false,
             success: function(a1) {
-                if (a1 !== "") {
-                    alert('Error uploading: ' + a1);
-                } else {
+                // tag upload answers after wad saved
+                if (a1 === "") {
                     alert('Success!');
                     window.location.reload();
+                    return;
                 }
+                // pack upload is done by job, because pack rearrangement can take minutes
+                let job;
+                try {
+                    job = JSON.parse(a1);
+                } catch (e) {}
+                if (!job || job.Id === undefined) {
+                    alert('Error uploading: ' + a1);
+                    return;
+                }
+                gowWaitJob(job.Id, function(job) {
+                    if (job.State === 'done') {
+                        alert('Success!');
+                        window.location.reload();
+                    } else {
+                        alert('Error uploading: ' + (job.Error || job.State));
+                    }
+                });
             }
         });
     });
@@ -276,6 +301,11 @@ function treeLoadVagVpk(filename, data) {
     list.append($("<li>").append("SampleRate: " + data.SampleRate));
     list.append($("<li>").append("Channels: " + data.Channels));
     list.append($("<li>").append($("<a>").attr("href", wavPath).append("Download WAV")));
+    if (filename.slice(-3).toLowerCase().startsWith('va')) {
+        list.append($("<li>").append($('<button>').text('Replace from WAV')
+            .attr('href', '/action/' + filename + '/vag/replace')
+            .click(uploadAjaxHandler)));
+    }
     dataTree.append(list)
 
     dataTree.append($("<audio controls autoplay>").append($("<source>").attr("src", wavPath)));
@@ -318,6 +348,15 @@ function treeLoadWad(wadName, data) {
     dataSelectors.append($('<div class="item-selector">').click(function() {
         treeLoadWadAsTags(wadName, data);
     }).text("Tags"));
+    dataSelectors.append($('<div class="item-selector">').click(function() {
+        let otherWad = prompt('Name of wad to compare with ' + wadName);
+        if (otherWad) {
+            summaryLoadWadDiff(wadName, otherWad);
+        }
+    }).text("Diff"));
+    dataSelectors.append($('<div class="item-selector">').click(function() {
+        summaryLoadWadGraph(wadName);
+    }).text("Graph"));
 
     if (wad_last_load_view_type === 'nodes') {
         treeLoadWadAsNodes(wadName, data);
@@ -326,6 +365,118 @@ function treeLoadWad(wadName, data) {
     }
 }
 
+function summaryLoadWadDiff(wadA, wadB) {
+    set3dVisible(false);
+    dataSummary.empty();
+    dataSummary.append($("<div>comparing " + wadA + " with " + wadB + "...</div>"));
+
+    $.getJSON('/json/diff/' + wadA + '/' + wadB, function(diff) {
+        dataSummary.empty();
+        if (diff.hasOwnProperty('error')) {
+            dataSummary.append($("<div>failed to diff:<b>" + diff.error + "</b></div>"));
+            return;
+        }
+        setTitle(viewSummary, wadA + ' → ' + wadB);
+
+        let table = $('<table>');
+        table.append($('<tr>').append(
+            $('<td>').text('Status'), $('<td>').text('Tag'), $('<td>').text('Name'),
+            $('<td>').text(wadA), $('<td>').text(wadB), $('<td>').text('Fields')));
+        for (let td of diff.Tags) {
+            let fields = $('<td>');
+            if (td.FieldsError) {
+                fields.append($('<b>').text(td.FieldsError));
+            }
+            for (let f of td.Fields || []) {
+                fields.append($('<div>').text(f.Path + ': ' + JSON.stringify(f.A) + ' → ' + JSON.stringify(f.B)));
+            }
+            table.append($('<tr>').append(
+                $('<td>').text(td.Status),
+                $('<td>').text(td.Tag),
+                $('<td>').text(td.Name),
+                $('<td>').text(td.IdA >= 0 ? td.IdA + ' (' + td.SizeA + ')' : ''),
+                $('<td>').text(td.IdB >= 0 ? td.IdB + ' (' + td.SizeB + ')' : ''),
+                fields));
+        }
+        if (diff.Tags.length == 0) {
+            dataSummary.append($('<p>').text('Wads are equal'));
+        } else {
+            dataSummary.append(table);
+        }
+    });
+}
+
+function summaryLoadWadGraph(wadName, tagid = undefined, dir = undefined) {
+    set3dVisible(false);
+    dataSummary.empty();
+
+    let query = (tagid !== undefined) ? {tag: tagid, dir: dir} : {};
+    $.getJSON('/json/graph/' + wadName, query, function(graph) {
+        dataSummary.empty();
+        if (graph.hasOwnProperty('error')) {
+            dataSummary.append($("<div>failed to build graph:<b>" + graph.error + "</b></div>"));
+            return;
+        }
+        setTitle(viewSummary, wadName + ((tagid !== undefined) ? ' ' + tagid + ' ' + dir : '') + ' graph');
+
+        let names = {};
+        for (let n of graph.Nodes) {
+            names[n.Id] = (n.Wad != wadName ? n.Wad + ': ' : '') + n.Name;
+        }
+        dataSummary.append($('<a>').attr('href', '/dump/graph/' + wadName + '?' + $.param(query)).text('Download .dot'));
+
+        let table = $('<table>');
+        table.append($('<tr>').append(
+            $('<td>').text('From'), $('<td>').text('Kind'), $('<td>').text('To')));
+        for (let e of graph.Edges) {
+            let to = $('<td>');
+            if (e.To) {
+                to.text(names[e.To]);
+            } else {
+                to.append($('<b>').text('missing ' + e.Name));
+            }
+            table.append($('<tr>').append($('<td>').text(names[e.From]), $('<td>').text(e.Kind), to));
+        }
+        dataSummary.append(table);
+    });
+}
+
+function summaryLoadSearch(q) {
+    set3dVisible(false);
+    dataSummary.empty();
+    setTitle(viewSummary, 'Search: ' + q);
+
+    $.getJSON('/json/search', {q: q}, function(res) {
+        dataSummary.empty();
+        if (res.hasOwnProperty('error')) {
+            dataSummary.append($("<div>search failed:<b>" + res.error + "</b></div>"));
+            return;
+        }
+        if (!res.Ready) {
+            dataSummary.append($('<p>').text('Indexing is in progress (' + Math.floor(res.Progress * 100) + '%), results are incomplete'));
+        }
+
+        let addList = function(title, entries) {
+            dataSummary.append($('<h4>').text(title + ' (' + entries.length + ')'));
+            let list = $('<ol>');
+            for (let e of entries) {
+                let refs = (e.References || []).map(r => r.Name).join(', ');
+                list.append($('<li>').append($('<a href="#">').text(e.Wad + ': ' + e.Name).click(function() {
+                    defferedLoadingWadNode = e.TagId;
+                    packLoadFile(e.Wad);
+                    return false;
+                })).append(document.createTextNode(refs ? ' → ' + refs : '')));
+            }
+            dataSummary.append(list);
+        };
+        addList('Nodes', res.Nodes);
+        addList('Used by', res.Referencing);
+        if (res.Truncated) {
+            dataSummary.append($('<p>').text('Too many results, refine query'));
+        }
+    });
+}
+
 function inputAsRenderMask(selector, bitIndex, init) {
     inputAsSwitch(selector, function(checked) {
         let bit = 1 << bitIndex;
@@ -397,6 +548,11 @@ $(document).ready(function() {
     let itemFilter = localStorage.getItem('item-filter');
     $('#view-pack-filter').on('input', treePackInputFilterHandler).val(packFilter ? packFilter : '.wad');
     $('#view-item-filter').on('input', treeItemInputFilterHandler).val(itemFilter ? itemFilter : '');
+    $('#view-pack-search').on('keypress', function(ev) {
+        if (ev.which == 13 && $(this).val().length > 0) {
+            summaryLoadSearch($(this).val());
+        }
+    });
 
     let urlParts = decodeURI(window.location.hash).split("/");
     if (urlParts.length > 1) {
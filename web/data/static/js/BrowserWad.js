@@ -251,7 +251,11 @@ function treeLoadWadNode(wad, tagid, filterServerId = undefined) {
                         break;
                 }
             } else if (tag.Tag == 112) {
-                summaryLoadWadGeomShape(data);
+                if (data.Points) {
+                    summaryLoadWadCamRail(data, wad, tagid);
+                } else {
+                    summaryLoadWadGeomShape(data);
+                }
             } else if (tag.Tag == 113 || tag.Tag == 114) {
                 summaryLoadWadTWK(data, wad, tagid);
                 needMarshalDump = false;
@@ -291,6 +295,14 @@ function treeLoadWadTag(wad, tagid) {
     tbl.append($('<tr>').append($('<td>')).append($('<td>').append($('<input type="submit" value="Update tag info">'))));
 
     dataSummary.append(form.append(tbl));
+
+    dataSummarySelectors.empty();
+    dataSummarySelectors.append($('<div class="item-selector">').click(function() {
+        summaryLoadWadGraph(wad, tagid, 'dependencies');
+    }).text("Dependencies"));
+    dataSummarySelectors.append($('<div class="item-selector">').click(function() {
+        summaryLoadWadGraph(wad, tagid, 'dependents');
+    }).text("Dependents"));
 }
 
 function displayResourceHexDump(wad, tagid) {
@@ -457,6 +469,32 @@ function loadMeshFromAjax(model, data, needTable = false) {
     return table;
 }
 
+function uploadFormForWadNode(wad, nodeid, action, title) {
+    let form = $('<form action="' + getActionLinkForWadNode(wad, nodeid, action) + '" method="post" enctype="multipart/form-data">');
+    form.append($('<input type="file" name="model">'));
+    let replaceBtn = $('<input type="button">').attr('value', title);
+    replaceBtn.click(function() {
+        let form = $(this).parent();
+        $.ajax({
+            url: form.attr('action'),
+            type: 'post',
+            data: new FormData(form[0]),
+            processData: false,
+            contentType: false,
+            success: function(a1) {
+                if (a1 !== "") {
+                    alert('Error: ' + a1);
+                } else {
+                    alert('Success!');
+                    window.location.reload();
+                }
+            }
+        });
+    });
+    form.append(replaceBtn);
+    return form;
+}
+
 function summaryLoadWadMesh(data, wad, nodeid) {
     gr_instance.cleanup();
     set3dVisible(true);
@@ -469,6 +507,9 @@ function summaryLoadWadMesh(data, wad, nodeid) {
     let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf');
     dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0'));
 
+    dataSummary.append(uploadFormForWadNode(wad, nodeid, 'fromgltf', 'Replace mesh from glTF'));
+    dataSummary.append(uploadFormForWadNode(wad, nodeid, 'fromcollada', 'Replace mesh from Collada'));
+
     let table = loadMeshFromAjax(mdl, data, true);
     dataSummary.append(table);
 
@@ -989,6 +1030,11 @@ function loadCollisionFromAjax(data, wad, nodeid, parentObject = null) {
         }
     };
 
+    if (!parentObject) {
+        let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf');
+        dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0'));
+    }
+
     if (data.ShapeName == "BallHull") {
         let ball = data.Shape;
 
@@ -1254,6 +1300,11 @@ function summaryLoadWadObj(data, wad, nodeid) {
 
     let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf');
     dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0'));
+    let dumplinkgltfnoanims = getActionLinkForWadNode(wad, nodeid, 'gltf', 'animations=0');
+    dataSummary.append($('<a class="center">').attr('href', dumplinkgltfnoanims).append('Download .glb bin glTF 2.0 without animations'));
+    let dumplinkfbxanims = getActionLinkForWadNode(wad, nodeid, 'fbx', 'animations=1');
+    dataSummary.append($('<a class="center">').attr('href', dumplinkfbxanims).append('Download .fbx with animations'));
+    dataSummary.append(uploadFormForWadNode(wad, nodeid, 'import', 'Replace skeleton from glTF'));
 
     let jointsTable = $('<table>');
 
@@ -1432,8 +1483,24 @@ function summaryLoadWadCxt(data, wad, nodeid) {
         let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf');
         dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0'));
     } else {
-        let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf_all');
-        dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0'));
+        // whole wad export is long, so it runs as job
+        let dumplinkgltf = getActionLinkForWadNode(wad, nodeid, 'gltf_all', 'job=1');
+        dataSummary.append($('<a class="center">').attr('href', '#').append('Download .glb bin glTF 2.0').click(function(ev) {
+            ev.preventDefault();
+            $.getJSON(dumplinkgltf, function(job) {
+                if (job.error) {
+                    alert('Export error: ' + job.error);
+                    return;
+                }
+                gowWaitJob(job.Id, function(job) {
+                    if (job.State === 'done') {
+                        window.location = '/dump/jobs/' + job.Id;
+                    } else {
+                        alert('Export ' + job.State + ': ' + job.Error);
+                    }
+                });
+            });
+        }));
     }
 
     if ((data.Instances !== null && data.Instances.length) || gw_cxt_group_loading) {
@@ -1466,6 +1533,7 @@ function summaryLoadWadSbk(data, wad, nodeid) {
             let wavplayer = $("<source>").attr("src", getSndLink('wav'));
             let wavlink = $("<audio controls>").attr("preload", "none").append(wavplayer);
             li.append("<br>").append(wavlink);
+            li.append($('<button>').text('Replace from WAV').attr('href', getSndLink('replace')).click(uploadAjaxHandler));
         } else {
             let commands = $("<table>");
             let banksound = data.Bank.BankSounds[snd.StreamId];
@@ -1483,7 +1551,8 @@ function summaryLoadWadSbk(data, wad, nodeid) {
             ));
 
             let cmdRow = $("<tr>").append($("<td>").text("Commands").attr("rowspan", banksound.Commands.length));
-            for (let command of banksound.Commands) {
+            for (let iCommand = 0; iCommand < banksound.Commands.length; iCommand++) {
+                let command = banksound.Commands[iCommand];
                 let commandClean = {};
                 for (let key in command) {
                     if (key != 'SampleRef' && key != 'Cmd' && key != 'VagRef' && key != 'UnkRef') {
@@ -1512,6 +1581,10 @@ function summaryLoadWadSbk(data, wad, nodeid) {
 
                     argsCol.append($("<br>")).append("Audio offset " + sampleRef.AdpcmOffset + " size " + sampleRef.AdpcmSize);
                     argsCol.append($("<br>")).append(wavlink);
+
+                    let replaceUrl = getActionLinkForWadNode(wad, nodeid, 'replace',
+                        'snd=' + snd.Name + '&cmd=' + iCommand);
+                    argsCol.append($('<button>').text('Replace from WAV').attr('href', replaceUrl).click(uploadAjaxHandler));
                 }
                 if (command.VagRef != null) {
                     let vagRef = command.VagRef;
@@ -1565,6 +1638,52 @@ function summaryLoadWadGeomShape(data) {
     gr_instance.requestRedraw();
 }
 
+function summaryLoadWadCamRail(data, wad, tagid) {
+    gr_instance.cleanup();
+    set3dVisible(true);
+
+    let dumplinkgltf = getActionLinkForWadNode(wad, tagid, 'gltf');
+    dataSummary.append($('<a class="center">').attr('href', dumplinkgltf).append('Download .glb bin glTF 2.0 (animated camera)'));
+
+    let vertices = [];
+    let indices = [];
+    let pointIndices = [];
+    for (let i = 0; i < data.Points.length; i++) {
+        let p = data.Points[i];
+        vertices.push(p[0], p[1], p[2]);
+        pointIndices.push(i);
+        if (i != 0) {
+            indices.push(i - 1, i);
+        }
+    }
+
+    let material = new RenderMaterial();
+    let layer = new RenderMaterialLayer();
+    material.setColor([1, 1, 0, 1]);
+    layer.setColor([1, 1, 1, 1]);
+    material.addLayer(layer);
+
+    let model = new RenderModel();
+    model.addMaterial(material);
+    for (const mesh of [new RenderMesh(vertices, indices, gl.LINES), new RenderMesh(vertices, pointIndices, gl.POINTS)]) {
+        mesh.setMaterialID(0);
+        model.addMesh(mesh);
+    }
+
+    gr_instance.addNode(new ObjectTreeNodeModel("rail", model));
+    gr_instance.requestRedraw();
+
+    let table = $('<table>');
+    table.append($('<tr><td>Point</td><td>Position</td><td>Float</td></tr>'));
+    for (let i = 0; i < data.Points.length; i++) {
+        let p = data.Points[i];
+        table.append($('<tr>').append($('<td>').text(i))
+            .append($('<td>').text(p[0].toFixed(2) + ', ' + p[1].toFixed(2) + ', ' + p[2].toFixed(2)))
+            .append($('<td>').text(data.Floats[i])));
+    }
+    dataSummary.append(table);
+}
+
 function summaryLoadWadScript(data, wad, tagid) {
     gr_instance.cleanup();
 
@@ -2,6 +2,39 @@
 
 var wsStatusSocket;
 var wsStatusTimeout = false;
+var wsStatusJobWaiters = {};
+
+function isJobFinished(job) {
+    return job.State === 'done' || job.State === 'failed' || job.State === 'cancelled';
+}
+
+// calls callback with job when it is finished
+function gowWaitJob(id, callback) {
+    wsStatusJobWaiters[id] = callback;
+    // job can be finished before waiter registered
+    $.getJSON('/json/jobs/' + id, function(job) {
+        if (isJobFinished(job) && wsStatusJobWaiters[id]) {
+            delete wsStatusJobWaiters[id];
+            callback(job);
+        }
+    });
+}
+
+function gowJobUpdate(job) {
+    let $cancel = $("#status-cancel");
+    if (isJobFinished(job)) {
+        $cancel.hide();
+        let callback = wsStatusJobWaiters[job.Id];
+        if (callback) {
+            delete wsStatusJobWaiters[job.Id];
+            callback(job);
+        }
+    } else {
+        $cancel.show().off('click').click(function() {
+            $.get('/cancel/jobs/' + job.Id);
+        });
+    }
+}
 
 function gowWsStatusTimer() {
     if (wsStatusSocket) {
@@ -53,6 +86,24 @@ function gowWsStatusConnect() {
                     }
                     $sp.width(progress * 100 + "%");
                     break;
+                case 3:
+                    switch (s.Job.State) {
+                        case 'failed':
+                            $sp.addClass("error");
+                            $sp.width("100%");
+                            break;
+                        case 'done':
+                        case 'cancelled':
+                            $sp.addClass("info");
+                            $sp.width("100%");
+                            break;
+                        default:
+                            $sp.addClass("progress");
+                            $sp.width(Math.min(Math.max(s.Progress, 0), 1) * 100 + "%");
+                            break;
+                    }
+                    gowJobUpdate(s.Job);
+                    break;
             }
         };
     } else {
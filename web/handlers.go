@@ -2,22 +2,23 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"sort"
 	"strconv"
 
 	"github.com/gorilla/mux"
 
 	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/pack/refgraph"
 	file_vpk "github.com/mogaika/god_of_war_browser/pack/vpk"
 	file_wad "github.com/mogaika/god_of_war_browser/pack/wad"
 	file_vagp "github.com/mogaika/god_of_war_browser/ps2/vagp"
 	"github.com/mogaika/god_of_war_browser/status"
+	"github.com/mogaika/god_of_war_browser/utils"
+	"github.com/mogaika/god_of_war_browser/utils/hashcrack"
 	"github.com/mogaika/god_of_war_browser/vfs"
 	"github.com/mogaika/god_of_war_browser/webutils"
 )
@@ -81,6 +82,105 @@ func HandlerAjaxPackFileParam(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func loadWad(file string) (*file_wad.Wad, error) {
+	data, err := pack.GetInstanceHandler(ServerDirectory, file)
+	if err != nil {
+		return nil, err
+	}
+	if wad, ok := data.(*file_wad.Wad); ok {
+		return wad, nil
+	}
+	return nil, fmt.Errorf("File %s is not wad", file)
+}
+
+func HandlerAjaxDiff(w http.ResponseWriter, r *http.Request) {
+	wadA, err := loadWad(mux.Vars(r)["fileA"])
+	if err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	wadB, err := loadWad(mux.Vars(r)["fileB"])
+	if err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	webutils.WriteJson(w, file_wad.Diff(wadA, wadB))
+}
+
+func HandlerAjaxValidate(w http.ResponseWriter, r *http.Request) {
+	wad, err := loadWad(mux.Vars(r)["file"])
+	if err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	webutils.WriteJson(w, wad.Validate())
+}
+
+func HandlerAjaxSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		webutils.WriteError(w, fmt.Errorf("Empty search query"))
+		return
+	}
+	webutils.WriteJson(w, SearchIndex.Search(q))
+}
+
+// HandlerAjaxHashCrack cracks hashes which were shown as @hash(...) since start.
+// Optional words parameter is newline separated dictionary, write=1 appends confirmed hits
+func HandlerAjaxHashCrack(w http.ResponseWriter, r *http.Request) {
+	utils.GameStringHashesWait()
+	q := r.URL.Query()
+	result := hashcrack.NewCracker(utils.GameStringUnresolvedHashes(),
+		utils.GameStringKnownStrings(), hashcrack.ParseWords(q.Get("words"))).Run()
+	if q.Get("write") == "1" {
+		if _, err := hashcrack.Append(utils.HASHES_DUMP_FILE, result.Hits); err != nil {
+			webutils.WriteError(w, err)
+			return
+		}
+	}
+	webutils.WriteJson(w, result)
+}
+
+// buildGraph returns graph of whole wad, or part of it
+// if tag and dir (dependencies or dependents) query parameters provided
+func buildGraph(r *http.Request) (*refgraph.Graph, error) {
+	file := mux.Vars(r)["file"]
+	g, err := refgraph.Build(ServerDirectory, file)
+	if err != nil {
+		return nil, err
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		id, err := strconv.Atoi(tag)
+		if err != nil {
+			return nil, fmt.Errorf("tag '%s' is not integer", tag)
+		}
+		return g.Subgraph(refgraph.TagNodeId(file, file_wad.TagId(id)), r.URL.Query().Get("dir"))
+	}
+	return g, nil
+}
+
+func HandlerAjaxGraph(w http.ResponseWriter, r *http.Request) {
+	if g, err := buildGraph(r); err != nil {
+		webutils.WriteError(w, err)
+	} else {
+		webutils.WriteJson(w, g)
+	}
+}
+
+func HandlerDumpGraph(w http.ResponseWriter, r *http.Request) {
+	g, err := buildGraph(r)
+	if err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := g.WriteDot(&buf); err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	webutils.WriteFile(w, &buf, mux.Vars(r)["file"]+".dot")
+}
+
 func handlerDumpFileVfs(w http.ResponseWriter, r *http.Request, d vfs.Directory) {
 	file := mux.Vars(r)["file"]
 	f, err := vfs.DirectoryGetFile(d, file)
@@ -113,7 +213,9 @@ func HandlerDeletePackFile(w http.ResponseWriter, r *http.Request) {
 	err := ServerDirectory.Remove(file)
 	if err != nil {
 		webutils.WriteError(w, err)
+		return
 	}
+	SearchIndex.Update(ServerDirectory, file)
 }
 
 func HandlerDumpPackParamFile(w http.ResponseWriter, r *http.Request) {
@@ -186,70 +288,147 @@ func HandlerActionPackFileParam(w http.ResponseWriter, r *http.Request) {
 					webutils.WriteError(w, fmt.Errorf("Wad handler error on %s-%d instance: %v", file, id, err))
 				}
 			}
+		case *file_vagp.VAGP:
+			if action != "replace" {
+				webutils.WriteError(w, fmt.Errorf("Unknown vag action %q", action))
+				return
+			}
+			if err := replaceVag(data.(*file_vagp.VAGP), file, r); err != nil {
+				log.Printf("Error replacing vag: %v", err)
+				fmt.Fprintln(w, "vag replace error:", err)
+			}
 		default:
 			webutils.WriteError(w, fmt.Errorf("File %s not contain subdata", file))
 		}
 	}
 }
 
-func HandlerUploadPackFile(w http.ResponseWriter, r *http.Request) {
-	targetFile := mux.Vars(r)["file"]
+// replaceVag encodes uploaded wave into vag pack file
+func replaceVag(vag *file_vagp.VAGP, file string, r *http.Request) error {
 	fileStream, _, err := r.FormFile("data")
+	if err != nil {
+		return err
+	}
 	defer fileStream.Close()
 
+	wave, err := utils.WaveRead(fileStream)
 	if err != nil {
-		webutils.WriteError(w, fmt.Errorf("File stream getting error: %v", err))
-		return
+		return fmt.Errorf("Failed to read wave: %v", err)
+	}
+	if err := vag.FromWave(wave); err != nil {
+		return err
 	}
 
-	fileSize, err := fileStream.Seek(0, os.SEEK_END)
+	f, err := vfs.DirectoryGetFile(ServerDirectory, file)
 	if err != nil {
-		webutils.WriteError(w, fmt.Errorf("Cannot seek file: %v", err))
+		return err
+	}
+	defer f.Close()
+	return vfs.OpenFileAndCopy(f, vag.MarshalBuffer())
+}
+
+// HandlerUploadPackFile starts job which replaces pack file,
+// because pack can be rearranged for minutes to find free space
+func HandlerUploadPackFile(w http.ResponseWriter, r *http.Request) {
+	targetFile := mux.Vars(r)["file"]
+	fileData, err := webutils.ReadFile(r, "data")
+	if err != nil {
+		webutils.WriteError(w, fmt.Errorf("File stream getting error: %v", err))
 		return
 	}
-	fileStream.Seek(0, os.SEEK_SET)
 
-	if f, err := vfs.DirectoryGetFile(ServerDirectory, targetFile); err != nil {
-		webutils.WriteError(w, err)
-	} else {
+	job := status.StartJob("Upload "+targetFile, func(ctx context.Context) (interface{}, error) {
+		f, err := vfs.DirectoryGetOrCreateFile(ServerDirectory, targetFile)
+		if err != nil {
+			return nil, err
+		}
 		defer f.Close()
-		if err := vfs.OpenFileAndCopy(f, io.NewSectionReader(fileStream, 0, fileSize)); err != nil {
-			webutils.WriteError(w, fmt.Errorf("Error when updating pack file: %v", err))
+		if err := vfs.OpenFileAndCopyContext(ctx, f, bytes.NewReader(fileData)); err != nil {
+			return nil, fmt.Errorf("Error when updating pack file: %v", err)
 		}
-	}
+		SearchIndex.Update(ServerDirectory, targetFile)
+		return nil, nil
+	})
+	webutils.WriteJson(w, job)
 }
 
+// HandlerUploadPackFileParam replaces data of wad tag. Answered after save,
+// so validation errors of wad returned together with their details
 func HandlerUploadPackFileParam(w http.ResponseWriter, r *http.Request) {
 	targetFile := mux.Vars(r)["file"]
 	param := mux.Vars(r)["param"]
-	fileStream, _, err := r.FormFile("data")
-	defer fileStream.Close()
+	fileData, err := webutils.ReadFile(r, "data")
 	if err != nil {
 		webutils.WriteError(w, fmt.Errorf("File stream getting error: %v", err))
 		return
 	}
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		webutils.WriteError(w, fmt.Errorf("target wad resource name '%s' is not integer: %v", param, err))
+		return
+	}
 
 	data, err := pack.GetInstanceHandler(ServerDirectory, targetFile)
 	if err != nil {
 		log.Printf("Error getting instance from pack: %v", err)
 		webutils.WriteError(w, err)
+		return
+	}
+	wad, ok := data.(*file_wad.Wad)
+	if !ok {
+		webutils.WriteError(w, fmt.Errorf("File %s not contain subdata", targetFile))
+		return
+	}
+	if err := wad.UpdateTagsData(map[file_wad.TagId][]byte{file_wad.TagId(id): fileData}); err != nil {
+		webutils.WriteError(w, fmt.Errorf("Error updating tags: %w", err))
+	}
+}
+
+func jobFromRequest(r *http.Request) (status.Job, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return status.Job{}, fmt.Errorf("job id '%s' is not integer", mux.Vars(r)["id"])
+	}
+	job, ok := status.GetJob(id)
+	if !ok {
+		return job, fmt.Errorf("Job %d not found", id)
+	}
+	return job, nil
+}
+
+func HandlerAjaxJobs(w http.ResponseWriter, r *http.Request) {
+	webutils.WriteJson(w, status.Jobs())
+}
+
+func HandlerAjaxJob(w http.ResponseWriter, r *http.Request) {
+	if job, err := jobFromRequest(r); err != nil {
+		webutils.WriteError(w, err)
 	} else {
-		switch data.(type) {
-		case *file_wad.Wad:
-			wad := data.(*file_wad.Wad)
-			id, err := strconv.Atoi(param)
-			if err != nil {
-				webutils.WriteError(w, fmt.Errorf("target wad resource name '%s' is not integer: %v", param, err))
-			} else {
-				if fileData, err := ioutil.ReadAll(fileStream); err == nil {
-					if err := wad.UpdateTagsData(map[file_wad.TagId][]byte{file_wad.TagId(id): fileData}); err != nil {
-						webutils.WriteError(w, fmt.Errorf("Error updating tags: %v", err))
-					}
-				} else {
-					webutils.WriteError(w, fmt.Errorf("reading file error: %v", err))
-				}
-			}
-		}
+		webutils.WriteJson(w, job)
+	}
+}
+
+func HandlerCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobFromRequest(r)
+	if err == nil {
+		err = status.CancelJob(job.Id)
+	}
+	if err != nil {
+		webutils.WriteError(w, err)
+	}
+}
+
+// HandlerDumpJob returns file produced by job
+func HandlerDumpJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobFromRequest(r)
+	if err != nil {
+		webutils.WriteError(w, err)
+		return
+	}
+	if f, ok := job.Result.(*status.JobFile); ok && job.State == status.JOB_DONE {
+		webutils.WriteFile(w, bytes.NewReader(f.Data), f.Name)
+	} else {
+		webutils.WriteError(w, fmt.Errorf("Job %d has no file result (state %s)", job.Id, job.State))
 	}
 }
 
@@ -10,23 +10,51 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 
+	"github.com/mogaika/god_of_war_browser/pack"
+	"github.com/mogaika/god_of_war_browser/pack/index"
+	"github.com/mogaika/god_of_war_browser/status"
 	"github.com/mogaika/god_of_war_browser/vfs"
 )
 
 var ServerDirectory vfs.Directory
 var DriverDirectory vfs.Directory
 var wsUpgrader = websocket.Upgrader{}
+var SearchIndex = index.NewIndex(INDEX_CACHE_FILE)
+
+const INDEX_CACHE_FILE = "index_cache.json.gz"
+const JOBS_HISTORY_FILE = "jobs.json"
 
 func StartServer(addr string, packsDir vfs.Directory, driver vfs.Directory, webPath string) error {
 	ServerDirectory = packsDir
 	DriverDirectory = driver
 
+	if err := status.LoadJobs(JOBS_HISTORY_FILE); err != nil {
+		log.Printf("[web] Failed to load jobs history: %v", err)
+	}
+
+	pack.OnSave(SearchIndex.Update)
+	go func() {
+		if err := SearchIndex.Build(packsDir); err != nil {
+			log.Printf("[web] Failed to build search index: %v", err)
+		}
+	}()
+
 	r := mux.NewRouter()
 	r.HandleFunc("/action/{file}/{param}/{action}", HandlerActionPackFileParam)
 	r.HandleFunc("/json/pack/{file}/{param}", HandlerAjaxPackFileParam)
 	r.HandleFunc("/json/pack/{file}", HandlerAjaxPackFile)
 	r.HandleFunc("/json/pack", HandlerAjaxPack)
 	r.HandleFunc("/json/fs", HandlerAjaxFs)
+	r.HandleFunc("/json/diff/{fileA}/{fileB}", HandlerAjaxDiff)
+	r.HandleFunc("/json/search", HandlerAjaxSearch)
+	r.HandleFunc("/json/graph/{file}", HandlerAjaxGraph)
+	r.HandleFunc("/json/validate/{file}", HandlerAjaxValidate)
+	r.HandleFunc("/json/hashcrack", HandlerAjaxHashCrack)
+	r.HandleFunc("/json/jobs", HandlerAjaxJobs)
+	r.HandleFunc("/json/jobs/{id}", HandlerAjaxJob)
+	r.HandleFunc("/cancel/jobs/{id}", HandlerCancelJob)
+	r.HandleFunc("/dump/jobs/{id}", HandlerDumpJob)
+	r.HandleFunc("/dump/graph/{file}", HandlerDumpGraph)
 	r.HandleFunc("/dump/pack/{file}/{param}", HandlerDumpPackParamFile)
 	r.HandleFunc("/dump/pack/{file}", HandlerDumpPackFile)
 	r.HandleFunc("/dump/fs/{file}", HandlerDumpFsFile)
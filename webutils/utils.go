@@ -96,11 +96,22 @@ func WriteResult(w http.ResponseWriter, data []byte) {
 	}
 }
 
+// ErrorDetailer implemented by errors which have structured description
+type ErrorDetailer interface {
+	ErrorDetails() interface{}
+}
+
 func WriteError(w http.ResponseWriter, err error) {
 	type jError struct {
-		Error string `json:"error"`
+		Error   string      `json:"error"`
+		Details interface{} `json:"details,omitempty"`
+	}
+	jerr := &jError{Error: err.Error()}
+	var detailer ErrorDetailer
+	if errors.As(err, &detailer) {
+		jerr.Details = detailer.ErrorDetails()
 	}
-	data, err := json.Marshal(&jError{Error: err.Error()})
+	data, err := json.Marshal(jerr)
 	if err == nil {
 		log.Printf("HERR: %v", string(data))
 		WriteResult(w, data)